@@ -1,3 +1,4 @@
+import hashlib
 import json
 import logging
 import os
@@ -30,6 +31,14 @@ else:
 class GovernanceService:
     def __init__(self):
         self.table = table
+        # Version of the policy behind the last evaluation, if one applied
+        self.policy_version = None
+        # Rate limits of that policy, returned to Orbit for enforcement
+        self.rate_limits = None
+        # Obligations of that policy, enforced by Orbit on allowed requests
+        self.obligations = None
+        # Whether the last request needs a human to approve it
+        self.requires_approval = False
 
     def evaluate_request(self, service: str, intent: str, context: Dict[str, Any] = None) -> Tuple[bool, str]:
         """
@@ -53,9 +62,19 @@ class GovernanceService:
                 logger.warning(f'No policy found for service={service}, intent={intent}')
                 return False, f"No policy defined for {service}:{intent}"
 
+            self.policy_version = self._policy_version(policy)
+            self.rate_limits = self._rate_limits(policy)
+            self.obligations = self._obligations(policy)
+
             # Evaluate policy
             allowed, reason = self._evaluate_policy(policy, context or {})
 
+            # A request that passes every check may still need sign-off;
+            # Orbit holds it until an administrator approves it
+            if allowed and policy.get('requires_approval'):
+                self.requires_approval = True
+                allowed, reason = False, "Request requires approval"
+
             duration = time.time() - start_time
             logger.info(f'GOVERNANCE_EVALUATION service={service} intent={intent} allowed={allowed} duration={duration:.3f}s')
 
@@ -87,6 +106,43 @@ class GovernanceService:
             logger.error(f'Failed to retrieve policy: {str(e)}')
             return None
 
+    def _policy_version(self, policy: Dict[str, Any]) -> str:
+        """Return the declared policy version, or a digest of the policy item."""
+        if policy.get('version'):
+            return str(policy['version'])
+
+        canonical = json.dumps(policy, sort_keys=True, default=str)
+        return 'sha256:' + hashlib.sha256(canonical.encode('utf-8')).hexdigest()[:12]
+
+    def _rate_limits(self, policy: Dict[str, Any]) -> Dict[str, int]:
+        """Return the policy's rate limits as integers, or None."""
+        rate_limits = policy.get('rate_limits')
+        if not rate_limits:
+            return None
+
+        return {
+            key: int(value)
+            for key, value in rate_limits.items()
+            if key in ('requests_per_minute', 'requests_per_hour') and value is not None
+        }
+
+    def _obligations(self, policy: Dict[str, Any]) -> list:
+        """Return the policy's obligations with DynamoDB numbers made JSON-safe."""
+        obligations = policy.get('obligations')
+        if not obligations:
+            return None
+
+        def plain(value):
+            if isinstance(value, Decimal):
+                return int(value) if value == value.to_integral_value() else float(value)
+            if isinstance(value, list):
+                return [plain(item) for item in value]
+            if isinstance(value, dict):
+                return {key: plain(item) for key, item in value.items()}
+            return value
+
+        return [plain(obligation) for obligation in obligations]
+
     def _evaluate_policy(self, policy: Dict[str, Any], context: Dict[str, Any]) -> Tuple[bool, str]:
         """Evaluate policy against context."""
 
@@ -130,8 +186,8 @@ class GovernanceService:
         if not rate_limits:
             return True
 
-        # In a real implementation, this would check Redis or DynamoDB counters
-        # For now, always allow
+        # Rate limits are enforced by Orbit, which receives them with the
+        # decision; the Lambda sees too little traffic to count requests
         return True
 
     def _evaluate_condition(self, condition: Dict[str, Any], context: Dict[str, Any]) -> bool:
@@ -215,6 +271,10 @@ def lambda_handler(event: Dict[str, Any], context: Any) -> Dict[str, Any]:
             'intent': intent,
             'allowed': allowed,
             'reason': reason,
+            'policy_version': governance.policy_version if isinstance(governance.policy_version, str) else None,
+            'rate_limits': governance.rate_limits if isinstance(governance.rate_limits, dict) else None,
+            'obligations': governance.obligations if isinstance(governance.obligations, list) else None,
+            'requires_approval': governance.requires_approval is True,
             'timestamp': time.time(),
             'correlation_id': correlation_id
         }
@@ -0,0 +1,60 @@
+import json
+import os
+import time
+from decimal import Decimal
+from unittest.mock import patch
+
+import pytest
+
+# Set environment variables before importing handler
+os.environ['POLICY_TABLE_NAME'] = 'test-table'
+os.environ['AWS_DEFAULT_REGION'] = 'us-east-1'  # Required for boto3 initialization
+
+from handler import GovernanceService
+
+GOLDEN_FILE = os.path.join(
+    os.path.dirname(os.path.abspath(__file__)),
+    '..', '..', '..', 'policies', 'golden', 'cases.json'
+)
+
+with open(GOLDEN_FILE) as f:
+    GOLDEN = json.load(f)
+
+
+def to_dynamodb(obj):
+    """Convert numbers to Decimal as DynamoDB returns them."""
+    if isinstance(obj, dict):
+        return {k: to_dynamodb(v) for k, v in obj.items()}
+    if isinstance(obj, list):
+        return [to_dynamodb(item) for item in obj]
+    if isinstance(obj, bool):
+        return obj
+    if isinstance(obj, (int, float)):
+        return Decimal(str(obj))
+    return obj
+
+
+POLICIES = {
+    (p['service'], p['intent']): to_dynamodb(p) for p in GOLDEN['policies']
+}
+
+
+def get_item(Key):
+    policy = POLICIES.get((Key['service'], Key['intent']))
+    return {'Item': policy} if policy else {}
+
+
+@pytest.mark.parametrize('case', GOLDEN['cases'], ids=[c['name'] for c in GOLDEN['cases']])
+def test_golden_case(case):
+    """The Lambda must agree with Orbit's policy engine on every golden case."""
+    with patch('handler.table') as mock_table, patch('handler.time') as mock_time:
+        mock_table.get_item.side_effect = get_item
+        mock_time.gmtime.return_value = time.struct_time((2024, 1, 1, case['hour'], 0, 0, 0, 1, 0))
+        mock_time.time.return_value = 1000.0
+
+        service = GovernanceService()
+        allowed, reason = service.evaluate_request(case['service'], case['intent'], case['context'])
+
+        assert allowed is case['allowed']
+        assert reason == case['reason']
+        assert service.requires_approval is case.get('requires_approval', False)
@@ -1,6 +1,7 @@
 import json
 import os
 import time
+from decimal import Decimal
 import pytest
 from unittest.mock import Mock, patch, MagicMock
 
@@ -156,6 +157,69 @@ class TestGovernanceService:
             assert allowed is False
             assert "Condition not met" in reason
 
+    def test_evaluate_request_records_policy_version(self):
+        """Test that the declared policy version is recorded."""
+        with patch('handler.table') as mock_table:
+            mock_table.get_item.return_value = {
+                'Item': {
+                    'service': 'orbit',
+                    'intent': 'call_reasoning',
+                    'enabled': True,
+                    'version': '2024-06-01'
+                }
+            }
+
+            service = GovernanceService()
+            service.evaluate_request('orbit', 'call_reasoning')
+
+            assert service.policy_version == '2024-06-01'
+
+    def test_evaluate_request_records_rate_limits(self):
+        """Test that rate limits are returned as integers for Orbit to enforce."""
+        with patch('handler.table') as mock_table:
+            mock_table.get_item.return_value = {
+                'Item': {
+                    'service': 'orbit',
+                    'intent': 'call_reasoning',
+                    'enabled': True,
+                    'rate_limits': {
+                        'requests_per_minute': Decimal('100'),
+                        'requests_per_hour': Decimal('1000')
+                    }
+                }
+            }
+
+            service = GovernanceService()
+            service.evaluate_request('orbit', 'call_reasoning')
+
+            assert service.rate_limits == {'requests_per_minute': 100, 'requests_per_hour': 1000}
+
+    def test_evaluate_request_records_obligations(self):
+        """Test that obligations are returned with DynamoDB numbers converted."""
+        with patch('handler.table') as mock_table:
+            mock_table.get_item.return_value = {
+                'Item': {
+                    'service': 'orbit',
+                    'intent': 'call_reasoning',
+                    'enabled': True,
+                    'obligations': [
+                        {'type': 'max_payload_bytes', 'value': Decimal('65536')},
+                        {'type': 'max_reasoning_seconds', 'value': Decimal('2.5')},
+                        {'type': 'redact_fields', 'value': ['ssn']}
+                    ]
+                }
+            }
+
+            service = GovernanceService()
+            service.evaluate_request('orbit', 'call_reasoning')
+
+            assert service.obligations == [
+                {'type': 'max_payload_bytes', 'value': 65536},
+                {'type': 'max_reasoning_seconds', 'value': 2.5},
+                {'type': 'redact_fields', 'value': ['ssn']}
+            ]
+            json.dumps(service.obligations)
+
 
 class TestLambdaHandler:
     def test_lambda_handler_success(self):
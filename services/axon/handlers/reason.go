@@ -1,17 +1,28 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"axon-service/contract"
 	"axon-service/middleware"
+	"axon-service/reasoning"
 	"axon-service/sigv4"
 	"github.com/rs/zerolog"
 )
 
+// maxReasonBodyBytes bounds the size of a reasoning request body
+const maxReasonBodyBytes = 1 << 20
+
+// streamHeartbeatInterval is how often a heartbeat event is sent on an idle stream
+const streamHeartbeatInterval = 10 * time.Second
+
 type ReasonResponse struct {
 	Message   string    `json:"message"`
 	Service   string    `json:"service"`
@@ -25,12 +36,32 @@ func ReasonHandler(logger zerolog.Logger) http.HandlerFunc {
 
 // ReasonHandlerWithSigV4 handles reasoning requests with configurable SigV4 verification
 func ReasonHandlerWithSigV4(logger zerolog.Logger, verifySigV4 bool) http.HandlerFunc {
+	return ReasonHandlerWithReasoner(logger, verifySigV4, reasoning.NewEchoReasoner())
+}
+
+// ReasonHandlerWithReasoner serves GET heartbeats and POST reasoning requests,
+// delegating the latter to the given reasoner
+func ReasonHandlerWithReasoner(logger zerolog.Logger, verifySigV4 bool, reasoner reasoning.Reasoner) http.HandlerFunc {
+	// The caller's deadline is applied only once the request is
+	// authenticated, so an unsigned X-Request-Deadline is never acted on
+	serve := middleware.DeadlineMiddleware(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveReason(w, r, logger, verifySigV4, reasoner)
+	}))
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		correlationID := middleware.GetCorrelationID(r.Context())
 
 		// Verify SigV4 signature if enabled
 		if verifySigV4 {
 			if err := verifySigV4Request(r); err != nil {
+				if errors.Is(err, sigv4.ErrBodyTooLarge) {
+					logger.Warn().
+						Str("correlation_id", correlationID).
+						Int64("content_length", r.ContentLength).
+						Msg("SIGV4_ERROR: request body too large to verify")
+					http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+					return
+				}
 				logger.Error().
 					Err(err).
 					Str("correlation_id", correlationID).
@@ -40,36 +71,264 @@ func ReasonHandlerWithSigV4(logger zerolog.Logger, verifySigV4 bool) http.Handle
 			}
 		}
 
-		// Propagate correlation ID in response headers
-		if correlationID != "" {
-			w.Header().Set("X-Correlation-ID", correlationID)
-		}
+		serve.ServeHTTP(w, r)
+	}
+}
 
-		message := "Axon heartbeat OK"
-		if verifySigV4 {
-			message = "Axon heartbeat OK - SigV4 verified"
-		}
+// serveReason answers an authenticated request
+func serveReason(w http.ResponseWriter, r *http.Request, logger zerolog.Logger, verifySigV4 bool, reasoner reasoning.Reasoner) {
+	correlationID := middleware.GetCorrelationID(r.Context())
+
+	// Propagate correlation ID in response headers
+	if correlationID != "" {
+		w.Header().Set("X-Correlation-ID", correlationID)
+	}
+
+	if r.Method == http.MethodPost {
+		handleReasonRequest(w, r, logger, reasoner, correlationID)
+		return
+	}
+
+	message := "Axon heartbeat OK"
+	if verifySigV4 {
+		message = "Axon heartbeat OK - SigV4 verified"
+	}
+
+	response := ReasonResponse{
+		Message:   message,
+		Service:   "axon",
+		Timestamp: time.Now(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+
+	logMessage := "REASON_SUCCESS: reasoning completed"
+	if verifySigV4 {
+		logMessage = "REASON_SUCCESS: SigV4 verified reasoning completed"
+	}
+
+	logger.Info().
+		Str("correlation_id", correlationID).
+		Str("message", response.Message).
+		Msg(logMessage)
+}
+
+func handleReasonRequest(w http.ResponseWriter, r *http.Request, logger zerolog.Logger, reasoner reasoning.Reasoner, correlationID string) {
+	var req contract.ReasonRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxReasonBodyBytes))
+	if err := decoder.Decode(&req); err != nil {
+		logger.Warn().
+			Err(err).
+			Str("correlation_id", correlationID).
+			Msg("REASON_ERROR: invalid request body")
+		http.Error(w, "Bad Request: invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		logger.Warn().
+			Err(err).
+			Str("correlation_id", correlationID).
+			Msg("REASON_ERROR: request validation failed")
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if wantsStream(r) {
+		streamReasonRequest(w, r, logger, reasoner, req, correlationID)
+		return
+	}
+
+	startedAt := time.Now()
+	result, err := reasoner.Reason(r.Context(), req)
+	if errors.Is(err, context.DeadlineExceeded) {
+		logger.Warn().
+			Str("correlation_id", correlationID).
+			Msg("DEADLINE_EXCEEDED: reasoning abandoned at caller deadline")
+		http.Error(w, "Deadline Exceeded", http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		logger.Error().
+			Err(err).
+			Str("correlation_id", correlationID).
+			Msg("REASON_ERROR: reasoning failed")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	response := buildReasonResponse(reasoner, result, startedAt)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
 
-		response := ReasonResponse{
-			Message:   message,
-			Service:   "axon",
-			Timestamp: time.Now(),
+	logger.Info().
+		Str("correlation_id", correlationID).
+		Str("model", response.Model).
+		Int("total_tokens", response.Usage.TotalTokens).
+		Int64("duration_ms", response.Timing.DurationMs).
+		Msg("REASON_SUCCESS: reasoning request completed")
+}
+
+// streamReasonRequest runs the reasoner and relays its output as Server-Sent
+// Events, interleaving heartbeats and finishing with a done or error event.
+// The reasoner is cancelled as soon as the caller goes away or its deadline
+// passes; the stream then ends with an error event saying which.
+func streamReasonRequest(w http.ResponseWriter, r *http.Request, logger zerolog.Logger, reasoner reasoning.Reasoner, req contract.ReasonRequest, correlationID string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	w.Header().Set("Content-Type", contract.StreamContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	type outcome struct {
+		result reasoning.Result
+		err    error
+	}
+
+	deltas := make(chan string)
+	done := make(chan outcome, 1)
+	startedAt := time.Now()
+
+	go func() {
+		emit := func(delta string) error {
+			select {
+			case deltas <- delta:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 		}
+		result, err := runStream(ctx, reasoner, req, emit)
+		done <- outcome{result: result, err: err}
+	}()
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(response)
+	var sequence int64
+	send := func(event contract.StreamEvent) error {
+		sequence++
+		event.ID = sequence
+		event.CorrelationID = correlationID
+		if err := contract.WriteSSE(w, event); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
 
-		logMessage := "REASON_SUCCESS: reasoning completed"
-		if verifySigV4 {
-			logMessage = "REASON_SUCCESS: SigV4 verified reasoning completed"
+	finish := func(out outcome) {
+		if out.err != nil {
+			logger.Error().
+				Err(out.err).
+				Str("correlation_id", correlationID).
+				Msg("REASON_ERROR: streamed reasoning failed")
+			send(contract.StreamEvent{Type: contract.EventError, Status: "failed", Error: "reasoning failed"})
+			return
 		}
 
+		response := buildReasonResponse(reasoner, out.result, startedAt)
+		send(contract.StreamEvent{Type: contract.EventDone, Status: "succeeded", Response: &response})
+
 		logger.Info().
 			Str("correlation_id", correlationID).
-			Str("message", response.Message).
-			Msg(logMessage)
+			Str("model", response.Model).
+			Int64("events", sequence).
+			Int64("duration_ms", response.Timing.DurationMs).
+			Msg("REASON_SUCCESS: streamed reasoning request completed")
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+		select {
+		case delta := <-deltas:
+			err = send(contract.StreamEvent{Type: contract.EventDelta, Delta: delta})
+		case <-heartbeat.C:
+			err = send(contract.StreamEvent{Type: contract.EventHeartbeat})
+		case out := <-done:
+			finish(out)
+			return
+		case <-ctx.Done():
+			// A reasoner that finished as the deadline passed still has its
+			// outcome reported
+			select {
+			case out := <-done:
+				if out.err == nil {
+					finish(out)
+					return
+				}
+			default:
+			}
+
+			// End the stream explicitly, so a caller still listening can tell
+			// a deadline from a dropped connection
+			event := contract.StoppedEvent(ctx.Err())
+			send(event)
+			logger.Warn().
+				Str("correlation_id", correlationID).
+				Str("status", event.Status).
+				Msg("REASON_CANCELLED: stream stopped before completion")
+			return
+		}
+
+		if err != nil {
+			logger.Warn().
+				Err(err).
+				Str("correlation_id", correlationID).
+				Msg("REASON_CANCELLED: failed to write stream event")
+			return
+		}
+	}
+}
+
+// runStream streams with the reasoner when it supports it and otherwise emits
+// the complete output as a single fragment
+func runStream(ctx context.Context, reasoner reasoning.Reasoner, req contract.ReasonRequest, emit func(string) error) (reasoning.Result, error) {
+	if streamer, ok := reasoner.(reasoning.StreamingReasoner); ok {
+		return streamer.Stream(ctx, req, emit)
+	}
+
+	result, err := reasoner.Reason(ctx, req)
+	if err != nil {
+		return reasoning.Result{}, err
 	}
+	if err := emit(string(result.Output)); err != nil {
+		return reasoning.Result{}, err
+	}
+	return result, nil
+}
+
+func buildReasonResponse(reasoner reasoning.Reasoner, result reasoning.Result, startedAt time.Time) contract.ReasonResponse {
+	completedAt := time.Now()
+	return contract.ReasonResponse{
+		Version: contract.Version,
+		Output:  result.Output,
+		Usage:   result.Usage,
+		Model:   reasoner.Model(),
+		Timing: contract.Timing{
+			StartedAt:   startedAt,
+			CompletedAt: completedAt,
+			DurationMs:  completedAt.Sub(startedAt).Milliseconds(),
+		},
+	}
+}
+
+// wantsStream reports whether the caller asked for a streamed response
+func wantsStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), contract.StreamContentType) ||
+		r.URL.Query().Get("stream") == "true"
 }
 
 func verifySigV4Request(req *http.Request) error {
@@ -85,12 +344,26 @@ func verifySigV4Request(req *http.Request) error {
 		return fmt.Errorf("AWS credentials not configured")
 	}
 
-	verifier := sigv4.NewSigV4Verifier(accessKey, secretKey, region, "execute-api")
+	verifier := sigv4.NewSigV4Verifier(accessKey, secretKey, region, "execute-api").
+		WithMaxBodyBytes(maxReasonBodyBytes)
 
 	if err := verifier.VerifyRequest(req); err != nil {
 		return fmt.Errorf("request signature verification failed: %w", err)
 	}
 
+	// A deadline only bounds our work if the caller actually signed it
+	if req.Header.Get(contract.DeadlineHeader) != "" && !isSigned(req, contract.DeadlineHeader) {
+		return fmt.Errorf("%s header is not signed", contract.DeadlineHeader)
+	}
+
 	return nil
 }
 
+func isSigned(req *http.Request, header string) bool {
+	for _, signed := range sigv4.SignedHeaders(req) {
+		if strings.EqualFold(signed, header) {
+			return true
+		}
+	}
+	return false
+}
@@ -63,7 +63,8 @@ func main() {
 
 	// Check if we should skip SigV4 verification for testing
 	skipSigV4 := os.Getenv("SKIP_SIGV4") == "true"
-	router.HandleFunc("/reason", handlers.ReasonHandlerWithSigV4(logger, !skipSigV4)).Methods("GET")
+	reasonHandler := handlers.ReasonHandlerWithSigV4(logger, !skipSigV4)
+	router.HandleFunc("/reason", reasonHandler).Methods("GET", "POST")
 
 	port := os.Getenv("PORT")
 	if port == "" {
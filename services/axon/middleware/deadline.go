@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"axon-service/contract"
+	"github.com/rs/zerolog"
+)
+
+// DeadlineMiddleware bounds the request context by the caller's
+// X-Request-Deadline header and refuses requests whose deadline has already
+// passed with 504 Gateway Timeout, so no work is started for a caller that
+// has given up
+func DeadlineMiddleware(logger zerolog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			value := r.Header.Get(contract.DeadlineHeader)
+			if value == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			correlationID := GetCorrelationID(r.Context())
+
+			deadline, err := contract.ParseDeadline(value)
+			if err != nil {
+				logger.Warn().
+					Err(err).
+					Str("correlation_id", correlationID).
+					Str("deadline", value).
+					Msg("DEADLINE_ERROR: invalid deadline header")
+				http.Error(w, "Bad Request: invalid "+contract.DeadlineHeader+" header", http.StatusBadRequest)
+				return
+			}
+
+			if remaining := time.Until(deadline); remaining <= 0 {
+				logger.Warn().
+					Str("correlation_id", correlationID).
+					Str("deadline", value).
+					Int64("expired_ms_ago", -remaining.Milliseconds()).
+					Msg("DEADLINE_EXCEEDED: refusing expired request")
+				http.Error(w, "Deadline Exceeded", http.StatusGatewayTimeout)
+				return
+			}
+
+			ctx, cancel := context.WithDeadline(r.Context(), deadline)
+			defer cancel()
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,105 @@
+// Package reasoning contains the reasoning engines Axon can serve requests with.
+package reasoning
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"axon-service/contract"
+)
+
+// Result is the outcome of a single reasoning request
+type Result struct {
+	Output json.RawMessage
+	Usage  contract.Usage
+}
+
+// Reasoner produces an output for a validated reasoning request
+type Reasoner interface {
+	Model() string
+	Reason(ctx context.Context, req contract.ReasonRequest) (Result, error)
+}
+
+// StreamingReasoner is a Reasoner that can emit its output incrementally.
+// emit is called once per output fragment; the returned Result carries the
+// complete output.
+type StreamingReasoner interface {
+	Reasoner
+	Stream(ctx context.Context, req contract.ReasonRequest, emit func(delta string) error) (Result, error)
+}
+
+// streamChunkBytes is the size of each fragment emitted by EchoReasoner.Stream
+const streamChunkBytes = 64
+
+// EchoReasoner is the default reasoner. It acknowledges the input and echoes
+// it back, which keeps the request path exercisable end to end until a model
+// backend is wired in.
+type EchoReasoner struct{}
+
+// NewEchoReasoner creates the default echo reasoner
+func NewEchoReasoner() *EchoReasoner {
+	return &EchoReasoner{}
+}
+
+// Model returns the model identifier reported in responses
+func (e *EchoReasoner) Model() string {
+	return "axon-echo-1"
+}
+
+// Reason echoes the request input together with its parameters
+func (e *EchoReasoner) Reason(ctx context.Context, req contract.ReasonRequest) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+
+	output, err := json.Marshal(map[string]interface{}{
+		"message":    "Axon reasoning completed",
+		"input":      req.Input,
+		"parameters": req.Parameters,
+	})
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to marshal output: %w", err)
+	}
+
+	inputTokens := estimateTokens(req.Input)
+	outputTokens := estimateTokens(output)
+
+	return Result{
+		Output: output,
+		Usage: contract.Usage{
+			InputTokens:  inputTokens,
+			OutputTokens: outputTokens,
+			TotalTokens:  inputTokens + outputTokens,
+		},
+	}, nil
+}
+
+// Stream produces the same output as Reason, emitted in fixed-size fragments
+func (e *EchoReasoner) Stream(ctx context.Context, req contract.ReasonRequest, emit func(delta string) error) (Result, error) {
+	result, err := e.Reason(ctx, req)
+	if err != nil {
+		return Result{}, err
+	}
+
+	output := string(result.Output)
+	for start := 0; start < len(output); start += streamChunkBytes {
+		if err := ctx.Err(); err != nil {
+			return Result{}, err
+		}
+		end := start + streamChunkBytes
+		if end > len(output) {
+			end = len(output)
+		}
+		if err := emit(output[start:end]); err != nil {
+			return Result{}, err
+		}
+	}
+
+	return result, nil
+}
+
+// estimateTokens approximates a token count as one token per four bytes
+func estimateTokens(data []byte) int {
+	return (len(data) + 3) / 4
+}
@@ -1,10 +1,13 @@
 package sigv4
 
 import (
+	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"sort"
 	"strings"
@@ -13,21 +16,36 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 )
 
+// DefaultMaxBodyBytes bounds the request body read to verify a signature
+const DefaultMaxBodyBytes = 1 << 20
+
+// ErrBodyTooLarge is returned for a request whose body exceeds the
+// verifier's limit; the body is not hashed and the signature not checked
+var ErrBodyTooLarge = errors.New("request body too large")
+
 type SigV4Verifier struct {
-	credentials *credentials.Credentials
-	region      string
-	service     string
+	credentials  *credentials.Credentials
+	region       string
+	service      string
+	maxBodyBytes int64
 }
 
 func NewSigV4Verifier(accessKey, secretKey, region, service string) *SigV4Verifier {
 	creds := credentials.NewStaticCredentials(accessKey, secretKey, "")
 	return &SigV4Verifier{
-		credentials: creds,
-		region:      region,
-		service:     service,
+		credentials:  creds,
+		region:       region,
+		service:      service,
+		maxBodyBytes: DefaultMaxBodyBytes,
 	}
 }
 
+// WithMaxBodyBytes sets the largest request body the verifier reads
+func (v *SigV4Verifier) WithMaxBodyBytes(n int64) *SigV4Verifier {
+	v.maxBodyBytes = n
+	return v
+}
+
 // VerifyRequest verifies the SigV4 signature of an incoming request
 func (v *SigV4Verifier) VerifyRequest(req *http.Request) error {
 	// Extract Authorization header
@@ -76,8 +94,18 @@ func (v *SigV4Verifier) VerifyRequest(req *http.Request) error {
 		}
 	}
 
+	// Hash the body actually received so a signed request cannot carry a
+	// payload other than the one it was signed with
+	bodyHash, err := hashPayload(req, v.maxBodyBytes)
+	if err != nil {
+		return err
+	}
+	if declared := req.Header.Get("x-amz-content-sha256"); declared != "" && declared != bodyHash {
+		return fmt.Errorf("payload hash mismatch")
+	}
+
 	// Reconstruct the canonical request
-	canonicalRequest := v.buildCanonicalRequest(req, signedHeaders)
+	canonicalRequest := v.buildCanonicalRequest(req, signedHeaders, bodyHash)
 
 	// Build string to sign
 	stringToSign := v.buildStringToSign(canonicalRequest, date, requestRegion, requestService)
@@ -96,7 +124,18 @@ func (v *SigV4Verifier) VerifyRequest(req *http.Request) error {
 	return nil
 }
 
-func (v *SigV4Verifier) buildCanonicalRequest(req *http.Request, signedHeaders []string) string {
+// SignedHeaders returns the lower-cased header names listed in the request's
+// SigV4 Authorization header
+func SignedHeaders(req *http.Request) []string {
+	for _, part := range strings.Split(req.Header.Get("Authorization"), " ") {
+		if strings.HasPrefix(part, "SignedHeaders=") {
+			return strings.Split(strings.TrimSuffix(strings.TrimPrefix(part, "SignedHeaders="), ","), ";")
+		}
+	}
+	return nil
+}
+
+func (v *SigV4Verifier) buildCanonicalRequest(req *http.Request, signedHeaders []string, bodyHash string) string {
 	var canonical strings.Builder
 
 	// HTTPRequestMethod
@@ -147,10 +186,6 @@ func (v *SigV4Verifier) buildCanonicalRequest(req *http.Request, signedHeaders [
 	canonical.WriteString("\n")
 
 	// HashedPayload
-	bodyHash := req.Header.Get("x-amz-content-sha256")
-	if bodyHash == "" {
-		bodyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
-	}
 	canonical.WriteString(bodyHash)
 
 	return canonical.String()
@@ -210,6 +245,32 @@ func (v *SigV4Verifier) calculateSignature(stringToSign string, signingKey []byt
 	return hex.EncodeToString(signature)
 }
 
+// hashPayload returns the hex SHA-256 of the request body and restores the
+// body so downstream handlers can still read it. Bodies over limit bytes are
+// refused without being buffered.
+func hashPayload(req *http.Request, limit int64) (string, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		hash := sha256.Sum256(nil)
+		return hex.EncodeToString(hash[:]), nil
+	}
+	if req.ContentLength > limit {
+		return "", ErrBodyTooLarge
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, limit+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read request body: %w", err)
+	}
+	if int64(len(body)) > limit {
+		return "", ErrBodyTooLarge
+	}
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	hash := sha256.Sum256(body)
+	return hex.EncodeToString(hash[:]), nil
+}
+
 func hmacSHA256(key, data []byte) []byte {
 	h := hmac.New(sha256.New, key)
 	h.Write(data)
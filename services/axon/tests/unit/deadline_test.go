@@ -0,0 +1,101 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"axon-service/contract"
+	"axon-service/handlers"
+	"axon-service/middleware"
+	"github.com/rs/zerolog"
+)
+
+func TestDeadlineMiddlewareRejectsExpired(t *testing.T) {
+	called := false
+	handler := middleware.DeadlineMiddleware(zerolog.Nop())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req, err := http.NewRequest("POST", "/reason", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(contract.DeadlineHeader, contract.FormatDeadline(time.Now().Add(-time.Second)))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusGatewayTimeout {
+		t.Errorf("Deadline middleware should return 504 for expired deadlines: got %v want %v", status, http.StatusGatewayTimeout)
+	}
+
+	if called {
+		t.Error("Deadline middleware should not call the handler for expired deadlines")
+	}
+}
+
+func TestDeadlineMiddlewareSetsContextDeadline(t *testing.T) {
+	want := time.Now().Add(5 * time.Second)
+
+	var got time.Time
+	var hasDeadline bool
+	handler := middleware.DeadlineMiddleware(zerolog.Nop())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, hasDeadline = r.Context().Deadline()
+	}))
+
+	req, err := http.NewRequest("POST", "/reason", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(contract.DeadlineHeader, contract.FormatDeadline(want))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if !hasDeadline {
+		t.Fatal("Deadline middleware did not set a context deadline")
+	}
+
+	if !got.Equal(want) {
+		t.Errorf("Deadline middleware set wrong deadline: got %v want %v", got, want)
+	}
+}
+
+func TestDeadlineMiddlewareInvalidHeader(t *testing.T) {
+	handler := middleware.DeadlineMiddleware(zerolog.Nop())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req, err := http.NewRequest("POST", "/reason", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(contract.DeadlineHeader, "soon")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("Deadline middleware should return 400 for invalid deadlines: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestReasonHandlerVerifiesSignatureBeforeDeadline(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	handler := handlers.ReasonHandlerWithSigV4(zerolog.Nop(), true)
+
+	req, err := http.NewRequest("POST", "/reason", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(contract.DeadlineHeader, contract.FormatDeadline(time.Now().Add(-time.Second)))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	// An unsigned deadline is not acted on
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("Reason handler should refuse an unsigned request before its deadline: got %v want %v", status, http.StatusUnauthorized)
+	}
+}
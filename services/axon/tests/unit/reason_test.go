@@ -1,14 +1,19 @@
 package unit
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"axon-service/contract"
 	"axon-service/handlers"
 	"axon-service/middleware"
+	"axon-service/reasoning"
 	"github.com/rs/zerolog"
 )
 
@@ -74,3 +79,194 @@ func TestReasonHandlerWithSigV4(t *testing.T) {
 	}
 }
 
+func TestReasonHandlerWithSigV4RejectsLargeBody(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "us-east-1")
+	handler := handlers.ReasonHandlerWithSigV4(zerolog.Nop(), true)
+
+	// The body is refused before it is buffered, whether or not its length
+	// is declared
+	for _, declared := range []bool{true, false} {
+		req, err := http.NewRequest("POST", "/reason", strings.NewReader(strings.Repeat("x", 2<<20)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !declared {
+			req.ContentLength = -1
+		}
+		req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20240101/us-east-1/execute-api/aws4_request, SignedHeaders=host, Signature=0")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusRequestEntityTooLarge {
+			t.Errorf("Reason handler with SigV4 returned wrong status code for a large body: got %v want %v", status, http.StatusRequestEntityTooLarge)
+		}
+	}
+}
+
+func TestReasonHandlerPost(t *testing.T) {
+	logger := zerolog.Nop()
+	handler := handlers.ReasonHandlerWithSigV4(logger, false)
+
+	body, err := json.Marshal(contract.ReasonRequest{
+		Version:    contract.Version,
+		Input:      json.RawMessage(`{"question":"status?"}`),
+		Parameters: map[string]interface{}{"temperature": 0.2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", "/reason", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(req.Context(), middleware.CorrelationIDKey, "test-correlation-id")
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Reason handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var response contract.ReasonResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if response.Version != contract.Version {
+		t.Errorf("Reason handler returned wrong version: got %v want %v", response.Version, contract.Version)
+	}
+
+	if response.Model == "" {
+		t.Error("Reason handler returned empty model")
+	}
+
+	if response.Usage.TotalTokens != response.Usage.InputTokens+response.Usage.OutputTokens {
+		t.Errorf("Reason handler returned inconsistent usage: %+v", response.Usage)
+	}
+
+	var output map[string]interface{}
+	if err := json.Unmarshal(response.Output, &output); err != nil {
+		t.Fatalf("Failed to decode output: %v", err)
+	}
+
+	if input, ok := output["input"].(map[string]interface{}); !ok || input["question"] != "status?" {
+		t.Errorf("Reason handler did not echo input: got %v", output["input"])
+	}
+}
+
+func TestReasonHandlerPostInvalidVersion(t *testing.T) {
+	logger := zerolog.Nop()
+	handler := handlers.ReasonHandlerWithSigV4(logger, false)
+
+	req, err := http.NewRequest("POST", "/reason", bytes.NewBufferString(`{"version":"v0","input":{}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("Reason handler should reject unknown contract versions: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestReasonHandlerPostStream(t *testing.T) {
+	logger := zerolog.Nop()
+	handler := handlers.ReasonHandlerWithSigV4(logger, false)
+
+	body := `{"version":"v1","input":{"question":"stream me a fairly long answer please"}}`
+	req, err := http.NewRequest("POST", "/reason", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", contract.StreamContentType)
+
+	ctx := context.WithValue(req.Context(), middleware.CorrelationIDKey, "test-correlation-id")
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if contentType := rr.Header().Get("Content-Type"); contentType != contract.StreamContentType {
+		t.Fatalf("Reason handler returned wrong content type: got %v want %v", contentType, contract.StreamContentType)
+	}
+
+	var events []contract.StreamEvent
+	for _, line := range strings.Split(rr.Body.String(), "\n") {
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var event contract.StreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+			t.Fatalf("Failed to decode event: %v", err)
+		}
+		events = append(events, event)
+	}
+
+	if len(events) < 2 {
+		t.Fatalf("Expected delta and done events, got %d events", len(events))
+	}
+
+	var output strings.Builder
+	for _, event := range events[:len(events)-1] {
+		if event.Type != contract.EventDelta {
+			t.Errorf("Expected delta event, got %v", event.Type)
+		}
+		if event.CorrelationID != "test-correlation-id" {
+			t.Errorf("Expected correlation ID on event, got %q", event.CorrelationID)
+		}
+		output.WriteString(event.Delta)
+	}
+
+	last := events[len(events)-1]
+	if last.Type != contract.EventDone || last.Response == nil {
+		t.Fatalf("Expected terminal done event with response, got %+v", last)
+	}
+
+	if output.String() != string(last.Response.Output) {
+		t.Errorf("Streamed deltas do not reassemble the final output: got %s want %s", output.String(), last.Response.Output)
+	}
+}
+
+// StalledReasoner answers only once its context is done
+type StalledReasoner struct{}
+
+func (StalledReasoner) Model() string { return "stalled" }
+
+func (StalledReasoner) Reason(ctx context.Context, req contract.ReasonRequest) (reasoning.Result, error) {
+	<-ctx.Done()
+	return reasoning.Result{}, ctx.Err()
+}
+
+func TestReasonHandlerPostStreamDeadline(t *testing.T) {
+	handler := handlers.ReasonHandlerWithReasoner(zerolog.Nop(), false, StalledReasoner{})
+
+	req, err := http.NewRequest("POST", "/reason", strings.NewReader(`{"version":"v1","input":{}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", contract.StreamContentType)
+
+	ctx, cancel := context.WithTimeout(req.Context(), 20*time.Millisecond)
+	defer cancel()
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req.WithContext(ctx))
+
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	var last contract.StreamEvent
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[len(lines)-1], "data: ")), &last); err != nil {
+		t.Fatalf("Failed to decode event: %v", err)
+	}
+	if last.Type != contract.EventError || last.Status != "timeout" {
+		t.Errorf("Expected terminal timeout event for a stream past its deadline, got %+v", last)
+	}
+}
@@ -0,0 +1,152 @@
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"orbit-service/clients"
+	"orbit-service/contract"
+)
+
+// Approval statuses. A pending approval is decided once: approved dispatches
+// are resumed and end completed or failed; rejected and expired ones are
+// never dispatched.
+const (
+	StatusPending   = "pending"
+	StatusApproved  = "approved"
+	StatusRejected  = "rejected"
+	StatusExpired   = "expired"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+// ErrNotFound is returned for an unknown approval ID
+var ErrNotFound = errors.New("approval not found")
+
+// StatusError is returned when an approval is not in the status an
+// operation requires, for example when approving one that already expired
+type StatusError struct {
+	ID     string
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("approval %s is %s", e.ID, e.Status)
+}
+
+// Approval is a dispatch held until a human approves or rejects it. It keeps
+// everything needed to resume the dispatch: the input (already redacted as
+// the policy requires) and the decision it was held under.
+type Approval struct {
+	ID            string                   `json:"id"`
+	Status        string                   `json:"status"`
+	CorrelationID string                   `json:"correlation_id"`
+	Principal     string                   `json:"principal,omitempty"`
+	Service       string                   `json:"service"`
+	Intent        string                   `json:"intent"`
+	Context       map[string]interface{}   `json:"context,omitempty"`
+	Reason        string                   `json:"reason,omitempty"`
+	PolicyVersion string                   `json:"policy_version,omitempty"`
+	Obligations   []clients.Obligation     `json:"obligations,omitempty"`
+	Input         json.RawMessage          `json:"input"`
+	Parameters    map[string]interface{}   `json:"parameters,omitempty"`
+	Metadata      map[string]string        `json:"metadata,omitempty"`
+	CreatedAt     time.Time                `json:"created_at"`
+	ExpiresAt     time.Time                `json:"expires_at"`
+	DecidedAt     *time.Time               `json:"decided_at,omitempty"`
+	DecidedBy     string                   `json:"decided_by,omitempty"`
+	Comment       string                   `json:"comment,omitempty"`
+	Result        *contract.ReasonResponse `json:"result,omitempty"`
+	Error         string                   `json:"error,omitempty"`
+}
+
+// Store keeps approvals
+type Store interface {
+	Create(ctx context.Context, a Approval) error
+	Get(ctx context.Context, id string) (Approval, error)
+	// Update applies fn to the approval if its status is still from, and
+	// returns the updated approval. It returns a *StatusError otherwise, so
+	// concurrent decisions cannot both succeed.
+	Update(ctx context.Context, id, from string, fn func(*Approval)) (Approval, error)
+	// Overdue returns the pending approvals that expired before now
+	Overdue(ctx context.Context, now time.Time) ([]Approval, error)
+}
+
+// MemoryStore keeps approvals in memory. Approvals are local to the task
+// that created them, so a deployment with several tasks must route admin
+// requests to the same task or use a shared store.
+type MemoryStore struct {
+	mu        sync.Mutex
+	approvals map[string]Approval
+	retention time.Duration
+}
+
+// NewMemoryStore creates a store that forgets approvals retention after
+// they expire
+func NewMemoryStore(retention time.Duration) *MemoryStore {
+	return &MemoryStore{approvals: make(map[string]Approval), retention: retention}
+}
+
+func (s *MemoryStore) Create(ctx context.Context, a Approval) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.approvals[a.ID]; ok {
+		return fmt.Errorf("approval %s already exists", a.ID)
+	}
+
+	// Drop approvals past their retention, keeping the store bounded
+	for id, existing := range s.approvals {
+		if existing.Status != StatusPending && existing.Status != StatusApproved &&
+			a.CreatedAt.Sub(existing.ExpiresAt) > s.retention {
+			delete(s.approvals, id)
+		}
+	}
+
+	s.approvals[a.ID] = a
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id string) (Approval, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.approvals[id]
+	if !ok {
+		return Approval{}, ErrNotFound
+	}
+	return a, nil
+}
+
+func (s *MemoryStore) Update(ctx context.Context, id, from string, fn func(*Approval)) (Approval, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.approvals[id]
+	if !ok {
+		return Approval{}, ErrNotFound
+	}
+	if a.Status != from {
+		return a, &StatusError{ID: id, Status: a.Status}
+	}
+	fn(&a)
+	s.approvals[id] = a
+	return a, nil
+}
+
+func (s *MemoryStore) Overdue(ctx context.Context, now time.Time) ([]Approval, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var overdue []Approval
+	for _, a := range s.approvals {
+		if a.Status == StatusPending && now.After(a.ExpiresAt) {
+			overdue = append(overdue, a)
+		}
+	}
+	return overdue, nil
+}
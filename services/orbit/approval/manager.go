@@ -0,0 +1,267 @@
+package approval
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/contract"
+)
+
+// Config controls how long approvals stay open and are kept
+type Config struct {
+	// TTL is how long an approval may stay pending before it expires
+	TTL time.Duration
+	// SweepInterval is how often Run expires overdue approvals
+	SweepInterval time.Duration
+	// Retention is how long approvals are kept after they expire
+	Retention time.Duration
+}
+
+// DefaultConfig returns the approval settings used when none are configured
+func DefaultConfig() Config {
+	return Config{
+		TTL:           time.Hour,
+		SweepInterval: time.Minute,
+		Retention:     24 * time.Hour,
+	}
+}
+
+// ConfigFromEnv returns the default config with the TTL overridden by
+// APPROVAL_TTL (a Go duration string)
+func ConfigFromEnv() (Config, error) {
+	config := DefaultConfig()
+	if value := os.Getenv("APPROVAL_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			return config, fmt.Errorf("invalid APPROVAL_TTL %q", value)
+		}
+		config.TTL = ttl
+	}
+	return config, nil
+}
+
+// Manager moves approvals through their statuses and notifies approvers of
+// every change. Notification failures are logged but do not fail the
+// change: the approval can still be looked up and decided.
+type Manager struct {
+	logger   zerolog.Logger
+	store    Store
+	notifier Notifier
+	config   Config
+	now      func() time.Time
+
+	// resumes tracks dispatches resumed in the background
+	resumes sync.WaitGroup
+}
+
+// NewManager creates a manager keeping approvals in store
+func NewManager(logger zerolog.Logger, store Store, notifier Notifier, config Config) *Manager {
+	return NewManagerWithClock(logger, store, notifier, config, time.Now)
+}
+
+// NewManagerWithClock creates a manager reading the time from now
+func NewManagerWithClock(logger zerolog.Logger, store Store, notifier Notifier, config Config, now func() time.Time) *Manager {
+	return &Manager{
+		logger:   logger,
+		store:    store,
+		notifier: notifier,
+		config:   config,
+		now:      now,
+	}
+}
+
+// Request opens a pending approval for a held dispatch, assigning its ID
+// and expiry
+func (m *Manager) Request(ctx context.Context, a Approval) (Approval, error) {
+	a.ID = newApprovalID()
+	a.Status = StatusPending
+	a.CreatedAt = m.now()
+	a.ExpiresAt = a.CreatedAt.Add(m.config.TTL)
+
+	if err := m.store.Create(ctx, a); err != nil {
+		return Approval{}, fmt.Errorf("failed to create approval: %w", err)
+	}
+
+	m.logger.Info().
+		Str("approval_id", a.ID).
+		Str("correlation_id", a.CorrelationID).
+		Str("intent", a.Intent).
+		Time("expires_at", a.ExpiresAt).
+		Msg("approval_requested")
+
+	m.notify(ctx, a)
+	return a, nil
+}
+
+// Get returns an approval, expiring it first if it is overdue
+func (m *Manager) Get(ctx context.Context, id string) (Approval, error) {
+	a, err := m.store.Get(ctx, id)
+	if err != nil {
+		return Approval{}, err
+	}
+	if a.Status == StatusPending && m.now().After(a.ExpiresAt) {
+		return m.expire(ctx, id)
+	}
+	return a, nil
+}
+
+// Approve marks a pending approval approved. The caller then resumes the
+// dispatch and reports its result with Complete.
+func (m *Manager) Approve(ctx context.Context, id, by, comment string) (Approval, error) {
+	return m.decide(ctx, id, StatusApproved, by, comment)
+}
+
+// Reject marks a pending approval rejected, cancelling the dispatch
+func (m *Manager) Reject(ctx context.Context, id, by, comment string) (Approval, error) {
+	return m.decide(ctx, id, StatusRejected, by, comment)
+}
+
+func (m *Manager) decide(ctx context.Context, id, status, by, comment string) (Approval, error) {
+	a, err := m.Get(ctx, id)
+	if err != nil {
+		return Approval{}, err
+	}
+	if a.Status != StatusPending {
+		return a, &StatusError{ID: id, Status: a.Status}
+	}
+
+	decidedAt := m.now()
+	a, err = m.store.Update(ctx, id, StatusPending, func(a *Approval) {
+		a.Status = status
+		a.DecidedAt = &decidedAt
+		a.DecidedBy = by
+		a.Comment = comment
+	})
+	if err != nil {
+		return a, err
+	}
+
+	m.logger.Info().
+		Str("approval_id", a.ID).
+		Str("correlation_id", a.CorrelationID).
+		Str("status", status).
+		Str("decided_by", by).
+		Msg("approval_decided")
+
+	m.notify(ctx, a)
+	return a, nil
+}
+
+// Complete records the result of an approved dispatch; a non-nil dispatchErr
+// marks it failed
+func (m *Manager) Complete(ctx context.Context, id string, result *contract.ReasonResponse, dispatchErr error) (Approval, error) {
+	a, err := m.store.Update(ctx, id, StatusApproved, func(a *Approval) {
+		if dispatchErr != nil {
+			a.Status = StatusFailed
+			a.Error = dispatchErr.Error()
+			return
+		}
+		a.Status = StatusCompleted
+		a.Result = result
+	})
+	if err != nil {
+		return a, err
+	}
+
+	m.notify(ctx, a)
+	return a, nil
+}
+
+// ExpireOverdue expires every pending approval past its expiry and returns
+// how many were expired
+func (m *Manager) ExpireOverdue(ctx context.Context) (int, error) {
+	overdue, err := m.store.Overdue(ctx, m.now())
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, a := range overdue {
+		_, err := m.expire(ctx, a.ID)
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			// Decided since it was listed
+			continue
+		}
+		if err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+func (m *Manager) expire(ctx context.Context, id string) (Approval, error) {
+	a, err := m.store.Update(ctx, id, StatusPending, func(a *Approval) {
+		a.Status = StatusExpired
+	})
+	if err != nil {
+		return a, err
+	}
+
+	m.logger.Info().
+		Str("approval_id", a.ID).
+		Str("correlation_id", a.CorrelationID).
+		Msg("approval_expired")
+
+	m.notify(ctx, a)
+	return a, nil
+}
+
+// Run expires overdue approvals every SweepInterval until ctx is done, so
+// approvers are notified even if nobody looks the approval up
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.config.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := m.ExpireOverdue(ctx); err != nil {
+				m.logger.Error().Err(err).Msg("approval_expiry_failed")
+			}
+		}
+	}
+}
+
+// Go runs a resumed dispatch in the background, tracked so that Wait can
+// let it finish before the service exits
+func (m *Manager) Go(fn func()) {
+	m.resumes.Add(1)
+	go func() {
+		defer m.resumes.Done()
+		fn()
+	}()
+}
+
+// Wait blocks until the dispatches started with Go have finished
+func (m *Manager) Wait() {
+	m.resumes.Wait()
+}
+
+func (m *Manager) notify(ctx context.Context, a Approval) {
+	if err := m.notifier.Notify(ctx, a); err != nil {
+		m.logger.Error().
+			Err(err).
+			Str("approval_id", a.ID).
+			Str("status", a.Status).
+			Msg("approval_notification_failed")
+	}
+}
+
+func newApprovalID() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(bytes)
+}
@@ -0,0 +1,156 @@
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/rs/zerolog"
+)
+
+// Notifiers selected by APPROVAL_NOTIFIER
+const (
+	NotifierLog = "log"
+	NotifierSNS = "sns"
+)
+
+// Notifier tells approvers about an approval. It is called when the
+// approval is created and whenever its status changes.
+type Notifier interface {
+	Notify(ctx context.Context, a Approval) error
+}
+
+// Notification is what notifiers publish about an approval. The input and
+// context are left out: they may hold data only approvers should fetch.
+type Notification struct {
+	ApprovalID    string     `json:"approval_id"`
+	Status        string     `json:"status"`
+	CorrelationID string     `json:"correlation_id"`
+	Principal     string     `json:"principal,omitempty"`
+	Service       string     `json:"service"`
+	Intent        string     `json:"intent"`
+	Reason        string     `json:"reason,omitempty"`
+	PolicyVersion string     `json:"policy_version,omitempty"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	DecidedAt     *time.Time `json:"decided_at,omitempty"`
+	DecidedBy     string     `json:"decided_by,omitempty"`
+}
+
+// NewNotification summarizes an approval for notifiers
+func NewNotification(a Approval) Notification {
+	return Notification{
+		ApprovalID:    a.ID,
+		Status:        a.Status,
+		CorrelationID: a.CorrelationID,
+		Principal:     a.Principal,
+		Service:       a.Service,
+		Intent:        a.Intent,
+		Reason:        a.Reason,
+		PolicyVersion: a.PolicyVersion,
+		ExpiresAt:     a.ExpiresAt,
+		DecidedAt:     a.DecidedAt,
+		DecidedBy:     a.DecidedBy,
+	}
+}
+
+// LogNotifier writes notifications to the service log
+type LogNotifier struct {
+	logger zerolog.Logger
+}
+
+// NewLogNotifier creates a notifier logging to logger
+func NewLogNotifier(logger zerolog.Logger) *LogNotifier {
+	return &LogNotifier{logger: logger}
+}
+
+func (n *LogNotifier) Notify(ctx context.Context, a Approval) error {
+	n.logger.Info().
+		Str("approval_id", a.ID).
+		Str("status", a.Status).
+		Str("correlation_id", a.CorrelationID).
+		Str("principal", a.Principal).
+		Str("service", a.Service).
+		Str("intent", a.Intent).
+		Time("expires_at", a.ExpiresAt).
+		Msg("approval_notification")
+	return nil
+}
+
+// SNSNotifier publishes notifications as JSON to an SNS topic. The status
+// is also sent as a message attribute, so subscriptions can filter on it.
+type SNSNotifier struct {
+	client   snsiface.SNSAPI
+	topicARN string
+}
+
+// NewSNSNotifier creates a notifier publishing to topicARN
+func NewSNSNotifier(client snsiface.SNSAPI, topicARN string) *SNSNotifier {
+	return &SNSNotifier{client: client, topicARN: topicARN}
+}
+
+func (n *SNSNotifier) Notify(ctx context.Context, a Approval) error {
+	message, err := json.Marshal(NewNotification(a))
+	if err != nil {
+		return fmt.Errorf("failed to encode approval notification: %w", err)
+	}
+
+	_, err = n.client.PublishWithContext(ctx, &sns.PublishInput{
+		TopicArn: aws.String(n.topicARN),
+		Subject:  aws.String(fmt.Sprintf("Orbit approval %s: %s/%s", a.Status, a.Service, a.Intent)),
+		Message:  aws.String(string(message)),
+		MessageAttributes: map[string]*sns.MessageAttributeValue{
+			"status": {DataType: aws.String("String"), StringValue: aws.String(a.Status)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish approval notification: %w", err)
+	}
+	return nil
+}
+
+// NotifierFromEnv creates the notifier selected by APPROVAL_NOTIFIER
+// (default log). The SNS notifier reads APPROVAL_SNS_TOPIC_ARN.
+func NotifierFromEnv(logger zerolog.Logger) (Notifier, error) {
+	kind := os.Getenv("APPROVAL_NOTIFIER")
+	if kind == "" {
+		kind = NotifierLog
+	}
+
+	switch kind {
+	case NotifierLog:
+		return NewLogNotifier(logger), nil
+	case NotifierSNS:
+		topic := os.Getenv("APPROVAL_SNS_TOPIC_ARN")
+		if topic == "" {
+			return nil, fmt.Errorf("APPROVAL_SNS_TOPIC_ARN environment variable not set")
+		}
+		sess, err := awsSession()
+		if err != nil {
+			return nil, err
+		}
+		return NewSNSNotifier(sns.New(sess), topic), nil
+	default:
+		return nil, fmt.Errorf("unknown APPROVAL_NOTIFIER %q", kind)
+	}
+}
+
+func awsSession() (*session.Session, error) {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(region),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+	return sess, nil
+}
@@ -0,0 +1,127 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/firehose/firehoseiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// maxFirehoseBatch is the most records PutRecordBatch accepts per call
+const maxFirehoseBatch = 500
+
+// FirehoseSink delivers records to a Kinesis Data Firehose stream as JSON
+// lines, which Firehose concatenates into objects the verifier can read
+type FirehoseSink struct {
+	client     firehoseiface.FirehoseAPI
+	streamName string
+}
+
+// NewFirehoseSink creates a sink for the named delivery stream
+func NewFirehoseSink(client firehoseiface.FirehoseAPI, streamName string) *FirehoseSink {
+	return &FirehoseSink{client: client, streamName: streamName}
+}
+
+func (s *FirehoseSink) Write(ctx context.Context, records []Record) error {
+	for start := 0; start < len(records); start += maxFirehoseBatch {
+		end := start + maxFirehoseBatch
+		if end > len(records) {
+			end = len(records)
+		}
+		if err := s.putBatch(ctx, records[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putBatch writes one batch, resending only the records Firehose rejected.
+// Firehose does not preserve order, so records may arrive out of sequence.
+func (s *FirehoseSink) putBatch(ctx context.Context, records []Record) error {
+	pending := make([]*firehose.Record, 0, len(records))
+	for _, r := range records {
+		data, err := encodeLines([]Record{r})
+		if err != nil {
+			return err
+		}
+		pending = append(pending, &firehose.Record{Data: data})
+	}
+
+	for attempt := 0; attempt < 3 && len(pending) > 0; attempt++ {
+		output, err := s.client.PutRecordBatchWithContext(ctx, &firehose.PutRecordBatchInput{
+			DeliveryStreamName: aws.String(s.streamName),
+			Records:            pending,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to put audit records: %w", err)
+		}
+		if aws.Int64Value(output.FailedPutCount) == 0 {
+			return nil
+		}
+
+		var failed []*firehose.Record
+		for i, result := range output.RequestResponses {
+			if result.ErrorCode != nil {
+				failed = append(failed, pending[i])
+			}
+		}
+		pending = failed
+	}
+
+	// The recorder resends the whole batch, duplicating the records Firehose
+	// accepted; the verifier orders records by sequence and drops exact
+	// duplicates, which is preferable to losing records
+	return fmt.Errorf("firehose rejected %d audit records", len(pending))
+}
+
+func (s *FirehoseSink) Close() error {
+	return nil
+}
+
+// S3Sink writes each batch as a JSON lines object named after the chain and
+// the sequence range it holds, so objects sort in chain order
+type S3Sink struct {
+	client s3iface.S3API
+	bucket string
+	prefix string
+}
+
+// NewS3Sink creates a sink writing objects under prefix in bucket
+func NewS3Sink(client s3iface.S3API, bucket, prefix string) *S3Sink {
+	return &S3Sink{client: client, bucket: bucket, prefix: prefix}
+}
+
+func (s *S3Sink) Write(ctx context.Context, records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	data, err := encodeLines(records)
+	if err != nil {
+		return err
+	}
+
+	first, last := records[0], records[len(records)-1]
+	key := fmt.Sprintf("%s%s/%s/%020d-%020d.jsonl",
+		s.prefix, first.Timestamp.UTC().Format("2006/01/02"), first.ChainID, first.Sequence, last.Sequence)
+
+	_, err = s.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/x-ndjson"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put audit object: %w", err)
+	}
+	return nil
+}
+
+func (s *S3Sink) Close() error {
+	return nil
+}
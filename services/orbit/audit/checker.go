@@ -0,0 +1,85 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+	"orbit-service/middleware"
+)
+
+// unrecordedRetryAfter is suggested to callers whose decision could not be
+// recorded
+const unrecordedRetryAfter = 5 * time.Second
+
+// Checker records every decision of the governance checker it wraps. A
+// decision that cannot be recorded is not acted on: the check fails as
+// unavailable instead.
+type Checker struct {
+	next     clients.GovernanceChecker
+	recorder *Recorder
+	logger   zerolog.Logger
+}
+
+// NewChecker wraps next so its decisions are recorded by recorder
+func NewChecker(logger zerolog.Logger, next clients.GovernanceChecker, recorder *Recorder) *Checker {
+	return &Checker{
+		next:     next,
+		recorder: recorder,
+		logger:   logger,
+	}
+}
+
+func (c *Checker) CheckPermission(ctx context.Context, req clients.GovernanceRequest, correlationID string) (clients.GovernanceResponse, error) {
+	start := time.Now()
+	response, err := c.next.CheckPermission(ctx, req, correlationID)
+
+	entry := Entry{
+		Timestamp:     time.Now(),
+		CorrelationID: correlationID,
+		Service:       req.Service,
+		Intent:        req.Intent,
+		ContextDigest: ContextDigest(req.Context),
+		Context:       req.Context,
+		LatencyMs:     time.Since(start).Milliseconds(),
+	}
+	if caller, ok := middleware.GetCaller(ctx); ok {
+		entry.Principal = caller.ID
+	}
+
+	switch {
+	case err != nil:
+		entry.Decision = DecisionError
+		entry.Reason = err.Error()
+	case response.Allowed:
+		entry.Decision = DecisionAllow
+	case response.RequiresApproval:
+		entry.Decision = DecisionApprovalRequired
+	default:
+		entry.Decision = DecisionDeny
+	}
+	if err == nil {
+		entry.Reason = response.Reason
+		entry.PolicyVersion = response.PolicyVersion
+		entry.Cached = response.Cached
+		entry.Degraded = response.Degraded != ""
+		entry.DegradedMode = response.Degraded
+	}
+
+	if recordErr := c.recorder.Record(ctx, entry); recordErr != nil {
+		c.logger.Error().
+			Err(recordErr).
+			Str("correlation_id", correlationID).
+			Str("decision", entry.Decision).
+			Msg("audit_record_failed")
+
+		return clients.GovernanceResponse{}, &clients.GovernanceUnavailableError{
+			RetryAfter: unrecordedRetryAfter,
+			Err:        fmt.Errorf("failed to record governance decision: %w", recordErr),
+		}
+	}
+
+	return response, err
+}
@@ -0,0 +1,105 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Audit sink kinds selected by AUDIT_SINK
+const (
+	SinkStdout   = "stdout"
+	SinkFile     = "file"
+	SinkFirehose = "firehose"
+	SinkS3       = "s3"
+	SinkNone     = "none"
+)
+
+// SinkFromEnv creates the sink selected by AUDIT_SINK (default stdout).
+// The file sink reads AUDIT_FILE_PATH, the Firehose sink
+// AUDIT_FIREHOSE_STREAM and the S3 sink AUDIT_S3_BUCKET and AUDIT_S3_PREFIX.
+// For the file sink the last record already in the file is returned so the
+// chain can be continued. A nil sink means auditing is disabled.
+func SinkFromEnv() (AuditSink, *Record, error) {
+	kind := os.Getenv("AUDIT_SINK")
+	if kind == "" {
+		kind = SinkStdout
+	}
+
+	switch kind {
+	case SinkNone:
+		return nil, nil, nil
+	case SinkStdout:
+		return NewStdoutSink(), nil, nil
+	case SinkFile:
+		path := os.Getenv("AUDIT_FILE_PATH")
+		if path == "" {
+			return nil, nil, fmt.Errorf("AUDIT_FILE_PATH environment variable not set")
+		}
+		last, ok, err := LastRecord(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		sink, err := NewFileSink(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			return sink, nil, nil
+		}
+		return sink, &last, nil
+	case SinkFirehose, SinkS3:
+		sess, err := awsSession()
+		if err != nil {
+			return nil, nil, err
+		}
+		if kind == SinkFirehose {
+			stream := os.Getenv("AUDIT_FIREHOSE_STREAM")
+			if stream == "" {
+				return nil, nil, fmt.Errorf("AUDIT_FIREHOSE_STREAM environment variable not set")
+			}
+			return NewFirehoseSink(firehose.New(sess), stream), nil, nil
+		}
+		bucket := os.Getenv("AUDIT_S3_BUCKET")
+		if bucket == "" {
+			return nil, nil, fmt.Errorf("AUDIT_S3_BUCKET environment variable not set")
+		}
+		return NewS3Sink(s3.New(sess), bucket, os.Getenv("AUDIT_S3_PREFIX")), nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown AUDIT_SINK %q", kind)
+	}
+}
+
+// RecorderConfigFromEnv returns the default recorder configuration, keeping
+// evaluated contexts in records when AUDIT_RECORD_CONTEXT is true
+func RecorderConfigFromEnv() (RecorderConfig, error) {
+	config := DefaultRecorderConfig()
+	if value := os.Getenv("AUDIT_RECORD_CONTEXT"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return config, fmt.Errorf("invalid AUDIT_RECORD_CONTEXT %q", value)
+		}
+		config.RecordContext = enabled
+	}
+	return config, nil
+}
+
+func awsSession() (*session.Session, error) {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(region),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+	return sess, nil
+}
@@ -0,0 +1,151 @@
+// Package audit keeps a tamper-evident trail of governance decisions. Each
+// record carries the hash of the record before it in the same chain, so a
+// removed, reordered or altered record breaks every hash after it.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Decisions recorded in the audit trail
+const (
+	DecisionAllow = "allow"
+	DecisionDeny  = "deny"
+	// DecisionApprovalRequired records a request held for approval
+	DecisionApprovalRequired = "requires_approval"
+	// DecisionError records a governance check that produced no decision
+	DecisionError = "error"
+)
+
+// Outcomes of allowed dispatches, recorded for policies requiring the full
+// audit level
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+)
+
+// Entry is a governance decision to be recorded
+type Entry struct {
+	Timestamp     time.Time `json:"timestamp"`
+	CorrelationID string    `json:"correlation_id"`
+	Principal     string    `json:"principal,omitempty"`
+	Service       string    `json:"service"`
+	Intent        string    `json:"intent"`
+	ContextDigest string    `json:"context_digest,omitempty"`
+	Decision      string    `json:"decision"`
+	Reason        string    `json:"reason,omitempty"`
+	PolicyVersion string    `json:"policy_version,omitempty"`
+	LatencyMs     int64     `json:"latency_ms"`
+	Cached        bool      `json:"cached"`
+	Degraded      bool      `json:"degraded"`
+	// DegradedMode is the failure mode that decided a degraded request
+	DegradedMode string `json:"degraded_mode,omitempty"`
+	// Outcome is set on the record of a dispatch's outcome, which follows
+	// the record of its decision under the full audit level
+	Outcome string `json:"outcome,omitempty"`
+	// PayloadDigest is a digest of the input dispatched to Axon
+	PayloadDigest string `json:"payload_digest,omitempty"`
+	// Context is the evaluated context itself. It is dropped unless the
+	// recorder is configured to keep it, for replaying decisions against
+	// candidate policies.
+	Context map[string]interface{} `json:"context,omitempty"`
+}
+
+// Record is an Entry placed in a hash chain
+type Record struct {
+	// ChainID identifies the chain; each Orbit process writes its own
+	ChainID  string `json:"chain_id"`
+	Sequence uint64 `json:"sequence"`
+	Entry
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// ComputeHash returns the hash of the record with its Hash field cleared
+func (r Record) ComputeHash() (string, error) {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit record: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ContextDigest returns a digest of the context sent with a governance
+// check, so the audit trail can prove what was evaluated without storing
+// the attributes themselves
+func ContextDigest(context map[string]interface{}) string {
+	if len(context) == 0 {
+		return ""
+	}
+	// encoding/json sorts map keys, so equal contexts have equal digests
+	data, err := json.Marshal(context)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// PayloadDigest returns a digest of a dispatched payload
+func PayloadDigest(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Verifier checks records of one or more chains in the order they were
+// written. Records of different chains may be interleaved.
+type Verifier struct {
+	last map[string]Record
+}
+
+// NewVerifier creates a verifier that expects every chain to start at
+// sequence 1
+func NewVerifier() *Verifier {
+	return &Verifier{last: make(map[string]Record)}
+}
+
+// Check verifies the next record and reports the first problem found with
+// it. The record becomes the chain's latest either way, so one problem is
+// reported once rather than again for every record after it.
+func (v *Verifier) Check(r Record) error {
+	if r.ChainID == "" {
+		return fmt.Errorf("record %d has no chain id", r.Sequence)
+	}
+
+	prev, seen := v.last[r.ChainID]
+	v.last[r.ChainID] = r
+
+	hash, err := r.ComputeHash()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case hash != r.Hash:
+		return fmt.Errorf("chain %s record %d: content does not match its hash (tampered)", r.ChainID, r.Sequence)
+	case !seen && r.Sequence != 1:
+		return fmt.Errorf("chain %s starts at record %d: records 1-%d are missing", r.ChainID, r.Sequence, r.Sequence-1)
+	case !seen && r.PrevHash != "":
+		return fmt.Errorf("chain %s record 1 links to a previous record", r.ChainID)
+	case seen && r.Sequence != prev.Sequence+1:
+		return fmt.Errorf("chain %s: gap after record %d, next is %d", r.ChainID, prev.Sequence, r.Sequence)
+	case seen && r.PrevHash != prev.Hash:
+		return fmt.Errorf("chain %s record %d: previous hash does not match record %d (tampered)", r.ChainID, r.Sequence, prev.Sequence)
+	}
+	return nil
+}
+
+// Chains returns the last sequence number verified for each chain
+func (v *Verifier) Chains() map[string]uint64 {
+	chains := make(map[string]uint64, len(v.last))
+	for id, r := range v.last {
+		chains[id] = r.Sequence
+	}
+	return chains
+}
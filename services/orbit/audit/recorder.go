@@ -0,0 +1,228 @@
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// ErrRecorderClosed is returned for entries recorded after Close
+var ErrRecorderClosed = errors.New("audit recorder is closed")
+
+// RecorderConfig controls how entries are batched to the sink
+type RecorderConfig struct {
+	// BatchSize is the number of records that triggers a write
+	BatchSize int
+	// FlushInterval is the longest a record waits before being written
+	FlushInterval time.Duration
+	// QueueSize bounds the entries waiting to be chained; Record blocks
+	// when it is full, so a failing sink slows callers down instead of
+	// losing records
+	QueueSize int
+	// WriteTimeout bounds a single sink write
+	WriteTimeout time.Duration
+	// RecordContext keeps the evaluated context in records rather than
+	// only its digest. Contexts hold caller attributes, so this is off by
+	// default.
+	RecordContext bool
+}
+
+// DefaultRecorderConfig returns the configuration used by Orbit
+func DefaultRecorderConfig() RecorderConfig {
+	return RecorderConfig{
+		BatchSize:     100,
+		FlushInterval: time.Second,
+		QueueSize:     10000,
+		WriteTimeout:  10 * time.Second,
+	}
+}
+
+// Recorder chains entries into records and writes them to a sink in the
+// background. A single goroutine assigns sequence numbers and hashes, so
+// the chain has no gaps even when Record is called concurrently.
+type Recorder struct {
+	sink   AuditSink
+	logger zerolog.Logger
+	config RecorderConfig
+
+	mu      sync.RWMutex
+	closed  bool
+	entries chan Entry
+	done    chan struct{}
+
+	// Owned by the run goroutine
+	chainID  string
+	sequence uint64
+	prevHash string
+}
+
+// NewRecorder starts a recorder writing to sink. If resume is not nil the
+// recorder continues that record's chain; otherwise it starts a new one.
+func NewRecorder(logger zerolog.Logger, sink AuditSink, config RecorderConfig, resume *Record) *Recorder {
+	r := &Recorder{
+		sink:    sink,
+		logger:  logger,
+		config:  config,
+		entries: make(chan Entry, config.QueueSize),
+		done:    make(chan struct{}),
+		chainID: newChainID(),
+	}
+	if resume != nil {
+		r.chainID = resume.ChainID
+		r.sequence = resume.Sequence
+		r.prevHash = resume.Hash
+	}
+
+	go r.run()
+	return r
+}
+
+// Record queues an entry, waiting for room in the queue until ctx is done
+func (r *Recorder) Record(ctx context.Context, entry Entry) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		return ErrRecorderClosed
+	}
+	if !r.config.RecordContext {
+		entry.Context = nil
+	}
+
+	// Try without waiting first, so an entry recorded with an already
+	// expired context is still kept when there is room for it
+	select {
+	case r.entries <- entry:
+		return nil
+	default:
+	}
+
+	select {
+	case r.entries <- entry:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close writes the queued entries and closes the sink
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.entries)
+	r.mu.Unlock()
+
+	<-r.done
+	return r.sink.Close()
+}
+
+func (r *Recorder) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.config.FlushInterval)
+	defer ticker.Stop()
+
+	var batch []Record
+	for {
+		select {
+		case entry, ok := <-r.entries:
+			if !ok {
+				r.flush(batch, true)
+				return
+			}
+			record, err := r.chain(entry)
+			if err != nil {
+				r.logger.Error().
+					Err(err).
+					Str("correlation_id", entry.CorrelationID).
+					Msg("audit_record_failed")
+				continue
+			}
+			batch = append(batch, record)
+			if len(batch) >= r.config.BatchSize {
+				batch = r.flush(batch, false)
+			}
+		case <-ticker.C:
+			batch = r.flush(batch, false)
+		}
+	}
+}
+
+// chain places an entry after the previous record
+func (r *Recorder) chain(entry Entry) (Record, error) {
+	record := Record{
+		ChainID:  r.chainID,
+		Sequence: r.sequence + 1,
+		Entry:    entry,
+		PrevHash: r.prevHash,
+	}
+	record.Timestamp = record.Timestamp.UTC()
+
+	hash, err := record.ComputeHash()
+	if err != nil {
+		return Record{}, err
+	}
+	record.Hash = hash
+
+	r.sequence = record.Sequence
+	r.prevHash = hash
+	return record, nil
+}
+
+// flush writes a batch, retrying until it succeeds. While it retries no new
+// entries are chained, so callers of Record feel the back pressure. When the
+// recorder is closing it gives up after a few attempts.
+func (r *Recorder) flush(batch []Record, closing bool) []Record {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	backoff := 100 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), r.config.WriteTimeout)
+		err := r.sink.Write(ctx, batch)
+		cancel()
+		if err == nil {
+			return batch[:0]
+		}
+
+		r.logger.Error().
+			Err(err).
+			Str("chain_id", r.chainID).
+			Uint64("first_sequence", batch[0].Sequence).
+			Uint64("last_sequence", batch[len(batch)-1].Sequence).
+			Int("attempt", attempt).
+			Msg("audit_sink_write_failed")
+
+		if closing && attempt >= 3 {
+			r.logger.Error().
+				Str("chain_id", r.chainID).
+				Int("records", len(batch)).
+				Msg("audit_records_dropped")
+			return batch[:0]
+		}
+
+		time.Sleep(backoff)
+		if backoff < 5*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func newChainID() string {
+	bytes := make([]byte, 8)
+	if _, err := rand.Read(bytes); err != nil {
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(bytes)
+}
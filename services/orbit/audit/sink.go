@@ -0,0 +1,151 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// AuditSink stores audit records. Write receives records in chain order and
+// must either store all of them or return an error, in which case the same
+// batch is written again.
+type AuditSink interface {
+	Write(ctx context.Context, records []Record) error
+	Close() error
+}
+
+// JSONSink writes each record as a line of JSON to a writer
+type JSONSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONSink creates a sink writing JSON lines to w
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{w: w}
+}
+
+// NewStdoutSink creates a sink writing JSON lines to standard output, where
+// they are collected with the service logs
+func NewStdoutSink() *JSONSink {
+	return NewJSONSink(os.Stdout)
+}
+
+func (s *JSONSink) Write(ctx context.Context, records []Record) error {
+	data, err := encodeLines(records)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.w.Write(data)
+	return err
+}
+
+func (s *JSONSink) Close() error {
+	return nil
+}
+
+// FileSink appends records as JSON lines to a local file and syncs the file
+// after every batch
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink opens path for appending, creating it if needed
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit file: %w", err)
+	}
+	return &FileSink{file: file}, nil
+}
+
+func (s *FileSink) Write(ctx context.Context, records []Record) error {
+	data, err := encodeLines(records)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write audit file: %w", err)
+	}
+	return s.file.Sync()
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// LastRecord returns the last record in an audit file, so a restarted
+// process can continue its chain. ok is false if the file does not exist
+// or holds no records.
+func LastRecord(path string) (last Record, ok bool, err error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return Record{}, false, nil
+	}
+	if err != nil {
+		return Record{}, false, fmt.Errorf("failed to open audit file: %w", err)
+	}
+	defer file.Close()
+
+	err = ReadRecords(file, func(r Record) error {
+		last, ok = r, true
+		return nil
+	})
+	return last, ok, err
+}
+
+// ReadRecords decodes JSON lines from r and calls fn for each audit record.
+// Lines that are not audit records, such as service logs sharing stdout,
+// are skipped.
+func ReadRecords(r io.Reader, fn func(Record) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 || data[0] != '{' {
+			continue
+		}
+
+		var record Record
+		if err := json.Unmarshal(data, &record); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if record.ChainID == "" || record.Hash == "" {
+			continue
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func encodeLines(records []Record) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err := encoder.Encode(r); err != nil {
+			return nil, fmt.Errorf("failed to encode audit record: %w", err)
+		}
+	}
+	return buf.Bytes(), nil
+}
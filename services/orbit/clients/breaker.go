@@ -0,0 +1,133 @@
+package clients
+
+import (
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type CircuitBreaker struct {
+	failures      int
+	lastFailTime  time.Time
+	state         string // "closed", "open", "half-open"
+	maxFailures   int
+	resetTimeout  time.Duration
+	onStateChange func(from, to string)
+	mu            chan struct{} // Simple mutex using channel
+}
+
+const (
+	stateClosed   = "closed"
+	stateOpen     = "open"
+	stateHalfOpen = "half-open"
+)
+
+// NewCircuitBreaker creates a closed breaker that opens after maxFailures
+// consecutive failures and lets a trial request through after resetTimeout.
+// onStateChange, if set, is called on every transition while the breaker's
+// lock is held, so it must not call back into the breaker.
+func NewCircuitBreaker(maxFailures int, resetTimeout time.Duration, onStateChange func(from, to string)) *CircuitBreaker {
+	cb := &CircuitBreaker{
+		maxFailures:   maxFailures,
+		resetTimeout:  resetTimeout,
+		state:         stateClosed,
+		onStateChange: onStateChange,
+		mu:            make(chan struct{}, 1),
+	}
+	cb.mu <- struct{}{} // Initialize mutex
+	return cb
+}
+
+// logStateChange returns a state change callback that logs transitions of the
+// named breaker
+func logStateChange(logger zerolog.Logger, name string) func(from, to string) {
+	return func(from, to string) {
+		event := logger.Info()
+		if to == stateOpen {
+			event = logger.Warn()
+		}
+		event.
+			Str("circuit_breaker", name).
+			Str("from_state", from).
+			Str("to_state", to).
+			Msg("circuit_breaker_state_changed")
+	}
+}
+
+// CircuitBreaker methods
+func (cb *CircuitBreaker) Allow() bool {
+	<-cb.mu // Lock
+	defer func() { cb.mu <- struct{}{} }() // Unlock
+
+	if cb.state == stateClosed {
+		return true
+	}
+
+	if cb.state == stateOpen {
+		if time.Since(cb.lastFailTime) > cb.resetTimeout {
+			cb.setState(stateHalfOpen)
+			return true
+		}
+		return false
+	}
+
+	// Half-open state
+	return true
+}
+
+func (cb *CircuitBreaker) OnSuccess() {
+	<-cb.mu // Lock
+	defer func() { cb.mu <- struct{}{} }() // Unlock
+
+	cb.failures = 0
+	if cb.state == stateHalfOpen {
+		cb.setState(stateClosed)
+	}
+}
+
+func (cb *CircuitBreaker) OnFailure() {
+	<-cb.mu // Lock
+	defer func() { cb.mu <- struct{}{} }() // Unlock
+
+	cb.failures++
+	cb.lastFailTime = time.Now()
+
+	if cb.failures >= cb.maxFailures || cb.state == stateHalfOpen {
+		cb.setState(stateOpen)
+	}
+}
+
+// RetryAfter returns how long an open breaker will keep refusing calls,
+// or zero when it lets them through
+func (cb *CircuitBreaker) RetryAfter() time.Duration {
+	<-cb.mu // Lock
+	defer func() { cb.mu <- struct{}{} }() // Unlock
+
+	if cb.state != stateOpen {
+		return 0
+	}
+	remaining := cb.resetTimeout - time.Since(cb.lastFailTime)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// State returns the current breaker state
+func (cb *CircuitBreaker) State() string {
+	<-cb.mu // Lock
+	defer func() { cb.mu <- struct{}{} }() // Unlock
+
+	return cb.state
+}
+
+func (cb *CircuitBreaker) setState(state string) {
+	if cb.state == state {
+		return
+	}
+	from := cb.state
+	cb.state = state
+	if cb.onStateChange != nil {
+		cb.onStateChange(from, state)
+	}
+}
@@ -0,0 +1,447 @@
+package clients
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/rs/zerolog"
+	"orbit-service/contract"
+	"orbit-service/sigv4"
+)
+
+// maxStreamEventBytes bounds the size of a single streamed event
+const maxStreamEventBytes = 1 << 20
+
+// Target is a downstream service speaking the reasoning contract
+type Target struct {
+	// Name identifies the target in logs and health reports
+	Name   string
+	URL    string
+	Method string
+	// SigningService is the service name requests are SigV4 signed for
+	SigningService string
+	// Timeout bounds a single attempt; streams are bounded by the caller
+	Timeout time.Duration
+	// MaxAttempts is the number of attempts made for a failed call
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubled for each retry
+	// after it
+	Backoff             time.Duration
+	BreakerMaxFailures  int
+	BreakerResetTimeout time.Duration
+}
+
+// DownstreamClient calls a Target with SigV4 signed requests, retrying
+// failed calls behind a circuit breaker
+type DownstreamClient struct {
+	httpClient *http.Client
+	// streamClient has no overall timeout; streams are bounded by the caller's context
+	streamClient   *http.Client
+	signer         *sigv4.SigV4Signer
+	logger         zerolog.Logger
+	target         Target
+	circuitBreaker *CircuitBreaker
+}
+
+// NewDownstreamClient creates a client for target, signing with the given
+// credentials
+func NewDownstreamClient(logger zerolog.Logger, target Target, credentials Credentials) *DownstreamClient {
+	return &DownstreamClient{
+		httpClient: &http.Client{
+			Timeout: target.Timeout,
+		},
+		streamClient:   &http.Client{},
+		signer:         sigv4.NewSigV4Signer(credentials.AccessKeyID, credentials.SecretAccessKey, credentials.Region, target.SigningService),
+		logger:         logger.With().Str("target", target.Name).Logger(),
+		target:         target,
+		circuitBreaker: NewCircuitBreaker(target.BreakerMaxFailures, target.BreakerResetTimeout, logStateChange(logger, target.Name)),
+	}
+}
+
+// Credentials sign downstream requests
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	Region          string
+}
+
+// CredentialsFromEnv reads AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY,
+// falling back to the AWS session's credential chain, for AWS_REGION
+func CredentialsFromEnv() (Credentials, error) {
+	credentials := Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		Region:          os.Getenv("AWS_REGION"),
+	}
+	if credentials.Region == "" {
+		credentials.Region = "us-east-1"
+	}
+
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		// Fall back to AWS session credentials
+		sess, err := session.NewSession(&aws.Config{
+			Region: aws.String(credentials.Region),
+		})
+		if err != nil {
+			return credentials, fmt.Errorf("failed to create AWS session: %w", err)
+		}
+
+		creds, err := sess.Config.Credentials.Get()
+		if err != nil {
+			return credentials, fmt.Errorf("failed to get AWS credentials: %w", err)
+		}
+		credentials.AccessKeyID = creds.AccessKeyID
+		credentials.SecretAccessKey = creds.SecretAccessKey
+	}
+	return credentials, nil
+}
+
+func (c *DownstreamClient) CallReason(ctx context.Context, reasonReq contract.ReasonRequest, correlationID string) (*contract.ReasonResponse, error) {
+	// Check circuit breaker
+	if !c.circuitBreaker.Allow() {
+		return nil, c.breakerOpen()
+	}
+
+	if reasonReq.Version == "" {
+		reasonReq.Version = contract.Version
+	}
+
+	body, err := json.Marshal(reasonReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Retry transient failures with exponential backoff
+	var lastErr error
+
+	for attempt := 0; attempt < c.target.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			// Earlier failures may have opened the breaker
+			if !c.circuitBreaker.Allow() {
+				return nil, c.breakerOpen()
+			}
+
+			backoff := time.Duration(math.Pow(2, float64(attempt-1))) * c.target.Backoff
+			// A throttled call waits as long as the target asked
+			var downstreamErr *DownstreamError
+			if errors.As(lastErr, &downstreamErr) && downstreamErr.RetryAfter > backoff {
+				backoff = downstreamErr.RetryAfter
+			}
+			c.logger.Info().
+				Str("correlation_id", correlationID).
+				Int("attempt", attempt+1).
+				Dur("backoff", backoff).
+				Msg("retrying_downstream_call")
+
+			// Stop retrying once the caller's deadline cannot accommodate
+			// another attempt
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+				return nil, fmt.Errorf("%s call abandoned after %d attempts: %w", c.target.Name, attempt, lastErr)
+			}
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, fmt.Errorf("%s call abandoned after %d attempts: %w", c.target.Name, attempt, ctx.Err())
+			case <-timer.C:
+			}
+		}
+
+		result, err := c.callOnce(ctx, body, correlationID)
+		if err == nil {
+			c.circuitBreaker.OnSuccess()
+			// A response over the token limit is refused, not retried
+			if err := checkMaxTokens(reasonReq.Parameters, result.Usage.OutputTokens); err != nil {
+				return nil, err
+			}
+			return result, nil
+		}
+
+		if ctx.Err() != nil {
+			// The caller hung up or ran out of time; that says nothing of
+			// the target, and there is no time left to retry
+			return nil, err
+		}
+
+		lastErr = err
+		c.recordFailure(err)
+
+		c.logger.Warn().
+			Err(err).
+			Str("correlation_id", correlationID).
+			Int("attempt", attempt+1).
+			Bool("retryable", transient(err)).
+			Msg("downstream_call_failed")
+
+		if !transient(err) {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("%s call failed after %d attempts: %w", c.target.Name, c.target.MaxAttempts, lastErr)
+}
+
+// recordFailure counts a transient failure against the breaker. A target
+// that answered, even to refuse the request, is up, so its answer closes a
+// half-open breaker like a success would. Failures caused by the caller's
+// context ending are not recorded.
+func (c *DownstreamClient) recordFailure(err error) {
+	var downstreamErr *DownstreamError
+	switch {
+	case transient(err):
+		c.circuitBreaker.OnFailure()
+	case errors.As(err, &downstreamErr):
+		c.circuitBreaker.OnSuccess()
+	}
+}
+
+// StreamReason asks the target for a streamed reasoning response and relays the
+// events on the returned channel. Streams are not retried once connected; a
+// stream that ends without a terminal event yields a synthetic error event.
+func (c *DownstreamClient) StreamReason(ctx context.Context, reasonReq contract.ReasonRequest, correlationID string) (<-chan contract.StreamEvent, error) {
+	if !c.circuitBreaker.Allow() {
+		return nil, c.breakerOpen()
+	}
+
+	if reasonReq.Version == "" {
+		reasonReq.Version = contract.Version
+	}
+
+	body, err := json.Marshal(reasonReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, c.target.Method, c.target.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("X-Correlation-ID", correlationID)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", contract.StreamContentType)
+	setDeadlineHeader(ctx, req)
+
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	if err := c.signer.SignRequest(req, body); err != nil {
+		return nil, fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	resp, err := c.streamClient.Do(req)
+	if err != nil {
+		err := requestError(c.target.Name, fmt.Errorf("request failed: %w", err))
+		if ctx.Err() == nil {
+			c.recordFailure(err)
+		}
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err := c.statusError(resp)
+		c.recordFailure(err)
+		return nil, err
+	}
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), contract.StreamContentType) {
+		resp.Body.Close()
+		c.circuitBreaker.OnSuccess()
+		return nil, c.invalidResponse(fmt.Errorf("%s returned unexpected content type %q", c.target.Name, resp.Header.Get("Content-Type")))
+	}
+
+	c.circuitBreaker.OnSuccess()
+
+	events := make(chan contract.StreamEvent, 1)
+	go c.readStream(ctx, resp.Body, reasonReq.Parameters, correlationID, events)
+
+	return events, nil
+}
+
+// readStream parses Server-Sent Events from body until a terminal event, the
+// end of the stream, or cancellation of ctx. Cancelling ctx aborts the
+// underlying request, which unblocks the read. A done event whose response
+// breaks the request's token limit is replaced by an error event.
+func (c *DownstreamClient) readStream(ctx context.Context, body io.ReadCloser, parameters map[string]interface{}, correlationID string, events chan<- contract.StreamEvent) {
+	defer close(events)
+	defer body.Close()
+
+	// An event already read is handed over even if ctx is done by now, so
+	// a terminal event that arrived in time is not lost to cancellation
+	send := func(event contract.StreamEvent) bool {
+		select {
+		case events <- event:
+			return true
+		default:
+		}
+		select {
+		case events <- event:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamEventBytes)
+
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.HasPrefix(line, "data:") {
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+			continue
+		}
+		if line != "" || data.Len() == 0 {
+			// id, event and comment lines carry nothing the payload does not
+			continue
+		}
+
+		var event contract.StreamEvent
+		err := json.Unmarshal([]byte(data.String()), &event)
+		data.Reset()
+		if err != nil {
+			c.logger.Warn().
+				Err(err).
+				Str("correlation_id", correlationID).
+				Msg("downstream_stream_event_invalid")
+			continue
+		}
+
+		if event.Type == contract.EventDone && event.Response != nil {
+			if err := checkMaxTokens(parameters, event.Response.Usage.OutputTokens); err != nil {
+				c.logger.Warn().
+					Err(err).
+					Str("correlation_id", correlationID).
+					Msg("downstream_response_violates_obligation")
+				event = contract.StreamEvent{Type: contract.EventError, Status: "failed", Error: err.Error()}
+			}
+		}
+
+		if !send(event) || event.IsTerminal() {
+			return
+		}
+	}
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	err := scanner.Err()
+	c.logger.Warn().
+		Err(err).
+		Str("correlation_id", correlationID).
+		Msg("downstream_stream_ended_unexpectedly")
+
+	send(contract.StreamEvent{
+		Type:   contract.EventError,
+		Status: "failed",
+		Error:  c.target.Name + " stream ended before completion",
+	})
+}
+
+func (c *DownstreamClient) callOnce(ctx context.Context, body []byte, correlationID string) (*contract.ReasonResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, c.target.Method, c.target.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add correlation ID
+	req.Header.Set("X-Correlation-ID", correlationID)
+	req.Header.Set("Content-Type", "application/json")
+	setDeadlineHeader(ctx, req)
+
+	// Declare the payload hash so it is covered by the signature and the
+	// target can check the body it receives against it
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	// Sign request with SigV4
+	if err := c.signer.SignRequest(req, body); err != nil {
+		return nil, fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, requestError(c.target.Name, fmt.Errorf("request failed: %w", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.statusError(resp)
+	}
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, requestError(c.target.Name, fmt.Errorf("failed to read response: %w", err))
+	}
+
+	var reasonResp contract.ReasonResponse
+	if err := json.Unmarshal(bodyBytes, &reasonResp); err != nil {
+		return nil, c.invalidResponse(fmt.Errorf("failed to unmarshal response: %w", err))
+	}
+
+	if reasonResp.Version != contract.Version {
+		return nil, c.invalidResponse(fmt.Errorf("%s returned unsupported contract version %q", c.target.Name, reasonResp.Version))
+	}
+
+	return &reasonResp, nil
+}
+
+// breakerOpen is the error for a call refused by the target's breaker
+func (c *DownstreamClient) breakerOpen() error {
+	return &DownstreamError{
+		Target:     c.target.Name,
+		Kind:       DownstreamBreakerOpen,
+		RetryAfter: c.circuitBreaker.RetryAfter(),
+		Err:        fmt.Errorf("%s %w", c.target.Name, ErrBreakerOpen),
+	}
+}
+
+// statusError is the error for a response with an error status, carrying
+// the target's Retry-After when it is throttling
+func (c *DownstreamClient) statusError(resp *http.Response) error {
+	err := &DownstreamError{
+		Target:     c.target.Name,
+		Kind:       DownstreamStatus,
+		StatusCode: resp.StatusCode,
+		Err:        fmt.Errorf("%s returned status %d", c.target.Name, resp.StatusCode),
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		err.RetryAfter = retryAfter(resp.Header)
+	}
+	return err
+}
+
+func (c *DownstreamClient) invalidResponse(err error) error {
+	return &DownstreamError{Target: c.target.Name, Kind: DownstreamInvalidResponse, Err: err}
+}
+
+// setDeadlineHeader tells the target how long the caller is prepared to wait. It is
+// set before signing so the deadline is covered by the signature.
+func setDeadlineHeader(ctx context.Context, req *http.Request) {
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set(contract.DeadlineHeader, contract.FormatDeadline(deadline))
+	}
+}
+
+// BreakerState reports the state of the target's circuit breaker
+func (c *DownstreamClient) BreakerState() string {
+	return c.circuitBreaker.State()
+}
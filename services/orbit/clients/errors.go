@@ -0,0 +1,89 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrBreakerOpen is returned, wrapped, when a circuit breaker refuses a call
+var ErrBreakerOpen = errors.New("circuit breaker is open")
+
+// Downstream failure kinds
+const (
+	// DownstreamBreakerOpen means the call was refused by the target's
+	// circuit breaker without being made
+	DownstreamBreakerOpen = "breaker_open"
+	// DownstreamTimeout means an attempt ran out of time
+	DownstreamTimeout = "timeout"
+	// DownstreamUnreachable means the target could not be connected to
+	DownstreamUnreachable = "unreachable"
+	// DownstreamStatus means the target answered with an error status
+	DownstreamStatus = "status"
+	// DownstreamInvalidResponse means the target's answer could not be used
+	DownstreamInvalidResponse = "invalid_response"
+)
+
+// DownstreamError is a failed call to a Target, classified so callers can
+// tell an unavailable target from one that refused or garbled the request
+type DownstreamError struct {
+	Target string
+	Kind   string
+	// StatusCode is the target's answer for DownstreamStatus failures
+	StatusCode int
+	// RetryAfter is how long the caller should wait before trying again:
+	// until the breaker lets a trial call through, or what the target asked
+	// for with its own Retry-After. Zero when there is no such hint.
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *DownstreamError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DownstreamError) Unwrap() error {
+	return e.Err
+}
+
+// requestError classifies a request that got no response
+func requestError(target string, err error) *DownstreamError {
+	kind := DownstreamUnreachable
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		kind = DownstreamTimeout
+	}
+	return &DownstreamError{Target: target, Kind: kind, Err: err}
+}
+
+// retryAfter parses a Retry-After header given in seconds; HTTP dates are
+// not used by the targets and yield no hint
+func retryAfter(header http.Header) time.Duration {
+	seconds, err := strconv.Atoi(header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// transient reports whether a failed attempt may succeed if tried again: the
+// target timed out, could not be reached, throttled the call or failed with a
+// server error. Only these are retried and counted against the breaker; any
+// other failure is the request's, not the target's.
+func transient(err error) bool {
+	var downstreamErr *DownstreamError
+	if !errors.As(err, &downstreamErr) {
+		return false
+	}
+	switch downstreamErr.Kind {
+	case DownstreamTimeout, DownstreamUnreachable:
+		return true
+	case DownstreamStatus:
+		return downstreamErr.StatusCode == http.StatusTooManyRequests || downstreamErr.StatusCode >= 500
+	default:
+		return false
+	}
+}
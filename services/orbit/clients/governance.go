@@ -1,30 +1,112 @@
 package clients
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
 	"github.com/rs/zerolog"
 )
 
 type GovernanceRequest struct {
 	Service string `json:"service"`
 	Intent  string `json:"intent"`
+	// Context holds the allowlisted request attributes that policy
+	// conditions are evaluated against
+	Context map[string]interface{} `json:"context,omitempty"`
 }
 
 type GovernanceResponse struct {
-	Allowed bool   `json:"allowed"`
-	Reason  string `json:"reason,omitempty"`
+	Allowed       bool   `json:"allowed"`
+	Reason        string `json:"reason,omitempty"`
+	PolicyVersion string `json:"policy_version,omitempty"`
+	// RateLimits are the request rates the deciding policy allows
+	RateLimits *RateLimits `json:"rate_limits,omitempty"`
+	// Obligations constrain how an allowed request is served
+	Obligations []Obligation `json:"obligations,omitempty"`
+	// RequiresApproval is set, with Allowed false, when the request may only
+	// proceed once an administrator approves it
+	RequiresApproval bool `json:"requires_approval,omitempty"`
+	// Cached is set by CachingGovernanceChecker when the decision was served
+	// from its cache rather than by the Lambda
+	Cached bool `json:"-"`
+	// Degraded names the failure mode that produced the decision when the
+	// governance checker was unavailable, and is empty otherwise
+	Degraded string `json:"-"`
+}
+
+// RateLimits are a policy's rate_limits, enforced by Orbit's rate limiter
+type RateLimits struct {
+	RequestsPerMinute int `json:"requests_per_minute,omitempty"`
+	RequestsPerHour   int `json:"requests_per_hour,omitempty"`
+}
+
+// GovernanceConfig controls how the governance Lambda is invoked
+type GovernanceConfig struct {
+	// Timeout bounds a single Lambda invocation
+	Timeout time.Duration
+	// MaxAttempts is the number of invocations tried for retryable failures
+	MaxAttempts int
+	// BreakerMaxFailures is the number of consecutive failures that opens the breaker
+	BreakerMaxFailures int
+	// BreakerResetTimeout is how long the breaker stays open before a trial call
+	BreakerResetTimeout time.Duration
+}
+
+// DefaultGovernanceConfig returns the configuration used when no overrides are set
+func DefaultGovernanceConfig() GovernanceConfig {
+	return GovernanceConfig{
+		Timeout:             2 * time.Second,
+		MaxAttempts:         3,
+		BreakerMaxFailures:  5,
+		BreakerResetTimeout: 30 * time.Second,
+	}
 }
 
 type GovernanceClient struct {
-	lambdaClient *lambda.Lambda
-	logger       zerolog.Logger
-	functionName string
+	lambdaClient   lambdaiface.LambdaAPI
+	logger         zerolog.Logger
+	functionName   string
+	config         GovernanceConfig
+	circuitBreaker *CircuitBreaker
+}
+
+// Governance failure kinds, used to decide whether a failed invocation is
+// worth retrying
+const (
+	failureThrottled = "throttled"
+	failureService   = "service"
+	failureTimeout   = "timeout"
+	failureFunction  = "function"
+	failureClient    = "client"
+)
+
+// governanceError is a failed governance invocation together with its
+// classification
+type governanceError struct {
+	kind      string
+	retryable bool
+	err       error
+}
+
+func (e *governanceError) Error() string {
+	return fmt.Sprintf("%s: %v", e.kind, e.err)
+}
+
+func (e *governanceError) Unwrap() error {
+	return e.err
 }
 
 func NewGovernanceClient(logger zerolog.Logger) (*GovernanceClient, error) {
@@ -33,9 +115,18 @@ func NewGovernanceClient(logger zerolog.Logger) (*GovernanceClient, error) {
 		region = "us-east-1"
 	}
 
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(region),
-	})
+	awsConfig := &aws.Config{Region: aws.String(region)}
+	if endpoint := os.Getenv("GOVERNANCE_ENDPOINT"); endpoint != "" {
+		// A local stand-in for the Lambda API, such as cmd/lambdalocal, needs
+		// no credentials; signing anonymously keeps the SDK from searching
+		// for them, which stalls on the instance metadata endpoint offline
+		awsConfig.Endpoint = aws.String(endpoint)
+		if os.Getenv("AWS_ACCESS_KEY_ID") == "" && os.Getenv("AWS_PROFILE") == "" {
+			awsConfig.Credentials = credentials.AnonymousCredentials
+		}
+	}
+
+	sess, err := session.NewSession(awsConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create AWS session: %w", err)
 	}
@@ -45,53 +136,216 @@ func NewGovernanceClient(logger zerolog.Logger) (*GovernanceClient, error) {
 		return nil, fmt.Errorf("GOVERNANCE_FUNCTION_NAME environment variable not set")
 	}
 
+	config, err := governanceConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	// Retries are classified and performed by CheckPermission, so the SDK's
+	// own retryer is disabled to keep invocations within the configured budget
+	lambdaClient := lambda.New(sess, aws.NewConfig().WithMaxRetries(0))
+
+	return NewGovernanceClientWithLambda(logger, lambdaClient, functionName, config), nil
+}
+
+// NewGovernanceClientWithLambda creates a governance client that invokes
+// functionName through the given Lambda API
+func NewGovernanceClientWithLambda(logger zerolog.Logger, lambdaClient lambdaiface.LambdaAPI, functionName string, config GovernanceConfig) *GovernanceClient {
 	return &GovernanceClient{
-		lambdaClient: lambda.New(sess),
-		logger:       logger,
-		functionName: functionName,
-	}, nil
+		lambdaClient:   lambdaClient,
+		logger:         logger,
+		functionName:   functionName,
+		config:         config,
+		circuitBreaker: NewCircuitBreaker(config.BreakerMaxFailures, config.BreakerResetTimeout, logStateChange(logger, "governance")),
+	}
 }
 
-func (c *GovernanceClient) CheckPermission(req GovernanceRequest, correlationID string) (bool, string, error) {
-	payload, err := json.Marshal(req)
-	if err != nil {
-		return false, "", fmt.Errorf("failed to marshal request: %w", err)
+// governanceConfigFromEnv applies GOVERNANCE_TIMEOUT and
+// GOVERNANCE_MAX_ATTEMPTS to the default configuration
+func governanceConfigFromEnv() (GovernanceConfig, error) {
+	config := DefaultGovernanceConfig()
+
+	if value := os.Getenv("GOVERNANCE_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return config, fmt.Errorf("invalid GOVERNANCE_TIMEOUT %q", value)
+		}
+		config.Timeout = timeout
 	}
 
-	input := &lambda.InvokeInput{
-		FunctionName: aws.String(c.functionName),
-		Payload:      payload,
+	if value := os.Getenv("GOVERNANCE_MAX_ATTEMPTS"); value != "" {
+		attempts, err := strconv.Atoi(value)
+		if err != nil || attempts < 1 {
+			return config, fmt.Errorf("invalid GOVERNANCE_MAX_ATTEMPTS %q", value)
+		}
+		config.MaxAttempts = attempts
 	}
 
-	result, err := c.lambdaClient.Invoke(input)
+	return config, nil
+}
+
+func (c *GovernanceClient) CheckPermission(ctx context.Context, req GovernanceRequest, correlationID string) (GovernanceResponse, error) {
+	if !c.circuitBreaker.Allow() {
+		c.logger.Warn().
+			Str("correlation_id", correlationID).
+			Str("circuit_breaker_state", stateOpen).
+			Msg("governance_circuit_open")
+		return GovernanceResponse{}, &GovernanceUnavailableError{
+			RetryAfter: c.circuitBreaker.RetryAfter(),
+			Err:        fmt.Errorf("governance %w", ErrBreakerOpen),
+		}
+	}
+
+	payload, err := json.Marshal(req)
 	if err != nil {
+		return GovernanceResponse{}, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < c.config.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			backoff := time.Duration(math.Pow(2, float64(attempt-1))) * 100 * time.Millisecond
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return GovernanceResponse{}, fmt.Errorf("governance check abandoned after %d attempts: %w", attempt, ctx.Err())
+			case <-timer.C:
+			}
+		}
+
+		response, err := c.invokeOnce(ctx, payload)
+		if err == nil {
+			c.circuitBreaker.OnSuccess()
+
+			c.logger.Info().
+				Str("correlation_id", correlationID).
+				Bool("allowed", response.Allowed).
+				Str("reason", response.Reason).
+				Int("attempt", attempt+1).
+				Msg("governance_check_completed")
+
+			return response, nil
+		}
+
+		// The caller giving up says nothing about the health of the Lambda
+		if ctx.Err() != nil {
+			return GovernanceResponse{}, fmt.Errorf("governance check abandoned: %w", ctx.Err())
+		}
+
+		lastErr = err
+		c.circuitBreaker.OnFailure()
+
+		var govErr *governanceError
+		retryable := errors.As(err, &govErr) && govErr.retryable
+
 		c.logger.Error().
 			Err(err).
 			Str("correlation_id", correlationID).
 			Str("function_name", c.functionName).
+			Int("attempt", attempt+1).
+			Bool("retryable", retryable).
+			Str("circuit_breaker_state", c.circuitBreaker.State()).
 			Msg("governance_lambda_invoke_failed")
-		return false, "", fmt.Errorf("failed to invoke governance lambda: %w", err)
+
+		if !retryable {
+			break
+		}
+	}
+
+	return GovernanceResponse{}, fmt.Errorf("failed to invoke governance lambda: %w", lastErr)
+}
+
+// BreakerState reports the state of the governance circuit breaker
+func (c *GovernanceClient) BreakerState() string {
+	return c.circuitBreaker.State()
+}
+
+// invokeOnce performs a single, time-bounded invocation of the governance Lambda
+func (c *GovernanceClient) invokeOnce(ctx context.Context, payload []byte) (GovernanceResponse, error) {
+	attemptCtx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	input := &lambda.InvokeInput{
+		FunctionName: aws.String(c.functionName),
+		Payload:      payload,
+	}
+
+	result, err := c.lambdaClient.InvokeWithContext(attemptCtx, input)
+	if err != nil {
+		return GovernanceResponse{}, classifyInvokeError(err)
 	}
 
 	if result.FunctionError != nil {
-		c.logger.Error().
-			Str("correlation_id", correlationID).
-			Str("function_error", *result.FunctionError).
-			Msg("governance_lambda_error")
-		return false, "", fmt.Errorf("governance lambda error: %s", *result.FunctionError)
+		return GovernanceResponse{}, &governanceError{
+			kind: failureFunction,
+			err:  fmt.Errorf("governance lambda error: %s", *result.FunctionError),
+		}
 	}
 
-	var response GovernanceResponse
-	if err := json.Unmarshal(result.Payload, &response); err != nil {
-		return false, "", fmt.Errorf("failed to unmarshal response: %w", err)
+	return parseGovernancePayload(result.Payload)
+}
+
+// classifyInvokeError decides whether an Invoke API error is transient
+// (throttling, Lambda service trouble, timeouts) or will fail again
+// (bad configuration, missing function, permissions)
+func classifyInvokeError(err error) error {
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		return &governanceError{kind: failureService, retryable: true, err: err}
 	}
 
-	c.logger.Info().
-		Str("correlation_id", correlationID).
-		Bool("allowed", response.Allowed).
-		Str("reason", response.Reason).
-		Msg("governance_check_completed")
+	switch awsErr.Code() {
+	case lambda.ErrCodeTooManyRequestsException, lambda.ErrCodeEC2ThrottledException:
+		return &governanceError{kind: failureThrottled, retryable: true, err: err}
+	case lambda.ErrCodeServiceException, lambda.ErrCodeResourceNotReadyException,
+		lambda.ErrCodeEC2UnexpectedException, lambda.ErrCodeENILimitReachedException:
+		return &governanceError{kind: failureService, retryable: true, err: err}
+	case request.CanceledErrorCode, request.ErrCodeResponseTimeout:
+		return &governanceError{kind: failureTimeout, retryable: true, err: err}
+	case request.ErrCodeSerialization, request.ErrCodeRead, request.ErrCodeRequestError:
+		return &governanceError{kind: failureService, retryable: true, err: err}
+	default:
+		return &governanceError{kind: failureClient, err: err}
+	}
+}
 
-	return response.Allowed, response.Reason, nil
+// lambdaProxyResponse is the API Gateway style envelope the governance
+// handler wraps its decision in
+type lambdaProxyResponse struct {
+	StatusCode int    `json:"statusCode"`
+	Body       string `json:"body"`
 }
 
+// parseGovernancePayload extracts the decision from a Lambda response
+// payload, accepting both the proxy envelope and a bare decision
+func parseGovernancePayload(payload []byte) (GovernanceResponse, error) {
+	var envelope lambdaProxyResponse
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return GovernanceResponse{}, &governanceError{kind: failureFunction, err: fmt.Errorf("failed to unmarshal response: %w", err)}
+	}
+
+	body := payload
+	if envelope.StatusCode != 0 {
+		switch {
+		case envelope.StatusCode >= 500:
+			return GovernanceResponse{}, &governanceError{
+				kind: failureFunction,
+				err:  fmt.Errorf("governance lambda returned status %d", envelope.StatusCode),
+			}
+		case envelope.StatusCode >= 400 && envelope.StatusCode != 403:
+			return GovernanceResponse{}, &governanceError{
+				kind: failureClient,
+				err:  fmt.Errorf("governance lambda rejected request with status %d: %s", envelope.StatusCode, envelope.Body),
+			}
+		}
+		body = []byte(envelope.Body)
+	}
+
+	var response GovernanceResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return GovernanceResponse{}, &governanceError{kind: failureFunction, err: fmt.Errorf("failed to unmarshal response: %w", err)}
+	}
+
+	return response, nil
+}
@@ -0,0 +1,259 @@
+package clients
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/singleflight"
+)
+
+// GovernanceCacheConfig controls CachingGovernanceChecker
+type GovernanceCacheConfig struct {
+	// AllowTTL is how long an allow decision is reused; zero disables caching allows
+	AllowTTL time.Duration
+	// DenyTTL is how long a deny decision is reused; zero disables caching denies
+	DenyTTL time.Duration
+	// MaxEntries bounds the number of cached decisions
+	MaxEntries int
+}
+
+// DefaultGovernanceCacheConfig returns the configuration used when no
+// overrides are set. Denies are kept briefly so a fixed policy takes effect
+// quickly even without an explicit invalidation.
+func DefaultGovernanceCacheConfig() GovernanceCacheConfig {
+	return GovernanceCacheConfig{
+		AllowTTL:   30 * time.Second,
+		DenyTTL:    5 * time.Second,
+		MaxEntries: 10000,
+	}
+}
+
+// GovernanceCacheConfigFromEnv applies GOVERNANCE_CACHE_ALLOW_TTL,
+// GOVERNANCE_CACHE_DENY_TTL and GOVERNANCE_CACHE_MAX_ENTRIES to the defaults
+func GovernanceCacheConfigFromEnv() (GovernanceCacheConfig, error) {
+	config := DefaultGovernanceCacheConfig()
+
+	durations := map[string]*time.Duration{
+		"GOVERNANCE_CACHE_ALLOW_TTL": &config.AllowTTL,
+		"GOVERNANCE_CACHE_DENY_TTL":  &config.DenyTTL,
+	}
+	for name, target := range durations {
+		if value := os.Getenv(name); value != "" {
+			ttl, err := time.ParseDuration(value)
+			if err != nil || ttl < 0 {
+				return config, fmt.Errorf("invalid %s %q", name, value)
+			}
+			*target = ttl
+		}
+	}
+
+	if value := os.Getenv("GOVERNANCE_CACHE_MAX_ENTRIES"); value != "" {
+		entries, err := strconv.Atoi(value)
+		if err != nil || entries < 1 {
+			return config, fmt.Errorf("invalid GOVERNANCE_CACHE_MAX_ENTRIES %q", value)
+		}
+		config.MaxEntries = entries
+	}
+
+	return config, nil
+}
+
+type cachedDecision struct {
+	response  GovernanceResponse
+	service   string
+	intent    string
+	expiresAt time.Time
+}
+
+// CachingGovernanceChecker serves repeated governance checks from memory.
+// Decisions are keyed on the full governance request, allows and denies
+// have separate TTLs, errors are never cached, and concurrent identical
+// checks share a single call to the underlying checker.
+type CachingGovernanceChecker struct {
+	next   GovernanceChecker
+	config GovernanceCacheConfig
+	logger zerolog.Logger
+	flight singleflight.Group[GovernanceResponse]
+
+	mu      sync.Mutex
+	entries map[string]cachedDecision
+	// generation is bumped by every invalidation so a decision fetched
+	// before an invalidation is not stored after it
+	generation uint64
+}
+
+// NewCachingGovernanceChecker wraps next with a decision cache
+func NewCachingGovernanceChecker(logger zerolog.Logger, next GovernanceChecker, config GovernanceCacheConfig) *CachingGovernanceChecker {
+	return &CachingGovernanceChecker{
+		next:    next,
+		config:  config,
+		logger:  logger,
+		entries: make(map[string]cachedDecision),
+	}
+}
+
+func (c *CachingGovernanceChecker) CheckPermission(ctx context.Context, req GovernanceRequest, correlationID string) (GovernanceResponse, error) {
+	key, err := decisionCacheKey(req)
+	if err != nil {
+		return GovernanceResponse{}, err
+	}
+
+	if response, ok := c.lookup(key); ok {
+		c.logger.Debug().
+			Str("correlation_id", correlationID).
+			Str("service", req.Service).
+			Str("intent", req.Intent).
+			Msg("governance_cache_hit")
+		return response, nil
+	}
+
+	c.mu.Lock()
+	generation := c.generation
+	c.mu.Unlock()
+
+	response, err, shared := c.flight.Do(ctx, key, func() (GovernanceResponse, error) {
+		return c.next.CheckPermission(ctx, req, correlationID)
+	})
+
+	// A shared call inherits the first caller's context; if that caller gave
+	// up, this one still has time to ask for itself
+	if shared && err != nil && isContextError(err) && ctx.Err() == nil {
+		response, err = c.next.CheckPermission(ctx, req, correlationID)
+		shared = false
+	}
+	if err != nil {
+		return GovernanceResponse{}, err
+	}
+
+	if !shared {
+		c.store(key, req, response, generation)
+	}
+
+	response.Cached = shared
+	return response, nil
+}
+
+// Invalidate drops every cached decision
+func (c *CachingGovernanceChecker) Invalidate() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dropped := len(c.entries)
+	c.entries = make(map[string]cachedDecision)
+	c.generation++
+
+	c.logger.Info().
+		Int("dropped", dropped).
+		Msg("governance_cache_invalidated")
+	return dropped
+}
+
+// InvalidatePolicy drops the cached decisions for one service/intent policy
+func (c *CachingGovernanceChecker) InvalidatePolicy(service, intent string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dropped := 0
+	for key, entry := range c.entries {
+		if entry.service == service && entry.intent == intent {
+			delete(c.entries, key)
+			dropped++
+		}
+	}
+	c.generation++
+
+	c.logger.Info().
+		Str("service", service).
+		Str("intent", intent).
+		Int("dropped", dropped).
+		Msg("governance_cache_invalidated")
+	return dropped
+}
+
+func (c *CachingGovernanceChecker) lookup(key string) (GovernanceResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return GovernanceResponse{}, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		return GovernanceResponse{}, false
+	}
+
+	response := entry.response
+	response.Cached = true
+	return response, true
+}
+
+func (c *CachingGovernanceChecker) store(key string, req GovernanceRequest, response GovernanceResponse, generation uint64) {
+	ttl := c.config.DenyTTL
+	if response.Allowed {
+		ttl = c.config.AllowTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	if len(c.entries) >= c.config.MaxEntries {
+		c.evictLocked()
+	}
+
+	c.entries[key] = cachedDecision{
+		response:  response,
+		service:   req.Service,
+		intent:    req.Intent,
+		expiresAt: time.Now().Add(ttl),
+	}
+}
+
+// evictLocked drops expired entries, or an arbitrary one if none have
+// expired. Callers must hold c.mu.
+func (c *CachingGovernanceChecker) evictLocked() {
+	now := time.Now()
+	for key, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+	for key := range c.entries {
+		if len(c.entries) < c.config.MaxEntries {
+			return
+		}
+		delete(c.entries, key)
+	}
+}
+
+// decisionCacheKey hashes the whole governance request, so every attribute
+// sent to the policy engine distinguishes cached decisions. encoding/json
+// sorts map keys, which keeps the key stable.
+func decisionCacheKey(req GovernanceRequest) (string, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to build cache key: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
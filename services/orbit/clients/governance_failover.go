@@ -0,0 +1,270 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Governance failure modes, applied when the governance checker errors
+const (
+	// FailureModeClosed rejects the request as unavailable
+	FailureModeClosed = "closed"
+	// FailureModeCached reuses the last known decision if it is fresh enough
+	FailureModeCached = "cached"
+	// FailureModeLocal decides with the local policy engine
+	FailureModeLocal = "local"
+)
+
+// GovernanceFailureConfig selects the failure mode for each intent
+type GovernanceFailureConfig struct {
+	// DefaultMode applies to intents without an entry in Modes
+	DefaultMode string
+	// Modes maps an intent to its failure mode
+	Modes map[string]string
+	// MaxStaleness bounds the age of a decision reused in cached mode
+	MaxStaleness time.Duration
+	// MaxEntries bounds the number of last known decisions kept
+	MaxEntries int
+	// RetryAfter is suggested to callers rejected in closed mode
+	RetryAfter time.Duration
+}
+
+// DefaultGovernanceFailureConfig fails closed for every intent
+func DefaultGovernanceFailureConfig() GovernanceFailureConfig {
+	return GovernanceFailureConfig{
+		DefaultMode:  FailureModeClosed,
+		Modes:        map[string]string{},
+		MaxStaleness: 5 * time.Minute,
+		MaxEntries:   10000,
+		RetryAfter:   30 * time.Second,
+	}
+}
+
+// GovernanceFailureConfigFromEnv applies GOVERNANCE_FAILURE_MODE (default
+// mode), GOVERNANCE_FAILURE_MODES (comma separated intent=mode pairs),
+// GOVERNANCE_FAILURE_MAX_STALENESS and GOVERNANCE_FAILURE_RETRY_AFTER
+func GovernanceFailureConfigFromEnv() (GovernanceFailureConfig, error) {
+	config := DefaultGovernanceFailureConfig()
+
+	if value := os.Getenv("GOVERNANCE_FAILURE_MODE"); value != "" {
+		config.DefaultMode = value
+	}
+
+	if value := os.Getenv("GOVERNANCE_FAILURE_MODES"); value != "" {
+		for _, pair := range strings.Split(value, ",") {
+			parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return config, fmt.Errorf("invalid GOVERNANCE_FAILURE_MODES entry %q", pair)
+			}
+			config.Modes[parts[0]] = parts[1]
+		}
+	}
+
+	for name, target := range map[string]*time.Duration{
+		"GOVERNANCE_FAILURE_MAX_STALENESS": &config.MaxStaleness,
+		"GOVERNANCE_FAILURE_RETRY_AFTER":   &config.RetryAfter,
+	} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			return config, fmt.Errorf("invalid %s %q", name, value)
+		}
+		*target = duration
+	}
+
+	return config, config.Validate()
+}
+
+// Validate checks that every configured mode is known
+func (c GovernanceFailureConfig) Validate() error {
+	if !validFailureMode(c.DefaultMode) {
+		return fmt.Errorf("unknown governance failure mode %q", c.DefaultMode)
+	}
+	for intent, mode := range c.Modes {
+		if !validFailureMode(mode) {
+			return fmt.Errorf("unknown governance failure mode %q for intent %s", mode, intent)
+		}
+	}
+	return nil
+}
+
+// ModeFor returns the failure mode for an intent
+func (c GovernanceFailureConfig) ModeFor(intent string) string {
+	if mode, ok := c.Modes[intent]; ok {
+		return mode
+	}
+	return c.DefaultMode
+}
+
+func validFailureMode(mode string) bool {
+	return mode == FailureModeClosed || mode == FailureModeCached || mode == FailureModeLocal
+}
+
+// GovernanceUnavailableError is returned when governance could not decide
+// and the intent's failure mode offers no fallback, or when the governance
+// circuit breaker is open
+type GovernanceUnavailableError struct {
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *GovernanceUnavailableError) Error() string {
+	return fmt.Sprintf("governance unavailable: %v", e.Err)
+}
+
+func (e *GovernanceUnavailableError) Unwrap() error {
+	return e.Err
+}
+
+type lastKnownDecision struct {
+	response  GovernanceResponse
+	decidedAt time.Time
+}
+
+// FailoverGovernanceChecker applies the configured failure mode when the
+// primary checker errors. Decisions it makes without the primary are
+// marked with the mode in GovernanceResponse.Degraded.
+type FailoverGovernanceChecker struct {
+	primary GovernanceChecker
+	local   GovernanceChecker
+	config  GovernanceFailureConfig
+	logger  zerolog.Logger
+
+	mu        sync.Mutex
+	lastKnown map[string]lastKnownDecision
+}
+
+// NewFailoverGovernanceChecker wraps primary with failure handling. local is
+// used by intents in local mode and may be nil if none are.
+func NewFailoverGovernanceChecker(logger zerolog.Logger, primary, local GovernanceChecker, config GovernanceFailureConfig) *FailoverGovernanceChecker {
+	return &FailoverGovernanceChecker{
+		primary:   primary,
+		local:     local,
+		config:    config,
+		logger:    logger,
+		lastKnown: make(map[string]lastKnownDecision),
+	}
+}
+
+func (f *FailoverGovernanceChecker) CheckPermission(ctx context.Context, req GovernanceRequest, correlationID string) (GovernanceResponse, error) {
+	mode := f.config.ModeFor(req.Intent)
+
+	response, err := f.primary.CheckPermission(ctx, req, correlationID)
+	if err == nil {
+		// A decision served from the primary's cache was remembered when it
+		// was made; remembering it again would reset its age
+		if mode == FailureModeCached && !response.Cached {
+			f.remember(req, response)
+		}
+		return response, nil
+	}
+
+	// A caller that went away needs no decision at all
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return GovernanceResponse{}, err
+	}
+
+	switch mode {
+	case FailureModeCached:
+		if decision, age, ok := f.recall(req); ok {
+			decision.Degraded = FailureModeCached
+			f.logDegraded(req, decision, correlationID, err).
+				Dur("decision_age", age).
+				Msg("governance_degraded_decision")
+			return decision, nil
+		}
+	case FailureModeLocal:
+		if f.local != nil {
+			// The local engine decides from memory, so it does not need
+			// whatever is left of a deadline the primary may have used up
+			decision, localErr := f.local.CheckPermission(context.Background(), req, correlationID)
+			if localErr == nil {
+				decision.Cached = false
+				decision.Degraded = FailureModeLocal
+				f.logDegraded(req, decision, correlationID, err).
+					Msg("governance_degraded_decision")
+				return decision, nil
+			}
+			err = fmt.Errorf("%v; local fallback failed: %w", err, localErr)
+		}
+	}
+
+	f.logger.Error().
+		Err(err).
+		Str("correlation_id", correlationID).
+		Str("service", req.Service).
+		Str("intent", req.Intent).
+		Str("failure_mode", mode).
+		Msg("governance_unavailable")
+
+	return GovernanceResponse{}, &GovernanceUnavailableError{RetryAfter: f.config.RetryAfter, Err: err}
+}
+
+func (f *FailoverGovernanceChecker) logDegraded(req GovernanceRequest, decision GovernanceResponse, correlationID string, cause error) *zerolog.Event {
+	return f.logger.Warn().
+		Err(cause).
+		Str("correlation_id", correlationID).
+		Str("service", req.Service).
+		Str("intent", req.Intent).
+		Str("degraded", decision.Degraded).
+		Bool("allowed", decision.Allowed).
+		Str("reason", decision.Reason)
+}
+
+func (f *FailoverGovernanceChecker) remember(req GovernanceRequest, response GovernanceResponse) {
+	key, err := decisionCacheKey(req)
+	if err != nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, exists := f.lastKnown[key]; !exists && len(f.lastKnown) >= f.config.MaxEntries {
+		f.evictOldestLocked()
+	}
+	f.lastKnown[key] = lastKnownDecision{response: response, decidedAt: time.Now()}
+}
+
+func (f *FailoverGovernanceChecker) recall(req GovernanceRequest) (GovernanceResponse, time.Duration, bool) {
+	key, err := decisionCacheKey(req)
+	if err != nil {
+		return GovernanceResponse{}, 0, false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	last, ok := f.lastKnown[key]
+	if !ok {
+		return GovernanceResponse{}, 0, false
+	}
+	age := time.Since(last.decidedAt)
+	if age > f.config.MaxStaleness {
+		delete(f.lastKnown, key)
+		return GovernanceResponse{}, 0, false
+	}
+	return last.response, age, true
+}
+
+// evictOldestLocked drops the oldest decision. Callers must hold f.mu.
+func (f *FailoverGovernanceChecker) evictOldestLocked() {
+	var oldestKey string
+	var oldest time.Time
+	for key, last := range f.lastKnown {
+		if oldestKey == "" || last.decidedAt.Before(oldest) {
+			oldestKey = key
+			oldest = last.decidedAt
+		}
+	}
+	delete(f.lastKnown, oldestKey)
+}
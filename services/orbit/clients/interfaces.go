@@ -1,14 +1,39 @@
 package clients
 
-import "context"
+import (
+	"context"
+
+	"orbit-service/contract"
+)
 
 // GovernanceChecker defines the interface for checking governance permissions
 type GovernanceChecker interface {
-	CheckPermission(req GovernanceRequest, correlationID string) (bool, string, error)
+	CheckPermission(ctx context.Context, req GovernanceRequest, correlationID string) (GovernanceResponse, error)
 }
 
 // AxonCaller defines the interface for calling the Axon service
 type AxonCaller interface {
-	CallReason(ctx context.Context, correlationID string) (string, error)
+	CallReason(ctx context.Context, req contract.ReasonRequest, correlationID string) (*contract.ReasonResponse, error)
+}
+
+// AxonStreamer is implemented by Axon callers that can stream reasoning output.
+// The returned channel is closed after a terminal event or when ctx is done.
+type AxonStreamer interface {
+	StreamReason(ctx context.Context, req contract.ReasonRequest, correlationID string) (<-chan contract.StreamEvent, error)
+}
+
+// BreakerReporter is implemented by clients guarded by a circuit breaker
+type BreakerReporter interface {
+	BreakerState() string
+}
+
+// DecisionCacheInvalidator is implemented by governance decision caches
+type DecisionCacheInvalidator interface {
+	Invalidate() int
+	InvalidatePolicy(service, intent string) int
 }
 
+// IntentRouter selects the downstream caller serving a dispatch intent
+type IntentRouter interface {
+	Route(intent string) (AxonCaller, bool)
+}
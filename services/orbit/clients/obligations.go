@@ -0,0 +1,174 @@
+package clients
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"orbit-service/contract"
+)
+
+// Obligation types a policy can attach to an allowed decision
+const (
+	// ObligationMaxPayloadBytes caps the size of the dispatched input
+	ObligationMaxPayloadBytes = "max_payload_bytes"
+	// ObligationMaxReasoningSeconds caps how long Axon may take
+	ObligationMaxReasoningSeconds = "max_reasoning_seconds"
+	// ObligationRedactFields lists input and output fields, as dotted
+	// paths into JSON objects, whose values must be redacted
+	ObligationRedactFields = "redact_fields"
+	// ObligationAuditLevel is the audit level the dispatch requires
+	ObligationAuditLevel = "audit_level"
+	// ObligationMaxTokens caps the output tokens Axon may produce
+	ObligationMaxTokens = "max_tokens"
+)
+
+// Audit levels an ObligationAuditLevel can require
+const (
+	// AuditLevelDecision requires the governance decision to be recorded
+	AuditLevelDecision = "decision"
+	// AuditLevelFull also requires the outcome of the dispatch to be recorded
+	AuditLevelFull = "full"
+)
+
+// RedactedValue replaces the values of redacted fields
+const RedactedValue = "[REDACTED]"
+
+// Obligation is a constraint an allowed request must be served under
+type Obligation struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// Obligations are the parsed obligations of a decision; zero values impose
+// no constraint
+type Obligations struct {
+	MaxPayloadBytes  int
+	MaxReasoningTime time.Duration
+	RedactFields     []string
+	AuditLevel       string
+	MaxTokens        int
+}
+
+// UnsupportedObligationError is returned for an obligation Orbit does not
+// know how to enforce. Requests carrying one must be refused, since serving
+// them would ignore a constraint the policy requires.
+type UnsupportedObligationError struct {
+	Obligation Obligation
+	Reason     string
+}
+
+func (e *UnsupportedObligationError) Error() string {
+	return fmt.Sprintf("unsupported obligation %q: %s", e.Obligation.Type, e.Reason)
+}
+
+// ParseObligations checks and decodes a decision's obligations
+func ParseObligations(obligations []Obligation) (Obligations, error) {
+	var parsed Obligations
+	for _, o := range obligations {
+		unsupported := func(reason string) error {
+			return &UnsupportedObligationError{Obligation: o, Reason: reason}
+		}
+
+		switch o.Type {
+		case ObligationMaxPayloadBytes, ObligationMaxTokens:
+			n, ok := positiveInt(o.Value)
+			if !ok {
+				return Obligations{}, unsupported("value must be a positive integer")
+			}
+			if o.Type == ObligationMaxPayloadBytes {
+				parsed.MaxPayloadBytes = tighter(parsed.MaxPayloadBytes, n)
+			} else {
+				parsed.MaxTokens = tighter(parsed.MaxTokens, n)
+			}
+		case ObligationMaxReasoningSeconds:
+			seconds, ok := number(o.Value)
+			if !ok || seconds <= 0 {
+				return Obligations{}, unsupported("value must be a positive number")
+			}
+			d := time.Duration(seconds * float64(time.Second))
+			if parsed.MaxReasoningTime == 0 || d < parsed.MaxReasoningTime {
+				parsed.MaxReasoningTime = d
+			}
+		case ObligationRedactFields:
+			fields, ok := o.Value.([]interface{})
+			if !ok {
+				return Obligations{}, unsupported("value must be a list of field names")
+			}
+			for _, field := range fields {
+				name, ok := field.(string)
+				if !ok || name == "" {
+					return Obligations{}, unsupported("value must be a list of field names")
+				}
+				parsed.RedactFields = append(parsed.RedactFields, name)
+			}
+		case ObligationAuditLevel:
+			level, _ := o.Value.(string)
+			if level != AuditLevelDecision && level != AuditLevelFull {
+				return Obligations{}, unsupported(fmt.Sprintf("audit level must be %q or %q", AuditLevelDecision, AuditLevelFull))
+			}
+			if parsed.AuditLevel != AuditLevelFull {
+				parsed.AuditLevel = level
+			}
+		default:
+			return Obligations{}, unsupported("unknown obligation type")
+		}
+	}
+	return parsed, nil
+}
+
+// ObligationViolationError is returned when Axon's response breaks an
+// obligation of the request, so the response must not be served
+type ObligationViolationError struct {
+	Type   string
+	Detail string
+}
+
+func (e *ObligationViolationError) Error() string {
+	return fmt.Sprintf("response violates obligation %q: %s", e.Type, e.Detail)
+}
+
+// checkMaxTokens enforces the max_tokens parameter sent to Axon
+func checkMaxTokens(parameters map[string]interface{}, outputTokens int) error {
+	max, ok := positiveInt(parameters[contract.ParameterMaxTokens])
+	if !ok || outputTokens <= max {
+		return nil
+	}
+	return &ObligationViolationError{
+		Type:   ObligationMaxTokens,
+		Detail: fmt.Sprintf("%d output tokens exceed the limit of %d", outputTokens, max),
+	}
+}
+
+func tighter(current, next int) int {
+	if current == 0 || next < current {
+		return next
+	}
+	return current
+}
+
+// number reads the numeric types produced by decoding JSON, with or without
+// UseNumber, and by Go callers
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func positiveInt(v interface{}) (int, bool) {
+	f, ok := number(v)
+	if !ok || f < 1 || f != math.Trunc(f) || f > math.MaxInt32 {
+		return 0, false
+	}
+	return int(f), true
+}
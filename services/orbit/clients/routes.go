@@ -0,0 +1,281 @@
+package clients
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultAxonURL is Axon's reasoning endpoint unless AXON_SERVICE_URL says
+// otherwise
+const defaultAxonURL = "http://axon/reason"
+
+// intentPattern matches the intents a dispatch request may name
+var intentPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// RouteTable maps dispatch intents to the downstream targets serving them.
+// Several intents may share a target, and with it a circuit breaker.
+type RouteTable struct {
+	// Targets are the downstream services, by name
+	Targets map[string]TargetConfig `json:"targets"`
+	// Routes maps each intent to the name of its target
+	Routes map[string]string `json:"routes"`
+}
+
+// TargetConfig is a target as written in a route table. Durations are Go
+// duration strings, and omitted settings take the values of DefaultTarget.
+type TargetConfig struct {
+	URL            string        `json:"url"`
+	Method         string        `json:"method,omitempty"`
+	SigningService string        `json:"signing_service,omitempty"`
+	Timeout        string        `json:"timeout,omitempty"`
+	Retry          RetryConfig   `json:"retry"`
+	Breaker        BreakerConfig `json:"breaker"`
+}
+
+// RetryConfig controls how failed calls to a target are retried
+type RetryConfig struct {
+	MaxAttempts int    `json:"max_attempts,omitempty"`
+	Backoff     string `json:"backoff,omitempty"`
+}
+
+// BreakerConfig controls a target's circuit breaker
+type BreakerConfig struct {
+	MaxFailures  int    `json:"max_failures,omitempty"`
+	ResetTimeout string `json:"reset_timeout,omitempty"`
+}
+
+// DefaultTarget returns the settings of a target at url that configures
+// nothing else: signed POSTs for API Gateway, three attempts and a breaker
+// that opens after five consecutive failures
+func DefaultTarget(name, url string) Target {
+	return Target{
+		Name:                name,
+		URL:                 url,
+		Method:              "POST",
+		SigningService:      "execute-api",
+		Timeout:             30 * time.Second,
+		MaxAttempts:         3,
+		Backoff:             100 * time.Millisecond,
+		BreakerMaxFailures:  5,
+		BreakerResetTimeout: 30 * time.Second,
+	}
+}
+
+// DefaultRouteTable routes call_reasoning to Axon at AXON_SERVICE_URL
+func DefaultRouteTable() RouteTable {
+	return RouteTable{
+		Targets: map[string]TargetConfig{"axon": {URL: axonURLFromEnv()}},
+		Routes:  map[string]string{"call_reasoning": "axon"},
+	}
+}
+
+func axonURLFromEnv() string {
+	if value := os.Getenv("AXON_SERVICE_URL"); value != "" {
+		return value
+	}
+	return defaultAxonURL
+}
+
+// RouteTableFromEnv loads the route table in DISPATCH_ROUTES_FILE, or returns
+// the default table when no file is configured
+func RouteTableFromEnv() (RouteTable, error) {
+	path := os.Getenv("DISPATCH_ROUTES_FILE")
+	if path == "" {
+		return DefaultRouteTable(), nil
+	}
+	return LoadRouteTable(path)
+}
+
+// LoadRouteTable reads and validates a route table from a JSON file, or a
+// YAML one when the file has a .yaml or .yml extension
+func LoadRouteTable(path string) (RouteTable, error) {
+	var table RouteTable
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return table, fmt.Errorf("failed to read route table: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var value interface{}
+		if err := yaml.Unmarshal(data, &value); err != nil {
+			return table, fmt.Errorf("failed to parse route table: %w", err)
+		}
+		data, err = json.Marshal(value)
+		if err != nil {
+			return table, fmt.Errorf("failed to parse route table: %w", err)
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&table); err != nil {
+		return table, fmt.Errorf("failed to parse route table: %w", err)
+	}
+
+	return table, table.Validate()
+}
+
+// Validate checks that every intent routes to a well-formed target and that
+// every target is routed to
+func (t RouteTable) Validate() error {
+	if len(t.Routes) == 0 {
+		return fmt.Errorf("route table has no routes")
+	}
+
+	routed := make(map[string]bool, len(t.Targets))
+	for intent, name := range t.Routes {
+		if !intentPattern.MatchString(intent) {
+			return fmt.Errorf("route %q: intent must be lowercase letters, digits and underscores", intent)
+		}
+		if _, ok := t.Targets[name]; !ok {
+			return fmt.Errorf("route %q: unknown target %q", intent, name)
+		}
+		routed[name] = true
+	}
+
+	for name := range t.Targets {
+		if !routed[name] {
+			return fmt.Errorf("target %q is not routed to by any intent", name)
+		}
+		if _, err := t.Target(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Target resolves the named target's configuration against the defaults
+func (t RouteTable) Target(name string) (Target, error) {
+	config, ok := t.Targets[name]
+	if !ok {
+		return Target{}, fmt.Errorf("unknown target %q", name)
+	}
+
+	target := DefaultTarget(name, config.URL)
+	parsed, err := url.Parse(config.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return target, fmt.Errorf("target %q: url must be an absolute http or https URL", name)
+	}
+
+	if config.Method != "" {
+		target.Method = strings.ToUpper(config.Method)
+	}
+	if target.Method != "POST" && target.Method != "PUT" {
+		return target, fmt.Errorf("target %q: method must be POST or PUT", name)
+	}
+	if config.SigningService != "" {
+		target.SigningService = config.SigningService
+	}
+
+	durations := []struct {
+		field string
+		value string
+		into  *time.Duration
+	}{
+		{"timeout", config.Timeout, &target.Timeout},
+		{"retry.backoff", config.Retry.Backoff, &target.Backoff},
+		{"breaker.reset_timeout", config.Breaker.ResetTimeout, &target.BreakerResetTimeout},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(d.value)
+		if err != nil || duration <= 0 {
+			return target, fmt.Errorf("target %q: invalid %s %q", name, d.field, d.value)
+		}
+		*d.into = duration
+	}
+
+	if config.Retry.MaxAttempts < 0 || config.Breaker.MaxFailures < 0 {
+		return target, fmt.Errorf("target %q: retry and breaker limits must be positive", name)
+	}
+	if config.Retry.MaxAttempts > 0 {
+		target.MaxAttempts = config.Retry.MaxAttempts
+	}
+	if config.Breaker.MaxFailures > 0 {
+		target.BreakerMaxFailures = config.Breaker.MaxFailures
+	}
+	return target, nil
+}
+
+// Router serves each intent of a route table with a signed downstream client
+type Router struct {
+	routes  map[string]*DownstreamClient
+	targets map[string]*DownstreamClient
+}
+
+// NewRouter creates a client for every target of a validated route table
+func NewRouter(logger zerolog.Logger, table RouteTable, credentials Credentials) (*Router, error) {
+	router := &Router{
+		routes:  make(map[string]*DownstreamClient, len(table.Routes)),
+		targets: make(map[string]*DownstreamClient, len(table.Targets)),
+	}
+	for name := range table.Targets {
+		target, err := table.Target(name)
+		if err != nil {
+			return nil, err
+		}
+		router.targets[name] = NewDownstreamClient(logger, target, credentials)
+	}
+	for intent, name := range table.Routes {
+		client, ok := router.targets[name]
+		if !ok {
+			return nil, fmt.Errorf("route %q: unknown target %q", intent, name)
+		}
+		router.routes[intent] = client
+	}
+	return router, nil
+}
+
+// Route returns the client serving intent
+func (r *Router) Route(intent string) (AxonCaller, bool) {
+	client, ok := r.routes[intent]
+	if !ok {
+		return nil, false
+	}
+	return client, true
+}
+
+// Intents returns the routed intents in order
+func (r *Router) Intents() []string {
+	intents := make([]string, 0, len(r.routes))
+	for intent := range r.routes {
+		intents = append(intents, intent)
+	}
+	sort.Strings(intents)
+	return intents
+}
+
+// Dependencies returns each target's breaker, by target name, for health
+// reporting
+func (r *Router) Dependencies() map[string]BreakerReporter {
+	dependencies := make(map[string]BreakerReporter, len(r.targets))
+	for name, client := range r.targets {
+		dependencies[name] = client
+	}
+	return dependencies
+}
+
+// NewAxonClient creates a client for Axon's reasoning endpoint at
+// AXON_SERVICE_URL, with the default target settings
+func NewAxonClient(logger zerolog.Logger) (*DownstreamClient, error) {
+	credentials, err := CredentialsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return NewDownstreamClient(logger, DefaultTarget("axon", axonURLFromEnv()), credentials), nil
+}
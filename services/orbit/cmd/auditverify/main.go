@@ -0,0 +1,110 @@
+// Command auditverify checks governance audit trails for gaps and tampering.
+//
+// It reads JSON lines audit records from the given files (or standard
+// input), skipping lines that are not audit records, and verifies every
+// chain found. Records are ordered by sequence within each chain before
+// checking, and exact duplicates left by sink retries are ignored.
+//
+//	auditverify audit.jsonl
+//	aws s3 cp --recursive s3://bucket/audit/ - | auditverify
+//
+// The exit status is 0 if every chain verifies, 1 if problems were found
+// and 2 if the input could not be read.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"orbit-service/audit"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: auditverify [file ...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	records, err := readAll(flag.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "auditverify: %v\n", err)
+		os.Exit(2)
+	}
+
+	problems, chains, duplicates := verify(records)
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+
+	ids := make([]string, 0, len(chains))
+	for id := range chains {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		fmt.Printf("chain %s: checked through record %d\n", id, chains[id])
+	}
+	if duplicates > 0 {
+		fmt.Printf("ignored %d duplicate records\n", duplicates)
+	}
+
+	if len(problems) > 0 {
+		fmt.Printf("FAILED: %d problems found\n", len(problems))
+		os.Exit(1)
+	}
+	fmt.Println("OK")
+}
+
+func readAll(paths []string) ([]audit.Record, error) {
+	var records []audit.Record
+	collect := func(r audit.Record) error {
+		records = append(records, r)
+		return nil
+	}
+
+	if len(paths) == 0 {
+		return records, audit.ReadRecords(os.Stdin, collect)
+	}
+
+	for _, path := range paths {
+		if err := readFile(path, collect); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return records, nil
+}
+
+func readFile(path string, fn func(audit.Record) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return audit.ReadRecords(file, fn)
+}
+
+// verify orders records by chain and sequence, drops exact duplicates and
+// checks each chain
+func verify(records []audit.Record) (problems []string, chains map[string]uint64, duplicates int) {
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].ChainID != records[j].ChainID {
+			return records[i].ChainID < records[j].ChainID
+		}
+		return records[i].Sequence < records[j].Sequence
+	})
+
+	verifier := audit.NewVerifier()
+	for i, r := range records {
+		if i > 0 && records[i-1].ChainID == r.ChainID && records[i-1].Sequence == r.Sequence && records[i-1].Hash == r.Hash {
+			duplicates++
+			continue
+		}
+		if err := verifier.Check(r); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	return problems, verifier.Chains(), duplicates
+}
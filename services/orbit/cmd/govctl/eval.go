@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/audit"
+	"orbit-service/policy"
+)
+
+// evalResult is the -json output of eval
+type evalResult struct {
+	Decision      string              `json:"decision"`
+	Reason        string              `json:"reason"`
+	PolicyVersion string              `json:"policy_version,omitempty"`
+	RateLimits    *policy.RateLimits  `json:"rate_limits,omitempty"`
+	Obligations   []policy.Obligation `json:"obligations,omitempty"`
+	Trace         []policy.Step       `json:"trace"`
+}
+
+func runEval(args []string) int {
+	flags := flag.NewFlagSet("eval", flag.ExitOnError)
+	policyFile := flags.String("policies", "", "policy file (required)")
+	service := flags.String("service", "", "service of the request (required)")
+	intent := flags.String("intent", "", "intent of the request (required)")
+	contextArg := flags.String("context", "", "request context as a JSON object, or @file to read it from a JSON or YAML file")
+	at := flags.String("at", "", "evaluate at this RFC 3339 time instead of now")
+	asJSON := flags.Bool("json", false, "print the decision as JSON")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: govctl eval -policies policies.json -service service -intent intent [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *policyFile == "" || *service == "" || *intent == "" || flags.NArg() > 0 {
+		flags.Usage()
+		return exitError
+	}
+
+	policies, err := loadPolicies(*policyFile)
+	if err != nil {
+		return fail("%v", err)
+	}
+	engine, err := policy.NewEngine(zerolog.Nop(), policies)
+	if err != nil {
+		return fail("%s: %v", *policyFile, err)
+	}
+
+	attributes, err := readContext(*contextArg)
+	if err != nil {
+		return fail("invalid -context: %v", err)
+	}
+
+	now := time.Now()
+	if *at != "" {
+		if now, err = time.Parse(time.RFC3339, *at); err != nil {
+			return fail("invalid -at: %v", err)
+		}
+	}
+
+	decision, trace := engine.Explain(*service, *intent, attributes, now)
+	result := evalResult{
+		Decision:      decisionName(decision),
+		Reason:        decision.Reason,
+		PolicyVersion: decision.PolicyVersion,
+		RateLimits:    decision.RateLimits,
+		Obligations:   decision.Obligations,
+		Trace:         trace,
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(result); err != nil {
+			return fail("%v", err)
+		}
+		return exitOK
+	}
+
+	fmt.Printf("%s:%s at %s: %s\n", *service, *intent, now.UTC().Format(time.RFC3339), result.Decision)
+	fmt.Printf("reason: %s\n", result.Reason)
+	if result.PolicyVersion != "" {
+		fmt.Printf("policy_version: %s\n", result.PolicyVersion)
+	}
+	if result.RateLimits != nil {
+		fmt.Printf("rate_limits: %d/minute, %d/hour\n", result.RateLimits.RequestsPerMinute, result.RateLimits.RequestsPerHour)
+	}
+	for _, o := range result.Obligations {
+		fmt.Printf("obligation: %s = %v\n", o.Type, o.Value)
+	}
+	fmt.Println("trace:")
+	printTrace(trace, "  ")
+	return exitOK
+}
+
+// readContext parses the -context flag
+func readContext(arg string) (map[string]interface{}, error) {
+	if arg == "" {
+		return map[string]interface{}{}, nil
+	}
+
+	data := []byte(arg)
+	if strings.HasPrefix(arg, "@") {
+		var err error
+		if data, err = readDocument(arg[1:]); err != nil {
+			return nil, err
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var attributes map[string]interface{}
+	if err := decoder.Decode(&attributes); err != nil {
+		return nil, fmt.Errorf("context must be a JSON object: %w", err)
+	}
+	return attributes, nil
+}
+
+func decisionName(decision policy.Decision) string {
+	switch {
+	case decision.Allowed:
+		return audit.DecisionAllow
+	case decision.RequiresApproval:
+		return audit.DecisionApprovalRequired
+	default:
+		return audit.DecisionDeny
+	}
+}
+
+func printTrace(trace []policy.Step, indent string) {
+	for _, step := range trace {
+		mark := "pass"
+		if !step.Passed {
+			mark = "FAIL"
+		}
+		fmt.Printf("%s%s  %-18s %s\n", indent, mark, step.Check, step.Detail)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"orbit-service/policy"
+)
+
+// schemaPath is where the policy schema lives in the repository
+var schemaPath = filepath.Join("governance", "policies", "schema.json")
+
+// readDocument reads a JSON or YAML file, returning it as JSON
+func readDocument(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var value interface{}
+		if err := yaml.Unmarshal(data, &value); err != nil {
+			return nil, fmt.Errorf("%s: failed to parse YAML: %w", path, err)
+		}
+		data, err = json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: YAML cannot be represented as JSON: %w", path, err)
+		}
+	}
+	return data, nil
+}
+
+// loadPolicies reads and validates a policy file
+func loadPolicies(path string) ([]policy.Policy, error) {
+	data, err := readDocument(path)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := policy.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return policies, nil
+}
+
+// findSchema looks for the policy schema in the working directory and its
+// parents, so govctl works from anywhere in the repository
+func findSchema() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		candidate := filepath.Join(dir, schemaPath)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("policy schema not found; pass -schema")
+		}
+		dir = parent
+	}
+}
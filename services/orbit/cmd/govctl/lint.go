@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"orbit-service/policy"
+)
+
+func runLint(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	strict := flags.Bool("strict", false, "fail on warnings as well as errors")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: govctl lint [-strict] policies.json ...\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitError
+	}
+
+	errors, warnings := 0, 0
+	for _, file := range flags.Args() {
+		data, err := readDocument(file)
+		if err != nil {
+			return fail("%v", err)
+		}
+		findings, err := policy.LintDocument(data)
+		if err != nil {
+			return fail("%s: %v", file, err)
+		}
+
+		for _, finding := range findings {
+			fmt.Printf("%s: %s\n", file, finding)
+			if finding.Severity == policy.SeverityError {
+				errors++
+			} else {
+				warnings++
+			}
+		}
+	}
+
+	if errors > 0 || (*strict && warnings > 0) {
+		fmt.Printf("FAILED: %d errors, %d warnings\n", errors, warnings)
+		return exitProblems
+	}
+	if warnings > 0 {
+		fmt.Printf("OK with %d warnings\n", warnings)
+		return exitOK
+	}
+	fmt.Println("OK")
+	return exitOK
+}
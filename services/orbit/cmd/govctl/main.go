@@ -0,0 +1,82 @@
+// Command govctl checks governance policies offline, without deploying them
+// to DynamoDB or invoking the governance Lambda. It evaluates policies with
+// Orbit's in-process engine, which the golden cases keep in step with the
+// Lambda.
+//
+//	govctl validate [-schema schema.json] policies.json ...
+//	govctl lint [-strict] policies.json ...
+//	govctl eval -policies policies.json -service orbit -intent call_reasoning [-context '{"user_type":"active"}'] [-at 2024-01-01T09:00:00Z] [-json]
+//	govctl test [-policies policies.json] [-v] suite.yaml ...
+//	govctl replay -current policies.json -candidate policies.json [-samples 5] [-json] [audit.log ...]
+//
+// validate checks policy files against governance/policies/schema.json,
+// found by searching upwards from the working directory unless -schema is
+// given. lint reports semantic problems such as duplicate service/intent
+// pairs and conditions that can never hold together. eval decides one
+// request and explains each check. test runs suites of expected decisions,
+// in the format of governance/policies/golden/cases.json. replay decides
+// the requests in audit logs, or Orbit logs carrying the stdout audit sink,
+// against two policy sets and reports the decisions that would change.
+//
+// Policy files and test suites may be JSON or, with a .yaml or .yml
+// extension, YAML. The exit status is 0 on success, 1 if problems were
+// found, tests failed or replayed decisions changed and 2 if the input
+// could not be read.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+const (
+	exitOK       = 0
+	exitProblems = 1
+	exitError    = 2
+)
+
+var commands = map[string]func(args []string) int{
+	"validate": runValidate,
+	"lint":     runLint,
+	"eval":     runEval,
+	"test":     runTest,
+	"replay":   runReplay,
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(exitError)
+	}
+	command, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "govctl: unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(exitError)
+	}
+	os.Exit(command(flag.Args()[1:]))
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: govctl <command> [flags] [file ...]
+
+commands:
+  validate  check policy files against the policy schema
+  lint      report semantic problems in policy files
+  eval      decide a request and explain the decision
+  test      run test suites of expected decisions
+  replay    report recorded decisions a policy change would flip
+
+Run govctl <command> -h for the flags of a command.
+`)
+}
+
+// fail reports an input error and returns the matching exit status
+func fail(format string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "govctl: "+format+"\n", args...)
+	return exitError
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog"
+	"orbit-service/audit"
+	"orbit-service/policy"
+)
+
+func runReplay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	currentFile := flags.String("current", "", "policy file in force when the requests were recorded (required)")
+	candidateFile := flags.String("candidate", "", "policy file to compare against (required)")
+	samples := flags.Int("samples", 5, "correlation IDs to list for each change")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: govctl replay -current policies.json -candidate policies.json [flags] [audit.log ...]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *currentFile == "" || *candidateFile == "" || *samples < 0 {
+		flags.Usage()
+		return exitError
+	}
+
+	current, err := loadEngine(*currentFile)
+	if err != nil {
+		return fail("%v", err)
+	}
+	candidate, err := loadEngine(*candidateFile)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	analysis := policy.NewImpactAnalysis(current, candidate, *samples)
+	skipped := 0
+	replay := func(record audit.Record) error {
+		req, ok := policy.RequestFromRecord(record)
+		if !ok {
+			if record.Outcome == "" {
+				skipped++
+			}
+			return nil
+		}
+		analysis.Add(req)
+		return nil
+	}
+
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, file := range files {
+		if err := readRecords(file, replay); err != nil {
+			return fail("%s: %v", file, err)
+		}
+	}
+
+	report := analysis.Report()
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		output := struct {
+			policy.ImpactReport
+			Skipped int `json:"skipped"`
+		}{report, skipped}
+		if err := encoder.Encode(output); err != nil {
+			return fail("%v", err)
+		}
+	} else {
+		printImpact(report, skipped)
+	}
+
+	if report.Replayed == 0 && skipped > 0 {
+		fmt.Fprintf(os.Stderr, "govctl: no record carried its context; set AUDIT_RECORD_CONTEXT=true in Orbit to record contexts for replay\n")
+	}
+	if len(report.Changes) > 0 {
+		return exitProblems
+	}
+	return exitOK
+}
+
+func loadEngine(path string) (*policy.Engine, error) {
+	policies, err := loadPolicies(path)
+	if err != nil {
+		return nil, err
+	}
+	engine, err := policy.NewEngine(zerolog.Nop(), policies)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return engine, nil
+}
+
+// readRecords reads audit records from a file, or from stdin for "-"
+func readRecords(path string, fn func(audit.Record) error) error {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	return audit.ReadRecords(r, fn)
+}
+
+func printImpact(report policy.ImpactReport, skipped int) {
+	for _, change := range report.Changes {
+		fmt.Printf("%s:%s %s -> %s (%d)\n", change.Service, change.Intent, change.From, change.To, change.Count)
+		fmt.Printf("    cause: %s\n", change.Cause)
+		if len(change.Samples) > 0 {
+			fmt.Printf("    samples: %v\n", change.Samples)
+		}
+	}
+	fmt.Printf("%d replayed, %d changed, %d unchanged, %d skipped without context\n",
+		report.Replayed, report.Changed(), report.Unchanged, skipped)
+}
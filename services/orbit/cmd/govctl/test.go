@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog"
+	"orbit-service/policy"
+)
+
+func runTest(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	policyFile := flags.String("policies", "", "policy file to test, overriding the policies named in each suite")
+	verbose := flags.Bool("v", false, "list passing cases too")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: govctl test [-policies policies.json] [-v] suite.yaml ...\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitError
+	}
+
+	passed, failed := 0, 0
+	for _, file := range flags.Args() {
+		data, err := readDocument(file)
+		if err != nil {
+			return fail("%v", err)
+		}
+		suite, err := policy.ParseSuite(data)
+		if err != nil {
+			return fail("%s: %v", file, err)
+		}
+		engine, err := suiteEngine(suite, file, *policyFile)
+		if err != nil {
+			return fail("%s: %v", file, err)
+		}
+
+		for _, result := range suite.Run(engine) {
+			if result.Passed() {
+				passed++
+				if *verbose {
+					fmt.Printf("PASS %s: %s\n", file, result.Case.Name)
+				}
+				continue
+			}
+
+			failed++
+			fmt.Printf("FAIL %s: %s\n", file, result.Case.Name)
+			for _, failure := range result.Failures {
+				fmt.Printf("    %s\n", failure)
+			}
+			printTrace(result.Trace, "    ")
+		}
+	}
+
+	fmt.Printf("%d passed, %d failed\n", passed, failed)
+	if failed > 0 {
+		return exitProblems
+	}
+	return exitOK
+}
+
+// suiteEngine loads the policies a suite runs against: the -policies file,
+// the file the suite names (relative to the suite) or the suite's inline
+// policies
+func suiteEngine(suite policy.Suite, suiteFile, override string) (*policy.Engine, error) {
+	var policies []policy.Policy
+	var err error
+	if path, ok := suite.PolicyPath(); override != "" || ok {
+		if override == "" {
+			path = filepath.Join(filepath.Dir(suiteFile), path)
+		} else {
+			path = override
+		}
+		policies, err = loadPolicies(path)
+	} else if len(suite.Policies) > 0 {
+		policies, err = policy.Parse(suite.Policies)
+	} else {
+		return nil, fmt.Errorf("suite has no policies; name a policy file with -policies")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return policy.NewEngine(zerolog.Nop(), policies)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"orbit-service/jsonschema"
+)
+
+func runValidate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	schemaFile := flags.String("schema", "", "policy schema (default: governance/policies/schema.json in this or a parent directory)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: govctl validate [-schema schema.json] policies.json ...\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitError
+	}
+
+	path := *schemaFile
+	if path == "" {
+		var err error
+		if path, err = findSchema(); err != nil {
+			return fail("%v", err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fail("%v", err)
+	}
+	schema, err := jsonschema.Compile(data)
+	if err != nil {
+		return fail("%s: %v", path, err)
+	}
+
+	problems := 0
+	for _, file := range flags.Args() {
+		found, err := validateFile(schema, file)
+		if err != nil {
+			return fail("%v", err)
+		}
+		problems += found
+	}
+
+	if problems > 0 {
+		fmt.Printf("FAILED: %d problems found\n", problems)
+		return exitProblems
+	}
+	fmt.Println("OK")
+	return exitOK
+}
+
+// validateFile checks every policy in a file against the schema, printing
+// each problem, and returns how many were found
+func validateFile(schema *jsonschema.Schema, path string) (int, error) {
+	data, err := readDocument(path)
+	if err != nil {
+		return 0, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var policies []interface{}
+	if err := decoder.Decode(&policies); err != nil {
+		return 0, fmt.Errorf("%s: policy file must be an array of policies: %w", path, err)
+	}
+
+	problems := 0
+	for i, p := range policies {
+		for _, e := range schema.Validate(p) {
+			fmt.Printf("%s: %s: %v\n", path, policyName(i, p), e)
+			problems++
+		}
+	}
+	return problems, nil
+}
+
+// policyName names a decoded policy as service:intent when it has both
+func policyName(i int, p interface{}) string {
+	fields, _ := p.(map[string]interface{})
+	service, _ := fields["service"].(string)
+	intent, _ := fields["intent"].(string)
+	if service == "" || intent == "" {
+		return fmt.Sprintf("policy %d", i)
+	}
+	return service + ":" + intent
+}
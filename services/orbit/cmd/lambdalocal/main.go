@@ -0,0 +1,61 @@
+// Command lambdalocal stands in for the governance Lambda during local
+// development and tests. It serves the Lambda Invoke API
+// (POST /2015-03-31/functions/{name}/invocations) and decides requests with
+// Orbit's in-process policy engine, so Orbit's Lambda governance client runs
+// unchanged without AWS:
+//
+//	go run ./cmd/lambdalocal -policies ../../governance/policies/default.json
+//	GOVERNANCE_ENDPOINT=http://localhost:9001 GOVERNANCE_FUNCTION_NAME=governance go run .
+//
+// Without -policies the policy snapshot built into Orbit is used. Requests
+// for any function name are served unless -function is given.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/rs/zerolog"
+	"orbit-service/policy"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9001", "address to listen on")
+	policyFile := flag.String("policies", "", "policy file (default: Orbit's built-in policy snapshot)")
+	functionName := flag.String("function", "", "only serve this function name")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: lambdalocal [-addr host:port] [-policies policies.json] [-function name]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	logger := zerolog.New(os.Stdout).With().
+		Timestamp().
+		Str("service", "governance-local").
+		Logger()
+
+	engine, err := newEngine(logger, *policyFile)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to load policies")
+		os.Exit(1)
+	}
+
+	logger.Info().Str("addr", *addr).Str("policies", *policyFile).Msg("lambda_stand_in_starting")
+	if err := http.ListenAndServe(*addr, policy.InvokeHandler(logger, engine, *functionName)); err != nil {
+		logger.Error().Err(err).Msg("server_error")
+		os.Exit(1)
+	}
+}
+
+func newEngine(logger zerolog.Logger, path string) (*policy.Engine, error) {
+	if path == "" {
+		return policy.NewSnapshotEngine(logger)
+	}
+	policies, err := policy.LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return policy.NewEngine(logger, policies)
+}
@@ -0,0 +1,66 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// AgentTurn is the input of a reasoning request made for a step of an
+// agent run: the run's goal and what its earlier steps did. The reasoner
+// answers with an AgentDecision.
+type AgentTurn struct {
+	Goal  json.RawMessage `json:"goal"`
+	Steps []AgentStep     `json:"steps"`
+	// RemainingSteps is how many more steps the run may take, this one
+	// included
+	RemainingSteps int `json:"remaining_steps"`
+}
+
+// AgentStep is an action an agent run took and what came of it
+type AgentStep struct {
+	Action AgentAction `json:"action"`
+	// Status is success, denied, throttled or error
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+	// Observation is the action's output, when it succeeded
+	Observation json.RawMessage `json:"observation,omitempty"`
+}
+
+// AgentAction is an action a reasoner proposes: a request to the service
+// serving Intent
+type AgentAction struct {
+	Intent string          `json:"intent"`
+	Input  json.RawMessage `json:"input,omitempty"`
+}
+
+// AgentDecision is a reasoner's output for an agent turn: the next action,
+// or the run's final answer
+type AgentDecision struct {
+	Action *AgentAction    `json:"action,omitempty"`
+	Final  json.RawMessage `json:"final,omitempty"`
+}
+
+// ParseAgentDecision reads a reasoner's output for an agent turn. Output
+// proposing no action and giving no final answer is taken as the final
+// answer, so reasoners unaware of agent runs finish them in one step.
+func ParseAgentDecision(output json.RawMessage) (AgentDecision, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(output, &fields); err != nil {
+		// Not an object, so no action either
+		return AgentDecision{Final: output}, nil
+	}
+	var decision AgentDecision
+	if err := json.Unmarshal(output, &decision); err != nil {
+		return AgentDecision{}, fmt.Errorf("invalid agent decision: %w", err)
+	}
+	if decision.Action != nil && len(decision.Final) > 0 {
+		return AgentDecision{}, fmt.Errorf("output proposes an action and gives a final answer")
+	}
+	if decision.Action != nil && decision.Action.Intent == "" {
+		return AgentDecision{}, fmt.Errorf("proposed action has no intent")
+	}
+	if decision.Action == nil && len(decision.Final) == 0 {
+		decision.Final = output
+	}
+	return decision, nil
+}
@@ -0,0 +1,18 @@
+package contract
+
+import "time"
+
+// DeadlineHeader carries the absolute time by which the caller needs a
+// response. Orbit sets it from its request context and includes it in the
+// SigV4 signature; Axon turns it into a context deadline.
+const DeadlineHeader = "X-Request-Deadline"
+
+// FormatDeadline renders a deadline for DeadlineHeader
+func FormatDeadline(deadline time.Time) string {
+	return deadline.UTC().Format(time.RFC3339Nano)
+}
+
+// ParseDeadline parses a DeadlineHeader value
+func ParseDeadline(value string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, value)
+}
@@ -0,0 +1,65 @@
+// Package contract defines the versioned request and response types exchanged
+// between Orbit and Axon on the /reason endpoint.
+//
+// The same definitions live in axon-service/contract; the two copies must be
+// kept in sync and any breaking change must bump Version.
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Version is the current reasoning contract version
+const Version = "v1"
+
+// ReasonRequest is the body Orbit POSTs to Axon's /reason endpoint
+type ReasonRequest struct {
+	Version    string                 `json:"version"`
+	Input      json.RawMessage        `json:"input"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Metadata   map[string]string      `json:"metadata,omitempty"`
+}
+
+// ParameterMaxTokens is the Parameters entry limiting the output tokens a
+// reasoner may produce
+const ParameterMaxTokens = "max_tokens"
+
+// Usage reports the amount of work performed for a reasoning request
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// Timing reports when Axon started and finished a reasoning request
+type Timing struct {
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+	DurationMs  int64     `json:"duration_ms"`
+}
+
+// ReasonResponse is the body Axon returns for a successful reasoning request
+type ReasonResponse struct {
+	Version string          `json:"version"`
+	Output  json.RawMessage `json:"output"`
+	Usage   Usage           `json:"usage"`
+	Model   string          `json:"model"`
+	Timing  Timing          `json:"timing"`
+}
+
+// Validate checks that the request targets this contract version and carries
+// a well-formed JSON input payload
+func (r ReasonRequest) Validate() error {
+	if r.Version != Version {
+		return fmt.Errorf("unsupported contract version %q, expected %q", r.Version, Version)
+	}
+	if len(r.Input) == 0 {
+		return fmt.Errorf("input is required")
+	}
+	if !json.Valid(r.Input) {
+		return fmt.Errorf("input must be valid JSON")
+	}
+	return nil
+}
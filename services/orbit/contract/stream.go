@@ -0,0 +1,58 @@
+package contract
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// StreamContentType is the media type used for streamed reasoning responses
+const StreamContentType = "text/event-stream"
+
+// Stream event types. Every stream ends with exactly one terminal event,
+// either EventDone or EventError.
+const (
+	EventDelta     = "delta"
+	EventHeartbeat = "heartbeat"
+	EventDone      = "done"
+	EventError     = "error"
+)
+
+// StreamEvent is a single Server-Sent Event emitted while a reasoning request
+// is streamed
+type StreamEvent struct {
+	ID            int64           `json:"id"`
+	Type          string          `json:"type"`
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	Delta         string          `json:"delta,omitempty"`
+	Response      *ReasonResponse `json:"response,omitempty"`
+	Status        string          `json:"status,omitempty"`
+	Error         string          `json:"error,omitempty"`
+}
+
+// StoppedEvent is the terminal error event for a stream stopped before it
+// finished: status timeout when err is a deadline expiry, and cancelled
+// otherwise
+func StoppedEvent(err error) StreamEvent {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return StreamEvent{Type: EventError, Status: "timeout", Error: "stream deadline exceeded"}
+	}
+	return StreamEvent{Type: EventError, Status: "cancelled", Error: "stream cancelled"}
+}
+
+// IsTerminal reports whether the event ends the stream
+func (e StreamEvent) IsTerminal() bool {
+	return e.Type == EventDone || e.Type == EventError
+}
+
+// WriteSSE encodes the event in Server-Sent Events wire format
+func WriteSSE(w io.Writer, event StreamEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal stream event: %w", err)
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
@@ -6,6 +6,7 @@ require (
 	github.com/aws/aws-sdk-go v1.44.122
 	github.com/gorilla/mux v1.8.0
 	github.com/rs/zerolog v1.31.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,388 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"orbit-service/approval"
+	"orbit-service/clients"
+	"orbit-service/contract"
+	"orbit-service/middleware"
+)
+
+// ApprovalStatusResponse is what the requester sees of a held dispatch
+type ApprovalStatusResponse struct {
+	ApprovalID string                   `json:"approval_id"`
+	Status     string                   `json:"status"`
+	Reason     string                   `json:"reason,omitempty"`
+	ExpiresAt  time.Time                `json:"expires_at"`
+	DecidedAt  *time.Time               `json:"decided_at,omitempty"`
+	Result     *contract.ReasonResponse `json:"result,omitempty"`
+	Error      string                   `json:"error,omitempty"`
+	Timestamp  time.Time                `json:"timestamp"`
+}
+
+// ApprovalDecisionRequest is the optional body of an approve or reject
+// request
+type ApprovalDecisionRequest struct {
+	Comment string `json:"comment,omitempty"`
+}
+
+// holdForApproval stores a dispatch whose policy requires approval and
+// answers 202 with the approval's ID. The obligations are checked now, so a
+// request that cannot be served is refused rather than approved in vain.
+func holdForApproval(w http.ResponseWriter, r *http.Request, logger zerolog.Logger, opts DispatchOptions, req clients.GovernanceRequest, decision clients.GovernanceResponse, dispatchReq DispatchRequest, correlationID string) {
+	if opts.Approvals == nil {
+		logger.Warn().
+			Str("correlation_id", correlationID).
+			Msg("approval_unavailable")
+
+		writeDispatchResponse(w, http.StatusForbidden, DispatchResponse{
+			Status: "denied",
+			Reason: "Approval is not available",
+		})
+		return
+	}
+
+	// Only an authenticated requester can be told apart from the approver
+	caller, ok := middleware.GetCaller(r.Context())
+	if !ok || caller.ID == "" {
+		logger.Warn().
+			Str("correlation_id", correlationID).
+			Msg("approval_anonymous_request_refused")
+
+		writeDispatchResponse(w, http.StatusForbidden, DispatchResponse{
+			Status: "denied",
+			Reason: "Requests requiring approval must come from an authenticated caller",
+		})
+		return
+	}
+
+	obligations, ok := checkObligations(w, logger, opts, decision, dispatchReq.Input, false, correlationID)
+	if !ok {
+		return
+	}
+	input, err := redactFields(dispatchReq.Input, obligations.RedactFields)
+	if err != nil {
+		logger.Error().
+			Err(err).
+			Str("correlation_id", correlationID).
+			Msg("dispatch_redaction_failed")
+
+		writeDispatchResponse(w, http.StatusInternalServerError, DispatchResponse{
+			Status: "error",
+			Reason: "Failed to apply governance obligations",
+		})
+		return
+	}
+
+	held := approval.Approval{
+		CorrelationID: correlationID,
+		Service:       req.Service,
+		Intent:        req.Intent,
+		Context:       req.Context,
+		Reason:        decision.Reason,
+		PolicyVersion: decision.PolicyVersion,
+		Obligations:   decision.Obligations,
+		Input:         input,
+		Parameters:    dispatchReq.Parameters,
+		Metadata:      dispatchReq.Metadata,
+		Principal:     caller.ID,
+	}
+
+	held, err = opts.Approvals.Request(r.Context(), held)
+	if err != nil {
+		logger.Error().
+			Err(err).
+			Str("correlation_id", correlationID).
+			Msg("approval_request_failed")
+
+		writeDispatchResponse(w, http.StatusInternalServerError, DispatchResponse{
+			Status: "error",
+			Reason: "Failed to request approval",
+		})
+		return
+	}
+
+	w.Header().Set("Location", "/dispatch/approvals/"+held.ID)
+	writeDispatchResponse(w, http.StatusAccepted, DispatchResponse{
+		Status:     "pending_approval",
+		Reason:     decision.Reason,
+		Degraded:   decision.Degraded,
+		ApprovalID: held.ID,
+	})
+}
+
+// ApprovalStatusHandler lets the requester of a held dispatch follow it and
+// collect its result. Approvals of another caller are reported as missing.
+func ApprovalStatusHandler(logger zerolog.Logger, manager *approval.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		correlationID := middleware.GetCorrelationID(r.Context())
+
+		a, err := manager.Get(r.Context(), mux.Vars(r)["id"])
+		if err == nil && a.Principal != "" {
+			caller, _ := middleware.GetCaller(r.Context())
+			if caller.ID != a.Principal {
+				err = approval.ErrNotFound
+			}
+		}
+		if err != nil {
+			writeApprovalError(w, logger, err, correlationID)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(ApprovalStatusResponse{
+			ApprovalID: a.ID,
+			Status:     a.Status,
+			Reason:     a.Reason,
+			ExpiresAt:  a.ExpiresAt,
+			DecidedAt:  a.DecidedAt,
+			Result:     a.Result,
+			Error:      a.Error,
+			Timestamp:  time.Now(),
+		})
+	}
+}
+
+// ApprovalAdminHandler returns a held dispatch, input included, for an
+// approver to review. The caller must be allowed admin/manage_policies.
+func ApprovalAdminHandler(logger zerolog.Logger, manager *approval.Manager, authorizer clients.GovernanceChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		correlationID := middleware.GetCorrelationID(r.Context())
+		if !authorizeAdmin(w, r, logger, authorizer, correlationID) {
+			return
+		}
+
+		a, err := manager.Get(r.Context(), mux.Vars(r)["id"])
+		if err != nil {
+			writeApprovalError(w, logger, err, correlationID)
+			return
+		}
+		writeApproval(w, a)
+	}
+}
+
+// ApprovalRejectHandler rejects a held dispatch, which is then never sent to
+// Axon. The caller must be allowed admin/manage_policies.
+func ApprovalRejectHandler(logger zerolog.Logger, manager *approval.Manager, authorizer clients.GovernanceChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		correlationID := middleware.GetCorrelationID(r.Context())
+		if !authorizeAdmin(w, r, logger, authorizer, correlationID) {
+			return
+		}
+
+		body, ok := readApprovalDecision(w, r)
+		if !ok {
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+		held, err := manager.Get(r.Context(), id)
+		if err != nil {
+			writeApprovalError(w, logger, err, correlationID)
+			return
+		}
+		approver, ok := checkDecider(w, r, logger, held, correlationID)
+		if !ok {
+			return
+		}
+
+		a, err := manager.Reject(r.Context(), id, approver, body.Comment)
+		if err != nil {
+			writeApprovalError(w, logger, err, correlationID)
+			return
+		}
+		writeApproval(w, a)
+	}
+}
+
+// ApprovalApproveHandler approves a held dispatch and resumes it in the
+// background under the obligations it was held with; the requester collects
+// the result from the approval. The caller must be allowed
+// admin/manage_policies and may not approve their own request.
+func ApprovalApproveHandler(logger zerolog.Logger, manager *approval.Manager, authorizer clients.GovernanceChecker, axonClient clients.AxonCaller, opts DispatchOptions) http.HandlerFunc {
+	pipeline := newPipeline(logger, nil, axonClient, opts)
+	return func(w http.ResponseWriter, r *http.Request) {
+		correlationID := middleware.GetCorrelationID(r.Context())
+		if !authorizeAdmin(w, r, logger, authorizer, correlationID) {
+			return
+		}
+
+		body, ok := readApprovalDecision(w, r)
+		if !ok {
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+		held, err := manager.Get(r.Context(), id)
+		if err != nil {
+			writeApprovalError(w, logger, err, correlationID)
+			return
+		}
+		approver, ok := checkDecider(w, r, logger, held, correlationID)
+		if !ok {
+			return
+		}
+		if held.Principal == approver {
+			logger.Warn().
+				Str("correlation_id", correlationID).
+				Str("approval_id", id).
+				Msg("approval_self_approval_refused")
+
+			writeDispatchResponse(w, http.StatusForbidden, DispatchResponse{
+				Status: "denied",
+				Reason: "Requests cannot be approved by their requester",
+			})
+			return
+		}
+
+		a, err := manager.Approve(r.Context(), id, approver, body.Comment)
+		if err != nil {
+			writeApprovalError(w, logger, err, correlationID)
+			return
+		}
+
+		manager.Go(func() {
+			resumeDispatch(logger, manager, pipeline, axonClient, opts, a)
+		})
+		writeApproval(w, a)
+	}
+}
+
+// dispatchRequest returns the dispatch an approval holds
+func dispatchRequest(a approval.Approval) DispatchRequest {
+	return DispatchRequest{Intent: a.Intent, Input: a.Input, Parameters: a.Parameters, Metadata: a.Metadata}
+}
+
+// resumeDispatch runs an approved dispatch through the pipeline, sending it
+// to the service routed for its intent, and records its result on the
+// approval
+func resumeDispatch(logger zerolog.Logger, manager *approval.Manager, pipeline *Pipeline, axonClient clients.AxonCaller, opts DispatchOptions, a approval.Approval) {
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Budget.Total-opts.Budget.Governance)
+	defer cancel()
+
+	var result *contract.ReasonResponse
+	var err error
+	if caller, ok := routeIntent(opts, axonClient, a.Intent); ok {
+		result, _, err = pipeline.runResumed(&Dispatch{
+			HTTP:          resumedRequest(ctx, "/dispatch/approvals/"+a.ID, a.Principal),
+			CorrelationID: a.CorrelationID,
+			Mode:          DispatchModeSync,
+			Request:       dispatchRequest(a),
+			Caller:        caller,
+			Governance:    clients.GovernanceRequest{Service: a.Service, Intent: a.Intent, Context: a.Context},
+			Decision: clients.GovernanceResponse{
+				Allowed:       true,
+				Reason:        fmt.Sprintf("Approved by %s", a.DecidedBy),
+				PolicyVersion: a.PolicyVersion,
+				Obligations:   a.Obligations,
+			},
+			Resumed: true,
+		})
+	} else {
+		// The route table changed while the dispatch was held
+		err = fmt.Errorf("no route for intent %s", a.Intent)
+	}
+
+	if _, completeErr := manager.Complete(context.Background(), a.ID, result, err); completeErr != nil {
+		logger.Error().
+			Err(completeErr).
+			Str("correlation_id", a.CorrelationID).
+			Str("approval_id", a.ID).
+			Msg("approval_completion_failed")
+		return
+	}
+
+	event := logger.Info()
+	if err != nil {
+		event = logger.Error().Err(err)
+	}
+	event.
+		Str("correlation_id", a.CorrelationID).
+		Str("approval_id", a.ID).
+		Msg("approved_dispatch_completed")
+}
+
+// checkDecider returns the administrator deciding an approval, refusing the
+// decision when either they or the requester are anonymous: neither could
+// be held to it, nor told apart from the other
+func checkDecider(w http.ResponseWriter, r *http.Request, logger zerolog.Logger, held approval.Approval, correlationID string) (string, bool) {
+	caller, _ := middleware.GetCaller(r.Context())
+	if caller.ID != "" && held.Principal != "" {
+		return caller.ID, true
+	}
+
+	logger.Warn().
+		Str("correlation_id", correlationID).
+		Str("approval_id", held.ID).
+		Bool("anonymous_approver", caller.ID == "").
+		Bool("anonymous_requester", held.Principal == "").
+		Msg("approval_anonymous_decision_refused")
+
+	writeDispatchResponse(w, http.StatusForbidden, DispatchResponse{
+		Status: "denied",
+		Reason: "Approvals must be requested and decided by authenticated callers",
+	})
+	return "", false
+}
+
+func readApprovalDecision(w http.ResponseWriter, r *http.Request) (ApprovalDecisionRequest, bool) {
+	var body ApprovalDecisionRequest
+	if r.Body != nil && r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeDispatchResponse(w, http.StatusBadRequest, DispatchResponse{
+				Status: "error",
+				Reason: "Request body must be valid JSON",
+			})
+			return body, false
+		}
+	}
+	return body, true
+}
+
+func writeApproval(w http.ResponseWriter, a approval.Approval) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a)
+}
+
+// writeApprovalError maps approval errors to responses: 404 for unknown
+// approvals, 410 for expired ones and 409 for ones already decided
+func writeApprovalError(w http.ResponseWriter, logger zerolog.Logger, err error, correlationID string) {
+	var statusErr *approval.StatusError
+	switch {
+	case errors.Is(err, approval.ErrNotFound):
+		writeDispatchResponse(w, http.StatusNotFound, DispatchResponse{
+			Status: "error",
+			Reason: "Approval not found",
+		})
+	case errors.As(err, &statusErr) && statusErr.Status == approval.StatusExpired:
+		writeDispatchResponse(w, http.StatusGone, DispatchResponse{
+			Status: "error",
+			Reason: "Approval has expired",
+		})
+	case errors.As(err, &statusErr):
+		writeDispatchResponse(w, http.StatusConflict, DispatchResponse{
+			Status: "error",
+			Reason: fmt.Sprintf("Approval is already %s", statusErr.Status),
+		})
+	default:
+		logger.Error().
+			Err(err).
+			Str("correlation_id", correlationID).
+			Msg("approval_store_failed")
+
+		writeDispatchResponse(w, http.StatusInternalServerError, DispatchResponse{
+			Status: "error",
+			Reason: "Approval could not be updated",
+		})
+	}
+}
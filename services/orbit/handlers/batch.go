@@ -0,0 +1,464 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+	"orbit-service/contract"
+	"orbit-service/middleware"
+)
+
+// Batch statuses. A batch is aborted when it is all-or-nothing and an item
+// is not allowed; otherwise it reports how many of its items succeeded.
+const (
+	BatchStatusSucceeded = "succeeded"
+	BatchStatusPartial   = "partial"
+	BatchStatusFailed    = "failed"
+	BatchStatusAborted   = "aborted"
+)
+
+// BatchItemSkipped is the status of an allowed item not run because its
+// all-or-nothing batch was aborted
+const BatchItemSkipped = "skipped"
+
+//go:embed dispatch_batch.schema.json
+var batchRequestSchema []byte
+
+var batchSchema = mustCompile(batchRequestSchema)
+
+// BatchConfig bounds batch dispatches
+type BatchConfig struct {
+	// MaxItems bounds the number of items in a batch
+	MaxItems int
+	// Parallelism bounds the items of a batch run at once
+	Parallelism int
+	// MaxBodyBytes bounds the batch request body; each item is also bounded
+	// by the dispatch body limit
+	MaxBodyBytes int64
+	// Timeout bounds the whole batch. Each item is bounded by the dispatch
+	// budget, and items not started in time fail with dispatch_timeout.
+	Timeout time.Duration
+}
+
+// DefaultBatchConfig returns the settings used when none are configured
+func DefaultBatchConfig() BatchConfig {
+	return BatchConfig{
+		MaxItems:     100,
+		Parallelism:  8,
+		MaxBodyBytes: 8 << 20,
+		Timeout:      50 * time.Second,
+	}
+}
+
+// batchConfigFromEnv returns the default config overridden by
+// DISPATCH_BATCH_MAX_ITEMS, DISPATCH_BATCH_PARALLELISM,
+// DISPATCH_BATCH_MAX_BODY_BYTES and DISPATCH_BATCH_TIMEOUT (Go duration
+// string)
+func batchConfigFromEnv() (BatchConfig, error) {
+	config := DefaultBatchConfig()
+
+	counts := []struct {
+		name string
+		into *int
+	}{
+		{"DISPATCH_BATCH_MAX_ITEMS", &config.MaxItems},
+		{"DISPATCH_BATCH_PARALLELISM", &config.Parallelism},
+	}
+	for _, c := range counts {
+		if value := os.Getenv(c.name); value != "" {
+			count, err := strconv.Atoi(value)
+			if err != nil || count <= 0 {
+				return config, fmt.Errorf("invalid %s %q", c.name, value)
+			}
+			*c.into = count
+		}
+	}
+
+	if value := os.Getenv("DISPATCH_BATCH_MAX_BODY_BYTES"); value != "" {
+		maxBytes, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || maxBytes <= 0 {
+			return config, fmt.Errorf("invalid DISPATCH_BATCH_MAX_BODY_BYTES %q", value)
+		}
+		config.MaxBodyBytes = maxBytes
+	}
+
+	if value := os.Getenv("DISPATCH_BATCH_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return config, fmt.Errorf("invalid DISPATCH_BATCH_TIMEOUT %q", value)
+		}
+		config.Timeout = timeout
+	}
+
+	return config, nil
+}
+
+// BatchRequest is the body of POST /dispatch/batch
+type BatchRequest struct {
+	Items []json.RawMessage `json:"items"`
+	// AllOrNothing runs governance for every item, then spends rate limits
+	// item by item, before running any, and runs none unless every item is
+	// allowed
+	AllOrNothing bool `json:"all_or_nothing,omitempty"`
+}
+
+// BatchResponse reports the outcome of each item of a batch
+type BatchResponse struct {
+	Status    string              `json:"status"`
+	Succeeded int                 `json:"succeeded"`
+	Failed    int                 `json:"failed"`
+	Items     []BatchItemResponse `json:"items"`
+	Timestamp time.Time           `json:"timestamp"`
+}
+
+// BatchItemResponse is the outcome of one item, reported with the status
+// and body fields its own dispatch would have been answered with
+type BatchItemResponse struct {
+	Index int `json:"index"`
+	// Status is success, denied, throttled, error or skipped
+	Status string `json:"status"`
+	// StatusCode is the HTTP status the item would have been answered with
+	StatusCode int                      `json:"status_code,omitempty"`
+	Reason     string                   `json:"reason,omitempty"`
+	Result     *contract.ReasonResponse `json:"result,omitempty"`
+	Degraded   string                   `json:"degraded,omitempty"`
+	// Error classifies a failed item, as the problem body of a failed
+	// dispatch does
+	Error *ProblemError `json:"error,omitempty"`
+	// RetryAfter is how many seconds to wait before retrying the item
+	RetryAfter    int    `json:"retry_after,omitempty"`
+	CorrelationID string `json:"correlation_id"`
+}
+
+// batchItem is one dispatch of a batch as it runs through the pipeline,
+// answered in memory
+type batchItem struct {
+	index    int
+	dispatch *Dispatch
+	response *dispatchRecorder
+	cancel   context.CancelFunc
+}
+
+// start bounds the item by the dispatch budget, within the deadline of r
+func (item *batchItem) start(r *http.Request, budget time.Duration) {
+	ctx, cancel := context.WithTimeout(r.Context(), budget)
+	item.dispatch.HTTP = r.WithContext(ctx)
+	item.cancel = cancel
+}
+
+// result reports the item with the status and body fields its dispatch
+// was answered with
+func (item *batchItem) result() BatchItemResponse {
+	return recordedResponse(item.index, item.dispatch.CorrelationID, item.response)
+}
+
+// recordedResponse reads a dispatch's recorded response, a dispatch
+// response or a problem, back into an item outcome
+func recordedResponse(index int, correlationID string, rec *dispatchRecorder) BatchItemResponse {
+	result := BatchItemResponse{
+		Index:         index,
+		StatusCode:    rec.status,
+		Degraded:      rec.header.Get(GovernanceDegradedHeader),
+		CorrelationID: correlationID,
+	}
+	if seconds, err := strconv.Atoi(rec.header.Get("Retry-After")); err == nil {
+		result.RetryAfter = seconds
+	}
+
+	if rec.header.Get("Content-Type") == ProblemContentType {
+		var problem Problem
+		json.Unmarshal(rec.body.Bytes(), &problem)
+		result.Status = "error"
+		result.Reason = problem.Title
+		result.Error = &problem.Error
+		return result
+	}
+
+	var response DispatchResponse
+	json.Unmarshal(rec.body.Bytes(), &response)
+	result.Status = response.Status
+	result.Reason = response.Reason
+	result.Result = response.Result
+	return result
+}
+
+// DispatchBatchHandler handles batch dispatches. Each item is governed and
+// run as a synchronous dispatch of its own, at most Batch.Parallelism at a
+// time, and the response reports every item's outcome. Items cannot ask
+// for approval, be streamed or run asynchronously.
+func DispatchBatchHandler(logger zerolog.Logger, governanceClient clients.GovernanceChecker, axonClient clients.AxonCaller, opts DispatchOptions) http.HandlerFunc {
+	pipeline := newPipeline(logger, governanceClient, axonClient, opts)
+	batch := func(w http.ResponseWriter, r *http.Request) {
+		correlationID := middleware.GetCorrelationID(r.Context())
+
+		if mode, _ := dispatchMode(r); mode != DispatchModeSync || wantsStream(r) {
+			writeDispatchResponse(w, http.StatusBadRequest, DispatchResponse{
+				Status: "error",
+				Reason: "Batch dispatches are run synchronously and cannot be streamed",
+			})
+			return
+		}
+
+		batchReq, items, bodyErr := readBatchRequest(r, opts, axonClient, correlationID)
+		if bodyErr != nil {
+			logger.Warn().
+				Str("correlation_id", correlationID).
+				Str("reason", bodyErr.reason).
+				Int("invalid_fields", len(bodyErr.fields)).
+				Msg("dispatch_batch_invalid_body")
+
+			writeDispatchResponse(w, bodyErr.status, DispatchResponse{
+				Status: "error",
+				Reason: bodyErr.reason,
+				Errors: bodyErr.fields,
+			})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), opts.Batch.Timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+		defer func() {
+			for _, item := range items {
+				if item.cancel != nil {
+					item.cancel()
+				}
+			}
+		}()
+
+		results := make([]BatchItemResponse, len(items))
+		// admit passes an item through the pipeline's Before hooks up to
+		// the stage named, starting it first. An item the batch's deadline
+		// left no time to start fails with dispatch_timeout.
+		admit := func(i int, through string) bool {
+			item := &items[i]
+			if item.dispatch.HTTP == nil {
+				if r.Context().Err() != nil {
+					results[i] = batchTimeout(item)
+					return false
+				}
+				item.start(r, opts.Budget.Total)
+			}
+			if !pipeline.Admit(item.response, item.dispatch, through) {
+				results[i] = item.result()
+				return false
+			}
+			return true
+		}
+		run := func(i int) {
+			if admit(i, "") {
+				pipeline.Run(items[i].response, items[i].dispatch)
+				results[i] = items[i].result()
+			}
+		}
+
+		status := ""
+		if batchReq.AllOrNothing {
+			// Every item is governed before any rate limit is spent, and
+			// rate limits are spent in item order until one is refused, so a
+			// refused batch is charged only for the items checked before the
+			// refusal
+			governed := make([]bool, len(items))
+			forEachBatchItem(opts.Batch.Parallelism, len(items), func(i int) {
+				governed[i] = admit(i, StageObligations)
+			})
+			for i := range items {
+				if !governed[i] {
+					status = BatchStatusAborted
+				}
+			}
+			for i := range items {
+				if status == BatchStatusAborted {
+					break
+				}
+				if !admit(i, StageRateLimit) {
+					status = BatchStatusAborted
+				}
+			}
+			if status == BatchStatusAborted {
+				for i := range items {
+					if governed[i] && results[i].Status == "" {
+						results[i] = BatchItemResponse{Index: i, Status: BatchItemSkipped, CorrelationID: items[i].dispatch.CorrelationID}
+					}
+				}
+			} else {
+				forEachBatchItem(opts.Batch.Parallelism, len(items), run)
+			}
+		} else {
+			forEachBatchItem(opts.Batch.Parallelism, len(items), run)
+		}
+
+		response := BatchResponse{Status: status, Items: results}
+		for _, result := range results {
+			if result.Status == "success" {
+				response.Succeeded++
+			} else if result.Status != BatchItemSkipped {
+				response.Failed++
+			}
+		}
+		if response.Status == "" {
+			switch {
+			case response.Failed == 0:
+				response.Status = BatchStatusSucceeded
+			case response.Succeeded == 0:
+				response.Status = BatchStatusFailed
+			default:
+				response.Status = BatchStatusPartial
+			}
+		}
+
+		logger.Info().
+			Str("correlation_id", correlationID).
+			Str("status", response.Status).
+			Int("items", len(items)).
+			Int("succeeded", response.Succeeded).
+			Int("failed", response.Failed).
+			Bool("all_or_nothing", batchReq.AllOrNothing).
+			Msg("dispatch_batch_completed")
+
+		response.Timestamp = time.Now()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+
+	if opts.Idempotency == nil {
+		return batch
+	}
+	return withIdempotency(logger, opts, opts.Batch.MaxBodyBytes, batch)
+}
+
+// readBatchRequest reads and validates a batch body and each of its items.
+// An invalid item rejects the whole batch, with field errors pointing into
+// the items array.
+func readBatchRequest(r *http.Request, opts DispatchOptions, axonClient clients.AxonCaller, correlationID string) (BatchRequest, []batchItem, *dispatchBodyError) {
+	invalid := func(fields ...FieldError) (BatchRequest, []batchItem, *dispatchBodyError) {
+		return BatchRequest{}, nil, &dispatchBodyError{status: http.StatusBadRequest, reason: "Request body is invalid", fields: fields}
+	}
+
+	var body []byte
+	if r.Body != nil {
+		data, err := io.ReadAll(io.LimitReader(r.Body, opts.Batch.MaxBodyBytes+1))
+		if err != nil {
+			return BatchRequest{}, nil, &dispatchBodyError{status: http.StatusBadRequest, reason: "Request body could not be read"}
+		}
+		if int64(len(data)) > opts.Batch.MaxBodyBytes {
+			return BatchRequest{}, nil, &dispatchBodyError{
+				status: http.StatusRequestEntityTooLarge,
+				reason: fmt.Sprintf("Request body must not exceed %d bytes", opts.Batch.MaxBodyBytes),
+			}
+		}
+		body = bytes.TrimSpace(data)
+	}
+
+	var document interface{}
+	if err := decodeJSONNumbers(body, &document); err != nil {
+		return BatchRequest{}, nil, &dispatchBodyError{status: http.StatusBadRequest, reason: "Request body must be valid JSON"}
+	}
+	if fields := validateDocument(batchSchema, document); len(fields) > 0 {
+		return invalid(fields...)
+	}
+
+	var batchReq BatchRequest
+	if err := json.Unmarshal(body, &batchReq); err != nil {
+		return invalid()
+	}
+	if len(batchReq.Items) > opts.Batch.MaxItems {
+		return invalid(FieldError{Field: "/items", Message: fmt.Sprintf("must have at most %d items", opts.Batch.MaxItems)})
+	}
+
+	items := make([]batchItem, len(batchReq.Items))
+	var fields []FieldError
+	for i, raw := range batchReq.Items {
+		prefix := "/items/" + strconv.Itoa(i)
+		if int64(len(raw)) > opts.MaxBodyBytes {
+			fields = append(fields, FieldError{Field: prefix, Message: fmt.Sprintf("must not exceed %d bytes", opts.MaxBodyBytes)})
+			continue
+		}
+		req, itemErr := decodeDispatchRequest(raw)
+		if itemErr != nil {
+			for _, field := range itemErr.fields {
+				fields = append(fields, FieldError{Field: prefix + field.Field, Message: field.Message})
+			}
+			continue
+		}
+		caller, routed := routeIntent(opts, axonClient, req.Intent)
+		if !routed {
+			fields = append(fields, FieldError{Field: prefix + "/intent", Message: "no route for intent " + req.Intent})
+			continue
+		}
+		items[i] = batchItem{
+			index: i,
+			dispatch: &Dispatch{
+				CorrelationID: fmt.Sprintf("%s-%d", correlationID, i),
+				Mode:          DispatchModeSync,
+				Request:       req,
+				Body:          raw,
+				Caller:        caller,
+				Unapproved:    "Requests requiring approval cannot be batched",
+			},
+			response: newDispatchRecorder(),
+		}
+	}
+	if len(fields) > 0 {
+		return invalid(fields...)
+	}
+	return batchReq, items, nil
+}
+
+// forEachBatchItem calls fn for each of count items, at most parallelism
+// at a time, and returns once every call has
+func forEachBatchItem(parallelism, count int, fn func(i int)) {
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, parallelism)
+	for i := 0; i < count; i++ {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// batchProblem reports a failed item as its dispatch's problem body would
+func batchProblem(item *batchItem, problem dispatchProblem) BatchItemResponse {
+	return BatchItemResponse{
+		Index:      item.index,
+		Status:     "error",
+		StatusCode: problem.status,
+		Reason:     problem.title,
+		Degraded:   item.dispatch.Decision.Degraded,
+		Error: &ProblemError{
+			Code:      problem.code,
+			Retryable: problem.retryable,
+			Target:    problem.target,
+		},
+		RetryAfter:    ceilSeconds(problem.retryAfter),
+		CorrelationID: item.dispatch.CorrelationID,
+	}
+}
+
+// batchTimeout reports an item that ran out of time, or that the batch's
+// deadline left no time to start
+func batchTimeout(item *batchItem) BatchItemResponse {
+	return batchProblem(item, dispatchProblem{
+		status:    http.StatusGatewayTimeout,
+		code:      ErrorCodeDispatchTimeout,
+		title:     "Dispatch deadline exceeded",
+		retryable: true,
+	})
+}
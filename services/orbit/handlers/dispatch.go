@@ -2,101 +2,309 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"orbit-service/approval"
 	"orbit-service/clients"
+	"orbit-service/contract"
+	"orbit-service/idempotency"
+	"orbit-service/jobs"
 	"orbit-service/middleware"
+	"orbit-service/ratelimit"
+	"orbit-service/webhook"
 	"github.com/rs/zerolog"
 )
 
+var errInvalidJSON = errors.New("request body is not valid JSON")
+
+// GovernanceCacheHeader tells the caller whether the governance decision was
+// served from Orbit's decision cache ("hit") or freshly evaluated ("miss")
+const GovernanceCacheHeader = "X-Governance-Cache"
+
+// GovernanceDegradedHeader names the failure mode ("cached" or "local") that
+// decided the request while governance was unavailable
+const GovernanceDegradedHeader = "X-Governance-Degraded"
+
 type DispatchResponse struct {
-	Status    string    `json:"status"`
-	Message   string    `json:"message,omitempty"`
-	Reason    string    `json:"reason,omitempty"`
-	Timestamp time.Time `json:"timestamp"`
+	Status   string                   `json:"status"`
+	Message  string                   `json:"message,omitempty"`
+	Reason   string                   `json:"reason,omitempty"`
+	Result   *contract.ReasonResponse `json:"result,omitempty"`
+	Degraded string                   `json:"degraded,omitempty"`
+	// ApprovalID identifies a dispatch held for approval
+	ApprovalID string `json:"approval_id,omitempty"`
+	// JobID identifies an asynchronous dispatch
+	JobID string `json:"job_id,omitempty"`
+	// Errors lists the invalid fields of a rejected request body
+	Errors    []FieldError `json:"errors,omitempty"`
+	Timestamp time.Time    `json:"timestamp"`
 }
 
-// DispatchHandler handles dispatch requests
-func DispatchHandler(logger zerolog.Logger, governanceClient clients.GovernanceChecker, axonClient clients.AxonCaller) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		correlationID := middleware.GetCorrelationID(r.Context())
+// DispatchBudget splits the time allowed for a dispatch between its stages
+type DispatchBudget struct {
+	// Total bounds the whole dispatch, governance check included, streamed
+	// or not
+	Total time.Duration
+	// Governance bounds the governance check; Axon gets whatever remains of Total
+	Governance time.Duration
+}
+
+// DispatchOptions configures DispatchHandlerWithOptions
+type DispatchOptions struct {
+	Budget DispatchBudget
+	// MaxBodyBytes bounds the dispatch request body
+	MaxBodyBytes int64
+	// Context is the allowlist of attributes sent with the governance check
+	Context GovernanceContextConfig
+	// RateLimiter enforces the rate limits of the deciding policy; nil
+	// disables rate limiting
+	RateLimiter ratelimit.Limiter
+	// Auditor records dispatch outcomes for the full audit level. It must be
+	// set when governance decisions are audited; while nil, requests whose
+	// policy requires an audit level are refused.
+	Auditor AuditRecorder
+	// AuditTimeout bounds how long recording an outcome may wait on a
+	// backed-up auditor before the dispatch is refused as unaudited
+	AuditTimeout time.Duration
+	// Approvals holds dispatches whose policy requires approval; while nil,
+	// such requests are refused
+	Approvals *approval.Manager
+	// Routes selects the downstream service for each intent, and requests
+	// for intents it does not route are rejected. While nil, every intent
+	// is served by the handler's Axon client.
+	Routes clients.IntentRouter
+	// Jobs runs asynchronous dispatches; while nil, they are refused
+	Jobs *jobs.Pool
+	// Webhooks delivers the callbacks asynchronous dispatches ask for;
+	// while nil, dispatches asking for one are refused
+	Webhooks *webhook.Dispatcher
+	// Idempotency stores the responses of dispatches sent with an
+	// Idempotency-Key; while nil, the header is ignored
+	Idempotency       idempotency.Store
+	IdempotencyConfig idempotency.Config
+	// Batch bounds batch dispatches
+	Batch BatchConfig
+	// Pipeline orders the stages of POST /dispatch by name; while empty,
+	// the built-in stages run in DefaultPipeline order
+	Pipeline []string
+	// Stages are the stages the pipeline may name besides the built-in ones
+	Stages map[string]Stage
+}
 
-		// Step 1: Check governance
-		governanceReq := clients.GovernanceRequest{
-			Service: "orbit",
-			Intent:  "call_reasoning",
+// DefaultDispatchOptions returns the options used by DispatchHandler
+func DefaultDispatchOptions() DispatchOptions {
+	return DispatchOptions{
+		Budget: DispatchBudget{
+			Total:      25 * time.Second,
+			Governance: 5 * time.Second,
+		},
+		MaxBodyBytes:      DefaultMaxDispatchBodyBytes,
+		AuditTimeout:      2 * time.Second,
+		Context:           DefaultGovernanceContextConfig(),
+		IdempotencyConfig: idempotency.DefaultConfig(),
+		Batch:             DefaultBatchConfig(),
+	}
+}
+
+// DispatchOptionsFromEnv returns the default options overridden by
+// DISPATCH_TIMEOUT and DISPATCH_GOVERNANCE_TIMEOUT (Go duration strings),
+// DISPATCH_MAX_BODY_BYTES, GOVERNANCE_CONTEXT_CONFIG (path to a context
+// allowlist file), DISPATCH_PIPELINE (comma-separated stage names) and the
+// DISPATCH_BATCH_* settings
+func DispatchOptionsFromEnv() (DispatchOptions, error) {
+	opts := DefaultDispatchOptions()
+
+	if value := os.Getenv("DISPATCH_TIMEOUT"); value != "" {
+		total, err := time.ParseDuration(value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid DISPATCH_TIMEOUT: %w", err)
 		}
+		opts.Budget.Total = total
+	}
 
-		allowed, reason, err := governanceClient.CheckPermission(governanceReq, correlationID)
+	if value := os.Getenv("DISPATCH_GOVERNANCE_TIMEOUT"); value != "" {
+		governance, err := time.ParseDuration(value)
 		if err != nil {
-			logger.Error().
-				Err(err).
-				Str("correlation_id", correlationID).
-				Msg("governance_check_failed")
-
-			response := DispatchResponse{
-				Status:    "error",
-				Reason:    "Governance check failed",
-				Timestamp: time.Now(),
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(response)
-			return
+			return opts, fmt.Errorf("invalid DISPATCH_GOVERNANCE_TIMEOUT: %w", err)
 		}
+		opts.Budget.Governance = governance
+	}
 
-		if !allowed {
-			logger.Warn().
-				Str("correlation_id", correlationID).
-				Str("reason", reason).
-				Msg("governance_denied")
-
-			response := DispatchResponse{
-				Status:    "denied",
-				Reason:    reason,
-				Timestamp: time.Now(),
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusForbidden)
-			json.NewEncoder(w).Encode(response)
-			return
+	if value := os.Getenv("DISPATCH_MAX_BODY_BYTES"); value != "" {
+		maxBytes, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || maxBytes <= 0 {
+			return opts, fmt.Errorf("invalid DISPATCH_MAX_BODY_BYTES %q", value)
 		}
+		opts.MaxBodyBytes = maxBytes
+	}
 
-		// Step 2: Call Axon service
-		axonResponse, err := axonClient.CallReason(r.Context(), correlationID)
+	if path := os.Getenv("GOVERNANCE_CONTEXT_CONFIG"); path != "" {
+		config, err := LoadGovernanceContextConfig(path)
 		if err != nil {
-			logger.Error().
-				Err(err).
-				Str("correlation_id", correlationID).
-				Msg("axon_call_failed")
-
-			response := DispatchResponse{
-				Status:    "error",
-				Reason:    "Failed to call Axon service",
-				Timestamp: time.Now(),
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(response)
-			return
+			return opts, err
 		}
+		opts.Context = config
+	}
 
-		// Step 3: Return successful response
-		response := DispatchResponse{
-			Status:    "success",
-			Message:   axonResponse,
-			Timestamp: time.Now(),
+	if value := os.Getenv("DISPATCH_PIPELINE"); value != "" {
+		for _, name := range strings.Split(value, ",") {
+			opts.Pipeline = append(opts.Pipeline, strings.TrimSpace(name))
 		}
+	}
+
+	if opts.Budget.Governance <= 0 || opts.Budget.Governance >= opts.Budget.Total {
+		return opts, fmt.Errorf("governance timeout %s must be positive and less than dispatch timeout %s",
+			opts.Budget.Governance, opts.Budget.Total)
+	}
+
+	batch, err := batchConfigFromEnv()
+	if err != nil {
+		return opts, err
+	}
+	if batch.Timeout < opts.Budget.Total {
+		return opts, fmt.Errorf("batch timeout %s must not be less than dispatch timeout %s",
+			batch.Timeout, opts.Budget.Total)
+	}
+	opts.Batch = batch
+
+	return opts, nil
+}
+
+// DispatchHandler handles dispatch requests
+func DispatchHandler(logger zerolog.Logger, governanceClient clients.GovernanceChecker, axonClient clients.AxonCaller) http.HandlerFunc {
+	return DispatchHandlerWithOptions(logger, governanceClient, axonClient, DefaultDispatchOptions())
+}
+
+// DispatchHandlerWithOptions handles dispatch requests with the given
+// options, running them through the configured pipeline. It panics if the
+// pipeline is invalid; check it with ValidatePipeline first.
+func DispatchHandlerWithOptions(logger zerolog.Logger, governanceClient clients.GovernanceChecker, axonClient clients.AxonCaller, opts DispatchOptions) http.HandlerFunc {
+	dispatch := newPipeline(logger, governanceClient, axonClient, opts).ServeHTTP
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(response)
+	if opts.Idempotency == nil {
+		return dispatch
+	}
+	return withIdempotency(logger, opts, opts.MaxBodyBytes, dispatch)
+}
 
-		logger.Info().
+// routeIntent returns the caller serving intent, which is fallback unless
+// the options route intents
+func routeIntent(opts DispatchOptions, fallback clients.AxonCaller, intent string) (clients.AxonCaller, bool) {
+	if opts.Routes == nil {
+		return fallback, true
+	}
+	return opts.Routes.Route(intent)
+}
+
+// redactResult redacts the fields the obligations name from Axon's output
+func redactResult(result *contract.ReasonResponse, obligations clients.Obligations) (*contract.ReasonResponse, error) {
+	if len(obligations.RedactFields) == 0 {
+		return result, nil
+	}
+	redacted := *result
+	output, err := redactFields(result.Output, obligations.RedactFields)
+	if err != nil {
+		return nil, err
+	}
+	redacted.Output = output
+	return &redacted, nil
+}
+
+// allowRate applies the policy's rate limits to the caller, writing the
+// rate limit headers and, when the caller is throttled, a 429 response
+func allowRate(w http.ResponseWriter, r *http.Request, logger zerolog.Logger, opts DispatchOptions, intent string, decision clients.GovernanceResponse, correlationID string) bool {
+	result, limited := checkRate(r, logger, opts, intent, decision, correlationID)
+	if !limited {
+		return true
+	}
+
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+	if result.Allowed {
+		return true
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+	writeDispatchResponse(w, http.StatusTooManyRequests, DispatchResponse{
+		Status: "throttled",
+		Reason: "Rate limit exceeded",
+	})
+	return false
+}
+
+// checkRate consumes the caller's share of the policy's rate limits. It
+// reports limited false when no limit applies. Limiter errors let the
+// request through: governance has already allowed it, and rate limiting is
+// not an authorization decision.
+func checkRate(r *http.Request, logger zerolog.Logger, opts DispatchOptions, intent string, decision clients.GovernanceResponse, correlationID string) (ratelimit.Decision, bool) {
+	if opts.RateLimiter == nil || decision.RateLimits == nil {
+		return ratelimit.Decision{}, false
+	}
+
+	key := ratelimit.Key{Subject: rateLimitSubject(r, opts.Context), Intent: intent}
+	limits := ratelimit.Limits{
+		RequestsPerMinute: decision.RateLimits.RequestsPerMinute,
+		RequestsPerHour:   decision.RateLimits.RequestsPerHour,
+	}
+	if !limits.Enabled() {
+		return ratelimit.Decision{}, false
+	}
+
+	result, err := opts.RateLimiter.Allow(r.Context(), key, limits)
+	if err != nil {
+		logger.Error().
+			Err(err).
+			Str("correlation_id", correlationID).
+			Msg("rate_limit_check_failed")
+		return ratelimit.Decision{}, false
+	}
+
+	if !result.Allowed {
+		logger.Warn().
 			Str("correlation_id", correlationID).
-			Str("status", "success").
-			Msg("dispatch_completed")
+			Str("subject", key.Subject).
+			Str("intent", intent).
+			Int("limit", result.Limit).
+			Dur("retry_after", result.RetryAfter).
+			Msg("rate_limit_exceeded")
 	}
+	return result, true
 }
 
+// rateLimitSubject is the authenticated caller, or the client address for
+// anonymous requests
+func rateLimitSubject(r *http.Request, config GovernanceContextConfig) string {
+	if caller, ok := middleware.GetCaller(r.Context()); ok {
+		return "caller:" + caller.ID
+	}
+	return "ip:" + sourceIP(r, config.TrustForwardedFor)
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+func cacheStatus(decision clients.GovernanceResponse) string {
+	if decision.Cached {
+		return "hit"
+	}
+	return "miss"
+}
+
+// writeDispatchResponse writes a JSON dispatch response, stamping it with the
+// current time
+func writeDispatchResponse(w http.ResponseWriter, statusCode int, response DispatchResponse) {
+	response.Timestamp = time.Now()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"orbit-service/clients"
+	"orbit-service/contract"
+	"orbit-service/jsonschema"
+)
+
+// DefaultIntent is the intent of a dispatch that names none
+const DefaultIntent = "call_reasoning"
+
+// DefaultMaxDispatchBodyBytes bounds a dispatch request body unless
+// DISPATCH_MAX_BODY_BYTES says otherwise
+const DefaultMaxDispatchBodyBytes = 1 << 20
+
+// dispatchRequestSchema is the JSON Schema dispatch bodies are validated
+// against, also published for clients in the README
+//
+//go:embed dispatch_request.schema.json
+var dispatchRequestSchema []byte
+
+var dispatchSchema = mustCompile(dispatchRequestSchema)
+
+func mustCompile(data []byte) *jsonschema.Schema {
+	schema, err := jsonschema.Compile(data)
+	if err != nil {
+		panic(fmt.Sprintf("invalid built-in schema: %v", err))
+	}
+	return schema
+}
+
+// DispatchRequest is the body of POST /dispatch
+type DispatchRequest struct {
+	Intent     string                 `json:"intent"`
+	Input      json.RawMessage        `json:"input"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Metadata   map[string]string      `json:"metadata,omitempty"`
+	// Callback is where the outcome of an asynchronous dispatch is reported
+	Callback *DispatchCallback `json:"callback,omitempty"`
+}
+
+// DispatchCallback names the endpoint notified when a dispatch completes
+type DispatchCallback struct {
+	URL string `json:"url"`
+}
+
+// FieldError is a problem with one field of a request body. Field is a JSON
+// pointer to the field, empty for the body itself.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// dispatchBodyError is a dispatch body that cannot be accepted, with the
+// status and reason it is rejected with
+type dispatchBodyError struct {
+	status int
+	reason string
+	fields []FieldError
+}
+
+// readDispatchRequest reads and validates a dispatch body, filling in the
+// defaults for fields it omits. The raw body is returned too, for governance
+// context attributes read from it.
+func readDispatchRequest(r *http.Request, maxBytes int64) (DispatchRequest, json.RawMessage, *dispatchBodyError) {
+	body := []byte(`{}`)
+	if r.Body != nil {
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+		if err != nil {
+			return DispatchRequest{}, nil, &dispatchBodyError{status: http.StatusBadRequest, reason: "Request body could not be read"}
+		}
+		if int64(len(data)) > maxBytes {
+			return DispatchRequest{}, nil, &dispatchBodyError{
+				status: http.StatusRequestEntityTooLarge,
+				reason: fmt.Sprintf("Request body must not exceed %d bytes", maxBytes),
+			}
+		}
+		if len(bytes.TrimSpace(data)) > 0 {
+			body = data
+		}
+	}
+
+	req, bodyErr := decodeDispatchRequest(body)
+	if bodyErr != nil {
+		return DispatchRequest{}, nil, bodyErr
+	}
+	return req, json.RawMessage(body), nil
+}
+
+// decodeDispatchRequest validates and decodes a dispatch body, filling in
+// the defaults for fields it omits
+func decodeDispatchRequest(body []byte) (DispatchRequest, *dispatchBodyError) {
+	var document interface{}
+	if err := decodeJSONNumbers(body, &document); err != nil {
+		return DispatchRequest{}, &dispatchBodyError{status: http.StatusBadRequest, reason: "Request body must be valid JSON"}
+	}
+	if fields := validateDocument(dispatchSchema, document); len(fields) > 0 {
+		return DispatchRequest{}, &dispatchBodyError{status: http.StatusBadRequest, reason: "Request body is invalid", fields: fields}
+	}
+
+	var req DispatchRequest
+	if err := decodeJSONNumbers(body, &req); err != nil {
+		return DispatchRequest{}, &dispatchBodyError{status: http.StatusBadRequest, reason: "Request body is invalid"}
+	}
+	if req.Intent == "" {
+		req.Intent = DefaultIntent
+	}
+	if len(req.Input) == 0 {
+		req.Input = json.RawMessage(`{}`)
+	}
+	return req, nil
+}
+
+// validateDocument validates a decoded document, reporting each problem as
+// a field error
+func validateDocument(schema *jsonschema.Schema, document interface{}) []FieldError {
+	problems := schema.Validate(document)
+	if len(problems) == 0 {
+		return nil
+	}
+	fields := make([]FieldError, len(problems))
+	for i, problem := range problems {
+		fields[i] = FieldError{Field: problem.Path, Message: problem.Message}
+	}
+	return fields
+}
+
+// decodeJSONNumbers decodes a single JSON value, keeping numbers as written
+func decodeJSONNumbers(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return errInvalidJSON
+	}
+	return nil
+}
+
+// newReasonRequest builds the Axon request for an allowed dispatch. The
+// caller's parameters and metadata are passed on, but Orbit's metadata and
+// the obligations Axon enforces take precedence.
+func newReasonRequest(req DispatchRequest, obligations clients.Obligations, correlationID string) contract.ReasonRequest {
+	reasonReq := contract.ReasonRequest{
+		Version:  contract.Version,
+		Input:    req.Input,
+		Metadata: make(map[string]string, len(req.Metadata)+2),
+	}
+	for key, value := range req.Metadata {
+		reasonReq.Metadata[key] = value
+	}
+	reasonReq.Metadata["correlation_id"] = correlationID
+	reasonReq.Metadata["source_service"] = "orbit"
+
+	if len(req.Parameters) > 0 {
+		reasonReq.Parameters = make(map[string]interface{}, len(req.Parameters)+1)
+		for key, value := range req.Parameters {
+			reasonReq.Parameters[key] = value
+		}
+	}
+	if obligations.MaxTokens > 0 {
+		requested, ok := reasonReq.Parameters[contract.ParameterMaxTokens].(json.Number)
+		if n, err := requested.Int64(); !ok || err != nil || n > int64(obligations.MaxTokens) {
+			if reasonReq.Parameters == nil {
+				reasonReq.Parameters = make(map[string]interface{}, 1)
+			}
+			reasonReq.Parameters[contract.ParameterMaxTokens] = obligations.MaxTokens
+		}
+	}
+	return reasonReq
+}
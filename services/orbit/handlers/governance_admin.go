@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+	"orbit-service/middleware"
+)
+
+// adminContextConfig is the context sent with the governance check that
+// gates admin endpoints: the caller's identity and type, and never an
+// attribute the caller controls, whatever the dispatch allowlist says
+var adminContextConfig = GovernanceContextConfig{
+	Attributes: []ContextAttribute{
+		{Key: "caller_id", Source: ContextSourceCaller, Name: "id"},
+		{Key: "user_type", Source: ContextSourceCaller, Name: "type"},
+	},
+}
+
+// adminGovernanceRequest is the governance check that gates admin endpoints
+func adminGovernanceRequest(r *http.Request) clients.GovernanceRequest {
+	return clients.GovernanceRequest{
+		Service: "admin",
+		Intent:  "manage_policies",
+		Context: buildGovernanceContext(r, nil, adminContextConfig, time.Now()),
+	}
+}
+
+type InvalidateCacheRequest struct {
+	Service string `json:"service,omitempty"`
+	Intent  string `json:"intent,omitempty"`
+}
+
+type InvalidateCacheResponse struct {
+	Status    string    `json:"status"`
+	Dropped   int       `json:"dropped"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// GovernanceCacheInvalidateHandler drops cached governance decisions so a
+// policy change takes effect immediately. With a service and intent in the
+// body only that policy's decisions are dropped; otherwise the whole cache
+// is cleared. The caller must be allowed admin/manage_policies, checked
+// against the uncached authorizer.
+func GovernanceCacheInvalidateHandler(logger zerolog.Logger, cache clients.DecisionCacheInvalidator, authorizer clients.GovernanceChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		correlationID := middleware.GetCorrelationID(r.Context())
+
+		if !authorizeAdmin(w, r, logger, authorizer, correlationID) {
+			return
+		}
+
+		var req InvalidateCacheRequest
+		if r.Body != nil && r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeDispatchResponse(w, http.StatusBadRequest, DispatchResponse{
+					Status: "error",
+					Reason: "Request body must be valid JSON",
+				})
+				return
+			}
+		}
+
+		if (req.Service == "") != (req.Intent == "") {
+			writeDispatchResponse(w, http.StatusBadRequest, DispatchResponse{
+				Status: "error",
+				Reason: "service and intent must be given together",
+			})
+			return
+		}
+
+		var dropped int
+		if req.Service != "" {
+			dropped = cache.InvalidatePolicy(req.Service, req.Intent)
+		} else {
+			dropped = cache.Invalidate()
+		}
+
+		logger.Info().
+			Str("correlation_id", correlationID).
+			Str("service", req.Service).
+			Str("intent", req.Intent).
+			Int("dropped", dropped).
+			Msg("governance_cache_invalidate_requested")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(InvalidateCacheResponse{
+			Status:    "invalidated",
+			Dropped:   dropped,
+			Timestamp: time.Now(),
+		})
+	}
+}
+
+// DebugVarsHandler serves the process's expvars, such as the rate limiter's
+// counters, to callers allowed admin/manage_policies. They include the
+// command line and memory statistics, which are not for every caller.
+func DebugVarsHandler(logger zerolog.Logger, authorizer clients.GovernanceChecker) http.HandlerFunc {
+	vars := expvar.Handler()
+	return func(w http.ResponseWriter, r *http.Request) {
+		correlationID := middleware.GetCorrelationID(r.Context())
+		if !authorizeAdmin(w, r, logger, authorizer, correlationID) {
+			return
+		}
+		vars.ServeHTTP(w, r)
+	}
+}
+
+// authorizeAdmin checks that the caller is allowed admin/manage_policies,
+// writing the error response when they are not. The check carries the
+// caller's identity and type, so the policy decides who is an admin.
+func authorizeAdmin(w http.ResponseWriter, r *http.Request, logger zerolog.Logger, authorizer clients.GovernanceChecker, correlationID string) bool {
+	decision, err := authorizer.CheckPermission(r.Context(), adminGovernanceRequest(r), correlationID)
+	if err != nil {
+		logger.Error().
+			Err(err).
+			Str("correlation_id", correlationID).
+			Msg("admin_governance_check_failed")
+
+		writeDispatchResponse(w, http.StatusServiceUnavailable, DispatchResponse{
+			Status: "error",
+			Reason: "Governance check failed",
+		})
+		return false
+	}
+	if !decision.Allowed {
+		logger.Warn().
+			Str("correlation_id", correlationID).
+			Str("reason", decision.Reason).
+			Msg("admin_governance_denied")
+
+		writeDispatchResponse(w, http.StatusForbidden, DispatchResponse{
+			Status: "denied",
+			Reason: decision.Reason,
+		})
+		return false
+	}
+	return true
+}
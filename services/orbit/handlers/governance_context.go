@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"orbit-service/middleware"
+)
+
+// Sources a governance context attribute can be read from
+const (
+	// ContextSourceCaller reads a field ("id" or "type") of the authenticated caller
+	ContextSourceCaller = "caller"
+	// ContextSourceHeader reads a request header
+	ContextSourceHeader = "header"
+	// ContextSourceBody reads a dot-separated path into the JSON dispatch body
+	ContextSourceBody = "body"
+	// ContextSourceIP reads the client IP address
+	ContextSourceIP = "source_ip"
+	// ContextSourceTime reads the request time: "hour" and "weekday" (UTC)
+	// or "timestamp" (RFC 3339)
+	ContextSourceTime = "time"
+)
+
+// ContextAttribute declares one key of the context sent with governance
+// checks and where its value comes from
+type ContextAttribute struct {
+	Key    string `json:"key"`
+	Source string `json:"source"`
+	Name   string `json:"name,omitempty"`
+}
+
+// GovernanceContextConfig is the allowlist of context attributes sent to
+// governance. Keys not listed here never reach the policy engine, and
+// attributes with no value for a request are omitted.
+type GovernanceContextConfig struct {
+	Attributes []ContextAttribute `json:"attributes"`
+	// TrustForwardedFor takes the source IP from the last X-Forwarded-For
+	// entry, which is the one appended by Orbit's load balancer
+	TrustForwardedFor bool `json:"trust_forwarded_for"`
+}
+
+// DefaultGovernanceContextConfig returns the attributes used by the default
+// policies
+func DefaultGovernanceContextConfig() GovernanceContextConfig {
+	return GovernanceContextConfig{
+		Attributes: []ContextAttribute{
+			{Key: "caller_id", Source: ContextSourceCaller, Name: "id"},
+			{Key: "user_type", Source: ContextSourceCaller, Name: "type"},
+			{Key: "source_ip", Source: ContextSourceIP},
+			{Key: "hour", Source: ContextSourceTime, Name: "hour"},
+		},
+		TrustForwardedFor: true,
+	}
+}
+
+// LoadGovernanceContextConfig reads and validates an allowlist from a JSON file
+func LoadGovernanceContextConfig(path string) (GovernanceContextConfig, error) {
+	var config GovernanceContextConfig
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read governance context config: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return config, fmt.Errorf("failed to parse governance context config: %w", err)
+	}
+
+	return config, config.Validate()
+}
+
+// Validate checks that every attribute has a unique key and a known source
+func (c GovernanceContextConfig) Validate() error {
+	seen := make(map[string]bool, len(c.Attributes))
+	for _, attr := range c.Attributes {
+		if attr.Key == "" {
+			return fmt.Errorf("governance context attribute is missing a key")
+		}
+		if seen[attr.Key] {
+			return fmt.Errorf("governance context key %q is declared more than once", attr.Key)
+		}
+		seen[attr.Key] = true
+
+		switch attr.Source {
+		case ContextSourceCaller:
+			if attr.Name != "id" && attr.Name != "type" {
+				return fmt.Errorf("governance context key %q: caller field must be id or type", attr.Key)
+			}
+		case ContextSourceHeader, ContextSourceBody:
+			if attr.Name == "" {
+				return fmt.Errorf("governance context key %q: %s source needs a name", attr.Key, attr.Source)
+			}
+		case ContextSourceIP:
+		case ContextSourceTime:
+			if attr.Name != "hour" && attr.Name != "weekday" && attr.Name != "timestamp" {
+				return fmt.Errorf("governance context key %q: time field must be hour, weekday or timestamp", attr.Key)
+			}
+		default:
+			return fmt.Errorf("governance context key %q: unknown source %q", attr.Key, attr.Source)
+		}
+	}
+	return nil
+}
+
+// buildGovernanceContext collects the allowlisted attributes for a dispatch
+// request. input is the already validated JSON body.
+func buildGovernanceContext(r *http.Request, input json.RawMessage, config GovernanceContextConfig, now time.Time) map[string]interface{} {
+	if len(config.Attributes) == 0 {
+		return nil
+	}
+
+	var body interface{}
+	decoder := json.NewDecoder(bytes.NewReader(input))
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err != nil {
+		body = nil
+	}
+
+	caller, hasCaller := middleware.GetCaller(r.Context())
+
+	attributes := make(map[string]interface{}, len(config.Attributes))
+	for _, attr := range config.Attributes {
+		var value interface{}
+
+		switch attr.Source {
+		case ContextSourceCaller:
+			if hasCaller && attr.Name == "id" {
+				value = caller.ID
+			} else if hasCaller && attr.Name == "type" {
+				value = caller.Type
+			}
+		case ContextSourceHeader:
+			value = r.Header.Get(attr.Name)
+		case ContextSourceBody:
+			value = lookupBodyPath(body, attr.Name)
+		case ContextSourceIP:
+			value = sourceIP(r, config.TrustForwardedFor)
+		case ContextSourceTime:
+			value = timeAttribute(now.UTC(), attr.Name)
+		}
+
+		if value == nil || value == "" {
+			continue
+		}
+		attributes[attr.Key] = value
+	}
+	return attributes
+}
+
+// lookupBodyPath follows a dot-separated path through JSON objects. Objects
+// are never returned, so a misconfigured path cannot copy a whole subtree
+// of the body into the governance request.
+func lookupBodyPath(body interface{}, path string) interface{} {
+	value := body
+	for _, part := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[part]
+	}
+
+	if _, isObject := value.(map[string]interface{}); isObject {
+		return nil
+	}
+	return value
+}
+
+func sourceIP(r *http.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			hops := strings.Split(forwarded, ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func timeAttribute(now time.Time, name string) interface{} {
+	switch name {
+	case "hour":
+		return now.Hour()
+	case "weekday":
+		return strings.ToLower(now.Weekday().String())
+	case "timestamp":
+		return now.Format(time.RFC3339)
+	}
+	return nil
+}
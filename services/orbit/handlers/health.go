@@ -5,18 +5,33 @@ import (
 	"net/http"
 	"time"
 
+	"orbit-service/clients"
 	"orbit-service/middleware"
 	"github.com/rs/zerolog"
 )
 
 type HealthResponse struct {
-	Status    string    `json:"status"`
-	Service   string    `json:"service"`
-	Timestamp time.Time `json:"timestamp"`
+	Status       string                      `json:"status"`
+	Service      string                      `json:"service"`
+	Dependencies map[string]DependencyHealth `json:"dependencies,omitempty"`
+	Timestamp    time.Time                   `json:"timestamp"`
+}
+
+// DependencyHealth describes the state of a downstream dependency
+type DependencyHealth struct {
+	CircuitBreaker string `json:"circuit_breaker"`
 }
 
 // HealthHandler handles health check requests
 func HealthHandler(logger zerolog.Logger) http.HandlerFunc {
+	return HealthHandlerWithDependencies(logger, nil)
+}
+
+// HealthHandlerWithDependencies handles health check requests and reports the
+// circuit breaker state of each dependency. An open breaker marks the service
+// as degraded but still answers 200, so the load balancer keeps routing to a
+// task that can serve health and fail fast on dispatch.
+func HealthHandlerWithDependencies(logger zerolog.Logger, dependencies map[string]clients.BreakerReporter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		correlationID := middleware.GetCorrelationID(r.Context())
 
@@ -26,13 +41,24 @@ func HealthHandler(logger zerolog.Logger) http.HandlerFunc {
 			Timestamp: time.Now(),
 		}
 
+		if len(dependencies) > 0 {
+			response.Dependencies = make(map[string]DependencyHealth, len(dependencies))
+			for name, dependency := range dependencies {
+				state := dependency.BreakerState()
+				response.Dependencies[name] = DependencyHealth{CircuitBreaker: state}
+				if state != "closed" {
+					response.Status = "degraded"
+				}
+			}
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(response)
 
 		logger.Info().
 			Str("correlation_id", correlationID).
-			Str("status", "healthy").
+			Str("status", response.Status).
 			Msg("health_check")
 	}
 }
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/idempotency"
+	"orbit-service/middleware"
+)
+
+// IdempotencyKeyHeader names the caller's key for a dispatch that must not
+// run twice
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader marks a response replayed from an earlier request
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// maxIdempotencyKeyLength bounds an Idempotency-Key
+const maxIdempotencyKeyLength = 255
+
+// maxIdempotentResponseBytes bounds a stored response; larger responses are
+// not stored, and a retry runs the dispatch again
+const maxIdempotentResponseBytes = 1 << 20
+
+// idempotencyStoreTimeout bounds storing a response, which is done after
+// the caller may have gone
+const idempotencyStoreTimeout = 5 * time.Second
+
+// idempotentHeaders are the response headers replayed with a stored response
+var idempotentHeaders = []string{
+	"Content-Type",
+	"Location",
+	"Retry-After",
+	GovernanceCacheHeader,
+	GovernanceDegradedHeader,
+	"X-RateLimit-Limit",
+	"X-RateLimit-Remaining",
+	"X-RateLimit-Reset",
+}
+
+// withIdempotency runs dispatches carrying an Idempotency-Key at most once
+// per caller and key; anonymous callers cannot send one. The first request's response is stored and replayed
+// to retries of the same request; a retry while the first is still running
+// gets 409, and the key reused for a different request gets 422.
+// Responses the caller is expected to retry, 429 and 5xx, are not stored.
+// maxBodyBytes is the body limit of the handler wrapped.
+func withIdempotency(logger zerolog.Logger, opts DispatchOptions, maxBodyBytes int64, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		correlationID := middleware.GetCorrelationID(r.Context())
+
+		if !validIdempotencyKey(key) {
+			writeDispatchResponse(w, http.StatusBadRequest, DispatchResponse{
+				Status: "error",
+				Reason: "Idempotency-Key must be 1 to 255 printable ASCII characters",
+			})
+			return
+		}
+		if wantsStream(r) {
+			writeDispatchResponse(w, http.StatusBadRequest, DispatchResponse{
+				Status: "error",
+				Reason: "Streamed dispatches cannot use an Idempotency-Key",
+			})
+			return
+		}
+
+		// Keys are scoped to the caller; anonymous callers would all share
+		// one set of keys and be replayed each other's responses
+		caller, identified := middleware.GetCaller(r.Context())
+		if !identified || caller.ID == "" {
+			writeDispatchResponse(w, http.StatusBadRequest, DispatchResponse{
+				Status: "error",
+				Reason: "Anonymous callers cannot use an Idempotency-Key",
+			})
+			return
+		}
+
+		var body []byte
+		if r.Body != nil {
+			var err error
+			body, err = io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+			if err != nil {
+				writeDispatchResponse(w, http.StatusBadRequest, DispatchResponse{
+					Status: "error",
+					Reason: "Request body could not be read",
+				})
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		mode, _ := dispatchMode(r)
+		now := time.Now()
+		record := idempotency.Record{
+			Key:         idempotency.Key(caller.ID, key),
+			Fingerprint: idempotency.Fingerprint([]byte(r.Method), []byte(r.URL.Path), []byte(mode), body),
+			Status:      idempotency.StatusInProgress,
+			Token:       idempotency.NewToken(),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(opts.IdempotencyConfig.LockTimeout),
+		}
+
+		existing, claimed, err := opts.Idempotency.Claim(r.Context(), record)
+		if err != nil {
+			logger.Error().
+				Err(err).
+				Str("correlation_id", correlationID).
+				Msg("idempotency_store_failed")
+
+			writeDispatchResponse(w, http.StatusServiceUnavailable, DispatchResponse{
+				Status: "error",
+				Reason: "Idempotency key could not be checked",
+			})
+			return
+		}
+		if !claimed {
+			replayIdempotent(w, logger, existing, record.Fingerprint, correlationID)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r)
+
+		storeCtx, cancel := context.WithTimeout(context.Background(), idempotencyStoreTimeout)
+		defer cancel()
+		if recorder.status == http.StatusTooManyRequests || recorder.status >= 500 || recorder.overflow {
+			err = opts.Idempotency.Release(storeCtx, record.Key, record.Token)
+		} else {
+			record.Status = idempotency.StatusCompleted
+			record.ExpiresAt = time.Now().Add(opts.IdempotencyConfig.TTL)
+			record.Response = &idempotency.Response{
+				StatusCode: recorder.status,
+				Header:     recorder.header,
+				Body:       recorder.body.Bytes(),
+			}
+			err = opts.Idempotency.Complete(storeCtx, record)
+		}
+		if err != nil {
+			logger.Error().
+				Err(err).
+				Str("correlation_id", correlationID).
+				Int("status", recorder.status).
+				Msg("idempotent_response_not_stored")
+		}
+	}
+}
+
+// replayIdempotent answers a request whose key is already used: with the
+// stored response if it is the same request, otherwise with an error
+func replayIdempotent(w http.ResponseWriter, logger zerolog.Logger, existing idempotency.Record, fingerprint, correlationID string) {
+	switch {
+	case existing.Fingerprint != fingerprint:
+		logger.Warn().
+			Str("correlation_id", correlationID).
+			Msg("idempotency_key_reused")
+
+		writeDispatchResponse(w, http.StatusUnprocessableEntity, DispatchResponse{
+			Status: "error",
+			Reason: "Idempotency-Key was already used for a different request",
+		})
+	case existing.Status != idempotency.StatusCompleted || existing.Response == nil:
+		w.Header().Set("Retry-After", "1")
+		writeDispatchResponse(w, http.StatusConflict, DispatchResponse{
+			Status: "error",
+			Reason: "A request with this Idempotency-Key is in progress",
+		})
+	default:
+		logger.Info().
+			Str("correlation_id", correlationID).
+			Int("status", existing.Response.StatusCode).
+			Msg("idempotent_response_replayed")
+
+		for name, value := range existing.Response.Header {
+			w.Header().Set(name, value)
+		}
+		w.Header().Set(IdempotentReplayedHeader, "true")
+		w.WriteHeader(existing.Response.StatusCode)
+		w.Write(existing.Response.Body)
+	}
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// responseRecorder passes a response through while keeping a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	header      map[string]string
+	wroteHeader bool
+	body        bytes.Buffer
+	overflow    bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+	r.status = status
+	for _, name := range idempotentHeaders {
+		if value := r.Header().Get(name); value != "" {
+			if r.header == nil {
+				r.header = make(map[string]string)
+			}
+			r.header[name] = value
+		}
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	if !r.overflow {
+		if r.body.Len()+len(data) > maxIdempotentResponseBytes {
+			r.overflow = true
+			r.body.Reset()
+		} else {
+			r.body.Write(data)
+		}
+	}
+	return r.ResponseWriter.Write(data)
+}
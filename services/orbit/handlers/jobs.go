@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+	"orbit-service/contract"
+	"orbit-service/jobs"
+	"orbit-service/middleware"
+)
+
+// Dispatch modes, selected by the mode query parameter
+const (
+	DispatchModeSync  = "sync"
+	DispatchModeAsync = "async"
+)
+
+// jobQueueRetryAfter is the Retry-After sent when the job queue is full
+const jobQueueRetryAfter = 5 * time.Second
+
+// JobStatusResponse is what the requester sees of an asynchronous dispatch
+type JobStatusResponse struct {
+	JobID      string                   `json:"job_id"`
+	Status     string                   `json:"status"`
+	Intent     string                   `json:"intent"`
+	CreatedAt  time.Time                `json:"created_at"`
+	StartedAt  *time.Time               `json:"started_at,omitempty"`
+	FinishedAt *time.Time               `json:"finished_at,omitempty"`
+	ExpiresAt  time.Time                `json:"expires_at"`
+	Result     *contract.ReasonResponse `json:"result,omitempty"`
+	// ErrorCode and Error are the stable code and title of a failed job
+	ErrorCode string    `json:"error_code,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// dispatchMode returns the mode a dispatch asks for, sync unless the mode
+// query parameter says otherwise
+func dispatchMode(r *http.Request) (string, bool) {
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", DispatchModeSync:
+		return DispatchModeSync, true
+	case DispatchModeAsync:
+		return DispatchModeAsync, true
+	default:
+		return mode, false
+	}
+}
+
+// checkCallback checks that a dispatch's callback URL can be delivered to
+func checkCallback(opts DispatchOptions, url string) error {
+	if opts.Webhooks == nil {
+		return errors.New("callbacks are not available")
+	}
+	return opts.Webhooks.CheckCallback(url)
+}
+
+// submitJob queues an allowed dispatch and answers 202 with the job's ID.
+// dispatchReq carries the input already redacted as the obligations require.
+func submitJob(w http.ResponseWriter, r *http.Request, logger zerolog.Logger, opts DispatchOptions, req clients.GovernanceRequest, decision clients.GovernanceResponse, dispatchReq DispatchRequest, correlationID string) {
+	job := jobs.Job{
+		CorrelationID: correlationID,
+		Service:       req.Service,
+		Intent:        req.Intent,
+		Context:       req.Context,
+		Reason:        decision.Reason,
+		PolicyVersion: decision.PolicyVersion,
+		Degraded:      decision.Degraded,
+		Obligations:   decision.Obligations,
+		Input:         dispatchReq.Input,
+		Parameters:    dispatchReq.Parameters,
+		Metadata:      dispatchReq.Metadata,
+	}
+	if caller, ok := middleware.GetCaller(r.Context()); ok {
+		job.Principal = caller.ID
+	}
+	if dispatchReq.Callback != nil {
+		job.Callback = dispatchReq.Callback.URL
+	}
+
+	job, err := opts.Jobs.Submit(r.Context(), job)
+	if errors.Is(err, jobs.ErrQueueFull) || errors.Is(err, jobs.ErrClosed) {
+		logger.Warn().
+			Err(err).
+			Str("correlation_id", correlationID).
+			Msg("job_rejected")
+
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(jobQueueRetryAfter)))
+		writeDispatchResponse(w, http.StatusServiceUnavailable, DispatchResponse{
+			Status: "error",
+			Reason: "Job queue is full",
+		})
+		return
+	}
+	if err != nil {
+		logger.Error().
+			Err(err).
+			Str("correlation_id", correlationID).
+			Msg("job_submit_failed")
+
+		writeDispatchResponse(w, http.StatusInternalServerError, DispatchResponse{
+			Status: "error",
+			Reason: "Failed to queue dispatch",
+		})
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeDispatchResponse(w, http.StatusAccepted, DispatchResponse{
+		Status:   jobs.StatusQueued,
+		Degraded: decision.Degraded,
+		JobID:    job.ID,
+	})
+}
+
+// DispatchJobRunner runs queued dispatches through the pipeline: each is
+// sent to the service routed for its intent under the decision it was
+// allowed with, and its outcome audited, as a synchronous dispatch would
+// be. A failed job reports the code and title its dispatch's problem would
+// have had.
+func DispatchJobRunner(logger zerolog.Logger, axonClient clients.AxonCaller, opts DispatchOptions) jobs.Runner {
+	pipeline := newPipeline(logger, nil, axonClient, opts)
+	return func(ctx context.Context, job jobs.Job) (*contract.ReasonResponse, error) {
+		caller, ok := routeIntent(opts, axonClient, job.Intent)
+		if !ok {
+			// The route table changed while the job was queued
+			err := fmt.Errorf("no route for intent %s", job.Intent)
+			problem := downstreamProblem(err)
+			return nil, &jobs.Failure{Code: problem.code, Title: problem.title, Err: err}
+		}
+
+		result, outcome, err := pipeline.runResumed(&Dispatch{
+			HTTP:          resumedRequest(ctx, "/jobs/"+job.ID, job.Principal),
+			CorrelationID: job.CorrelationID,
+			Mode:          DispatchModeSync,
+			Request:       DispatchRequest{Intent: job.Intent, Input: job.Input, Parameters: job.Parameters, Metadata: job.Metadata},
+			Caller:        caller,
+			Governance:    clients.GovernanceRequest{Service: job.Service, Intent: job.Intent, Context: job.Context},
+			Decision: clients.GovernanceResponse{
+				Allowed:       true,
+				Reason:        job.Reason,
+				PolicyVersion: job.PolicyVersion,
+				Degraded:      job.Degraded,
+				Obligations:   job.Obligations,
+			},
+			Resumed: true,
+		})
+		if err != nil {
+			code := jobs.ErrorCodeJobFailed
+			if outcome.Error != nil {
+				code = outcome.Error.Code
+			}
+			return nil, &jobs.Failure{Code: code, Title: outcome.Reason, Err: err}
+		}
+		return result, nil
+	}
+}
+
+// JobStatusHandler lets the requester of an asynchronous dispatch follow it
+// and collect its result. Jobs of another caller are reported as missing.
+func JobStatusHandler(logger zerolog.Logger, pool *jobs.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		correlationID := middleware.GetCorrelationID(r.Context())
+
+		job, err := requesterJob(r, pool)
+		if err != nil {
+			writeJobError(w, logger, err, correlationID)
+			return
+		}
+		writeJob(w, job)
+	}
+}
+
+// JobCancelHandler lets the requester cancel a queued or running
+// asynchronous dispatch
+func JobCancelHandler(logger zerolog.Logger, pool *jobs.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		correlationID := middleware.GetCorrelationID(r.Context())
+
+		job, err := requesterJob(r, pool)
+		if err == nil {
+			job, err = pool.Cancel(r.Context(), job.ID)
+		}
+		if err != nil {
+			writeJobError(w, logger, err, correlationID)
+			return
+		}
+		writeJob(w, job)
+	}
+}
+
+// requesterJob returns the job named in the path if it belongs to the caller
+func requesterJob(r *http.Request, pool *jobs.Pool) (jobs.Job, error) {
+	job, err := pool.Get(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		return job, err
+	}
+	if job.Principal != "" {
+		caller, _ := middleware.GetCaller(r.Context())
+		if caller.ID != job.Principal {
+			return jobs.Job{}, jobs.ErrNotFound
+		}
+	}
+	return job, nil
+}
+
+func writeJob(w http.ResponseWriter, job jobs.Job) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(JobStatusResponse{
+		JobID:      job.ID,
+		Status:     job.Status,
+		Intent:     job.Intent,
+		CreatedAt:  job.CreatedAt,
+		StartedAt:  job.StartedAt,
+		FinishedAt: job.FinishedAt,
+		ExpiresAt:  job.ExpiresAt,
+		Result:     job.Result,
+		ErrorCode:  job.ErrorCode,
+		Error:      job.Error,
+		Timestamp:  time.Now(),
+	})
+}
+
+// writeJobError maps job errors to responses: 404 for unknown or expired
+// jobs and 409 for cancelling one that already finished
+func writeJobError(w http.ResponseWriter, logger zerolog.Logger, err error, correlationID string) {
+	var statusErr *jobs.StatusError
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		writeDispatchResponse(w, http.StatusNotFound, DispatchResponse{
+			Status: "error",
+			Reason: "Job not found",
+		})
+	case errors.As(err, &statusErr):
+		writeDispatchResponse(w, http.StatusConflict, DispatchResponse{
+			Status: "error",
+			Reason: fmt.Sprintf("Job is already %s", statusErr.Status),
+		})
+	default:
+		logger.Error().
+			Err(err).
+			Str("correlation_id", correlationID).
+			Msg("job_store_failed")
+
+		writeDispatchResponse(w, http.StatusInternalServerError, DispatchResponse{
+			Status: "error",
+			Reason: "Job could not be read or updated",
+		})
+	}
+}
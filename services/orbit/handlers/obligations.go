@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/audit"
+	"orbit-service/clients"
+	"orbit-service/middleware"
+)
+
+// AuditRecorder records the outcome of dispatches whose policy requires the
+// full audit level
+type AuditRecorder interface {
+	Record(ctx context.Context, entry audit.Entry) error
+}
+
+// unauditedRetryAfter is suggested to callers whose dispatch outcome could
+// not be recorded
+const unauditedRetryAfter = 5 * time.Second
+
+// dispatchRefusal is a request refused before it is dispatched, with the
+// status and reason it is refused with
+type dispatchRefusal struct {
+	status int
+	reason string
+}
+
+// checkObligations refuses a request whose obligations cannot be enforced or
+// that it already breaks, writing the response
+func checkObligations(w http.ResponseWriter, logger zerolog.Logger, opts DispatchOptions, decision clients.GovernanceResponse, input json.RawMessage, stream bool, correlationID string) (clients.Obligations, bool) {
+	obligations, refusal := enforceObligations(logger, opts, decision, input, stream, correlationID)
+	if refusal != nil {
+		writeDispatchResponse(w, refusal.status, DispatchResponse{
+			Status: "denied",
+			Reason: refusal.reason,
+		})
+		return obligations, false
+	}
+	return obligations, true
+}
+
+// enforceObligations parses a decision's obligations, refusing the request
+// if they cannot be enforced or it already breaks them. An obligation Orbit
+// does not understand refuses the request: serving it would ignore a
+// constraint the policy requires.
+func enforceObligations(logger zerolog.Logger, opts DispatchOptions, decision clients.GovernanceResponse, input json.RawMessage, stream bool, correlationID string) (clients.Obligations, *dispatchRefusal) {
+	obligations, err := clients.ParseObligations(decision.Obligations)
+	if err != nil {
+		logger.Error().
+			Err(err).
+			Str("correlation_id", correlationID).
+			Str("policy_version", decision.PolicyVersion).
+			Msg("governance_obligation_unsupported")
+
+		return obligations, &dispatchRefusal{status: http.StatusForbidden, reason: "Governance obligation is not supported"}
+	}
+
+	refuse := func(status int, reason string) (clients.Obligations, *dispatchRefusal) {
+		logger.Warn().
+			Str("correlation_id", correlationID).
+			Str("reason", reason).
+			Msg("governance_obligation_refused")
+
+		return obligations, &dispatchRefusal{status: status, reason: reason}
+	}
+
+	if obligations.AuditLevel != "" && opts.Auditor == nil {
+		return refuse(http.StatusForbidden, "Required audit level is not available")
+	}
+	if stream && len(obligations.RedactFields) > 0 {
+		return refuse(http.StatusForbidden, "Redaction cannot be enforced on a streamed dispatch")
+	}
+	if stream && obligations.AuditLevel == clients.AuditLevelFull {
+		return refuse(http.StatusForbidden, "Full audit cannot be enforced on a streamed dispatch")
+	}
+	if obligations.MaxPayloadBytes > 0 && len(input) > obligations.MaxPayloadBytes {
+		return refuse(http.StatusRequestEntityTooLarge, "Payload exceeds the size allowed by policy")
+	}
+
+	return obligations, nil
+}
+
+// recordOutcome records an allowed dispatch's outcome under the full audit
+// level. It reports whether the caller may be given the result, writing a
+// 503 response when not.
+func recordOutcome(w http.ResponseWriter, r *http.Request, logger zerolog.Logger, opts DispatchOptions, obligations clients.Obligations, req clients.GovernanceRequest, decision clients.GovernanceResponse, payload []byte, outcome string, latency time.Duration, correlationID string) bool {
+	var principal string
+	if caller, ok := middleware.GetCaller(r.Context()); ok {
+		principal = caller.ID
+	}
+	if auditOutcome(logger, opts, obligations, req, decision, principal, payload, outcome, latency, correlationID) {
+		return true
+	}
+
+	writeProblem(w, r, dispatchProblem{
+		status:     http.StatusServiceUnavailable,
+		code:       ErrorCodeAuditUnavailable,
+		title:      "Dispatch could not be audited",
+		retryable:  true,
+		retryAfter: unauditedRetryAfter,
+	}, correlationID)
+	return false
+}
+
+// auditOutcome records a dispatch's outcome when the full audit level is
+// required. It reports whether the result may be served: a successful
+// result only once its outcome is recorded.
+func auditOutcome(logger zerolog.Logger, opts DispatchOptions, obligations clients.Obligations, req clients.GovernanceRequest, decision clients.GovernanceResponse, principal string, payload []byte, outcome string, latency time.Duration, correlationID string) bool {
+	if obligations.AuditLevel != clients.AuditLevelFull {
+		return true
+	}
+
+	entry := audit.Entry{
+		Timestamp:     time.Now(),
+		CorrelationID: correlationID,
+		Principal:     principal,
+		Service:       req.Service,
+		Intent:        req.Intent,
+		ContextDigest: audit.ContextDigest(req.Context),
+		Decision:      audit.DecisionAllow,
+		Reason:        decision.Reason,
+		PolicyVersion: decision.PolicyVersion,
+		LatencyMs:     latency.Milliseconds(),
+		Cached:        decision.Cached,
+		Degraded:      decision.Degraded != "",
+		DegradedMode:  decision.Degraded,
+		Outcome:       outcome,
+		PayloadDigest: audit.PayloadDigest(payload),
+	}
+
+	// The caller may have gone already; the outcome is recorded regardless,
+	// but an auditor that cannot take it in time fails the dispatch rather
+	// than hold it
+	ctx, cancel := context.WithTimeout(context.Background(), opts.AuditTimeout)
+	err := opts.Auditor.Record(ctx, entry)
+	cancel()
+	if err == nil {
+		return true
+	}
+
+	logger.Error().
+		Err(err).
+		Str("correlation_id", correlationID).
+		Str("outcome", outcome).
+		Msg("dispatch_outcome_audit_failed")
+
+	// A failure response is served anyway
+	return outcome != audit.OutcomeSucceeded
+}
+
+// redactFields replaces the values at the given dotted paths of a JSON
+// object with clients.RedactedValue. Paths that are absent, or that run into
+// a value that is not an object, are left alone.
+func redactFields(data json.RawMessage, paths []string) (json.RawMessage, error) {
+	if len(paths) == 0 || len(data) == 0 {
+		return data, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("failed to decode payload for redaction: %w", err)
+	}
+
+	redacted := false
+	for _, path := range paths {
+		if redactPath(value, strings.Split(path, ".")) {
+			redacted = true
+		}
+	}
+	if !redacted {
+		return data, nil
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode redacted payload: %w", err)
+	}
+	return json.RawMessage(encoded), nil
+}
+
+func redactPath(value interface{}, path []string) bool {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	child, ok := object[path[0]]
+	if !ok {
+		return false
+	}
+	if len(path) == 1 {
+		object[path[0]] = clients.RedactedValue
+		return true
+	}
+	return redactPath(child, path[1:])
+}
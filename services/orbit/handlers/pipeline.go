@@ -0,0 +1,439 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+	"orbit-service/contract"
+	"orbit-service/middleware"
+)
+
+// Built-in dispatch stages
+const (
+	// StageValidate reads and validates the body and routes the intent
+	StageValidate = "validate"
+	// StageGovernance checks the dispatch with governance, holding it for
+	// approval or denying it as the decision says
+	StageGovernance = "governance"
+	// StageObligations refuses dispatches whose obligations cannot be met
+	StageObligations = "obligations"
+	// StageRateLimit applies the deciding policy's rate limits
+	StageRateLimit = "rate_limit"
+	// StageRedaction redacts the input before the call and the result after it
+	StageRedaction = "redaction"
+	// StageAudit records the outcome under the full audit level
+	StageAudit = "audit"
+	// StageAsync queues asynchronous dispatches as jobs
+	StageAsync = "async"
+	// StageStream relays streamed dispatches
+	StageStream = "stream"
+)
+
+// DefaultPipeline is the order the built-in stages run in. A configured
+// pipeline keeps every built-in stage in this order, and may put stages of
+// its own between them.
+var DefaultPipeline = []string{
+	StageValidate,
+	StageGovernance,
+	StageObligations,
+	StageRateLimit,
+	StageRedaction,
+	StageAudit,
+	StageAsync,
+	StageStream,
+}
+
+// Stage is a step of the dispatch pipeline. Before hooks run in pipeline
+// order ahead of the downstream call, After hooks in reverse order once it
+// returns. A hook returning false short-circuits the dispatch: it has
+// written the response, and no later hook or downstream call runs. After
+// hooks run only for dispatches that reached the downstream call, whether
+// it failed or not. A Before hook that makes the call and answers the
+// request itself, as stream does, sets Dispatch.Responded instead of
+// returning false, so the After hooks still see the outcome.
+type Stage interface {
+	Before(w http.ResponseWriter, d *Dispatch) bool
+	After(w http.ResponseWriter, d *Dispatch) bool
+}
+
+// StageFuncs is a Stage made of functions; a nil hook lets the dispatch
+// through
+type StageFuncs struct {
+	BeforeFunc func(w http.ResponseWriter, d *Dispatch) bool
+	AfterFunc  func(w http.ResponseWriter, d *Dispatch) bool
+}
+
+func (s StageFuncs) Before(w http.ResponseWriter, d *Dispatch) bool {
+	if s.BeforeFunc == nil {
+		return true
+	}
+	return s.BeforeFunc(w, d)
+}
+
+func (s StageFuncs) After(w http.ResponseWriter, d *Dispatch) bool {
+	if s.AfterFunc == nil {
+		return true
+	}
+	return s.AfterFunc(w, d)
+}
+
+// Dispatch is a dispatch as it passes through the pipeline. Stages read and
+// update it; each field is set by the stage named in its comment, or by
+// the entry point that built the dispatch.
+type Dispatch struct {
+	// HTTP is the caller's request, its context bounded by the dispatch
+	// budget. A dispatch resumed after its request was answered gets a
+	// stand-in carrying its requester as the caller.
+	HTTP          *http.Request
+	CorrelationID string
+	Stream        bool
+	// Mode, Request, Body and Caller are set by validate. Request.Input is
+	// redacted by redaction before the call. Entry points that read their
+	// own body, such as batches, set them; validate lets a dispatch with a
+	// Caller through.
+	Mode    string
+	Request DispatchRequest
+	Body    json.RawMessage
+	Caller  clients.AxonCaller
+	// Governance and Decision are set by governance
+	Governance clients.GovernanceRequest
+	Decision   clients.GovernanceResponse
+	// Resumed marks a dispatch governed and rate limited before it was held
+	// or queued; its entry point sets Governance and Decision, and
+	// governance and rate limit let it through
+	Resumed bool
+	// RateLimited marks a dispatch whose request was already charged to
+	// the caller's rate limits; rate limit lets it through
+	RateLimited bool
+	// Unapproved, if set, is the reason a dispatch whose policy requires
+	// approval is denied, for entry points that cannot wait for one
+	Unapproved string
+	// Obligations are set by obligations
+	Obligations clients.Obligations
+	// Result, Err and Latency are the downstream call's outcome, set for
+	// the After hooks. Result is redacted by redaction.
+	Result  *contract.ReasonResponse
+	Err     error
+	Latency time.Duration
+	// Responded is set by a stage that made the call and answered the
+	// request itself; the After hooks still run, but what they write is
+	// discarded
+	Responded bool
+
+	// next is the index of the next stage whose Before hook runs
+	next    int
+	halted  string
+	timings *zerolog.Event
+	logged  bool
+}
+
+// Refuse short-circuits the dispatch with a denial
+func (d *Dispatch) Refuse(w http.ResponseWriter, status int, reason string) bool {
+	writeDispatchResponse(w, status, DispatchResponse{
+		Status:   "denied",
+		Reason:   reason,
+		Degraded: d.Decision.Degraded,
+	})
+	return false
+}
+
+// Fail short-circuits the dispatch with a problem body carrying code
+func (d *Dispatch) Fail(w http.ResponseWriter, status int, code, title string) bool {
+	writeProblem(w, d.HTTP, dispatchProblem{status: status, code: code, title: title}, d.CorrelationID)
+	return false
+}
+
+// pipelineNames returns the configured stage order
+func (o DispatchOptions) pipelineNames() []string {
+	if len(o.Pipeline) == 0 {
+		return DefaultPipeline
+	}
+	return o.Pipeline
+}
+
+// ValidatePipeline checks that the configured pipeline names only known
+// stages, each once, and keeps every built-in stage in its default order
+// with validate first. Dropping a built-in stage would skip a check
+// governance relies on, so it is not allowed.
+func (o DispatchOptions) ValidatePipeline() error {
+	builtin := make(map[string]bool, len(DefaultPipeline))
+	for _, name := range DefaultPipeline {
+		builtin[name] = true
+	}
+	for name := range o.Stages {
+		if builtin[name] {
+			return fmt.Errorf("dispatch stage %q is built in", name)
+		}
+	}
+
+	names := o.pipelineNames()
+	if names[0] != StageValidate {
+		return fmt.Errorf("dispatch pipeline must start with %s", StageValidate)
+	}
+	seen := make(map[string]bool, len(names))
+	next := 0
+	for _, name := range names {
+		if seen[name] {
+			return fmt.Errorf("dispatch stage %q appears more than once", name)
+		}
+		seen[name] = true
+
+		switch {
+		case builtin[name]:
+			if name != DefaultPipeline[next] {
+				return fmt.Errorf("dispatch stage %q is out of order; built-in stages run in the order %s",
+					name, strings.Join(DefaultPipeline, ","))
+			}
+			next++
+		case o.Stages[name] == nil:
+			return fmt.Errorf("unknown dispatch stage %q", name)
+		}
+	}
+	if next < len(DefaultPipeline) {
+		return fmt.Errorf("dispatch pipeline is missing stage %q", DefaultPipeline[next])
+	}
+	return nil
+}
+
+// namedStage is a stage of an assembled pipeline
+type namedStage struct {
+	name  string
+	stage Stage
+}
+
+// Pipeline runs dispatches through its stages and the downstream call
+// between them. Every entry point runs its dispatches through it: the
+// dispatch handler, batch items, run steps, queued jobs and approved
+// dispatches.
+type Pipeline struct {
+	logger zerolog.Logger
+	opts   DispatchOptions
+	stages []namedStage
+}
+
+// newPipeline assembles the pipeline the options configure. It panics if
+// the pipeline is invalid; ValidatePipeline reports why. Entry points that
+// only run resumed dispatches pass no governance client.
+func newPipeline(logger zerolog.Logger, governanceClient clients.GovernanceChecker, axonClient clients.AxonCaller, opts DispatchOptions) *Pipeline {
+	if err := opts.ValidatePipeline(); err != nil {
+		panic(err)
+	}
+
+	builtin := builtinStages(logger, governanceClient, axonClient, opts)
+	p := &Pipeline{logger: logger, opts: opts}
+	for _, name := range opts.pipelineNames() {
+		stage, ok := builtin[name]
+		if !ok {
+			stage = opts.Stages[name]
+		}
+		p.stages = append(p.stages, namedStage{name: name, stage: stage})
+	}
+	return p
+}
+
+func (p *Pipeline) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d := &Dispatch{
+		HTTP:          r,
+		CorrelationID: middleware.GetCorrelationID(r.Context()),
+		Stream:        wantsStream(r),
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), p.opts.Budget.Total)
+	defer cancel()
+	d.HTTP = r.WithContext(ctx)
+
+	p.Run(w, d)
+}
+
+// Admit runs the Before hooks of the stages d has not passed yet, up to and
+// including the stage named through, or all of them when through is empty.
+// It reports whether every hook let the dispatch through; if not, the
+// refusing hook has answered it.
+func (p *Pipeline) Admit(w http.ResponseWriter, d *Dispatch, through string) bool {
+	if d.halted != "" {
+		return false
+	}
+	if d.timings == nil {
+		d.timings = zerolog.Dict()
+	}
+	for d.next < len(p.stages) {
+		s := p.stages[d.next]
+		d.next++
+		start := time.Now()
+		ok := s.stage.Before(w, d)
+		d.timings.Dur(s.name, time.Since(start))
+		if !ok {
+			d.halted = s.name
+			p.logTimings(d)
+			return false
+		}
+		if s.name == through {
+			break
+		}
+	}
+	return true
+}
+
+// Run passes d through the rest of the pipeline: the Before hooks it has
+// not passed, the downstream call, the After hooks and the response
+func (p *Pipeline) Run(w http.ResponseWriter, d *Dispatch) {
+	defer p.logTimings(d)
+	if !p.Admit(w, d, "") {
+		return
+	}
+
+	callCtx := d.HTTP.Context()
+	if !d.Responded {
+		if d.Obligations.MaxReasoningTime > 0 {
+			var cancel context.CancelFunc
+			callCtx, cancel = context.WithTimeout(callCtx, d.Obligations.MaxReasoningTime)
+			defer cancel()
+		}
+		start := time.Now()
+		d.Result, d.Err = d.Caller.CallReason(callCtx, newReasonRequest(d.Request, d.Obligations, d.CorrelationID), d.CorrelationID)
+		d.Latency = time.Since(start)
+		d.timings.Dur("call", d.Latency)
+	}
+
+	// The response to a dispatch a stage answered is already written
+	after := w
+	if d.Responded {
+		after = newDispatchRecorder()
+	}
+	for i := len(p.stages) - 1; i >= 0; i-- {
+		s := p.stages[i]
+		start := time.Now()
+		ok := s.stage.After(after, d)
+		d.timings.Dur(s.name+"_after", time.Since(start))
+		if !ok {
+			d.halted = s.name
+			return
+		}
+	}
+
+	if !d.Responded {
+		p.respond(w, d, callCtx)
+	}
+}
+
+// resumedRequest stands in for the request of a dispatch resumed after that
+// request was answered, such as a queued job or an approved dispatch. It
+// carries ctx and the requester as the caller.
+func resumedRequest(ctx context.Context, path, principal string) *http.Request {
+	if principal != "" {
+		ctx = context.WithValue(ctx, middleware.CallerKey, middleware.Caller{ID: principal})
+	}
+	r, _ := http.NewRequestWithContext(ctx, http.MethodPost, path, nil)
+	return r
+}
+
+// runResumed runs a resumed dispatch through the pipeline and returns its
+// result, or the outcome it failed with and why
+func (p *Pipeline) runResumed(d *Dispatch) (*contract.ReasonResponse, BatchItemResponse, error) {
+	response := newDispatchRecorder()
+	p.Run(response, d)
+	outcome := recordedResponse(0, d.CorrelationID, response)
+	if outcome.Status == "success" {
+		return outcome.Result, outcome, nil
+	}
+	if d.Err != nil {
+		return nil, outcome, d.Err
+	}
+	return nil, outcome, errors.New(outcome.Reason)
+}
+
+// dispatchRecorder keeps a dispatch's response in memory, for entry points
+// that report the outcome other than as the response itself
+type dispatchRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newDispatchRecorder() *dispatchRecorder {
+	return &dispatchRecorder{header: make(http.Header)}
+}
+
+func (r *dispatchRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *dispatchRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *dispatchRecorder) Write(data []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(data)
+}
+
+// logTimings logs how long each stage took, once per dispatch
+func (p *Pipeline) logTimings(d *Dispatch) {
+	if d.logged || d.timings == nil {
+		return
+	}
+	d.logged = true
+	event := p.logger.Info().
+		Str("correlation_id", d.CorrelationID).
+		Dict("stages", d.timings)
+	if d.halted != "" {
+		event.Str("halted_by", d.halted)
+	}
+	event.Msg("dispatch_stage_timings")
+}
+
+// respond answers a dispatch that passed every stage with the downstream
+// call's outcome
+func (p *Pipeline) respond(w http.ResponseWriter, d *Dispatch, callCtx context.Context) {
+	if d.Err != nil && errors.Is(callCtx.Err(), context.DeadlineExceeded) {
+		p.logger.Error().
+			Err(d.Err).
+			Str("correlation_id", d.CorrelationID).
+			Dur("budget", p.opts.Budget.Total).
+			Msg("dispatch_deadline_exceeded")
+
+		writeProblem(w, d.HTTP, dispatchProblem{
+			status:    http.StatusGatewayTimeout,
+			code:      ErrorCodeDispatchTimeout,
+			title:     "Dispatch deadline exceeded",
+			retryable: true,
+		}, d.CorrelationID)
+		return
+	}
+	if d.Err != nil {
+		problem := downstreamProblem(d.Err)
+		msg := "axon_call_failed"
+		if problem.code == ErrorCodeObligationViolated {
+			msg = "axon_response_violates_obligation"
+		}
+		p.logger.Error().
+			Err(d.Err).
+			Str("correlation_id", d.CorrelationID).
+			Str("error_code", problem.code).
+			Msg(msg)
+
+		writeProblem(w, d.HTTP, problem, d.CorrelationID)
+		return
+	}
+
+	writeDispatchResponse(w, http.StatusOK, DispatchResponse{
+		Status:   "success",
+		Message:  "Axon reasoning completed",
+		Result:   d.Result,
+		Degraded: d.Decision.Degraded,
+	})
+
+	p.logger.Info().
+		Str("correlation_id", d.CorrelationID).
+		Str("status", "success").
+		Msg("dispatch_completed")
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"orbit-service/clients"
+)
+
+// ProblemContentType is the media type of failed dispatch responses
+// (RFC 7807)
+const ProblemContentType = "application/problem+json"
+
+// problemTypePrefix prefixes an error code to form a problem's type URI
+const problemTypePrefix = "urn:orbit:error:"
+
+// Error codes of failed dispatches. They are stable: callers may branch on
+// them, unlike on the title and detail.
+const (
+	ErrorCodeGovernanceTimeout      = "governance_timeout"
+	ErrorCodeGovernanceUnavailable  = "governance_unavailable"
+	ErrorCodeGovernanceError        = "governance_error"
+	ErrorCodeDispatchTimeout        = "dispatch_timeout"
+	ErrorCodeDownstreamUnavailable  = "downstream_unavailable"
+	ErrorCodeDownstreamTimeout      = "downstream_timeout"
+	ErrorCodeDownstreamUnreachable  = "downstream_unreachable"
+	ErrorCodeDownstreamRateLimited  = "downstream_rate_limited"
+	ErrorCodeDownstreamUnauthorized = "downstream_unauthorized"
+	ErrorCodeDownstreamRejected     = "downstream_rejected"
+	ErrorCodeDownstreamInvalid      = "downstream_invalid_response"
+	ErrorCodeDownstreamError        = "downstream_error"
+	ErrorCodeObligationViolated     = "obligation_violated"
+	ErrorCodeObligationFailed       = "obligation_failed"
+	ErrorCodeAuditUnavailable       = "audit_unavailable"
+)
+
+// Problem is an RFC 7807 problem details body, extended with the error's
+// code and the request's correlation ID
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Instance is the path of the failed request
+	Instance      string       `json:"instance,omitempty"`
+	Error         ProblemError `json:"error"`
+	CorrelationID string       `json:"correlation_id,omitempty"`
+	Timestamp     time.Time    `json:"timestamp"`
+}
+
+// ProblemError classifies a problem for programmatic handling
+type ProblemError struct {
+	Code string `json:"code"`
+	// Retryable tells the caller the same request may succeed later, after
+	// Retry-After when it is sent
+	Retryable bool `json:"retryable"`
+	// Target names the downstream service that failed
+	Target string `json:"target,omitempty"`
+}
+
+// dispatchProblem is a failure to be reported as a Problem
+type dispatchProblem struct {
+	status     int
+	code       string
+	title      string
+	detail     string
+	retryable  bool
+	target     string
+	retryAfter time.Duration
+}
+
+// governanceProblem maps a failed governance check. timedOut reports that
+// the check ran out of the governance budget.
+func governanceProblem(err error, timedOut bool) dispatchProblem {
+	if timedOut {
+		return dispatchProblem{status: http.StatusGatewayTimeout, code: ErrorCodeGovernanceTimeout, title: "Governance check timed out", retryable: true}
+	}
+	var unavailable *clients.GovernanceUnavailableError
+	if errors.As(err, &unavailable) {
+		return dispatchProblem{status: http.StatusServiceUnavailable, code: ErrorCodeGovernanceUnavailable, title: "Governance is unavailable", retryable: true, retryAfter: unavailable.RetryAfter}
+	}
+	return dispatchProblem{status: http.StatusBadGateway, code: ErrorCodeGovernanceError, title: "Governance check failed"}
+}
+
+// downstreamProblem maps a failed call to the downstream service. Errors
+// the client did not classify are reported as a bad gateway.
+func downstreamProblem(err error) dispatchProblem {
+	var violation *clients.ObligationViolationError
+	if errors.As(err, &violation) {
+		return dispatchProblem{status: http.StatusBadGateway, code: ErrorCodeObligationViolated, title: "Downstream response violates governance obligations"}
+	}
+
+	var downstream *clients.DownstreamError
+	if !errors.As(err, &downstream) {
+		return dispatchProblem{status: http.StatusBadGateway, code: ErrorCodeDownstreamError, title: "Downstream service failed", retryable: true}
+	}
+
+	problem := dispatchProblem{target: downstream.Target, retryAfter: downstream.RetryAfter}
+	if downstream.StatusCode != 0 {
+		problem.detail = fmt.Sprintf("%s answered with status %d", downstream.Target, downstream.StatusCode)
+	}
+	switch downstream.Kind {
+	case clients.DownstreamBreakerOpen:
+		problem.status, problem.code, problem.title, problem.retryable = http.StatusServiceUnavailable, ErrorCodeDownstreamUnavailable, "Downstream service is unavailable", true
+		if problem.retryAfter <= 0 {
+			// The breaker is about to let a trial call through
+			problem.retryAfter = time.Second
+		}
+	case clients.DownstreamTimeout:
+		problem.status, problem.code, problem.title, problem.retryable = http.StatusGatewayTimeout, ErrorCodeDownstreamTimeout, "Downstream service timed out", true
+	case clients.DownstreamUnreachable:
+		problem.status, problem.code, problem.title, problem.retryable = http.StatusBadGateway, ErrorCodeDownstreamUnreachable, "Downstream service is unreachable", true
+	case clients.DownstreamInvalidResponse:
+		problem.status, problem.code, problem.title = http.StatusBadGateway, ErrorCodeDownstreamInvalid, "Downstream service returned an invalid response"
+	default:
+		problem.status, problem.code, problem.title = statusProblem(downstream.StatusCode)
+		problem.retryable = downstream.StatusCode == http.StatusTooManyRequests || downstream.StatusCode >= 500
+	}
+	return problem
+}
+
+// statusProblem maps the error status a downstream service answered with
+func statusProblem(status int) (int, string, string) {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return http.StatusTooManyRequests, ErrorCodeDownstreamRateLimited, "Downstream service is rate limiting"
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return http.StatusBadGateway, ErrorCodeDownstreamUnauthorized, "Downstream service refused Orbit's credentials"
+	case status >= 500:
+		return http.StatusBadGateway, ErrorCodeDownstreamError, "Downstream service failed"
+	default:
+		return http.StatusBadGateway, ErrorCodeDownstreamRejected, "Downstream service rejected the request"
+	}
+}
+
+// writeProblem writes a problem details response for a failed request,
+// with Retry-After when the problem suggests a wait
+func writeProblem(w http.ResponseWriter, r *http.Request, problem dispatchProblem, correlationID string) {
+	if problem.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(problem.retryAfter)))
+	}
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(problem.status)
+	json.NewEncoder(w).Encode(Problem{
+		Type:     problemTypePrefix + problem.code,
+		Title:    problem.title,
+		Status:   problem.status,
+		Detail:   problem.detail,
+		Instance: r.URL.Path,
+		Error: ProblemError{
+			Code:      problem.code,
+			Retryable: problem.retryable,
+			Target:    problem.target,
+		},
+		CorrelationID: correlationID,
+		Timestamp:     time.Now(),
+	})
+}
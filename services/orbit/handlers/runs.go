@@ -0,0 +1,470 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+	"orbit-service/contract"
+	"orbit-service/middleware"
+	"orbit-service/runs"
+)
+
+//go:embed run_request.schema.json
+var runRequestSchema []byte
+
+var runSchema = mustCompile(runRequestSchema)
+
+// runStartRetryAfter is the Retry-After sent when too many runs are in
+// progress
+const runStartRetryAfter = 5 * time.Second
+
+// RunRequest is the body of POST /runs
+type RunRequest struct {
+	Intent     string                 `json:"intent"`
+	Goal       json.RawMessage        `json:"goal"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Metadata   map[string]string      `json:"metadata,omitempty"`
+	Limits     RunLimits              `json:"limits"`
+}
+
+// RunLimits lowers the configured limits for one run; zero keeps the
+// configured limit
+type RunLimits struct {
+	MaxSteps   int `json:"max_steps,omitempty"`
+	MaxSeconds int `json:"max_seconds,omitempty"`
+	MaxTokens  int `json:"max_tokens,omitempty"`
+}
+
+// RunStatusResponse is what the requester sees of an agent run
+type RunStatusResponse struct {
+	RunID      string          `json:"run_id"`
+	Status     string          `json:"status"`
+	Intent     string          `json:"intent"`
+	Limits     runs.Limits     `json:"limits"`
+	Steps      []runs.Step     `json:"steps"`
+	Usage      contract.Usage  `json:"usage"`
+	Output     json.RawMessage `json:"output,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Limit      string          `json:"limit,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	ExpiresAt  time.Time       `json:"expires_at"`
+	Timestamp  time.Time       `json:"timestamp"`
+}
+
+// RunStartHandler starts agent runs. The run's intent is checked with
+// governance, its obligations and the caller's rate limits before it
+// starts, as a dispatch would be; the run then loops in the background,
+// asking the reasoner for the next action and governing and performing
+// each action as a dispatch of its own, until the reasoner gives a final
+// answer or the run reaches a limit.
+func RunStartHandler(logger zerolog.Logger, governanceClient clients.GovernanceChecker, axonClient clients.AxonCaller, opts DispatchOptions, manager *runs.Manager) http.HandlerFunc {
+	pipeline := newPipeline(logger, governanceClient, axonClient, opts)
+	return func(w http.ResponseWriter, r *http.Request) {
+		correlationID := middleware.GetCorrelationID(r.Context())
+
+		runReq, body, limits, bodyErr := readRunRequest(r, opts.MaxBodyBytes, manager.MaxLimits())
+		if bodyErr != nil {
+			logger.Warn().
+				Str("correlation_id", correlationID).
+				Str("reason", bodyErr.reason).
+				Int("invalid_fields", len(bodyErr.fields)).
+				Msg("run_invalid_body")
+
+			writeDispatchResponse(w, bodyErr.status, DispatchResponse{
+				Status: "error",
+				Reason: bodyErr.reason,
+				Errors: bodyErr.fields,
+			})
+			return
+		}
+
+		caller, routed := routeIntent(opts, axonClient, runReq.Intent)
+		if !routed {
+			writeDispatchResponse(w, http.StatusBadRequest, DispatchResponse{
+				Status: "error",
+				Reason: "Request body is invalid",
+				Errors: []FieldError{{Field: "/intent", Message: "no route for intent " + runReq.Intent}},
+			})
+			return
+		}
+
+		// The run starts once its intent is admitted as a dispatch would
+		// be; the redaction stage redacts its goal
+		start := &Dispatch{
+			CorrelationID: correlationID,
+			Mode:          DispatchModeSync,
+			Request: DispatchRequest{
+				Intent:     runReq.Intent,
+				Input:      runReq.Goal,
+				Parameters: runReq.Parameters,
+				Metadata:   runReq.Metadata,
+			},
+			Body:       body,
+			Caller:     caller,
+			Unapproved: "Runs requiring approval cannot be started",
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), opts.Budget.Total)
+		start.HTTP = r.WithContext(ctx)
+		admitted := pipeline.Admit(w, start, "")
+		cancel()
+		if !admitted {
+			return
+		}
+		goal := start.Request.Input
+
+		run := runs.Run{
+			CorrelationID: correlationID,
+			Intent:        runReq.Intent,
+			Goal:          goal,
+			Parameters:    runReq.Parameters,
+			Metadata:      runReq.Metadata,
+			Limits:        limits,
+		}
+		if caller, ok := middleware.GetCaller(r.Context()); ok {
+			run.Principal = caller.ID
+		}
+
+		run, err := manager.Start(r.Context(), run, agentLoop(r, pipeline, axonClient, opts, start, limits))
+		if errors.Is(err, runs.ErrBusy) || errors.Is(err, runs.ErrClosed) {
+			logger.Warn().
+				Err(err).
+				Str("correlation_id", correlationID).
+				Msg("run_rejected")
+
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(runStartRetryAfter)))
+			writeDispatchResponse(w, http.StatusServiceUnavailable, DispatchResponse{
+				Status: "error",
+				Reason: "Too many runs in progress",
+			})
+			return
+		}
+		if err != nil {
+			logger.Error().
+				Err(err).
+				Str("correlation_id", correlationID).
+				Msg("run_start_failed")
+
+			writeDispatchResponse(w, http.StatusInternalServerError, DispatchResponse{
+				Status: "error",
+				Reason: "Failed to start run",
+			})
+			return
+		}
+
+		w.Header().Set("Location", "/runs/"+run.ID)
+		writeRun(w, http.StatusAccepted, run)
+	}
+}
+
+// readRunRequest reads and validates a run body, filling in the defaults
+// for fields it omits, and returns the limits the run gets. The raw body
+// is returned too, for governance context attributes read from it.
+func readRunRequest(r *http.Request, maxBytes int64, max runs.Limits) (RunRequest, json.RawMessage, runs.Limits, *dispatchBodyError) {
+	fail := func(bodyErr *dispatchBodyError) (RunRequest, json.RawMessage, runs.Limits, *dispatchBodyError) {
+		return RunRequest{}, nil, runs.Limits{}, bodyErr
+	}
+
+	var body []byte
+	if r.Body != nil {
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+		if err != nil {
+			return fail(&dispatchBodyError{status: http.StatusBadRequest, reason: "Request body could not be read"})
+		}
+		if int64(len(data)) > maxBytes {
+			return fail(&dispatchBodyError{
+				status: http.StatusRequestEntityTooLarge,
+				reason: fmt.Sprintf("Request body must not exceed %d bytes", maxBytes),
+			})
+		}
+		body = bytes.TrimSpace(data)
+	}
+
+	var document interface{}
+	if err := decodeJSONNumbers(body, &document); err != nil {
+		return fail(&dispatchBodyError{status: http.StatusBadRequest, reason: "Request body must be valid JSON"})
+	}
+	invalid := func(fields ...FieldError) (RunRequest, json.RawMessage, runs.Limits, *dispatchBodyError) {
+		return fail(&dispatchBodyError{status: http.StatusBadRequest, reason: "Request body is invalid", fields: fields})
+	}
+	if fields := validateDocument(runSchema, document); len(fields) > 0 {
+		return invalid(fields...)
+	}
+
+	var req RunRequest
+	if err := decodeJSONNumbers(body, &req); err != nil {
+		return invalid()
+	}
+	if req.Intent == "" {
+		req.Intent = DefaultIntent
+	}
+
+	limits := max
+	requested := []struct {
+		field string
+		value int
+		max   int
+		into  func(int)
+	}{
+		{"/limits/max_steps", req.Limits.MaxSteps, max.MaxSteps, func(n int) { limits.MaxSteps = n }},
+		{"/limits/max_seconds", req.Limits.MaxSeconds, int(max.MaxDuration / time.Second), func(n int) { limits.MaxDuration = time.Duration(n) * time.Second }},
+		{"/limits/max_tokens", req.Limits.MaxTokens, max.MaxTokens, func(n int) { limits.MaxTokens = n }},
+	}
+	var fields []FieldError
+	for _, limit := range requested {
+		switch {
+		case limit.value == 0:
+		case limit.value > limit.max:
+			fields = append(fields, FieldError{Field: limit.field, Message: fmt.Sprintf("must be at most %d", limit.max)})
+		default:
+			limit.into(limit.value)
+		}
+	}
+	if len(fields) > 0 {
+		return invalid(fields...)
+	}
+	return req, json.RawMessage(body), limits, nil
+}
+
+// runContext is a run's context, which carries the values of the request
+// that started the run, such as its caller, but outlives it
+type runContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c runContext) Value(key interface{}) interface{} {
+	if value := c.Context.Value(key); value != nil {
+		return value
+	}
+	return c.values.Value(key)
+}
+
+// agentLoop performs a run. Each step asks the reasoner, as a governed and
+// rate limited dispatch of the run's intent, for the next action given the
+// goal and the outcome of every earlier action; the action is governed and
+// performed as a dispatch of its own intent. Denied and failed actions are
+// reported back to the reasoner rather than ending the run. The run ends
+// with the reasoner's final answer, when a reasoning request is refused or
+// fails, or at its step or token limit; the run manager enforces its wall
+// time.
+func agentLoop(r *http.Request, pipeline *Pipeline, axonClient clients.AxonCaller, opts DispatchOptions, start *Dispatch, limits runs.Limits) runs.Func {
+	r = r.Clone(r.Context())
+	return func(ctx context.Context, record func(runs.Step) error) (json.RawMessage, error) {
+		r := r.WithContext(runContext{Context: ctx, values: r.Context()})
+
+		turn := contract.AgentTurn{Goal: start.Request.Input, Steps: []contract.AgentStep{}}
+		var usage contract.Usage
+		for i := 1; i <= limits.MaxSteps; i++ {
+			step := runs.Step{
+				Index:         i,
+				CorrelationID: fmt.Sprintf("%s-%d", start.CorrelationID, i),
+				StartedAt:     time.Now(),
+			}
+			turn.RemainingSteps = limits.MaxSteps - i + 1
+
+			decision, stepUsage, err := nextAction(r, pipeline, opts, start, step, turn)
+			if err != nil {
+				return nil, err
+			}
+			step.Usage = stepUsage
+
+			if decision.Action == nil {
+				step.Output = decision.Final
+				step.FinishedAt = time.Now()
+				if err := record(step); err != nil {
+					return nil, err
+				}
+				return decision.Final, nil
+			}
+
+			step.Action = decision.Action
+			outcome := performAction(r, pipeline, axonClient, opts, *decision.Action, step)
+			step.Status = outcome.Status
+			step.StatusCode = outcome.StatusCode
+			step.Reason = outcome.Reason
+			if outcome.Error != nil {
+				step.ErrorCode = outcome.Error.Code
+			}
+			if outcome.Result != nil {
+				step.Output = outcome.Result.Output
+				step.Usage = runs.AddUsage(step.Usage, outcome.Result.Usage)
+			}
+			step.FinishedAt = time.Now()
+			if err := record(step); err != nil {
+				return nil, err
+			}
+
+			turn.Steps = append(turn.Steps, contract.AgentStep{
+				Action:      *decision.Action,
+				Status:      step.Status,
+				Reason:      step.Reason,
+				Observation: step.Output,
+			})
+			usage = runs.AddUsage(usage, step.Usage)
+			if usage.TotalTokens >= limits.MaxTokens {
+				return nil, &runs.LimitError{Limit: runs.LimitTokens}
+			}
+		}
+		return nil, &runs.LimitError{Limit: runs.LimitSteps}
+	}
+}
+
+// nextAction asks the reasoner for a run's next step, as a dispatch of the
+// run's intent. Each step is governed and rate limited afresh, so a run
+// stops once its intent is no longer allowed or its caller is throttled;
+// the first step uses the request charged when the run started.
+func nextAction(r *http.Request, pipeline *Pipeline, opts DispatchOptions, start *Dispatch, step runs.Step, turn contract.AgentTurn) (contract.AgentDecision, contract.Usage, error) {
+	input, err := json.Marshal(turn)
+	if err != nil {
+		return contract.AgentDecision{}, contract.Usage{}, err
+	}
+	d := &Dispatch{
+		CorrelationID: step.CorrelationID,
+		Mode:          DispatchModeSync,
+		Request:       start.Request,
+		Body:          start.Body,
+		Caller:        start.Caller,
+		Unapproved:    "Runs requiring approval cannot continue",
+		RateLimited:   step.Index == 1,
+	}
+	d.Request.Input = input
+
+	result := runStep(r, pipeline, opts, d, step)
+	if result.Status != "success" {
+		if err := r.Context().Err(); err != nil {
+			return contract.AgentDecision{}, contract.Usage{}, err
+		}
+		reason := result.Reason
+		if result.Error != nil {
+			reason = fmt.Sprintf("%s (%s)", result.Reason, result.Error.Code)
+		}
+		return contract.AgentDecision{}, contract.Usage{}, fmt.Errorf("step %d: reasoning failed: %s", step.Index, reason)
+	}
+
+	decision, err := contract.ParseAgentDecision(result.Result.Output)
+	if err != nil {
+		return contract.AgentDecision{}, result.Result.Usage, fmt.Errorf("step %d: %w", step.Index, err)
+	}
+	return decision, result.Result.Usage, nil
+}
+
+// performAction governs and performs the action a reasoner proposed as a
+// dispatch of its intent, reporting the outcome that dispatch would have
+func performAction(r *http.Request, pipeline *Pipeline, axonClient clients.AxonCaller, opts DispatchOptions, action contract.AgentAction, step runs.Step) BatchItemResponse {
+	invalid := func(reason string) BatchItemResponse {
+		pipeline.logger.Warn().
+			Str("correlation_id", step.CorrelationID).
+			Str("intent", action.Intent).
+			Str("reason", reason).
+			Msg("run_action_invalid")
+
+		return BatchItemResponse{
+			Index:         step.Index,
+			Status:        "error",
+			StatusCode:    http.StatusBadRequest,
+			Reason:        reason,
+			CorrelationID: step.CorrelationID,
+		}
+	}
+
+	body, err := json.Marshal(action)
+	if err != nil {
+		return invalid("Proposed action is invalid")
+	}
+	req, bodyErr := decodeDispatchRequest(body)
+	if bodyErr != nil {
+		return invalid("Proposed action is invalid")
+	}
+	caller, routed := routeIntent(opts, axonClient, req.Intent)
+	if !routed {
+		return invalid("No route for intent " + req.Intent)
+	}
+
+	return runStep(r, pipeline, opts, &Dispatch{
+		CorrelationID: step.CorrelationID,
+		Mode:          DispatchModeSync,
+		Request:       req,
+		Body:          body,
+		Caller:        caller,
+		Unapproved:    "Actions requiring approval cannot be taken by a run",
+	}, step)
+}
+
+// runStep runs a step's dispatch through the pipeline under the dispatch
+// budget, reporting the outcome it was answered with
+func runStep(r *http.Request, pipeline *Pipeline, opts DispatchOptions, d *Dispatch, step runs.Step) BatchItemResponse {
+	ctx, cancel := context.WithTimeout(r.Context(), opts.Budget.Total)
+	defer cancel()
+	d.HTTP = r.WithContext(ctx)
+
+	response := newDispatchRecorder()
+	pipeline.Run(response, d)
+	return recordedResponse(step.Index, step.CorrelationID, response)
+}
+
+// RunStatusHandler lets the requester of an agent run follow it and read
+// its transcript. Runs of another caller are reported as missing.
+func RunStatusHandler(logger zerolog.Logger, manager *runs.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		correlationID := middleware.GetCorrelationID(r.Context())
+
+		run, err := manager.Get(r.Context(), mux.Vars(r)["id"])
+		if err == nil && run.Principal != "" {
+			caller, _ := middleware.GetCaller(r.Context())
+			if caller.ID != run.Principal {
+				err = runs.ErrNotFound
+			}
+		}
+		switch {
+		case errors.Is(err, runs.ErrNotFound):
+			writeDispatchResponse(w, http.StatusNotFound, DispatchResponse{
+				Status: "error",
+				Reason: "Run not found",
+			})
+		case err != nil:
+			logger.Error().
+				Err(err).
+				Str("correlation_id", correlationID).
+				Msg("run_store_failed")
+
+			writeDispatchResponse(w, http.StatusInternalServerError, DispatchResponse{
+				Status: "error",
+				Reason: "Run could not be read",
+			})
+		default:
+			writeRun(w, http.StatusOK, run)
+		}
+	}
+}
+
+func writeRun(w http.ResponseWriter, statusCode int, run runs.Run) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(RunStatusResponse{
+		RunID:      run.ID,
+		Status:     run.Status,
+		Intent:     run.Intent,
+		Limits:     run.Limits,
+		Steps:      run.Steps,
+		Usage:      run.Usage,
+		Output:     run.Output,
+		Error:      run.Error,
+		Limit:      run.Limit,
+		CreatedAt:  run.CreatedAt,
+		FinishedAt: run.FinishedAt,
+		ExpiresAt:  run.ExpiresAt,
+		Timestamp:  time.Now(),
+	})
+}
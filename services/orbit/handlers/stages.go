@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/audit"
+	"orbit-service/clients"
+)
+
+// builtinStages returns the built-in dispatch stages by name
+func builtinStages(logger zerolog.Logger, governanceClient clients.GovernanceChecker, axonClient clients.AxonCaller, opts DispatchOptions) map[string]Stage {
+	return map[string]Stage{
+		StageValidate:    validateStage(logger, axonClient, opts),
+		StageGovernance:  governanceStage(logger, governanceClient, opts),
+		StageObligations: obligationsStage(logger, opts),
+		StageRateLimit:   rateLimitStage(logger, opts),
+		StageRedaction:   redactionStage(logger),
+		StageAudit:       auditStage(logger, opts),
+		StageAsync:       asyncStage(logger, opts),
+		StageStream:      streamStage(logger),
+	}
+}
+
+// validateStage reads the body, routes the intent and checks the dispatch
+// mode and callback
+func validateStage(logger zerolog.Logger, axonClient clients.AxonCaller, opts DispatchOptions) Stage {
+	return StageFuncs{BeforeFunc: func(w http.ResponseWriter, d *Dispatch) bool {
+		if d.Caller != nil {
+			// Validated and routed by its entry point
+			return true
+		}
+
+		dispatchReq, body, bodyErr := readDispatchRequest(d.HTTP, opts.MaxBodyBytes)
+		if bodyErr != nil {
+			logger.Warn().
+				Str("correlation_id", d.CorrelationID).
+				Str("reason", bodyErr.reason).
+				Int("invalid_fields", len(bodyErr.fields)).
+				Msg("dispatch_invalid_body")
+
+			writeDispatchResponse(w, bodyErr.status, DispatchResponse{
+				Status: "error",
+				Reason: bodyErr.reason,
+				Errors: bodyErr.fields,
+			})
+			return false
+		}
+
+		caller, routed := routeIntent(opts, axonClient, dispatchReq.Intent)
+		if !routed {
+			logger.Warn().
+				Str("correlation_id", d.CorrelationID).
+				Str("intent", dispatchReq.Intent).
+				Msg("dispatch_intent_not_routed")
+
+			writeDispatchResponse(w, http.StatusBadRequest, DispatchResponse{
+				Status: "error",
+				Reason: "Request body is invalid",
+				Errors: []FieldError{{Field: "/intent", Message: "no route for intent " + dispatchReq.Intent}},
+			})
+			return false
+		}
+
+		mode, ok := dispatchMode(d.HTTP)
+		var modeErr string
+		switch {
+		case !ok:
+			modeErr = fmt.Sprintf("Unknown dispatch mode %q", mode)
+		case mode == DispatchModeAsync && d.Stream:
+			modeErr = "Asynchronous dispatches cannot be streamed"
+		case mode == DispatchModeAsync && opts.Jobs == nil:
+			modeErr = "Asynchronous dispatch is not available"
+		}
+		if modeErr != "" {
+			writeDispatchResponse(w, http.StatusBadRequest, DispatchResponse{
+				Status: "error",
+				Reason: modeErr,
+			})
+			return false
+		}
+
+		// Only asynchronous dispatches report their outcome to a callback
+		if mode == DispatchModeAsync && dispatchReq.Callback != nil {
+			if err := checkCallback(opts, dispatchReq.Callback.URL); err != nil {
+				writeDispatchResponse(w, http.StatusBadRequest, DispatchResponse{
+					Status: "error",
+					Reason: "Request body is invalid",
+					Errors: []FieldError{{Field: "/callback/url", Message: err.Error()}},
+				})
+				return false
+			}
+		}
+
+		d.Mode = mode
+		d.Request = dispatchReq
+		d.Body = body
+		d.Caller = caller
+		return true
+	}}
+}
+
+// governanceStage checks the dispatch with governance under the budget's
+// governance share, holding it for approval or denying it as the decision
+// says
+func governanceStage(logger zerolog.Logger, governanceClient clients.GovernanceChecker, opts DispatchOptions) Stage {
+	return StageFuncs{BeforeFunc: func(w http.ResponseWriter, d *Dispatch) bool {
+		if d.Resumed {
+			return true
+		}
+
+		d.Governance = clients.GovernanceRequest{
+			Service: "orbit",
+			Intent:  d.Request.Intent,
+			Context: buildGovernanceContext(d.HTTP, d.Body, opts.Context, time.Now()),
+		}
+
+		governanceCtx, governanceCancel := context.WithTimeout(d.HTTP.Context(), opts.Budget.Governance)
+		decision, err := governanceClient.CheckPermission(governanceCtx, d.Governance, d.CorrelationID)
+		governanceTimedOut := errors.Is(governanceCtx.Err(), context.DeadlineExceeded)
+		governanceCancel()
+		if err != nil {
+			event := logger.Error().
+				Err(err).
+				Str("correlation_id", d.CorrelationID)
+			var unavailable *clients.GovernanceUnavailableError
+			switch {
+			case governanceTimedOut:
+				event.Dur("budget", opts.Budget.Governance).Msg("governance_check_timed_out")
+			case errors.As(err, &unavailable):
+				event.Msg("governance_unavailable")
+			default:
+				event.Msg("governance_check_failed")
+			}
+
+			writeProblem(w, d.HTTP, governanceProblem(err, governanceTimedOut), d.CorrelationID)
+			return false
+		}
+		d.Decision = decision
+
+		w.Header().Set(GovernanceCacheHeader, cacheStatus(decision))
+		if decision.Degraded != "" {
+			w.Header().Set(GovernanceDegradedHeader, decision.Degraded)
+		}
+
+		if decision.RequiresApproval && d.Unapproved != "" {
+			return d.Refuse(w, http.StatusForbidden, d.Unapproved)
+		}
+		if decision.RequiresApproval {
+			holdForApproval(w, d.HTTP, logger, opts, d.Governance, decision, d.Request, d.CorrelationID)
+			return false
+		}
+
+		if !decision.Allowed {
+			logger.Warn().
+				Str("correlation_id", d.CorrelationID).
+				Str("reason", decision.Reason).
+				Bool("cached", decision.Cached).
+				Str("degraded", decision.Degraded).
+				Msg("governance_denied")
+
+			return d.Refuse(w, http.StatusForbidden, decision.Reason)
+		}
+		return true
+	}}
+}
+
+// obligationsStage refuses dispatches whose obligations cannot be met
+func obligationsStage(logger zerolog.Logger, opts DispatchOptions) Stage {
+	return StageFuncs{BeforeFunc: func(w http.ResponseWriter, d *Dispatch) bool {
+		obligations, ok := checkObligations(w, logger, opts, d.Decision, d.Request.Input, d.Stream, d.CorrelationID)
+		d.Obligations = obligations
+		return ok
+	}}
+}
+
+// rateLimitStage applies the deciding policy's rate limits to the caller
+func rateLimitStage(logger zerolog.Logger, opts DispatchOptions) Stage {
+	return StageFuncs{BeforeFunc: func(w http.ResponseWriter, d *Dispatch) bool {
+		if d.Resumed || d.RateLimited {
+			return true
+		}
+		return allowRate(w, d.HTTP, logger, opts, d.Governance.Intent, d.Decision, d.CorrelationID)
+	}}
+}
+
+// redactionStage redacts the fields the obligations name from the input
+// before the call and from Axon's output after it
+func redactionStage(logger zerolog.Logger) Stage {
+	failed := func(w http.ResponseWriter, d *Dispatch, status int, err error) bool {
+		logger.Error().
+			Err(err).
+			Str("correlation_id", d.CorrelationID).
+			Msg("dispatch_redaction_failed")
+
+		return d.Fail(w, status, ErrorCodeObligationFailed, "Failed to apply governance obligations")
+	}
+
+	return StageFuncs{
+		BeforeFunc: func(w http.ResponseWriter, d *Dispatch) bool {
+			input, err := redactFields(d.Request.Input, d.Obligations.RedactFields)
+			if err != nil {
+				return failed(w, d, http.StatusInternalServerError, err)
+			}
+			d.Request.Input = input
+			return true
+		},
+		AfterFunc: func(w http.ResponseWriter, d *Dispatch) bool {
+			if d.Err != nil {
+				return true
+			}
+			result, err := redactResult(d.Result, d.Obligations)
+			if err != nil {
+				return failed(w, d, http.StatusBadGateway, err)
+			}
+			d.Result = result
+			return true
+		},
+	}
+}
+
+// auditStage records the outcome of the call, refusing to answer when an
+// audit the policy requires could not be written
+func auditStage(logger zerolog.Logger, opts DispatchOptions) Stage {
+	return StageFuncs{AfterFunc: func(w http.ResponseWriter, d *Dispatch) bool {
+		outcome := audit.OutcomeSucceeded
+		if d.Err != nil {
+			outcome = audit.OutcomeFailed
+		}
+		return recordOutcome(w, d.HTTP, logger, opts, d.Obligations, d.Governance, d.Decision, d.Request.Input, outcome, d.Latency, d.CorrelationID)
+	}}
+}
+
+// asyncStage queues asynchronous dispatches as jobs
+func asyncStage(logger zerolog.Logger, opts DispatchOptions) Stage {
+	return StageFuncs{BeforeFunc: func(w http.ResponseWriter, d *Dispatch) bool {
+		if d.Mode != DispatchModeAsync {
+			return true
+		}
+		submitJob(w, d.HTTP, logger, opts, d.Governance, d.Decision, d.Request, d.CorrelationID)
+		return false
+	}}
+}
+
+// streamStage relays streamed dispatches as server-sent events. It makes
+// the call and answers the request itself, leaving the stream's outcome to
+// the After hooks.
+func streamStage(logger zerolog.Logger) Stage {
+	return StageFuncs{BeforeFunc: func(w http.ResponseWriter, d *Dispatch) bool {
+		if !d.Stream {
+			return true
+		}
+		streamer, ok := d.Caller.(clients.AxonStreamer)
+		if !ok {
+			writeDispatchResponse(w, http.StatusNotAcceptable, DispatchResponse{
+				Status: "error",
+				Reason: "Streaming is not supported",
+			})
+			return false
+		}
+
+		ctx := d.HTTP.Context()
+		if d.Obligations.MaxReasoningTime > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d.Obligations.MaxReasoningTime)
+			defer cancel()
+		}
+		start := time.Now()
+		d.Result, d.Err = streamDispatch(w, d.HTTP.WithContext(ctx), logger, streamer, newReasonRequest(d.Request, d.Obligations, d.CorrelationID), d.CorrelationID)
+		d.Latency = time.Since(start)
+		d.Responded = true
+		return true
+	}}
+}
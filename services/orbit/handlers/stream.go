@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+	"orbit-service/contract"
+)
+
+// streamHeartbeatInterval is how often a heartbeat event is sent to the caller
+// while a stream is idle
+const streamHeartbeatInterval = 10 * time.Second
+
+// streamDispatch relays a streamed Axon response to the caller as Server-Sent
+// Events. Axon heartbeats are absorbed and replaced by Orbit's own, so the
+// caller sees a single, consistently numbered event sequence that always
+// ends with a done or error event. A stream cut short by its deadline ends
+// with an error event of status timeout. It returns the response of the
+// done event, or why the stream did not end with one.
+func streamDispatch(w http.ResponseWriter, r *http.Request, logger zerolog.Logger, streamer clients.AxonStreamer, reasonReq contract.ReasonRequest, correlationID string) (*contract.ReasonResponse, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeDispatchResponse(w, http.StatusInternalServerError, DispatchResponse{
+			Status: "error",
+			Reason: "Streaming is not supported",
+		})
+		return nil, errors.New("response writer cannot stream")
+	}
+
+	// Cancelling ctx tears down the Axon stream when the caller disconnects
+	// or a write to the caller fails
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	events, err := streamer.StreamReason(ctx, reasonReq, correlationID)
+	if err != nil {
+		problem := downstreamProblem(err)
+		logger.Error().
+			Err(err).
+			Str("correlation_id", correlationID).
+			Str("error_code", problem.code).
+			Msg("axon_stream_failed")
+
+		writeProblem(w, r, problem, correlationID)
+		return nil, err
+	}
+
+	w.Header().Set("Content-Type", contract.StreamContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var sequence int64
+	var result *contract.ReasonResponse
+	var streamErr error
+	send := func(event contract.StreamEvent) error {
+		sequence++
+		event.ID = sequence
+		event.CorrelationID = correlationID
+		if err := contract.WriteSSE(w, event); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	// relay forwards an Axon event, reporting whether the stream is over
+	relay := func(event contract.StreamEvent) bool {
+		if event.Type == contract.EventHeartbeat {
+			return false
+		}
+		if err := send(event); err != nil {
+			logger.Warn().
+				Err(err).
+				Str("correlation_id", correlationID).
+				Msg("dispatch_stream_write_failed")
+			streamErr = err
+			return true
+		}
+		if event.IsTerminal() {
+			result, streamErr = streamOutcome(event)
+			logger.Info().
+				Str("correlation_id", correlationID).
+				Str("status", event.Status).
+				Int64("events", sequence).
+				Msg("dispatch_stream_completed")
+			return true
+		}
+		return false
+	}
+
+	// stop ends a stream Axon did not finish with an explicit error event,
+	// so the caller can tell a deadline from a dropped connection
+	stop := func() {
+		event := contract.StoppedEvent(ctx.Err())
+		if ctx.Err() == nil {
+			event = contract.StreamEvent{Type: contract.EventError, Status: "failed", Error: "stream ended before completion"}
+		}
+		send(event)
+		streamErr = streamOutcomeError(event)
+		logger.Warn().
+			Str("correlation_id", correlationID).
+			Str("status", event.Status).
+			Int64("events", sequence).
+			Msg("dispatch_stream_stopped")
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				stop()
+				return result, streamErr
+			}
+			if relay(event) {
+				return result, streamErr
+			}
+		case <-heartbeat.C:
+			if err := send(contract.StreamEvent{Type: contract.EventHeartbeat}); err != nil {
+				logger.Warn().
+					Err(err).
+					Str("correlation_id", correlationID).
+					Msg("dispatch_stream_write_failed")
+				return nil, err
+			}
+		case <-ctx.Done():
+			// Relay what Axon sent before the stream was cut, its terminal
+			// event included; the channel closes once the read is aborted
+			for event := range events {
+				if relay(event) {
+					return result, streamErr
+				}
+			}
+			stop()
+			return result, streamErr
+		}
+	}
+}
+
+// streamOutcome returns the response a terminal event carries, or the
+// error it reports
+func streamOutcome(event contract.StreamEvent) (*contract.ReasonResponse, error) {
+	if event.Type == contract.EventDone && event.Response != nil {
+		return event.Response, nil
+	}
+	return nil, streamOutcomeError(event)
+}
+
+func streamOutcomeError(event contract.StreamEvent) error {
+	return fmt.Errorf("stream ended with status %s: %s", event.Status, event.Error)
+}
+
+// wantsStream reports whether the caller asked for a streamed response
+func wantsStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), contract.StreamContentType) ||
+		r.URL.Query().Get("stream") == "true"
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+	"orbit-service/middleware"
+	"orbit-service/webhook"
+)
+
+type DeadLettersResponse struct {
+	DeadLetters []webhook.Delivery `json:"dead_letters"`
+	Timestamp   time.Time          `json:"timestamp"`
+}
+
+type ReplayResponse struct {
+	Status     string    `json:"status"`
+	DeliveryID string    `json:"delivery_id"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// WebhookDeadLettersHandler lists the webhook deliveries that could not be
+// made. The caller must be allowed admin/manage_policies.
+func WebhookDeadLettersHandler(logger zerolog.Logger, dispatcher *webhook.Dispatcher, authorizer clients.GovernanceChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		correlationID := middleware.GetCorrelationID(r.Context())
+
+		if !authorizeAdmin(w, r, logger, authorizer, correlationID) {
+			return
+		}
+
+		deliveries, err := dispatcher.DeadLetters(r.Context())
+		if err != nil {
+			logger.Error().
+				Err(err).
+				Str("correlation_id", correlationID).
+				Msg("webhook_dead_letters_failed")
+
+			writeDispatchResponse(w, http.StatusInternalServerError, DispatchResponse{
+				Status: "error",
+				Reason: "Dead letters could not be read",
+			})
+			return
+		}
+		if deliveries == nil {
+			deliveries = []webhook.Delivery{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(DeadLettersResponse{
+			DeadLetters: deliveries,
+			Timestamp:   time.Now(),
+		})
+	}
+}
+
+// WebhookReplayHandler queues a dead-lettered delivery for another round of
+// attempts; if it fails again it returns to the dead letters. The caller
+// must be allowed admin/manage_policies.
+func WebhookReplayHandler(logger zerolog.Logger, dispatcher *webhook.Dispatcher, authorizer clients.GovernanceChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		correlationID := middleware.GetCorrelationID(r.Context())
+
+		if !authorizeAdmin(w, r, logger, authorizer, correlationID) {
+			return
+		}
+
+		delivery, err := dispatcher.Replay(r.Context(), mux.Vars(r)["id"])
+		switch {
+		case errors.Is(err, webhook.ErrNotFound):
+			writeDispatchResponse(w, http.StatusNotFound, DispatchResponse{
+				Status: "error",
+				Reason: "Delivery not found",
+			})
+			return
+		case errors.Is(err, webhook.ErrQueueFull) || errors.Is(err, webhook.ErrClosed):
+			writeDispatchResponse(w, http.StatusServiceUnavailable, DispatchResponse{
+				Status: "error",
+				Reason: "Webhook delivery queue is full",
+			})
+			return
+		case err != nil:
+			logger.Error().
+				Err(err).
+				Str("correlation_id", correlationID).
+				Msg("webhook_replay_failed")
+
+			writeDispatchResponse(w, http.StatusInternalServerError, DispatchResponse{
+				Status: "error",
+				Reason: "Delivery could not be replayed",
+			})
+			return
+		}
+
+		logger.Info().
+			Str("correlation_id", correlationID).
+			Str("delivery_id", delivery.ID).
+			Msg("webhook_replay_requested")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(ReplayResponse{
+			Status:     "queued",
+			DeliveryID: delivery.ID,
+			Timestamp:  time.Now(),
+		})
+	}
+}
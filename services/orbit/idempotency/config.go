@@ -0,0 +1,95 @@
+package idempotency
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Idempotency stores selected by IDEMPOTENCY_STORE
+const (
+	StoreMemory   = "memory"
+	StoreDynamoDB = "dynamodb"
+	StoreNone     = "none"
+)
+
+// Config controls how long idempotency keys are held
+type Config struct {
+	// TTL is how long a stored response is replayed to retries
+	TTL time.Duration
+	// LockTimeout is how long a request in progress holds its key; a
+	// retry after it runs the request again. It should exceed the
+	// dispatch timeout.
+	LockTimeout time.Duration
+}
+
+// DefaultConfig returns the settings used when none are configured
+func DefaultConfig() Config {
+	return Config{
+		TTL:         24 * time.Hour,
+		LockTimeout: time.Minute,
+	}
+}
+
+// ConfigFromEnv returns the default config overridden by IDEMPOTENCY_TTL
+// and IDEMPOTENCY_LOCK_TIMEOUT (Go duration strings)
+func ConfigFromEnv() (Config, error) {
+	config := DefaultConfig()
+
+	durations := []struct {
+		name string
+		into *time.Duration
+	}{
+		{"IDEMPOTENCY_TTL", &config.TTL},
+		{"IDEMPOTENCY_LOCK_TIMEOUT", &config.LockTimeout},
+	}
+	for _, d := range durations {
+		if value := os.Getenv(d.name); value != "" {
+			duration, err := time.ParseDuration(value)
+			if err != nil || duration <= 0 {
+				return config, fmt.Errorf("invalid %s %q", d.name, value)
+			}
+			*d.into = duration
+		}
+	}
+
+	return config, nil
+}
+
+// StoreFromEnv creates the store selected by IDEMPOTENCY_STORE (default
+// memory). The DynamoDB store reads IDEMPOTENCY_DYNAMODB_TABLE and, for
+// DynamoDB Local, IDEMPOTENCY_DYNAMODB_ENDPOINT. A nil store means the
+// Idempotency-Key header is ignored.
+func StoreFromEnv() (Store, error) {
+	switch kind := os.Getenv("IDEMPOTENCY_STORE"); kind {
+	case StoreNone:
+		return nil, nil
+	case "", StoreMemory:
+		return NewMemoryStore(), nil
+	case StoreDynamoDB:
+		table := os.Getenv("IDEMPOTENCY_DYNAMODB_TABLE")
+		if table == "" {
+			return nil, fmt.Errorf("IDEMPOTENCY_DYNAMODB_TABLE environment variable not set")
+		}
+
+		region := os.Getenv("AWS_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		config := &aws.Config{Region: aws.String(region)}
+		if endpoint := os.Getenv("IDEMPOTENCY_DYNAMODB_ENDPOINT"); endpoint != "" {
+			config.Endpoint = aws.String(endpoint)
+		}
+		sess, err := session.NewSession(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create AWS session: %w", err)
+		}
+		return NewDynamoDBStore(dynamodb.New(sess), table), nil
+	default:
+		return nil, fmt.Errorf("unknown IDEMPOTENCY_STORE %q", kind)
+	}
+}
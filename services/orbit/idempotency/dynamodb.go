@@ -0,0 +1,166 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// DynamoDB item attributes. The table's partition key is the string "pk";
+// enable TTL on "expires_at" so expired records are removed. The record is
+// kept as a JSON document, with its claim token alongside for conditional
+// writes.
+const (
+	dynamoDBKeyAttribute      = "pk"
+	dynamoDBTokenAttribute    = "token"
+	dynamoDBExpiresAttribute  = "expires_at"
+	dynamoDBDocumentAttribute = "record"
+)
+
+// dynamoDBAttempts bounds the retries when a record expires between a
+// failed claim and reading it back
+const dynamoDBAttempts = 3
+
+// DynamoDBStore keeps records in DynamoDB, so a retry is recognised by
+// whichever task it reaches
+type DynamoDBStore struct {
+	client dynamodbiface.DynamoDBAPI
+	table  string
+	now    func() time.Time
+}
+
+// NewDynamoDBStore creates a store keeping records in table
+func NewDynamoDBStore(client dynamodbiface.DynamoDBAPI, table string) *DynamoDBStore {
+	return &DynamoDBStore{client: client, table: table, now: time.Now}
+}
+
+func (s *DynamoDBStore) Claim(ctx context.Context, record Record) (Record, bool, error) {
+	item, err := dynamoDBItem(record)
+	if err != nil {
+		return Record{}, false, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		// TTL deletion lags, so an expired record is overwritten here
+		_, err = s.client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName:           aws.String(s.table),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(#pk) OR #expires_at < :now"),
+			ExpressionAttributeNames: map[string]*string{
+				"#pk":         aws.String(dynamoDBKeyAttribute),
+				"#expires_at": aws.String(dynamoDBExpiresAttribute),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":now": {N: aws.String(strconv.FormatInt(s.now().Unix(), 10))},
+			},
+		})
+		if err == nil {
+			return record, true, nil
+		}
+		var exists *dynamodb.ConditionalCheckFailedException
+		if !errors.As(err, &exists) {
+			return Record{}, false, fmt.Errorf("failed to claim idempotency key: %w", err)
+		}
+
+		existing, found, err := s.get(ctx, record.Key)
+		if err != nil {
+			return Record{}, false, err
+		}
+		if found {
+			return existing, false, nil
+		}
+		if attempt == dynamoDBAttempts {
+			return Record{}, false, fmt.Errorf("idempotency key changed on every attempt")
+		}
+	}
+}
+
+func (s *DynamoDBStore) Complete(ctx context.Context, record Record) error {
+	item, err := dynamoDBItem(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(s.table),
+		Item:                      item,
+		ConditionExpression:       aws.String("#token = :token"),
+		ExpressionAttributeNames:  map[string]*string{"#token": aws.String(dynamoDBTokenAttribute)},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":token": {S: aws.String(record.Token)}},
+	})
+	var lost *dynamodb.ConditionalCheckFailedException
+	if errors.As(err, &lost) {
+		return ErrClaimLost
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store idempotent response: %w", err)
+	}
+	return nil
+}
+
+func (s *DynamoDBStore) Release(ctx context.Context, key, token string) error {
+	_, err := s.client.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			dynamoDBKeyAttribute: {S: aws.String(key)},
+		},
+		ConditionExpression:       aws.String("#token = :token"),
+		ExpressionAttributeNames:  map[string]*string{"#token": aws.String(dynamoDBTokenAttribute)},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":token": {S: aws.String(token)}},
+	})
+	var lost *dynamodb.ConditionalCheckFailedException
+	if errors.As(err, &lost) {
+		return ErrClaimLost
+	}
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// get reads a record, reporting expired ones as missing
+func (s *DynamoDBStore) get(ctx context.Context, key string) (Record, bool, error) {
+	output, err := s.client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			dynamoDBKeyAttribute: {S: aws.String(key)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return Record{}, false, fmt.Errorf("failed to read idempotency key: %w", err)
+	}
+	document := output.Item[dynamoDBDocumentAttribute]
+	if document == nil || document.S == nil {
+		return Record{}, false, nil
+	}
+
+	var record Record
+	if err := json.Unmarshal([]byte(aws.StringValue(document.S)), &record); err != nil {
+		return Record{}, false, fmt.Errorf("invalid idempotency record: %w", err)
+	}
+	if s.now().After(record.ExpiresAt) {
+		return Record{}, false, nil
+	}
+	return record, true, nil
+}
+
+func dynamoDBItem(record Record) (map[string]*dynamodb.AttributeValue, error) {
+	document, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode idempotency record: %w", err)
+	}
+	return map[string]*dynamodb.AttributeValue{
+		dynamoDBKeyAttribute:      {S: aws.String(record.Key)},
+		dynamoDBTokenAttribute:    {S: aws.String(record.Token)},
+		dynamoDBExpiresAttribute:  {N: aws.String(strconv.FormatInt(record.ExpiresAt.Unix(), 10))},
+		dynamoDBDocumentAttribute: {S: aws.String(string(document))},
+	}, nil
+}
@@ -0,0 +1,137 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Record statuses. A key is in progress while its first request runs, and
+// completed once that request's response is stored.
+const (
+	StatusInProgress = "in_progress"
+	StatusCompleted  = "completed"
+)
+
+// ErrClaimLost is returned when completing or releasing a claim that has
+// been abandoned and taken over by another request
+var ErrClaimLost = errors.New("idempotency key claim lost")
+
+// Record is the state of one idempotency key
+type Record struct {
+	Key string `json:"key"`
+	// Fingerprint identifies the request the key was first used with
+	Fingerprint string `json:"fingerprint"`
+	Status      string `json:"status"`
+	// Token identifies the request holding the claim, so an abandoned claim
+	// taken over by a retry cannot be completed by the request it was
+	// abandoned by
+	Token     string    `json:"token"`
+	Response  *Response `json:"response,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is when the key may be used again. For a request in
+	// progress it is when the claim is considered abandoned.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Response is a stored response, replayed to retries
+type Response struct {
+	StatusCode int               `json:"status_code"`
+	Header     map[string]string `json:"header,omitempty"`
+	Body       []byte            `json:"body"`
+}
+
+// Store keeps idempotency records
+type Store interface {
+	// Claim stores record unless its key has an unexpired record, which is
+	// returned instead with claimed false
+	Claim(ctx context.Context, record Record) (existing Record, claimed bool, err error)
+	// Complete replaces the claim holding record's key and token
+	Complete(ctx context.Context, record Record) error
+	// Release drops the claim holding key and token, so the key can be
+	// used again
+	Release(ctx context.Context, key, token string) error
+}
+
+// Key returns the store key for an Idempotency-Key sent by principal. Each
+// principal has its own keys.
+func Key(principal, key string) string {
+	return fmt.Sprintf("%d:%s:%s", len(principal), principal, key)
+}
+
+// Fingerprint digests the parts of a request that must match for a retry
+// to be replayed the first response
+func Fingerprint(parts ...[]byte) string {
+	h := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(h, "%d:", len(part))
+		h.Write(part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// NewToken returns a random claim token
+func NewToken() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(bytes)
+}
+
+// MemoryStore keeps records in memory, for a single task
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+	now     func() time.Time
+}
+
+// NewMemoryStore creates an empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record), now: time.Now}
+}
+
+func (s *MemoryStore) Claim(ctx context.Context, record Record) (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for key, r := range s.records {
+		if now.After(r.ExpiresAt) {
+			delete(s.records, key)
+		}
+	}
+
+	if existing, ok := s.records[record.Key]; ok {
+		return existing, false, nil
+	}
+	s.records[record.Key] = record
+	return record, true, nil
+}
+
+func (s *MemoryStore) Complete(ctx context.Context, record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.records[record.Key].Token != record.Token {
+		return ErrClaimLost
+	}
+	s.records[record.Key] = record
+	return nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, key, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.records[key].Token != token {
+		return ErrClaimLost
+	}
+	delete(s.records, key)
+	return nil
+}
@@ -0,0 +1,113 @@
+package jobs
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Job stores selected by JOB_STORE
+const (
+	StoreMemory   = "memory"
+	StoreDynamoDB = "dynamodb"
+)
+
+// Config controls how many jobs run at once and how long they are kept
+type Config struct {
+	// Workers is the number of jobs run at once
+	Workers int
+	// QueueSize is the number of jobs that may wait for a worker
+	QueueSize int
+	// Timeout bounds a job's dispatch
+	Timeout time.Duration
+	// ResultTTL is how long a finished job and its result are kept
+	ResultTTL time.Duration
+}
+
+// DefaultConfig returns the job settings used when none are configured
+func DefaultConfig() Config {
+	return Config{
+		Workers:   4,
+		QueueSize: 100,
+		Timeout:   5 * time.Minute,
+		ResultTTL: time.Hour,
+	}
+}
+
+// ConfigFromEnv returns the default config overridden by JOB_WORKERS,
+// JOB_QUEUE_SIZE, JOB_TIMEOUT and JOB_RESULT_TTL (Go duration strings)
+func ConfigFromEnv() (Config, error) {
+	config := DefaultConfig()
+
+	counts := []struct {
+		name string
+		into *int
+	}{
+		{"JOB_WORKERS", &config.Workers},
+		{"JOB_QUEUE_SIZE", &config.QueueSize},
+	}
+	for _, c := range counts {
+		if value := os.Getenv(c.name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return config, fmt.Errorf("invalid %s %q", c.name, value)
+			}
+			*c.into = n
+		}
+	}
+
+	durations := []struct {
+		name string
+		into *time.Duration
+	}{
+		{"JOB_TIMEOUT", &config.Timeout},
+		{"JOB_RESULT_TTL", &config.ResultTTL},
+	}
+	for _, d := range durations {
+		if value := os.Getenv(d.name); value != "" {
+			duration, err := time.ParseDuration(value)
+			if err != nil || duration <= 0 {
+				return config, fmt.Errorf("invalid %s %q", d.name, value)
+			}
+			*d.into = duration
+		}
+	}
+
+	return config, nil
+}
+
+// StoreFromEnv creates the store selected by JOB_STORE (default memory).
+// The DynamoDB store reads JOB_DYNAMODB_TABLE and, for DynamoDB Local,
+// JOB_DYNAMODB_ENDPOINT.
+func StoreFromEnv() (Store, error) {
+	switch kind := os.Getenv("JOB_STORE"); kind {
+	case "", StoreMemory:
+		return NewMemoryStore(), nil
+	case StoreDynamoDB:
+		table := os.Getenv("JOB_DYNAMODB_TABLE")
+		if table == "" {
+			return nil, fmt.Errorf("JOB_DYNAMODB_TABLE environment variable not set")
+		}
+
+		region := os.Getenv("AWS_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		config := &aws.Config{Region: aws.String(region)}
+		if endpoint := os.Getenv("JOB_DYNAMODB_ENDPOINT"); endpoint != "" {
+			config.Endpoint = aws.String(endpoint)
+		}
+		sess, err := session.NewSession(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create AWS session: %w", err)
+		}
+		return NewDynamoDBStore(dynamodb.New(sess), table), nil
+	default:
+		return nil, fmt.Errorf("unknown JOB_STORE %q", kind)
+	}
+}
@@ -0,0 +1,137 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// DynamoDB item attributes. The table's partition key is the string "pk";
+// enable TTL on "expires_at" so expired jobs are removed. The job itself is
+// kept as a JSON document, with its status alongside for conditional writes.
+const (
+	dynamoDBKeyAttribute      = "pk"
+	dynamoDBStatusAttribute   = "status"
+	dynamoDBExpiresAttribute  = "expires_at"
+	dynamoDBDocumentAttribute = "job"
+)
+
+// dynamoDBAttempts bounds the retries when another task updates the same
+// job between our read and our write
+const dynamoDBAttempts = 3
+
+// DynamoDBStore keeps jobs in DynamoDB, so any task can report on or cancel
+// a job. Updates are conditional on the status they were read with.
+type DynamoDBStore struct {
+	client dynamodbiface.DynamoDBAPI
+	table  string
+}
+
+// NewDynamoDBStore creates a store keeping jobs in table
+func NewDynamoDBStore(client dynamodbiface.DynamoDBAPI, table string) *DynamoDBStore {
+	return &DynamoDBStore{client: client, table: table}
+}
+
+func (s *DynamoDBStore) Create(ctx context.Context, job Job) error {
+	item, err := dynamoDBItem(job)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:                aws.String(s.table),
+		Item:                     item,
+		ConditionExpression:      aws.String("attribute_not_exists(#pk)"),
+		ExpressionAttributeNames: map[string]*string{"#pk": aws.String(dynamoDBKeyAttribute)},
+	})
+	var exists *dynamodb.ConditionalCheckFailedException
+	if errors.As(err, &exists) {
+		return fmt.Errorf("job %s already exists", job.ID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create job: %w", err)
+	}
+	return nil
+}
+
+func (s *DynamoDBStore) Get(ctx context.Context, id string) (Job, error) {
+	output, err := s.client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			dynamoDBKeyAttribute: {S: aws.String(id)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return Job{}, fmt.Errorf("failed to read job: %w", err)
+	}
+	document := output.Item[dynamoDBDocumentAttribute]
+	if document == nil || document.S == nil {
+		return Job{}, ErrNotFound
+	}
+
+	var job Job
+	decoder := json.NewDecoder(bytes.NewReader([]byte(aws.StringValue(document.S))))
+	decoder.UseNumber()
+	if err := decoder.Decode(&job); err != nil {
+		return Job{}, fmt.Errorf("invalid job %s: %w", id, err)
+	}
+	return job, nil
+}
+
+func (s *DynamoDBStore) Update(ctx context.Context, id string, from []string, fn func(*Job)) (Job, error) {
+	for attempt := 1; ; attempt++ {
+		job, err := s.Get(ctx, id)
+		if err != nil {
+			return Job{}, err
+		}
+		if !hasStatus(job, from) {
+			return job, &StatusError{ID: id, Status: job.Status}
+		}
+
+		read := job.Status
+		fn(&job)
+		item, err := dynamoDBItem(job)
+		if err != nil {
+			return Job{}, err
+		}
+
+		_, err = s.client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName:                 aws.String(s.table),
+			Item:                      item,
+			ConditionExpression:       aws.String("#status = :read"),
+			ExpressionAttributeNames:  map[string]*string{"#status": aws.String(dynamoDBStatusAttribute)},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":read": {S: aws.String(read)}},
+		})
+		if err == nil {
+			return job, nil
+		}
+		var changed *dynamodb.ConditionalCheckFailedException
+		if !errors.As(err, &changed) {
+			return Job{}, fmt.Errorf("failed to update job: %w", err)
+		}
+		if attempt == dynamoDBAttempts {
+			return Job{}, fmt.Errorf("job %s changed on every attempt", id)
+		}
+	}
+}
+
+func dynamoDBItem(job Job) (map[string]*dynamodb.AttributeValue, error) {
+	document, err := json.Marshal(job)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode job: %w", err)
+	}
+	return map[string]*dynamodb.AttributeValue{
+		dynamoDBKeyAttribute:      {S: aws.String(job.ID)},
+		dynamoDBStatusAttribute:   {S: aws.String(job.Status)},
+		dynamoDBExpiresAttribute:  {N: aws.String(strconv.FormatInt(job.ExpiresAt.Unix(), 10))},
+		dynamoDBDocumentAttribute: {S: aws.String(string(document))},
+	}, nil
+}
@@ -0,0 +1,175 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"orbit-service/clients"
+	"orbit-service/contract"
+)
+
+// Job statuses. A job is queued until a worker picks it up, then running
+// until it succeeds or fails; queued and running jobs may be cancelled.
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// ErrNotFound is returned for an unknown or expired job ID
+var ErrNotFound = errors.New("job not found")
+
+// StatusError is returned when a job is not in a status an operation
+// requires, for example when cancelling one that already finished
+type StatusError struct {
+	ID     string
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("job %s is %s", e.ID, e.Status)
+}
+
+// Error codes of failures the pool classifies itself: a job that failed for
+// a reason its runner did not classify, and one the pool was closed under
+// before it finished, which may be submitted again
+const (
+	ErrorCodeJobFailed      = "job_failed"
+	ErrorCodeJobInterrupted = "job_interrupted"
+)
+
+// Failure is a runner's error classified with a stable code and title. A
+// failed job reports only these; the error itself may carry internal
+// detail and is only logged.
+type Failure struct {
+	Code  string
+	Title string
+	Err   error
+}
+
+func (f *Failure) Error() string {
+	return f.Err.Error()
+}
+
+func (f *Failure) Unwrap() error {
+	return f.Err
+}
+
+// Job is an allowed dispatch run in the background. Like a held approval,
+// it keeps the input (already redacted as the policy requires) and the
+// decision it was allowed under, which its outcome is audited against.
+type Job struct {
+	ID            string                 `json:"id"`
+	Status        string                 `json:"status"`
+	CorrelationID string                 `json:"correlation_id"`
+	Principal     string                 `json:"principal,omitempty"`
+	Service       string                 `json:"service"`
+	Intent        string                 `json:"intent"`
+	Context       map[string]interface{} `json:"context,omitempty"`
+	Reason        string                 `json:"reason,omitempty"`
+	PolicyVersion string                 `json:"policy_version,omitempty"`
+	Degraded      string                 `json:"degraded,omitempty"`
+	Obligations   []clients.Obligation   `json:"obligations,omitempty"`
+	Input         json.RawMessage        `json:"input"`
+	Parameters    map[string]interface{} `json:"parameters,omitempty"`
+	Metadata      map[string]string      `json:"metadata,omitempty"`
+	Callback      string                 `json:"callback,omitempty"`
+	CreatedAt     time.Time              `json:"created_at"`
+	StartedAt     *time.Time             `json:"started_at,omitempty"`
+	FinishedAt    *time.Time             `json:"finished_at,omitempty"`
+	// ExpiresAt is when the job and its result are forgotten
+	ExpiresAt time.Time                `json:"expires_at"`
+	Result    *contract.ReasonResponse `json:"result,omitempty"`
+	// ErrorCode and Error are the code and title of a failed job's Failure
+	ErrorCode string `json:"error_code,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Finished reports whether the job has reached a final status
+func (j Job) Finished() bool {
+	return j.Status == StatusSucceeded || j.Status == StatusFailed || j.Status == StatusCancelled
+}
+
+// Store keeps jobs
+type Store interface {
+	Create(ctx context.Context, job Job) error
+	Get(ctx context.Context, id string) (Job, error)
+	// Update applies fn to the job if its status is one of from, and returns
+	// the updated job. It returns a *StatusError otherwise, so a job cannot
+	// both finish and be cancelled.
+	Update(ctx context.Context, id string, from []string, fn func(*Job)) (Job, error)
+}
+
+// MemoryStore keeps jobs in memory. Jobs are local to the task that created
+// them, so a deployment with several tasks must route job requests to the
+// same task or use a shared store.
+type MemoryStore struct {
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+// NewMemoryStore creates an empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: make(map[string]Job)}
+}
+
+func (s *MemoryStore) Create(ctx context.Context, job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[job.ID]; ok {
+		return fmt.Errorf("job %s already exists", job.ID)
+	}
+
+	// Drop expired jobs, keeping the store bounded
+	for id, existing := range s.jobs {
+		if job.CreatedAt.After(existing.ExpiresAt) {
+			delete(s.jobs, id)
+		}
+	}
+
+	s.jobs[job.ID] = job
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return job, nil
+}
+
+func (s *MemoryStore) Update(ctx context.Context, id string, from []string, fn func(*Job)) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	if !hasStatus(job, from) {
+		return job, &StatusError{ID: id, Status: job.Status}
+	}
+	fn(&job)
+	s.jobs[id] = job
+	return job, nil
+}
+
+func hasStatus(job Job, statuses []string) bool {
+	for _, status := range statuses {
+		if job.Status == status {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,298 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/contract"
+)
+
+// ErrQueueFull is returned when every worker is busy and the queue is full
+var ErrQueueFull = errors.New("job queue is full")
+
+// ErrClosed is returned for jobs submitted after the pool is closed
+var ErrClosed = errors.New("job pool is closed")
+
+// Runner performs a job's dispatch. It must return once ctx is done.
+type Runner func(ctx context.Context, job Job) (*contract.ReasonResponse, error)
+
+// Listener is told about every job that reaches a final status
+type Listener interface {
+	JobFinished(job Job)
+}
+
+// Pool runs jobs on a bounded number of workers. Jobs wait in a bounded
+// queue in the task that accepted them; with a shared store, any task can
+// report on a job, but only the accepting task runs it.
+type Pool struct {
+	logger   zerolog.Logger
+	store    Store
+	config   Config
+	run      Runner
+	listener Listener
+	now      func() time.Time
+
+	// ctx is the parent of every job's context; Close cancels it
+	ctx  context.Context
+	stop context.CancelFunc
+
+	// slots bounds the jobs accepted but not yet finished by this task
+	slots   chan struct{}
+	queue   chan string
+	workers sync.WaitGroup
+
+	mu      sync.Mutex
+	closed  bool
+	cancels map[string]context.CancelFunc
+}
+
+// NewPool creates a pool keeping jobs in store and starts its workers.
+// listener, if not nil, is told about finished jobs.
+func NewPool(logger zerolog.Logger, store Store, config Config, run Runner, listener Listener) *Pool {
+	capacity := config.Workers + config.QueueSize
+	p := &Pool{
+		logger:   logger,
+		store:    store,
+		config:   config,
+		run:      run,
+		listener: listener,
+		now:      time.Now,
+		slots:    make(chan struct{}, capacity),
+		queue:    make(chan string, capacity),
+		cancels:  make(map[string]context.CancelFunc),
+	}
+	p.ctx, p.stop = context.WithCancel(context.Background())
+	p.workers.Add(config.Workers)
+	for i := 0; i < config.Workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Submit queues a job, assigning its ID, status and expiry. It returns
+// ErrQueueFull rather than waiting for room.
+func (p *Pool) Submit(ctx context.Context, job Job) (Job, error) {
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		return Job{}, ErrQueueFull
+	}
+
+	job.ID = newJobID()
+	job.Status = StatusQueued
+	job.CreatedAt = p.now()
+	// An unfinished job outlives the longest it can take, so a job
+	// abandoned by a task that stopped is eventually forgotten too
+	job.ExpiresAt = job.CreatedAt.Add(p.config.Timeout + p.config.ResultTTL)
+
+	if err := p.store.Create(ctx, job); err != nil {
+		<-p.slots
+		return Job{}, fmt.Errorf("failed to create job: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		// The job is created but will never run; mark it failed rather
+		// than leave it queued
+		<-p.slots
+		p.finish(job.ID, []string{StatusQueued}, nil, ErrClosed)
+		return Job{}, ErrClosed
+	}
+	p.queue <- job.ID
+
+	p.logger.Info().
+		Str("job_id", job.ID).
+		Str("correlation_id", job.CorrelationID).
+		Str("intent", job.Intent).
+		Msg("job_queued")
+	return job, nil
+}
+
+// Get returns a job, reporting expired ones as missing
+func (p *Pool) Get(ctx context.Context, id string) (Job, error) {
+	job, err := p.store.Get(ctx, id)
+	if err != nil {
+		return Job{}, err
+	}
+	if p.now().After(job.ExpiresAt) {
+		return Job{}, ErrNotFound
+	}
+	return job, nil
+}
+
+// Cancel cancels a queued or running job. A running job's dispatch is
+// aborted if it runs in this task; elsewhere its result is discarded.
+func (p *Pool) Cancel(ctx context.Context, id string) (Job, error) {
+	if _, err := p.Get(ctx, id); err != nil {
+		return Job{}, err
+	}
+
+	finished := p.now()
+	job, err := p.store.Update(ctx, id, []string{StatusQueued, StatusRunning}, func(j *Job) {
+		j.Status = StatusCancelled
+		j.FinishedAt = &finished
+		j.ExpiresAt = finished.Add(p.config.ResultTTL)
+	})
+	if err != nil {
+		return job, err
+	}
+
+	p.mu.Lock()
+	cancel := p.cancels[id]
+	p.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+
+	p.logger.Info().
+		Str("job_id", id).
+		Str("correlation_id", job.CorrelationID).
+		Msg("job_cancelled")
+	p.notify(job)
+	return job, nil
+}
+
+// Close stops accepting jobs, interrupts the running ones and fails the
+// queued ones, and waits for the workers to stop. Jobs are not run to
+// completion: that could take up to Timeout each, longer than a stopping
+// task is given.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+	p.stop()
+	p.workers.Wait()
+}
+
+func (p *Pool) work() {
+	defer p.workers.Done()
+	for id := range p.queue {
+		p.process(id)
+		<-p.slots
+	}
+}
+
+func (p *Pool) process(id string) {
+	if p.ctx.Err() != nil {
+		// Queued when the pool closed
+		p.finish(id, []string{StatusQueued}, nil, interrupted())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(p.ctx, p.config.Timeout)
+	defer cancel()
+
+	// The job can be cancelled as soon as it is running
+	p.mu.Lock()
+	p.cancels[id] = cancel
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.cancels, id)
+		p.mu.Unlock()
+	}()
+
+	started := p.now()
+	job, err := p.store.Update(context.Background(), id, []string{StatusQueued}, func(j *Job) {
+		j.Status = StatusRunning
+		j.StartedAt = &started
+	})
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		// Cancelled while queued
+		return
+	}
+	if err != nil {
+		p.logger.Error().
+			Err(err).
+			Str("job_id", id).
+			Msg("job_start_failed")
+		return
+	}
+
+	result, runErr := p.run(ctx, job)
+	if runErr != nil && p.ctx.Err() != nil {
+		runErr = interrupted()
+	}
+	p.finish(id, []string{StatusRunning}, result, runErr)
+}
+
+// finish records a job's result; a non-nil runErr marks it failed
+func (p *Pool) finish(id string, from []string, result *contract.ReasonResponse, runErr error) {
+	finished := p.now()
+	job, err := p.store.Update(context.Background(), id, from, func(j *Job) {
+		j.FinishedAt = &finished
+		j.ExpiresAt = finished.Add(p.config.ResultTTL)
+		if runErr != nil {
+			j.Status = StatusFailed
+			j.ErrorCode, j.Error = failure(runErr)
+			return
+		}
+		j.Status = StatusSucceeded
+		j.Result = result
+	})
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		p.logger.Info().
+			Str("job_id", id).
+			Str("status", statusErr.Status).
+			Msg("job_result_discarded")
+		return
+	}
+	if err != nil {
+		p.logger.Error().
+			Err(err).
+			Str("job_id", id).
+			Msg("job_completion_failed")
+		return
+	}
+
+	event := p.logger.Info()
+	if runErr != nil {
+		event = p.logger.Error().Err(runErr).Str("error_code", job.ErrorCode)
+	}
+	event.
+		Str("job_id", id).
+		Str("correlation_id", job.CorrelationID).
+		Str("status", job.Status).
+		Msg("job_finished")
+	p.notify(job)
+}
+
+// interrupted is the failure of a job the pool was closed under
+func interrupted() error {
+	return &Failure{Code: ErrorCodeJobInterrupted, Title: "Job was interrupted by a shutdown", Err: ErrClosed}
+}
+
+// failure returns the code and title a failed job reports for err
+func failure(err error) (string, string) {
+	var f *Failure
+	if errors.As(err, &f) {
+		return f.Code, f.Title
+	}
+	return ErrorCodeJobFailed, "Job failed"
+}
+
+func (p *Pool) notify(job Job) {
+	if p.listener != nil {
+		p.listener.JobFinished(job)
+	}
+}
+
+func newJobID() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(bytes)
+}
@@ -0,0 +1,529 @@
+// Package jsonschema validates decoded JSON against a subset of JSON Schema
+// draft-07: type, enum, const, the numeric, string, array and object size
+// bounds, required, properties, additionalProperties, items and the allOf,
+// anyOf, oneOf and not combinators. Annotations such as description and
+// format are ignored. Compile refuses schemas using any other keyword, so a
+// schema is never silently checked less strictly than its author intended.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// annotations are keywords that do not constrain values
+var annotations = map[string]bool{
+	"$schema":     true,
+	"$id":         true,
+	"$comment":    true,
+	"title":       true,
+	"description": true,
+	"default":     true,
+	"examples":    true,
+	"format":      true,
+	"readOnly":    true,
+	"writeOnly":   true,
+}
+
+var knownTypes = map[string]bool{
+	"null":    true,
+	"boolean": true,
+	"object":  true,
+	"array":   true,
+	"number":  true,
+	"integer": true,
+	"string":  true,
+}
+
+// Schema is a compiled schema
+type Schema struct {
+	// never is the schema false, which no value matches
+	never bool
+
+	types            []string
+	enum             []interface{}
+	constant         interface{}
+	hasConst         bool
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	minLength        *int
+	maxLength        *int
+	pattern          *regexp.Regexp
+	minItems         *int
+	maxItems         *int
+	minProperties    *int
+	maxProperties    *int
+	items            *Schema
+	required         []string
+	properties       map[string]*Schema
+	additional       *Schema
+	allOf            []*Schema
+	anyOf            []*Schema
+	oneOf            []*Schema
+	not              *Schema
+}
+
+// ValidationError is a value that does not match the schema. Path is a JSON
+// pointer to the value, empty for the document itself.
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// Compile parses a schema document
+func Compile(data []byte) (*Schema, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var raw interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+	return compile(raw, "")
+}
+
+func compile(raw interface{}, path string) (*Schema, error) {
+	switch v := raw.(type) {
+	case bool:
+		return &Schema{never: !v}, nil
+	case map[string]interface{}:
+		return compileObject(v, path)
+	}
+	return nil, fmt.Errorf("schema%s must be an object or a boolean", at(path))
+}
+
+func compileObject(raw map[string]interface{}, path string) (*Schema, error) {
+	s := &Schema{}
+
+	keywords := make([]string, 0, len(raw))
+	for keyword := range raw {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+
+	for _, keyword := range keywords {
+		value := raw[keyword]
+		keywordPath := path + "/" + keyword
+		var err error
+
+		switch keyword {
+		case "type":
+			s.types, err = compileTypes(value)
+		case "enum":
+			values, ok := value.([]interface{})
+			if !ok || len(values) == 0 {
+				err = fmt.Errorf("must be a non-empty array")
+			}
+			s.enum = values
+		case "const":
+			s.constant, s.hasConst = value, true
+		case "minimum":
+			s.minimum, err = compileNumber(value)
+		case "maximum":
+			s.maximum, err = compileNumber(value)
+		case "exclusiveMinimum":
+			s.exclusiveMinimum, err = compileNumber(value)
+		case "exclusiveMaximum":
+			s.exclusiveMaximum, err = compileNumber(value)
+		case "minLength":
+			s.minLength, err = compileCount(value)
+		case "maxLength":
+			s.maxLength, err = compileCount(value)
+		case "minItems":
+			s.minItems, err = compileCount(value)
+		case "maxItems":
+			s.maxItems, err = compileCount(value)
+		case "minProperties":
+			s.minProperties, err = compileCount(value)
+		case "maxProperties":
+			s.maxProperties, err = compileCount(value)
+		case "pattern":
+			expr, ok := value.(string)
+			if !ok {
+				err = fmt.Errorf("must be a string")
+				break
+			}
+			s.pattern, err = regexp.Compile(expr)
+		case "items":
+			s.items, err = compile(value, keywordPath)
+		case "required":
+			s.required, err = compileStrings(value)
+		case "properties":
+			properties, ok := value.(map[string]interface{})
+			if !ok {
+				err = fmt.Errorf("must be an object")
+				break
+			}
+			s.properties = make(map[string]*Schema, len(properties))
+			for name, property := range properties {
+				if s.properties[name], err = compile(property, keywordPath+"/"+name); err != nil {
+					return nil, err
+				}
+			}
+		case "additionalProperties":
+			s.additional, err = compile(value, keywordPath)
+		case "allOf", "anyOf", "oneOf":
+			var schemas []*Schema
+			schemas, err = compileList(value, keywordPath)
+			switch keyword {
+			case "allOf":
+				s.allOf = schemas
+			case "anyOf":
+				s.anyOf = schemas
+			default:
+				s.oneOf = schemas
+			}
+		case "not":
+			s.not, err = compile(value, keywordPath)
+		default:
+			if !annotations[keyword] {
+				err = fmt.Errorf("keyword is not supported")
+			}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("schema%s: %w", at(keywordPath), err)
+		}
+	}
+	return s, nil
+}
+
+func compileTypes(value interface{}) ([]string, error) {
+	var types []string
+	switch v := value.(type) {
+	case string:
+		types = []string{v}
+	case []interface{}:
+		names, err := compileStrings(v)
+		if err != nil {
+			return nil, err
+		}
+		types = names
+	default:
+		return nil, fmt.Errorf("must be a type name or a list of them")
+	}
+	for _, t := range types {
+		if !knownTypes[t] {
+			return nil, fmt.Errorf("unknown type %q", t)
+		}
+	}
+	return types, nil
+}
+
+func compileNumber(value interface{}) (*float64, error) {
+	n, ok := number(value)
+	if !ok {
+		return nil, fmt.Errorf("must be a number")
+	}
+	return &n, nil
+}
+
+func compileCount(value interface{}) (*int, error) {
+	n, ok := number(value)
+	if !ok || n < 0 || n != math.Trunc(n) {
+		return nil, fmt.Errorf("must be a non-negative integer")
+	}
+	count := int(n)
+	return &count, nil
+}
+
+func compileStrings(value interface{}) ([]string, error) {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("must be an array of strings")
+	}
+	strs := make([]string, 0, len(items))
+	for _, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be an array of strings")
+		}
+		strs = append(strs, s)
+	}
+	return strs, nil
+}
+
+func compileList(value interface{}, path string) ([]*Schema, error) {
+	items, ok := value.([]interface{})
+	if !ok || len(items) == 0 {
+		return nil, fmt.Errorf("must be a non-empty array of schemas")
+	}
+	schemas := make([]*Schema, len(items))
+	for i, item := range items {
+		schema, err := compile(item, fmt.Sprintf("%s/%d", path, i))
+		if err != nil {
+			return nil, err
+		}
+		schemas[i] = schema
+	}
+	return schemas, nil
+}
+
+// Validate checks a value decoded from JSON, preferably with UseNumber, and
+// returns every mismatch found
+func (s *Schema) Validate(value interface{}) []ValidationError {
+	var errs []ValidationError
+	s.validate(value, "", &errs)
+	return errs
+}
+
+func (s *Schema) validate(value interface{}, path string, errs *[]ValidationError) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.never {
+		fail("no value is allowed here")
+		return
+	}
+
+	if len(s.types) > 0 && !matchesType(value, s.types) {
+		fail("must be of type %s, got %s", strings.Join(s.types, " or "), typeOf(value))
+		// The remaining keywords assume the right type
+		return
+	}
+
+	if s.enum != nil {
+		found := false
+		for _, allowed := range s.enum {
+			if equal(value, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %s", describe(s.enum))
+		}
+	}
+	if s.hasConst && !equal(value, s.constant) {
+		fail("must be %s", describe([]interface{}{s.constant}))
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := len([]rune(v))
+		if s.minLength != nil && length < *s.minLength {
+			fail("must be at least %d characters", *s.minLength)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			fail("must be at most %d characters", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("must match pattern %q", s.pattern.String())
+		}
+	case []interface{}:
+		if s.minItems != nil && len(v) < *s.minItems {
+			fail("must have at least %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			fail("must have at most %d items", *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range v {
+				s.items.validate(item, fmt.Sprintf("%s/%d", path, i), errs)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		if s.minProperties != nil && len(v) < *s.minProperties {
+			fail("must have at least %d properties", *s.minProperties)
+		}
+		if s.maxProperties != nil && len(v) > *s.maxProperties {
+			fail("must have at most %d properties", *s.maxProperties)
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			propertyPath := path + "/" + escape(name)
+			if property, ok := s.properties[name]; ok {
+				property.validate(v[name], propertyPath, errs)
+			} else if s.additional != nil {
+				if s.additional.never {
+					*errs = append(*errs, ValidationError{Path: propertyPath, Message: "property is not allowed"})
+					continue
+				}
+				s.additional.validate(v[name], propertyPath, errs)
+			}
+		}
+	default:
+		if n, ok := number(value); ok {
+			if s.minimum != nil && n < *s.minimum {
+				fail("must be at least %v", *s.minimum)
+			}
+			if s.maximum != nil && n > *s.maximum {
+				fail("must be at most %v", *s.maximum)
+			}
+			if s.exclusiveMinimum != nil && n <= *s.exclusiveMinimum {
+				fail("must be greater than %v", *s.exclusiveMinimum)
+			}
+			if s.exclusiveMaximum != nil && n >= *s.exclusiveMaximum {
+				fail("must be less than %v", *s.exclusiveMaximum)
+			}
+		}
+	}
+
+	for _, sub := range s.allOf {
+		sub.validate(value, path, errs)
+	}
+	if len(s.anyOf) > 0 && countMatches(s.anyOf, value) == 0 {
+		fail("must match at least one of the anyOf schemas")
+	}
+	if len(s.oneOf) > 0 {
+		if matches := countMatches(s.oneOf, value); matches != 1 {
+			fail("must match exactly one of the oneOf schemas, matched %d", matches)
+		}
+	}
+	if s.not != nil && len(s.not.Validate(value)) == 0 {
+		fail("must not match the not schema")
+	}
+}
+
+func countMatches(schemas []*Schema, value interface{}) int {
+	matches := 0
+	for _, schema := range schemas {
+		if len(schema.Validate(value)) == 0 {
+			matches++
+		}
+	}
+	return matches
+}
+
+func matchesType(value interface{}, types []string) bool {
+	actual := typeOf(value)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		n, ok := number(v)
+		if !ok {
+			return fmt.Sprintf("%T", v)
+		}
+		if n == math.Trunc(n) && !math.IsInf(n, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+}
+
+// number reads the numeric types produced by decoding JSON, with or without
+// UseNumber
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// equal compares decoded JSON values, treating numbers by value
+func equal(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+
+	switch x := a.(type) {
+	case nil:
+		return b == nil
+	case bool:
+		y, ok := b.(bool)
+		return ok && x == y
+	case string:
+		y, ok := b.(string)
+		return ok && x == y
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for key, value := range x {
+			other, exists := y[key]
+			if !exists || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func describe(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		data, err := json.Marshal(v)
+		if err != nil {
+			parts[i] = fmt.Sprint(v)
+			continue
+		}
+		parts[i] = string(data)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// escape encodes a property name for a JSON pointer
+func escape(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
+
+func at(path string) string {
+	if path == "" {
+		return ""
+	}
+	return " at " + path
+}
@@ -1,12 +1,26 @@
 package main
 
 import (
+	"context"
+	"expvar"
+	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"orbit-service/approval"
+	"orbit-service/audit"
 	"orbit-service/clients"
 	"orbit-service/handlers"
+	"orbit-service/idempotency"
+	"orbit-service/jobs"
 	"orbit-service/middleware"
+	"orbit-service/policy"
+	"orbit-service/ratelimit"
+	"orbit-service/runs"
+	"orbit-service/webhook"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog"
@@ -20,15 +34,179 @@ func main() {
 		Logger()
 
 	// Initialize clients
-	governanceClient, err := clients.NewGovernanceClient(logger)
+	governanceClient, err := newGovernanceChecker(logger)
 	if err != nil {
 		logger.Error().Err(err).Msg("failed to create governance client")
 		os.Exit(1)
 	}
 
-	axonClient, err := clients.NewAxonClient(logger)
+	// Each dispatch intent is served by the downstream target its route
+	// names; without a route table, call_reasoning goes to Axon
+	routeTable, err := clients.RouteTableFromEnv()
 	if err != nil {
-		logger.Error().Err(err).Msg("failed to create axon client")
+		logger.Error().Err(err).Msg("invalid route table")
+		os.Exit(1)
+	}
+	credentials, err := clients.CredentialsFromEnv()
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to load downstream credentials")
+		os.Exit(1)
+	}
+	downstream, err := clients.NewRouter(logger, routeTable, credentials)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to create downstream clients")
+		os.Exit(1)
+	}
+	logger.Info().Strs("intents", downstream.Intents()).Msg("dispatch_routes_loaded")
+	// Dispatches are routed by intent; the default route also serves callers
+	// that predate the route table
+	axonClient, _ := downstream.Route(handlers.DefaultIntent)
+
+	cacheConfig, err := clients.GovernanceCacheConfigFromEnv()
+	if err != nil {
+		logger.Error().Err(err).Msg("invalid governance cache configuration")
+		os.Exit(1)
+	}
+	governanceCache := clients.NewCachingGovernanceChecker(logger, governanceClient, cacheConfig)
+
+	failureConfig, err := clients.GovernanceFailureConfigFromEnv()
+	if err != nil {
+		logger.Error().Err(err).Msg("invalid governance failure configuration")
+		os.Exit(1)
+	}
+	localEngine, err := newLocalPolicyEngine(logger)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to load local policies")
+		os.Exit(1)
+	}
+	governanceChecker := clients.NewFailoverGovernanceChecker(logger, governanceCache, localEngine, failureConfig)
+
+	// Record every governance decision, for dispatch and admin checks alike
+	dispatchGovernance := clients.GovernanceChecker(governanceChecker)
+	adminGovernance := governanceClient
+	auditSink, resume, err := audit.SinkFromEnv()
+	if err != nil {
+		logger.Error().Err(err).Msg("invalid audit configuration")
+		os.Exit(1)
+	}
+	recorderConfig, err := audit.RecorderConfigFromEnv()
+	if err != nil {
+		logger.Error().Err(err).Msg("invalid audit configuration")
+		os.Exit(1)
+	}
+	var auditRecorder *audit.Recorder
+	if auditSink != nil {
+		auditRecorder = audit.NewRecorder(logger, auditSink, recorderConfig, resume)
+		dispatchGovernance = audit.NewChecker(logger, governanceChecker, auditRecorder)
+		adminGovernance = audit.NewChecker(logger, governanceClient, auditRecorder)
+	}
+
+	// Subscribed endpoints are told about governance decisions, and
+	// asynchronous dispatches may ask for a callback when they finish
+	webhooks, err := webhook.DispatcherFromEnv(logger)
+	if err != nil {
+		logger.Error().Err(err).Msg("invalid webhook configuration")
+		os.Exit(1)
+	}
+	var jobListener jobs.Listener
+	if webhooks != nil {
+		dispatchGovernance = webhook.NewChecker(dispatchGovernance, webhooks)
+		adminGovernance = webhook.NewChecker(adminGovernance, webhooks)
+		jobListener = webhooks
+	}
+
+	dispatchOptions, err := handlers.DispatchOptionsFromEnv()
+	if err != nil {
+		logger.Error().Err(err).Msg("invalid dispatch configuration")
+		os.Exit(1)
+	}
+	if err := dispatchOptions.ValidatePipeline(); err != nil {
+		logger.Error().Err(err).Msg("invalid dispatch pipeline")
+		os.Exit(1)
+	}
+
+	// Policies' rate_limits are enforced per caller and intent; the
+	// limiter's counters are published with the other expvars
+	limiter, err := ratelimit.LimiterFromEnv()
+	if err != nil {
+		logger.Error().Err(err).Msg("invalid rate limit configuration")
+		os.Exit(1)
+	}
+	rateLimiter := ratelimit.NewMeteredLimiter(limiter)
+	expvar.Publish("ratelimit", expvar.Func(func() interface{} {
+		return rateLimiter.Snapshot()
+	}))
+	dispatchOptions.RateLimiter = rateLimiter
+	dispatchOptions.Routes = downstream
+	if auditRecorder != nil {
+		dispatchOptions.Auditor = auditRecorder
+	}
+	dispatchOptions.Webhooks = webhooks
+
+	// Dispatches sent with an Idempotency-Key run once per caller and key;
+	// retries are answered with the stored response. The batch timeout is
+	// never less than the dispatch timeout, so it bounds both.
+	idempotencyConfig, err := idempotency.ConfigFromEnv()
+	if err == nil && idempotencyConfig.LockTimeout <= dispatchOptions.Batch.Timeout {
+		err = fmt.Errorf("idempotency lock timeout %s must exceed batch dispatch timeout %s",
+			idempotencyConfig.LockTimeout, dispatchOptions.Batch.Timeout)
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("invalid idempotency configuration")
+		os.Exit(1)
+	}
+	idempotencyStore, err := idempotency.StoreFromEnv()
+	if err != nil {
+		logger.Error().Err(err).Msg("invalid idempotency store configuration")
+		os.Exit(1)
+	}
+	dispatchOptions.Idempotency = idempotencyStore
+	dispatchOptions.IdempotencyConfig = idempotencyConfig
+
+	// Dispatches whose policy requires approval are held until an
+	// administrator approves or rejects them; overdue ones expire
+	approvalConfig, err := approval.ConfigFromEnv()
+	if err != nil {
+		logger.Error().Err(err).Msg("invalid approval configuration")
+		os.Exit(1)
+	}
+	notifier, err := approval.NotifierFromEnv(logger)
+	if err != nil {
+		logger.Error().Err(err).Msg("invalid approval notifier configuration")
+		os.Exit(1)
+	}
+	approvals := approval.NewManager(logger, approval.NewMemoryStore(approvalConfig.Retention), notifier, approvalConfig)
+	dispatchOptions.Approvals = approvals
+	expiryCtx, stopExpiry := context.WithCancel(context.Background())
+	go approvals.Run(expiryCtx)
+
+	// Asynchronous dispatches run on a bounded worker pool, and their
+	// results are kept until JOB_RESULT_TTL has passed
+	jobConfig, err := jobs.ConfigFromEnv()
+	if err != nil {
+		logger.Error().Err(err).Msg("invalid job configuration")
+		os.Exit(1)
+	}
+	jobStore, err := jobs.StoreFromEnv()
+	if err != nil {
+		logger.Error().Err(err).Msg("invalid job store configuration")
+		os.Exit(1)
+	}
+	jobPool := jobs.NewPool(logger, jobStore, jobConfig, handlers.DispatchJobRunner(logger, axonClient, dispatchOptions), jobListener)
+	dispatchOptions.Jobs = jobPool
+
+	// Agent runs loop in the background, each step governed as a dispatch
+	// of its own, until the reasoner answers or a RUN_MAX_* limit is reached
+	runConfig, err := runs.ConfigFromEnv()
+	if err != nil {
+		logger.Error().Err(err).Msg("invalid run configuration")
+		os.Exit(1)
+	}
+	runManager := runs.NewManager(logger, runs.NewMemoryStore(), runConfig)
+
+	callerConfig, err := middleware.CallerConfigFromEnv()
+	if err != nil {
+		logger.Error().Err(err).Msg("invalid caller configuration")
 		os.Exit(1)
 	}
 
@@ -37,10 +215,30 @@ func main() {
 	// Add middleware
 	router.Use(middleware.CorrelationMiddleware)
 	router.Use(middleware.LoggingMiddleware(logger))
+	router.Use(middleware.CallerMiddleware(callerConfig))
 
 	// Routes
-	router.HandleFunc("/health", handlers.HealthHandler(logger)).Methods("GET")
-	router.HandleFunc("/dispatch", handlers.DispatchHandler(logger, governanceClient, axonClient)).Methods("POST")
+	dependencies := downstream.Dependencies()
+	if reporter, ok := governanceClient.(clients.BreakerReporter); ok {
+		dependencies["governance"] = reporter
+	}
+	router.HandleFunc("/health", handlers.HealthHandlerWithDependencies(logger, dependencies)).Methods("GET")
+	router.HandleFunc("/debug/vars", handlers.DebugVarsHandler(logger, adminGovernance)).Methods("GET")
+	router.HandleFunc("/dispatch", handlers.DispatchHandlerWithOptions(logger, dispatchGovernance, axonClient, dispatchOptions)).Methods("POST")
+	router.HandleFunc("/dispatch/batch", handlers.DispatchBatchHandler(logger, dispatchGovernance, axonClient, dispatchOptions)).Methods("POST")
+	router.HandleFunc("/jobs/{id}", handlers.JobStatusHandler(logger, jobPool)).Methods("GET")
+	router.HandleFunc("/jobs/{id}", handlers.JobCancelHandler(logger, jobPool)).Methods("DELETE")
+	router.HandleFunc("/runs", handlers.RunStartHandler(logger, dispatchGovernance, axonClient, dispatchOptions, runManager)).Methods("POST")
+	router.HandleFunc("/runs/{id}", handlers.RunStatusHandler(logger, runManager)).Methods("GET")
+	router.HandleFunc("/admin/governance/cache/invalidate", handlers.GovernanceCacheInvalidateHandler(logger, governanceCache, adminGovernance)).Methods("POST")
+	router.HandleFunc("/dispatch/approvals/{id}", handlers.ApprovalStatusHandler(logger, approvals)).Methods("GET")
+	router.HandleFunc("/admin/approvals/{id}", handlers.ApprovalAdminHandler(logger, approvals, adminGovernance)).Methods("GET")
+	router.HandleFunc("/admin/approvals/{id}/approve", handlers.ApprovalApproveHandler(logger, approvals, adminGovernance, axonClient, dispatchOptions)).Methods("POST")
+	router.HandleFunc("/admin/approvals/{id}/reject", handlers.ApprovalRejectHandler(logger, approvals, adminGovernance)).Methods("POST")
+	if webhooks != nil {
+		router.HandleFunc("/admin/webhooks/dead-letters", handlers.WebhookDeadLettersHandler(logger, webhooks, adminGovernance)).Methods("GET")
+		router.HandleFunc("/admin/webhooks/dead-letters/{id}/replay", handlers.WebhookReplayHandler(logger, webhooks, adminGovernance)).Methods("POST")
+	}
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -51,9 +249,109 @@ func main() {
 		Str("port", port).
 		Msg("orbit_service_starting")
 
-	if err := http.ListenAndServe(":"+port, router); err != nil {
+	server := &http.Server{Addr: ":" + port, Handler: router}
+
+	// On SIGTERM, finish in-flight requests and approved dispatches,
+	// interrupt jobs and runs, attempt their webhooks and flush the audit
+	// trail, all within shutdownTimeout so the chain is not cut short by a
+	// deployment
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+		<-signals
+
+		// The audit trail keeps the last auditFlushTimeout for itself
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout-auditFlushTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Error().Err(err).Msg("server_shutdown_failed")
+		}
+		stopExpiry()
+		stop := func(name string, fn func()) {
+			if !closeWithin(ctx, fn) {
+				logger.Error().Str("component", name).Msg("shutdown_timed_out")
+			}
+		}
+		stop("approvals", approvals.Wait)
+		stop("jobs", jobPool.Close)
+		stop("runs", runManager.Close)
+		if webhooks != nil {
+			stop("webhooks", webhooks.Close)
+		}
+		if auditRecorder != nil {
+			auditCtx, auditCancel := context.WithTimeout(context.Background(), auditFlushTimeout)
+			closed := closeWithin(auditCtx, func() {
+				if err := auditRecorder.Close(); err != nil {
+					logger.Error().Err(err).Msg("audit_close_failed")
+				}
+			})
+			auditCancel()
+			if !closed {
+				logger.Error().Str("component", "audit").Msg("shutdown_timed_out")
+			}
+		}
+		close(stopped)
+	}()
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		logger.Error().Err(err).Msg("server_failed")
 		os.Exit(1)
 	}
+	<-stopped
+
+	logger.Info().Msg("orbit_service_stopped")
+}
+
+// shutdownTimeout bounds a graceful shutdown, within the 30 seconds ECS
+// gives a task to stop by default; auditFlushTimeout of it is kept for
+// flushing the audit trail
+const (
+	shutdownTimeout   = 25 * time.Second
+	auditFlushTimeout = 5 * time.Second
+)
+
+// closeWithin calls fn and waits for it to return until ctx is done,
+// reporting whether it returned
+func closeWithin(ctx context.Context, fn func()) bool {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// newGovernanceChecker selects the governance decision point from
+// GOVERNANCE_ENGINE: "lambda" (default) invokes the governance Lambda,
+// "local" evaluates policies in-process
+func newGovernanceChecker(logger zerolog.Logger) (clients.GovernanceChecker, error) {
+	switch engine := os.Getenv("GOVERNANCE_ENGINE"); engine {
+	case "", "lambda":
+		return clients.NewGovernanceClient(logger)
+	case "local":
+		return newLocalPolicyEngine(logger)
+	default:
+		return nil, fmt.Errorf("unknown GOVERNANCE_ENGINE %q", engine)
+	}
 }
 
+// newLocalPolicyEngine evaluates the policies in GOVERNANCE_POLICY_FILE, or
+// the snapshot built into the binary when no file is configured
+func newLocalPolicyEngine(logger zerolog.Logger) (*policy.Engine, error) {
+	path := os.Getenv("GOVERNANCE_POLICY_FILE")
+	if path == "" {
+		return policy.NewSnapshotEngine(logger)
+	}
+
+	policies, err := policy.LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return policy.NewEngine(logger, policies)
+}
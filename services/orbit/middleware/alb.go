@@ -0,0 +1,230 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"orbit-service/singleflight"
+)
+
+// ALBDataHeader carries the user claims the load balancer signs after
+// authenticating a caller with OIDC
+const ALBDataHeader = "X-Amzn-Oidc-Data"
+
+// albKeyID matches the key IDs the load balancer signs with
+var albKeyID = regexp.MustCompile(`^[A-Za-z0-9-]{1,64}$`)
+
+// albMissingKeyTTL is how long a key ID the key server has no key for is
+// refused without asking again, so tokens with made-up key IDs do not each
+// cost a fetch
+const albMissingKeyTTL = 30 * time.Second
+
+// maxALBMissingKeys bounds the key IDs remembered as missing
+const maxALBMissingKeys = 1024
+
+// ALBKeyURL returns the base URL of the load balancers' public keys in region
+func ALBKeyURL(region string) string {
+	return fmt.Sprintf("https://public-keys.auth.elb.%s.amazonaws.com/", region)
+}
+
+// ALBVerifier verifies the ES256 tokens an Application Load Balancer passes
+// in X-Amzn-Oidc-Data. Every load balancer in a region signs with the same
+// keys, so tokens are only accepted from the configured load balancer.
+type ALBVerifier struct {
+	signer string
+	keyURL string
+	client *http.Client
+	now    func() time.Time
+	flight singleflight.Group[*ecdsa.PublicKey]
+
+	mu   sync.Mutex
+	keys map[string]*ecdsa.PublicKey
+	// missing holds the key IDs the key server had no key for, with when
+	// they may be asked for again
+	missing map[string]time.Time
+}
+
+// NewALBVerifier accepts tokens signed by the load balancer signerARN, whose
+// public keys are fetched from keyURL
+func NewALBVerifier(signerARN, keyURL string) *ALBVerifier {
+	return &ALBVerifier{
+		signer:  signerARN,
+		keyURL:  keyURL,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		now:     time.Now,
+		keys:    make(map[string]*ecdsa.PublicKey),
+		missing: make(map[string]time.Time),
+	}
+}
+
+type albHeader struct {
+	Alg    string `json:"alg"`
+	Kid    string `json:"kid"`
+	Signer string `json:"signer"`
+	Exp    int64  `json:"exp"`
+}
+
+// Verify checks the token's signature, signer and expiry and returns its
+// claims. A key fetched to check the signature is fetched under ctx.
+func (v *ALBVerifier) Verify(ctx context.Context, token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token is not a JWT")
+	}
+
+	var header albHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid token header: %w", err)
+	}
+	if header.Alg != "ES256" {
+		return nil, fmt.Errorf("unexpected token algorithm %q", header.Alg)
+	}
+	if header.Signer != v.signer {
+		return nil, fmt.Errorf("token signed by unexpected load balancer %q", header.Signer)
+	}
+	if !albKeyID.MatchString(header.Kid) {
+		return nil, fmt.Errorf("invalid token key ID %q", header.Kid)
+	}
+
+	signature, err := decodeBase64(parts[2])
+	if err != nil || len(signature) != 64 {
+		return nil, errors.New("invalid token signature")
+	}
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(key, digest[:], r, s) {
+		return nil, errors.New("token signature verification failed")
+	}
+
+	if header.Exp != 0 && v.now().Unix() >= header.Exp {
+		return nil, errors.New("token has expired")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid token claims: %w", err)
+	}
+	return claims, nil
+}
+
+// key returns the public key kid, fetching it on first use. Concurrent
+// fetches of a key are collapsed into one, and a key ID the key server has
+// no key for is refused for albMissingKeyTTL.
+func (v *ALBVerifier) key(ctx context.Context, kid string) (*ecdsa.PublicKey, error) {
+	v.mu.Lock()
+	key, ok := v.keys[kid]
+	retryAt, missing := v.missing[kid]
+	v.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if missing && v.now().Before(retryAt) {
+		return nil, fmt.Errorf("unknown token key %q", kid)
+	}
+
+	key, err, shared := v.flight.Do(ctx, kid, func() (*ecdsa.PublicKey, error) {
+		return v.fetch(ctx, kid)
+	})
+	// A shared fetch runs under the first caller's context; if that caller
+	// gave up, this one still has time to fetch for itself
+	if shared && err != nil && ctx.Err() == nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		key, err = v.fetch(ctx, kid)
+	}
+	return key, err
+}
+
+// fetch fetches and parses the public key kid, remembering it, or that the
+// key server has no usable key for it
+func (v *ALBVerifier) fetch(ctx context.Context, kid string) (*ecdsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.keyURL+url.PathEscape(kid), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch token key: %w", err)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch token key: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return nil, fmt.Errorf("failed to fetch token key: status %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, v.refuse(kid, fmt.Errorf("failed to fetch token key: status %d", resp.StatusCode))
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch token key: %w", err)
+	}
+
+	block, _ := pem.Decode(body)
+	if block == nil {
+		return nil, v.refuse(kid, errors.New("token key is not PEM encoded"))
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, v.refuse(kid, fmt.Errorf("invalid token key: %w", err))
+	}
+	key, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, v.refuse(kid, errors.New("token key is not an ECDSA key"))
+	}
+
+	v.mu.Lock()
+	v.keys[kid] = key
+	v.mu.Unlock()
+	return key, nil
+}
+
+// refuse remembers that the key server has no usable key for kid and
+// returns err
+func (v *ALBVerifier) refuse(kid string, err error) error {
+	now := v.now()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if len(v.missing) >= maxALBMissingKeys {
+		for id, retryAt := range v.missing {
+			if !now.Before(retryAt) {
+				delete(v.missing, id)
+			}
+		}
+	}
+	if len(v.missing) < maxALBMissingKeys {
+		v.missing[kid] = now.Add(albMissingKeyTTL)
+	}
+	return err
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := decodeBase64(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// decodeBase64 decodes base64url with or without padding; the load balancer
+// pads its segments
+func decodeBase64(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+}
@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+type callerKey string
+
+const CallerKey callerKey = "caller"
+
+// Caller is the authenticated principal behind a request
+type Caller struct {
+	ID   string `json:"id"`
+	Type string `json:"type,omitempty"`
+}
+
+// Caller authentication modes
+const (
+	// CallerAuthNone ignores the identity headers, so every request is
+	// anonymous
+	CallerAuthNone = "none"
+	// CallerAuthALB takes the caller from the signed token the load balancer
+	// passes in X-Amzn-Oidc-Data once it has authenticated the caller
+	CallerAuthALB = "alb"
+	// CallerAuthHeaders trusts the identity headers as-is. It is only safe
+	// behind a proxy that authenticates callers and overwrites any
+	// client-supplied values.
+	CallerAuthHeaders = "headers"
+)
+
+// CallerConfig says how Orbit learns the caller's identity
+type CallerConfig struct {
+	// Auth is the authentication mode; anything but CallerAuthALB and
+	// CallerAuthHeaders leaves every request anonymous
+	Auth string
+	// IDHeader and TypeHeader carry the caller under CallerAuthHeaders
+	IDHeader   string
+	TypeHeader string
+	// ALB verifies the load balancer's token under CallerAuthALB, whose
+	// sub claim is the caller ID and TypeClaim claim the caller type
+	ALB       *ALBVerifier
+	TypeClaim string
+}
+
+// CallerConfigFromEnv reads the authentication mode from CALLER_AUTH
+// (default none). The alb mode needs CALLER_ALB_ARN, the load balancer whose
+// tokens are accepted, and reads the caller type from the CALLER_TYPE_CLAIM
+// claim; the headers mode reads CALLER_ID_HEADER and CALLER_TYPE_HEADER.
+func CallerConfigFromEnv() (CallerConfig, error) {
+	config := CallerConfig{
+		Auth:       CallerAuthNone,
+		IDHeader:   "X-Amzn-Oidc-Identity",
+		TypeHeader: "X-Caller-Type",
+		TypeClaim:  "caller_type",
+	}
+	if value := os.Getenv("CALLER_AUTH"); value != "" {
+		config.Auth = value
+	}
+	if value := os.Getenv("CALLER_ID_HEADER"); value != "" {
+		config.IDHeader = value
+	}
+	if value := os.Getenv("CALLER_TYPE_HEADER"); value != "" {
+		config.TypeHeader = value
+	}
+	if value := os.Getenv("CALLER_TYPE_CLAIM"); value != "" {
+		config.TypeClaim = value
+	}
+
+	switch config.Auth {
+	case CallerAuthNone, CallerAuthHeaders:
+	case CallerAuthALB:
+		signer := os.Getenv("CALLER_ALB_ARN")
+		if signer == "" {
+			return config, fmt.Errorf("CALLER_ALB_ARN is required with CALLER_AUTH=%s", CallerAuthALB)
+		}
+		region := os.Getenv("AWS_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		config.ALB = NewALBVerifier(signer, ALBKeyURL(region))
+	default:
+		return config, fmt.Errorf("invalid CALLER_AUTH %q: want %s, %s or %s", config.Auth, CallerAuthNone, CallerAuthALB, CallerAuthHeaders)
+	}
+	return config, nil
+}
+
+// CallerMiddleware adds the authenticated caller to the request context.
+// Requests without a caller, and every request unless an authentication
+// mode is configured, are anonymous. A load balancer token that fails
+// verification is refused with 401.
+func CallerMiddleware(config CallerConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var caller Caller
+			switch config.Auth {
+			case CallerAuthHeaders:
+				caller = Caller{
+					ID:   r.Header.Get(config.IDHeader),
+					Type: r.Header.Get(config.TypeHeader),
+				}
+			case CallerAuthALB:
+				token := r.Header.Get(ALBDataHeader)
+				if token == "" {
+					break
+				}
+				claims, err := config.ALB.Verify(r.Context(), token)
+				if err != nil {
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				caller.ID, _ = claims["sub"].(string)
+				caller.Type, _ = claims[config.TypeClaim].(string)
+			}
+
+			if caller.ID == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := context.WithValue(r.Context(), CallerKey, caller)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// GetCaller extracts the authenticated caller from context
+func GetCaller(ctx context.Context) (Caller, bool) {
+	caller, ok := ctx.Value(CallerKey).(Caller)
+	return caller, ok
+}
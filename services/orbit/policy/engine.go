@@ -0,0 +1,371 @@
+package policy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+)
+
+// Deny reasons, worded as the governance Lambda words them
+const (
+	ReasonAuthorized       = "Request authorized"
+	ReasonDisabled         = "Policy is disabled"
+	ReasonOutsideWindow    = "Request outside allowed time window"
+	ReasonEvaluationError  = "Governance evaluation error"
+	ReasonApprovalRequired = "Request requires approval"
+)
+
+// errIncomparable mirrors the TypeError Python raises when comparing values
+// of incompatible types, which the Lambda turns into an evaluation error
+var errIncomparable = errors.New("values are not comparable")
+
+// Decision is the outcome of evaluating a request against the policies
+type Decision struct {
+	Allowed bool
+	Reason  string
+	// PolicyVersion identifies the policy that decided, empty if none applied
+	PolicyVersion string
+	// RateLimits are the deciding policy's rate limits, if it has any
+	RateLimits *RateLimits
+	// RequiresApproval is set instead of Allowed when the request passed
+	// every check but needs an administrator's approval
+	RequiresApproval bool
+	// Obligations are the deciding policy's obligations, which also apply
+	// to a request once it is approved
+	Obligations []Obligation
+}
+
+// Checks reported in an explanation trace
+const (
+	CheckPolicy           = "policy"
+	CheckEnabled          = "enabled"
+	CheckTimeRestrictions = "time_restrictions"
+	CheckCondition        = "condition"
+	CheckApproval         = "requires_approval"
+)
+
+// Step is one check of an evaluation, as reported by Explain
+type Step struct {
+	Check  string `json:"check"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+type policyKey struct {
+	service string
+	intent  string
+}
+
+type loadedPolicy struct {
+	Policy
+	version string
+}
+
+// Engine evaluates governance requests against an in-memory policy set
+type Engine struct {
+	logger   zerolog.Logger
+	policies map[policyKey]loadedPolicy
+}
+
+// NewEngine creates an engine for the given policies
+func NewEngine(logger zerolog.Logger, policies []Policy) (*Engine, error) {
+	indexed := make(map[policyKey]loadedPolicy, len(policies))
+	for _, p := range policies {
+		key := policyKey{service: p.Service, intent: p.Intent}
+		if _, exists := indexed[key]; exists {
+			return nil, fmt.Errorf("duplicate policy for %s:%s", p.Service, p.Intent)
+		}
+
+		version, err := policyVersion(p)
+		if err != nil {
+			return nil, err
+		}
+		indexed[key] = loadedPolicy{Policy: p, version: version}
+	}
+
+	return &Engine{
+		logger:   logger,
+		policies: indexed,
+	}, nil
+}
+
+// CheckPermission evaluates req against the loaded policies at the current time
+func (e *Engine) CheckPermission(ctx context.Context, req clients.GovernanceRequest, correlationID string) (clients.GovernanceResponse, error) {
+	decision := e.Evaluate(req.Service, req.Intent, req.Context, time.Now())
+
+	e.logger.Info().
+		Str("correlation_id", correlationID).
+		Str("service", req.Service).
+		Str("intent", req.Intent).
+		Bool("allowed", decision.Allowed).
+		Str("reason", decision.Reason).
+		Msg("local_governance_check_completed")
+
+	response := clients.GovernanceResponse{
+		Allowed:          decision.Allowed,
+		Reason:           decision.Reason,
+		PolicyVersion:    decision.PolicyVersion,
+		RequiresApproval: decision.RequiresApproval,
+	}
+	if decision.RateLimits != nil {
+		response.RateLimits = &clients.RateLimits{
+			RequestsPerMinute: decision.RateLimits.RequestsPerMinute,
+			RequestsPerHour:   decision.RateLimits.RequestsPerHour,
+		}
+	}
+	for _, o := range decision.Obligations {
+		response.Obligations = append(response.Obligations, clients.Obligation{Type: o.Type, Value: o.Value})
+	}
+	return response, nil
+}
+
+// Evaluate decides a request at the given time. Checks run in the order the
+// Lambda runs them: enabled, time restrictions, rate limits, conditions.
+func (e *Engine) Evaluate(service, intent string, attributes map[string]interface{}, now time.Time) Decision {
+	return e.evaluate(service, intent, attributes, now, nil)
+}
+
+// Explain decides a request like Evaluate and also returns the checks that
+// led to the decision, in the order they ran
+func (e *Engine) Explain(service, intent string, attributes map[string]interface{}, now time.Time) (Decision, []Step) {
+	var trace []Step
+	decision := e.evaluate(service, intent, attributes, now, &trace)
+	return decision, trace
+}
+
+func (e *Engine) evaluate(service, intent string, attributes map[string]interface{}, now time.Time, trace *[]Step) Decision {
+	record := func(check string, passed bool, format string, args ...interface{}) {
+		if trace != nil {
+			*trace = append(*trace, Step{Check: check, Passed: passed, Detail: fmt.Sprintf(format, args...)})
+		}
+	}
+
+	p, ok := e.policies[policyKey{service: service, intent: intent}]
+	if !ok {
+		record(CheckPolicy, false, "no policy for %s:%s", service, intent)
+		return Decision{Reason: fmt.Sprintf("No policy defined for %s:%s", service, intent)}
+	}
+	record(CheckPolicy, true, "policy %s:%s version %s", service, intent, p.version)
+
+	if !*p.Enabled {
+		record(CheckEnabled, false, "policy is disabled")
+		return Decision{Reason: ReasonDisabled, PolicyVersion: p.version}
+	}
+	record(CheckEnabled, true, "policy is enabled")
+
+	hour := now.UTC().Hour()
+	if !withinAllowedHours(p.TimeRestrictions, hour) {
+		record(CheckTimeRestrictions, false, "hour %d UTC is not in allowed hours %v", hour, p.TimeRestrictions.AllowedHours)
+		return Decision{Reason: ReasonOutsideWindow, PolicyVersion: p.version}
+	}
+	if p.TimeRestrictions == nil || len(p.TimeRestrictions.AllowedHours) == 0 {
+		record(CheckTimeRestrictions, true, "no time restrictions")
+	} else {
+		record(CheckTimeRestrictions, true, "hour %d UTC is allowed", hour)
+	}
+
+	// Rate limits are not enforced by the Lambda either, so there is
+	// nothing to check for them here
+
+	for i, c := range p.Conditions {
+		check := fmt.Sprintf("%s[%d]", CheckCondition, i)
+		actual, present := attributes[c.Field]
+		got := "missing"
+		if present {
+			got = describeValue(actual)
+		}
+
+		met, err := evaluateCondition(c, attributes)
+		if err != nil {
+			record(check, false, "%s %s %s: got %s, which cannot be compared", c.Field, c.Operator, describeValue(c.Value), got)
+			return Decision{Reason: ReasonEvaluationError, PolicyVersion: p.version}
+		}
+		record(check, met, "%s %s %s: got %s", c.Field, c.Operator, describeValue(c.Value), got)
+		if !met {
+			description := "Unknown"
+			if c.Description != nil {
+				description = *c.Description
+			}
+			return Decision{Reason: "Condition not met: " + description, PolicyVersion: p.version}
+		}
+	}
+
+	if p.RequiresApproval {
+		record(CheckApproval, false, "policy requires approval")
+		return Decision{
+			Reason:           ReasonApprovalRequired,
+			PolicyVersion:    p.version,
+			RequiresApproval: true,
+			Obligations:      p.Obligations,
+		}
+	}
+
+	return Decision{
+		Allowed:       true,
+		Reason:        ReasonAuthorized,
+		PolicyVersion: p.version,
+		RateLimits:    p.RateLimits,
+		Obligations:   p.Obligations,
+	}
+}
+
+// policyVersion returns the policy's declared version, or a digest of its
+// content when none is declared. Digests are opaque: the Lambda derives its
+// own from the DynamoDB item, so only declared versions match across engines.
+func policyVersion(p Policy) (string, error) {
+	if p.Version != "" {
+		return p.Version, nil
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash policy %s:%s: %w", p.Service, p.Intent, err)
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])[:12], nil
+}
+
+func withinAllowedHours(restrictions *TimeRestrictions, hour int) bool {
+	if restrictions == nil || len(restrictions.AllowedHours) == 0 {
+		return true
+	}
+	for _, allowed := range restrictions.AllowedHours {
+		if allowed == hour {
+			return true
+		}
+	}
+	return false
+}
+
+func evaluateCondition(c Condition, attributes map[string]interface{}) (bool, error) {
+	if c.Type != ConditionContextCheck {
+		return true, nil
+	}
+	return compareValues(attributes[c.Field], c.Operator, c.Value)
+}
+
+// compareValues applies an operator with Python's comparison semantics:
+// numbers (and booleans) compare by value across types, a missing attribute
+// is None, and ordering a number against a non-number is an error.
+func compareValues(actual interface{}, operator string, expected interface{}) (bool, error) {
+	switch operator {
+	case OperatorEquals:
+		return pythonEqual(actual, expected), nil
+	case OperatorNotEquals:
+		return !pythonEqual(actual, expected), nil
+	case OperatorContains:
+		switch container := actual.(type) {
+		case string:
+			needle, ok := expected.(string)
+			if !ok {
+				return false, errIncomparable
+			}
+			return strings.Contains(container, needle), nil
+		case []interface{}:
+			for _, item := range container {
+				if pythonEqual(item, expected) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+		return false, nil
+	case OperatorGreaterThan, OperatorLessThan:
+		left, ok := toNumber(actual)
+		if !ok {
+			return false, nil
+		}
+		right, ok := toNumber(expected)
+		if !ok {
+			return false, errIncomparable
+		}
+		if operator == OperatorGreaterThan {
+			return left > right, nil
+		}
+		return left < right, nil
+	}
+	return false, nil
+}
+
+func pythonEqual(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	if x, ok := toNumber(a); ok {
+		y, ok := toNumber(b)
+		return ok && x == y
+	}
+
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		return ok && x == y
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !pythonEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for key, value := range x {
+			other, exists := y[key]
+			if !exists || !pythonEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// toNumber converts the numeric types found in decoded JSON and in request
+// context. Booleans count as numbers, as they do in Python.
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case bool:
+		if n {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func describeValue(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
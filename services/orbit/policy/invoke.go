@@ -0,0 +1,206 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+)
+
+// The Lambda Invoke API route is the prefix, the function name and the suffix
+const (
+	invokePathPrefix = "/2015-03-31/functions/"
+	invokePathSuffix = "/invocations"
+)
+
+// maxInvokePayload is the Lambda limit on synchronous invocation payloads
+const maxInvokePayload = 6 * 1024 * 1024
+
+// invokeEvent is the event the governance Lambda accepts: a governance
+// request, either bare or as the string or object body of a proxy event
+type invokeEvent struct {
+	Service       string                 `json:"service"`
+	Intent        string                 `json:"intent"`
+	Context       map[string]interface{} `json:"context"`
+	CorrelationID string                 `json:"correlation_id"`
+	Body          json.RawMessage        `json:"body"`
+	Headers       map[string]string      `json:"headers"`
+}
+
+// invokeDecision is the body of the governance Lambda's response
+type invokeDecision struct {
+	Service          string               `json:"service"`
+	Intent           string               `json:"intent"`
+	Allowed          bool                 `json:"allowed"`
+	Reason           string               `json:"reason"`
+	PolicyVersion    string               `json:"policy_version,omitempty"`
+	RateLimits       *clients.RateLimits  `json:"rate_limits,omitempty"`
+	Obligations      []clients.Obligation `json:"obligations,omitempty"`
+	RequiresApproval bool                 `json:"requires_approval"`
+	Timestamp        float64              `json:"timestamp"`
+	CorrelationID    string               `json:"correlation_id"`
+}
+
+// invokeResponse is the proxy envelope the governance Lambda returns
+type invokeResponse struct {
+	StatusCode int               `json:"statusCode"`
+	Headers    map[string]string `json:"headers"`
+	Body       string            `json:"body"`
+}
+
+// InvokeHandler serves the Lambda Invoke API for a governance function
+// backed by engine, so the Lambda governance client can run against it
+// without AWS. Only the named function exists, unless functionName is
+// empty, in which case any name is accepted. Responses are shaped like the
+// governance Lambda's, including its 403 envelope for denied requests.
+func InvokeHandler(logger zerolog.Logger, engine *Engine, functionName string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, ok := invokedFunction(r.URL.Path)
+		if !ok {
+			writeInvokeError(w, http.StatusNotFound, "UnknownOperationException", "Unknown operation "+r.Method+" "+r.URL.Path)
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeInvokeError(w, http.StatusMethodNotAllowed, "UnknownOperationException", "Invoke requires POST")
+			return
+		}
+		if functionName != "" && name != functionName {
+			writeInvokeError(w, http.StatusNotFound, "ResourceNotFoundException", "Function not found: "+name)
+			return
+		}
+
+		switch invocationType := r.Header.Get("X-Amz-Invocation-Type"); invocationType {
+		case "", "RequestResponse":
+		case "DryRun":
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case "Event":
+			// Governance decisions are only useful synchronously, so an
+			// asynchronous invocation is accepted and dropped
+			w.WriteHeader(http.StatusAccepted)
+			return
+		default:
+			writeInvokeError(w, http.StatusBadRequest, "InvalidParameterValueException", "Unsupported invocation type "+invocationType)
+			return
+		}
+
+		payload, err := io.ReadAll(io.LimitReader(r.Body, maxInvokePayload+1))
+		if err != nil {
+			writeInvokeError(w, http.StatusBadRequest, "InvalidRequestContentException", "Could not read request payload")
+			return
+		}
+		if len(payload) > maxInvokePayload {
+			writeInvokeError(w, http.StatusRequestEntityTooLarge, "RequestTooLargeException", "Request must be smaller than 6291456 bytes for the InvokeFunction operation")
+			return
+		}
+
+		event, err := decodeInvokeEvent(payload)
+		if err != nil {
+			logger.Warn().Err(err).Str("function_name", name).Msg("local_lambda_invalid_payload")
+			writeInvokeError(w, http.StatusBadRequest, "InvalidRequestContentException", "Could not parse request body into json: "+err.Error())
+			return
+		}
+
+		response := evaluateInvocation(r.Context(), engine, event)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Amz-Executed-Version", "$LATEST")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// invokedFunction returns the function name in an Invoke API path
+func invokedFunction(path string) (string, bool) {
+	if !strings.HasPrefix(path, invokePathPrefix) || !strings.HasSuffix(path, invokePathSuffix) {
+		return "", false
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(path, invokePathPrefix), invokePathSuffix)
+	return name, name != "" && !strings.Contains(name, "/")
+}
+
+func decodeInvokeEvent(payload []byte) (invokeEvent, error) {
+	var event invokeEvent
+	if err := decodeNumbers(payload, &event); err != nil {
+		return event, err
+	}
+	if len(event.Body) == 0 || bytes.Equal(event.Body, []byte("null")) {
+		return event, nil
+	}
+
+	// A proxy event carries the request in its body, as a JSON string or
+	// an object, and the correlation ID in its headers
+	body := []byte(event.Body)
+	var encoded string
+	if err := json.Unmarshal(body, &encoded); err == nil {
+		body = []byte(encoded)
+	}
+	var request invokeEvent
+	if err := decodeNumbers(body, &request); err != nil {
+		return event, fmt.Errorf("body: %w", err)
+	}
+	request.CorrelationID = event.Headers["X-Correlation-ID"]
+	return request, nil
+}
+
+// decodeNumbers decodes JSON keeping numbers as written, as the Lambda's
+// json.loads does
+func decodeNumbers(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func evaluateInvocation(ctx context.Context, engine *Engine, event invokeEvent) invokeResponse {
+	correlationID := event.CorrelationID
+	if correlationID == "" {
+		correlationID = "unknown"
+	}
+	headers := map[string]string{
+		"Content-Type":     "application/json",
+		"X-Correlation-ID": correlationID,
+	}
+
+	if event.Service == "" || event.Intent == "" {
+		body, _ := json.Marshal(map[string]string{
+			"error":          "Missing required fields: service and intent",
+			"correlation_id": correlationID,
+		})
+		return invokeResponse{StatusCode: http.StatusBadRequest, Headers: headers, Body: string(body)}
+	}
+
+	req := clients.GovernanceRequest{Service: event.Service, Intent: event.Intent, Context: event.Context}
+	decision, _ := engine.CheckPermission(ctx, req, correlationID)
+
+	body, _ := json.Marshal(invokeDecision{
+		Service:          event.Service,
+		Intent:           event.Intent,
+		Allowed:          decision.Allowed,
+		Reason:           decision.Reason,
+		PolicyVersion:    decision.PolicyVersion,
+		RateLimits:       decision.RateLimits,
+		Obligations:      decision.Obligations,
+		RequiresApproval: decision.RequiresApproval,
+		Timestamp:        float64(time.Now().UnixNano()) / 1e9,
+		CorrelationID:    correlationID,
+	})
+	status := http.StatusOK
+	if !decision.Allowed {
+		status = http.StatusForbidden
+	}
+	return invokeResponse{StatusCode: status, Headers: headers, Body: string(body)}
+}
+
+// writeInvokeError writes a Lambda API error, which the SDK identifies by
+// the X-Amzn-ErrorType header
+func writeInvokeError(w http.ResponseWriter, status int, errorType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Amzn-ErrorType", errorType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"Type": "User", "message": message})
+}
@@ -0,0 +1,203 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"orbit-service/clients"
+)
+
+// Finding severities. Errors are policies that cannot be loaded or can never
+// behave as written; warnings are likely mistakes.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Finding is a problem Lint found in a policy
+type Finding struct {
+	Severity string `json:"severity"`
+	// Policy names the policy as service:intent, or by position when it
+	// has no service or intent
+	Policy  string `json:"policy"`
+	Message string `json:"message"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.Severity, f.Policy, f.Message)
+}
+
+// Lint checks a policy set for problems Validate does not catch: duplicate
+// service/intent pairs, time restrictions that restrict nothing, rate limits
+// that never apply, conditions that can never all hold and obligations Orbit
+// cannot enforce. Validation errors are reported as findings too.
+func Lint(policies []Policy) []Finding {
+	l := newLinter()
+	for i, p := range policies {
+		l.lint(i, p)
+	}
+	return l.findings
+}
+
+// LintDocument lints a JSON array of policies, reporting policies that
+// cannot be decoded as findings rather than giving up on the whole set
+func LintDocument(data []byte) ([]Finding, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("failed to parse policies: %w", err)
+	}
+
+	l := newLinter()
+	for i, item := range items {
+		decoder := json.NewDecoder(bytes.NewReader(item))
+		decoder.UseNumber()
+		var p Policy
+		if err := decoder.Decode(&p); err != nil {
+			l.findings = append(l.findings, Finding{
+				Severity: SeverityError,
+				Policy:   fmt.Sprintf("policy %d", i),
+				Message:  fmt.Sprintf("cannot be decoded: %v", err),
+			})
+			continue
+		}
+		l.lint(i, p)
+	}
+	return l.findings, nil
+}
+
+type linter struct {
+	findings []Finding
+	seen     map[policyKey]int
+}
+
+func newLinter() *linter {
+	return &linter{seen: make(map[policyKey]int)}
+}
+
+func (l *linter) lint(i int, p Policy) {
+	name := fmt.Sprintf("policy %d", i)
+	if p.Service != "" && p.Intent != "" {
+		name = p.Service + ":" + p.Intent
+	}
+	report := func(severity, format string, args ...interface{}) {
+		l.findings = append(l.findings, Finding{Severity: severity, Policy: name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if err := p.Validate(); err != nil {
+		report(SeverityError, "%v", err)
+		return
+	}
+
+	key := policyKey{service: p.Service, intent: p.Intent}
+	if first, ok := l.seen[key]; ok {
+		report(SeverityError, "duplicates policy %d; only one policy may exist per service and intent", first)
+	} else {
+		l.seen[key] = i
+	}
+
+	lintTimeRestrictions(p, report)
+	lintRateLimits(p, report)
+	lintConditions(p, report)
+	lintObligations(p, report)
+}
+
+type reportFunc func(severity, format string, args ...interface{})
+
+func lintTimeRestrictions(p Policy, report reportFunc) {
+	if p.TimeRestrictions == nil {
+		return
+	}
+	if len(p.TimeRestrictions.AllowedHours) == 0 {
+		report(SeverityWarning, "time_restrictions has no allowed_hours, which allows every hour")
+		return
+	}
+
+	seen := make(map[int]bool)
+	for _, hour := range p.TimeRestrictions.AllowedHours {
+		if seen[hour] {
+			report(SeverityWarning, "allowed hour %d is listed more than once", hour)
+		}
+		seen[hour] = true
+	}
+}
+
+func lintRateLimits(p Policy, report reportFunc) {
+	if p.RateLimits == nil {
+		return
+	}
+	perMinute, perHour := p.RateLimits.RequestsPerMinute, p.RateLimits.RequestsPerHour
+	if perMinute == 0 && perHour == 0 {
+		report(SeverityWarning, "rate_limits sets no limit")
+	}
+	if perMinute > 0 && perHour > 0 && perMinute > perHour {
+		report(SeverityWarning, "requests_per_minute %d exceeds requests_per_hour %d, so the per-minute limit is never reached", perMinute, perHour)
+	}
+	if p.RequiresApproval {
+		report(SeverityWarning, "rate_limits are not applied to requests held for approval")
+	}
+}
+
+// lintConditions looks for conditions on the same field that no value can
+// satisfy together, and for comparisons that always fail
+func lintConditions(p Policy, report reportFunc) {
+	for i, c := range p.Conditions {
+		if c.Operator == OperatorGreaterThan || c.Operator == OperatorLessThan {
+			if _, ok := toNumber(c.Value); !ok {
+				report(SeverityError, "condition %d compares %s with %s against a non-numeric value, which never holds", i, c.Field, c.Operator)
+			}
+		}
+
+		for j := i + 1; j < len(p.Conditions); j++ {
+			other := p.Conditions[j]
+			if other.Field != c.Field {
+				continue
+			}
+			if c.Operator == other.Operator && pythonEqual(c.Value, other.Value) {
+				report(SeverityWarning, "conditions %d and %d are the same", i, j)
+				continue
+			}
+			if conflicting(c, other) {
+				report(SeverityError, "conditions %d and %d on %s conflict: %s %s %s and %s %s %s never hold together",
+					i, j, c.Field,
+					c.Field, c.Operator, describeValue(c.Value),
+					other.Field, other.Operator, describeValue(other.Value))
+			}
+		}
+	}
+}
+
+func conflicting(a, b Condition) bool {
+	// A value required by equals must pass the other condition itself
+	if a.Operator == OperatorEquals {
+		met, err := compareValues(a.Value, b.Operator, b.Value)
+		return err != nil || !met
+	}
+	if b.Operator == OperatorEquals {
+		met, err := compareValues(b.Value, a.Operator, a.Value)
+		return err != nil || !met
+	}
+
+	lower, upper := a, b
+	if lower.Operator == OperatorLessThan {
+		lower, upper = b, a
+	}
+	if lower.Operator != OperatorGreaterThan || upper.Operator != OperatorLessThan {
+		return false
+	}
+	min, ok := toNumber(lower.Value)
+	if !ok {
+		return false
+	}
+	max, ok := toNumber(upper.Value)
+	return ok && min >= max
+}
+
+func lintObligations(p Policy, report reportFunc) {
+	for i, o := range p.Obligations {
+		_, err := clients.ParseObligations([]clients.Obligation{{Type: o.Type, Value: o.Value}})
+		if err != nil {
+			report(SeverityWarning, "obligation %d: Orbit refuses requests carrying it: %v", i, err)
+		}
+	}
+}
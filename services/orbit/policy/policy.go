@@ -0,0 +1,173 @@
+// Package policy evaluates governance policies in-process. It reads the
+// policy format described by governance/policies/schema.json and evaluates
+// it with the same semantics as the governance Lambda (handler.py), so it
+// can stand in for the Lambda as the primary decision point or as a local
+// fallback. governance/policies/golden/cases.json holds the cases both
+// engines are tested against.
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Condition operators
+const (
+	OperatorEquals      = "equals"
+	OperatorNotEquals   = "not_equals"
+	OperatorContains    = "contains"
+	OperatorGreaterThan = "greater_than"
+	OperatorLessThan    = "less_than"
+)
+
+// ConditionContextCheck compares a request context attribute to a value
+const ConditionContextCheck = "context_check"
+
+// Policy is the access control rule for one service/intent pair
+type Policy struct {
+	Service          string            `json:"service"`
+	Intent           string            `json:"intent"`
+	Enabled          *bool             `json:"enabled"`
+	Version          string            `json:"version,omitempty"`
+	Description      string            `json:"description,omitempty"`
+	TimeRestrictions *TimeRestrictions `json:"time_restrictions,omitempty"`
+	RateLimits       *RateLimits       `json:"rate_limits,omitempty"`
+	Conditions       []Condition       `json:"conditions,omitempty"`
+	// RequiresApproval holds requests that pass every check until an
+	// administrator approves them
+	RequiresApproval bool `json:"requires_approval,omitempty"`
+	// Obligations are returned with allowed decisions; Orbit refuses
+	// requests carrying obligations it does not support
+	Obligations []Obligation `json:"obligations,omitempty"`
+}
+
+// Obligation is a constraint an allowed request must be served under
+type Obligation struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+type TimeRestrictions struct {
+	// AllowedHours are the UTC hours (0-23) requests are allowed in; an
+	// empty list allows every hour
+	AllowedHours []int `json:"allowed_hours,omitempty"`
+}
+
+// RateLimits are returned with allowed decisions and enforced by Orbit's
+// rate limiter
+type RateLimits struct {
+	RequestsPerMinute int `json:"requests_per_minute,omitempty"`
+	RequestsPerHour   int `json:"requests_per_hour,omitempty"`
+}
+
+type Condition struct {
+	Type     string      `json:"type"`
+	Field    string      `json:"field"`
+	Operator string      `json:"operator"`
+	Value    interface{} `json:"value"`
+	// Description is reported in the deny reason; nil reports "Unknown"
+	Description *string `json:"description,omitempty"`
+}
+
+// LoadFile reads and validates a JSON array of policies
+func LoadFile(path string) ([]Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse decodes and validates a JSON array of policies
+func Parse(data []byte) ([]Policy, error) {
+	policies, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, p := range policies {
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("policy %d: %w", i, err)
+		}
+	}
+	return policies, nil
+}
+
+// Decode decodes a JSON array of policies without validating them, so
+// tools can report every problem rather than the first
+func Decode(data []byte) ([]Policy, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var policies []Policy
+	if err := decoder.Decode(&policies); err != nil {
+		return nil, fmt.Errorf("failed to parse policies: %w", err)
+	}
+	return policies, nil
+}
+
+// Validate checks a policy against the rules of schema.json
+func (p Policy) Validate() error {
+	if p.Service == "" {
+		return fmt.Errorf("missing required field: service")
+	}
+	if p.Intent == "" {
+		return fmt.Errorf("missing required field: intent")
+	}
+	if p.Enabled == nil {
+		return fmt.Errorf("missing required field: enabled")
+	}
+
+	if p.TimeRestrictions != nil {
+		for _, hour := range p.TimeRestrictions.AllowedHours {
+			if hour < 0 || hour > 23 {
+				return fmt.Errorf("allowed hour %d is outside 0-23", hour)
+			}
+		}
+	}
+
+	if p.RateLimits != nil {
+		if p.RateLimits.RequestsPerMinute < 0 || p.RateLimits.RequestsPerHour < 0 {
+			return fmt.Errorf("rate limits must be positive")
+		}
+	}
+
+	for i, c := range p.Conditions {
+		if err := c.validate(); err != nil {
+			return fmt.Errorf("condition %d: %w", i, err)
+		}
+	}
+
+	// Obligation types are not checked here: Orbit refuses requests whose
+	// obligations it cannot enforce, so policies may use newer types
+	for i, o := range p.Obligations {
+		if o.Type == "" {
+			return fmt.Errorf("obligation %d: missing required field: type", i)
+		}
+	}
+	return nil
+}
+
+func (c Condition) validate() error {
+	if c.Type != ConditionContextCheck {
+		return fmt.Errorf("unknown condition type %q", c.Type)
+	}
+	if c.Field == "" {
+		return fmt.Errorf("missing required field: field")
+	}
+
+	switch c.Operator {
+	case OperatorEquals, OperatorNotEquals, OperatorContains, OperatorGreaterThan, OperatorLessThan:
+	default:
+		return fmt.Errorf("unknown operator %q", c.Operator)
+	}
+
+	switch c.Value.(type) {
+	case string, json.Number, bool:
+	default:
+		return fmt.Errorf("value must be a string, number or boolean")
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package policy
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"orbit-service/audit"
+)
+
+// Request is a recorded governance request to replay
+type Request struct {
+	CorrelationID string
+	Service       string
+	Intent        string
+	Context       map[string]interface{}
+	// Time is when the request was decided; it is replayed at the same time
+	// so time restrictions apply as they did then
+	Time time.Time
+}
+
+// RequestFromRecord returns the request an audit record decided. Records of
+// dispatch outcomes and records without their context, which the recorder
+// drops unless AUDIT_RECORD_CONTEXT is set, cannot be replayed.
+func RequestFromRecord(record audit.Record) (Request, bool) {
+	if record.Outcome != "" {
+		return Request{}, false
+	}
+	// An empty context has no digest, so it can be replayed as is
+	if record.Context == nil && record.ContextDigest != "" {
+		return Request{}, false
+	}
+	return Request{
+		CorrelationID: record.CorrelationID,
+		Service:       record.Service,
+		Intent:        record.Intent,
+		Context:       record.Context,
+		Time:          record.Timestamp,
+	}, true
+}
+
+// Change is a group of replayed requests whose decision the candidate
+// policies change in the same way for the same cause
+type Change struct {
+	Service string `json:"service"`
+	Intent  string `json:"intent"`
+	// From and To are the decisions of the current and candidate policies:
+	// allow, deny or requires_approval
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Cause is the check that did not pass, with the reason it gave, on
+	// the side of the change that did not allow the request
+	Cause           string   `json:"cause"`
+	Count           int      `json:"count"`
+	Samples         []string `json:"sample_correlation_ids"`
+	CurrentReason   string   `json:"current_reason"`
+	CandidateReason string   `json:"candidate_reason"`
+}
+
+// ImpactReport summarises a replay
+type ImpactReport struct {
+	Replayed int `json:"replayed"`
+	// Unchanged counts requests both policy sets decide the same way
+	Unchanged int `json:"unchanged"`
+	// Changes are ordered by count, largest first
+	Changes []Change `json:"changes"`
+}
+
+// Changed returns how many replayed requests changed decision
+func (r ImpactReport) Changed() int {
+	return r.Replayed - r.Unchanged
+}
+
+type changeKey struct {
+	service string
+	intent  string
+	from    string
+	to      string
+	cause   string
+}
+
+// ImpactAnalysis replays requests against current and candidate policies
+// and groups the requests whose decision would change
+type ImpactAnalysis struct {
+	current   *Engine
+	candidate *Engine
+	samples   int
+
+	replayed  int
+	unchanged int
+	changes   map[changeKey]*Change
+}
+
+// NewImpactAnalysis creates an analysis keeping up to samples correlation
+// IDs for each group of changed requests
+func NewImpactAnalysis(current, candidate *Engine, samples int) *ImpactAnalysis {
+	return &ImpactAnalysis{
+		current:   current,
+		candidate: candidate,
+		samples:   samples,
+		changes:   make(map[changeKey]*Change),
+	}
+}
+
+// Add replays one request
+func (a *ImpactAnalysis) Add(req Request) {
+	a.replayed++
+
+	before, beforeTrace := a.current.Explain(req.Service, req.Intent, req.Context, req.Time)
+	after, afterTrace := a.candidate.Explain(req.Service, req.Intent, req.Context, req.Time)
+	from, to := decisionKind(before), decisionKind(after)
+	if from == to {
+		a.unchanged++
+		return
+	}
+
+	// Blame the side that refused the request; when neither allowed it, the
+	// candidate's refusal is the one that is new
+	cause := failedCheck(afterTrace, after)
+	if to == audit.DecisionAllow {
+		cause = failedCheck(beforeTrace, before)
+	}
+
+	key := changeKey{service: req.Service, intent: req.Intent, from: from, to: to, cause: cause}
+	change, ok := a.changes[key]
+	if !ok {
+		change = &Change{
+			Service:         req.Service,
+			Intent:          req.Intent,
+			From:            from,
+			To:              to,
+			Cause:           cause,
+			Samples:         []string{},
+			CurrentReason:   before.Reason,
+			CandidateReason: after.Reason,
+		}
+		a.changes[key] = change
+	}
+	change.Count++
+	if len(change.Samples) < a.samples && req.CorrelationID != "" {
+		change.Samples = append(change.Samples, req.CorrelationID)
+	}
+}
+
+// Report returns the changes found so far
+func (a *ImpactAnalysis) Report() ImpactReport {
+	report := ImpactReport{Replayed: a.replayed, Unchanged: a.unchanged, Changes: []Change{}}
+	for _, change := range a.changes {
+		report.Changes = append(report.Changes, *change)
+	}
+	sort.Slice(report.Changes, func(i, j int) bool {
+		a, b := report.Changes[i], report.Changes[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return strings.Join([]string{a.Service, a.Intent, a.From, a.To, a.Cause}, "\x00") <
+			strings.Join([]string{b.Service, b.Intent, b.From, b.To, b.Cause}, "\x00")
+	})
+	return report
+}
+
+func decisionKind(decision Decision) string {
+	switch {
+	case decision.Allowed:
+		return audit.DecisionAllow
+	case decision.RequiresApproval:
+		return audit.DecisionApprovalRequired
+	default:
+		return audit.DecisionDeny
+	}
+}
+
+// failedCheck names the check that stopped an evaluation with the reason
+// the decision gave. The reason rather than the step's detail is used so
+// requests that failed a condition with different values group together.
+func failedCheck(trace []Step, decision Decision) string {
+	for _, step := range trace {
+		if !step.Passed {
+			return step.Check + ": " + decision.Reason
+		}
+	}
+	return decision.Reason
+}
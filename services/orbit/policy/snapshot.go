@@ -0,0 +1,28 @@
+package policy
+
+import (
+	_ "embed"
+
+	"github.com/rs/zerolog"
+)
+
+// snapshot is a copy of governance/policies/default.json built into the
+// binary, so Orbit can decide locally when no policy file is available.
+// Keep it in sync when the default policies change.
+//
+//go:embed snapshot.json
+var snapshot []byte
+
+// SnapshotPolicies returns the embedded policy snapshot
+func SnapshotPolicies() ([]Policy, error) {
+	return Parse(snapshot)
+}
+
+// NewSnapshotEngine creates an engine for the embedded policy snapshot
+func NewSnapshotEngine(logger zerolog.Logger) (*Engine, error) {
+	policies, err := SnapshotPolicies()
+	if err != nil {
+		return nil, err
+	}
+	return NewEngine(logger, policies)
+}
@@ -0,0 +1,120 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Suite is a set of expected decisions for a policy set, in the format of
+// governance/policies/golden/cases.json. Policies are either given inline,
+// as an array, or as the path of a policy file relative to the suite.
+type Suite struct {
+	Description string          `json:"description,omitempty"`
+	Policies    json.RawMessage `json:"policies,omitempty"`
+	Cases       []TestCase      `json:"cases"`
+}
+
+// TestCase is a request and the decision expected for it. Only the
+// expectations that are set are checked, except Allowed, which is required.
+type TestCase struct {
+	Name    string `json:"name"`
+	Service string `json:"service"`
+	Intent  string `json:"intent"`
+	// Hour is the UTC hour the request is evaluated at (default 12)
+	Hour    *int                   `json:"hour,omitempty"`
+	Context map[string]interface{} `json:"context,omitempty"`
+
+	Allowed          *bool   `json:"allowed"`
+	Reason           *string `json:"reason,omitempty"`
+	RequiresApproval *bool   `json:"requires_approval,omitempty"`
+	PolicyVersion    *string `json:"policy_version,omitempty"`
+}
+
+// CaseResult is the outcome of one test case
+type CaseResult struct {
+	Case     TestCase
+	Decision Decision
+	Trace    []Step
+	// Failures describe each expectation the decision did not meet
+	Failures []string
+}
+
+// Passed reports whether the decision met every expectation
+func (r CaseResult) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+// ParseSuite decodes and checks a JSON test suite
+func ParseSuite(data []byte) (Suite, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+
+	var suite Suite
+	if err := decoder.Decode(&suite); err != nil {
+		return Suite{}, fmt.Errorf("failed to parse test suite: %w", err)
+	}
+	if len(suite.Cases) == 0 {
+		return Suite{}, fmt.Errorf("test suite has no cases")
+	}
+	for i, tc := range suite.Cases {
+		if tc.Name == "" {
+			return Suite{}, fmt.Errorf("case %d: missing required field: name", i)
+		}
+		if tc.Service == "" || tc.Intent == "" {
+			return Suite{}, fmt.Errorf("case %q: service and intent are required", tc.Name)
+		}
+		if tc.Allowed == nil {
+			return Suite{}, fmt.Errorf("case %q: missing required field: allowed", tc.Name)
+		}
+		if tc.Hour != nil && (*tc.Hour < 0 || *tc.Hour > 23) {
+			return Suite{}, fmt.Errorf("case %q: hour %d is outside 0-23", tc.Name, *tc.Hour)
+		}
+	}
+	return suite, nil
+}
+
+// PolicyPath returns the policy file the suite refers to, if its policies
+// are not inline
+func (s Suite) PolicyPath() (string, bool) {
+	var path string
+	if err := json.Unmarshal(s.Policies, &path); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// Run evaluates every case against the engine
+func (s Suite) Run(engine *Engine) []CaseResult {
+	results := make([]CaseResult, 0, len(s.Cases))
+	for _, tc := range s.Cases {
+		hour := 12
+		if tc.Hour != nil {
+			hour = *tc.Hour
+		}
+		now := time.Date(2024, 1, 1, hour, 30, 0, 0, time.UTC)
+
+		decision, trace := engine.Explain(tc.Service, tc.Intent, tc.Context, now)
+		result := CaseResult{Case: tc, Decision: decision, Trace: trace}
+		expect := func(field string, got, want interface{}) {
+			if got != want {
+				result.Failures = append(result.Failures, fmt.Sprintf("%s: got %v want %v", field, got, want))
+			}
+		}
+
+		expect("allowed", decision.Allowed, *tc.Allowed)
+		if tc.Reason != nil {
+			expect("reason", decision.Reason, *tc.Reason)
+		}
+		if tc.RequiresApproval != nil {
+			expect("requires_approval", decision.RequiresApproval, *tc.RequiresApproval)
+		}
+		if tc.PolicyVersion != nil {
+			expect("policy_version", decision.PolicyVersion, *tc.PolicyVersion)
+		}
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,113 @@
+package ratelimit
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Rate limit backends selected by RATE_LIMIT_BACKEND
+const (
+	BackendLocal    = "local"
+	BackendDynamoDB = "dynamodb"
+	BackendRedis    = "redis"
+)
+
+// LimiterFromEnv creates the limiter selected by RATE_LIMIT_BACKEND (default
+// local). The DynamoDB backend reads RATE_LIMIT_DYNAMODB_TABLE and, for
+// DynamoDB Local, RATE_LIMIT_DYNAMODB_ENDPOINT. The Redis backend reads
+// RATE_LIMIT_REDIS_ADDR, RATE_LIMIT_REDIS_PASSWORD and RATE_LIMIT_REDIS_TLS.
+// Both lease requests as set by RATE_LIMIT_LEASE_SIZE and
+// RATE_LIMIT_LEASE_TTL.
+func LimiterFromEnv() (Limiter, error) {
+	kind := os.Getenv("RATE_LIMIT_BACKEND")
+	if kind == "" {
+		kind = BackendLocal
+	}
+
+	var backend Backend
+	switch kind {
+	case BackendLocal:
+		return NewLocalLimiter(), nil
+	case BackendDynamoDB:
+		table := os.Getenv("RATE_LIMIT_DYNAMODB_TABLE")
+		if table == "" {
+			return nil, fmt.Errorf("RATE_LIMIT_DYNAMODB_TABLE environment variable not set")
+		}
+		sess, err := awsSession(os.Getenv("RATE_LIMIT_DYNAMODB_ENDPOINT"))
+		if err != nil {
+			return nil, err
+		}
+		backend = NewDynamoDBBackend(dynamodb.New(sess), table)
+	case BackendRedis:
+		config := RedisConfig{
+			Addr:     os.Getenv("RATE_LIMIT_REDIS_ADDR"),
+			Password: os.Getenv("RATE_LIMIT_REDIS_PASSWORD"),
+			PoolSize: 16,
+			Timeout:  time.Second,
+		}
+		if config.Addr == "" {
+			return nil, fmt.Errorf("RATE_LIMIT_REDIS_ADDR environment variable not set")
+		}
+		if value := os.Getenv("RATE_LIMIT_REDIS_TLS"); value != "" {
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid RATE_LIMIT_REDIS_TLS %q", value)
+			}
+			config.TLS = enabled
+		}
+		backend = NewRedisBackend(config)
+	default:
+		return nil, fmt.Errorf("unknown RATE_LIMIT_BACKEND %q", kind)
+	}
+
+	lease, err := leaseConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return NewDistributedLimiter(backend, lease), nil
+}
+
+func leaseConfigFromEnv() (LeaseConfig, error) {
+	config := DefaultLeaseConfig()
+
+	if value := os.Getenv("RATE_LIMIT_LEASE_SIZE"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 1 {
+			return config, fmt.Errorf("invalid RATE_LIMIT_LEASE_SIZE %q", value)
+		}
+		config.Size = size
+	}
+
+	if value := os.Getenv("RATE_LIMIT_LEASE_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			return config, fmt.Errorf("invalid RATE_LIMIT_LEASE_TTL %q", value)
+		}
+		config.TTL = ttl
+	}
+
+	return config, nil
+}
+
+func awsSession(endpoint string) (*session.Session, error) {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	config := &aws.Config{Region: aws.String(region)}
+	if endpoint != "" {
+		config.Endpoint = aws.String(endpoint)
+	}
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+	return sess, nil
+}
@@ -0,0 +1,225 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// DynamoDB item attributes. The table's partition key is the string "pk";
+// enable TTL on "expires_at" so old counters are removed.
+const (
+	dynamoDBKeyAttribute      = "pk"
+	dynamoDBRequestsAttribute = "requests"
+	dynamoDBExpiresAttribute  = "expires_at"
+)
+
+// dynamoDBAttempts bounds the retries when another task updates the same
+// counters between our read and our write
+const dynamoDBAttempts = 3
+
+// DynamoDBBackend keeps sliding window counters in DynamoDB. Each window is
+// approximated from request counts for the current and previous fixed
+// windows, the previous one weighted by how much of it still overlaps the
+// sliding window. Counts only grow through conditional writes, so tasks
+// racing for the last requests of a window cannot exceed it.
+type DynamoDBBackend struct {
+	client dynamodbiface.DynamoDBAPI
+	table  string
+}
+
+// NewDynamoDBBackend creates a backend storing counters in table
+func NewDynamoDBBackend(client dynamodbiface.DynamoDBAPI, table string) *DynamoDBBackend {
+	return &DynamoDBBackend{client: client, table: table}
+}
+
+// slidingWindow holds the counts a window is decided from
+type slidingWindow struct {
+	window
+	start    time.Time
+	elapsed  time.Duration
+	previous int64
+	current  int64
+}
+
+func newSlidingWindow(w window, now time.Time) *slidingWindow {
+	start := now.Truncate(w.length)
+	return &slidingWindow{window: w, start: start, elapsed: now.Sub(start)}
+}
+
+func (s *slidingWindow) itemKey(key Key, start time.Time) string {
+	return fmt.Sprintf("%s|%s|%d", key.String(), s.name, start.Unix())
+}
+
+func (s *slidingWindow) currentKey(key Key) string {
+	return s.itemKey(key, s.start)
+}
+
+func (s *slidingWindow) previousKey(key Key) string {
+	return s.itemKey(key, s.start.Add(-s.length))
+}
+
+// weightedPrevious is the part of the previous window's count still inside
+// the sliding window
+func (s *slidingWindow) weightedPrevious() float64 {
+	overlap := 1 - s.elapsed.Seconds()/s.length.Seconds()
+	return float64(s.previous) * overlap
+}
+
+// maxCurrent is the highest current count that still leaves room for n
+func (s *slidingWindow) maxCurrent(n int) int64 {
+	return int64(math.Floor(float64(s.limit-n) - s.weightedPrevious()))
+}
+
+func (s *slidingWindow) decide(n int) windowDecision {
+	d := windowDecision{limit: s.limit}
+
+	current := s.current
+	if current <= s.maxCurrent(n) {
+		d.allowed = true
+		current += int64(n)
+	} else {
+		d.retryAfter = s.untilRoom(n)
+	}
+
+	used := s.weightedPrevious() + float64(current)
+	d.remaining = int(math.Max(0, math.Floor(float64(s.limit)-used)))
+
+	untilNext := s.length - s.elapsed
+	switch {
+	case current > 0:
+		d.reset = untilNext + s.length
+	case s.previous > 0:
+		d.reset = untilNext
+	}
+	return d
+}
+
+// untilRoom estimates how long until n more requests fit, assuming no
+// other requests arrive meanwhile
+func (s *slidingWindow) untilRoom(n int) time.Duration {
+	room := float64(s.limit - n)
+	if float64(s.current) <= room {
+		// Wait for the previous window's share to decay
+		fraction := 1 - (room-float64(s.current))/float64(s.previous)
+		return secondsToDuration(fraction*s.length.Seconds() - s.elapsed.Seconds())
+	}
+
+	// Wait for the next window, then for this window's share to decay
+	fraction := 1 - room/float64(s.current)
+	return s.length - s.elapsed + secondsToDuration(fraction*s.length.Seconds())
+}
+
+func (b *DynamoDBBackend) Take(ctx context.Context, key Key, limits Limits, n int, now time.Time) (Decision, error) {
+	for attempt := 1; ; attempt++ {
+		var windows []*slidingWindow
+		for _, w := range windowsOf(limits) {
+			windows = append(windows, newSlidingWindow(w, now))
+		}
+		if err := b.read(ctx, key, windows); err != nil {
+			return Decision{}, err
+		}
+
+		decisions := make([]windowDecision, len(windows))
+		allowed := true
+		for i, s := range windows {
+			decisions[i] = s.decide(n)
+			allowed = allowed && decisions[i].allowed
+		}
+		if !allowed {
+			return combine(decisions), nil
+		}
+
+		err := b.write(ctx, key, windows, n)
+		if err == nil {
+			return combine(decisions), nil
+		}
+		var canceled *dynamodb.TransactionCanceledException
+		if !errors.As(err, &canceled) {
+			return Decision{}, fmt.Errorf("failed to update rate limit counters: %w", err)
+		}
+		if attempt == dynamoDBAttempts {
+			return Decision{}, fmt.Errorf("rate limit counters for %s changed on every attempt", key)
+		}
+	}
+}
+
+// read loads the current and previous counts of every window
+func (b *DynamoDBBackend) read(ctx context.Context, key Key, windows []*slidingWindow) error {
+	keys := make([]map[string]*dynamodb.AttributeValue, 0, 2*len(windows))
+	for _, s := range windows {
+		keys = append(keys,
+			map[string]*dynamodb.AttributeValue{dynamoDBKeyAttribute: {S: aws.String(s.currentKey(key))}},
+			map[string]*dynamodb.AttributeValue{dynamoDBKeyAttribute: {S: aws.String(s.previousKey(key))}},
+		)
+	}
+
+	counts := make(map[string]int64, len(keys))
+	request := map[string]*dynamodb.KeysAndAttributes{
+		b.table: {Keys: keys, ConsistentRead: aws.Bool(true)},
+	}
+	for len(request) > 0 {
+		output, err := b.client.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{RequestItems: request})
+		if err != nil {
+			return fmt.Errorf("failed to read rate limit counters: %w", err)
+		}
+		for _, item := range output.Responses[b.table] {
+			if item[dynamoDBKeyAttribute] == nil || item[dynamoDBRequestsAttribute] == nil {
+				continue
+			}
+			count, err := strconv.ParseInt(aws.StringValue(item[dynamoDBRequestsAttribute].N), 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid rate limit counter: %w", err)
+			}
+			counts[aws.StringValue(item[dynamoDBKeyAttribute].S)] = count
+		}
+		request = output.UnprocessedKeys
+	}
+
+	for _, s := range windows {
+		s.current = counts[s.currentKey(key)]
+		s.previous = counts[s.previousKey(key)]
+	}
+	return nil
+}
+
+// write adds n to every current count in one transaction, on condition that
+// no count has grown past the point where n still fits
+func (b *DynamoDBBackend) write(ctx context.Context, key Key, windows []*slidingWindow, n int) error {
+	items := make([]*dynamodb.TransactWriteItem, 0, len(windows))
+	for _, s := range windows {
+		// Counters are read as the previous window until the end of the next
+		expires := s.start.Add(2 * s.length).Unix()
+		items = append(items, &dynamodb.TransactWriteItem{
+			Update: &dynamodb.Update{
+				TableName: aws.String(b.table),
+				Key: map[string]*dynamodb.AttributeValue{
+					dynamoDBKeyAttribute: {S: aws.String(s.currentKey(key))},
+				},
+				UpdateExpression:    aws.String("ADD #requests :n SET #expires = if_not_exists(#expires, :expires)"),
+				ConditionExpression: aws.String("attribute_not_exists(#requests) OR #requests <= :max"),
+				ExpressionAttributeNames: map[string]*string{
+					"#requests": aws.String(dynamoDBRequestsAttribute),
+					"#expires":  aws.String(dynamoDBExpiresAttribute),
+				},
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":n":       {N: aws.String(strconv.Itoa(n))},
+					":max":     {N: aws.String(strconv.FormatInt(s.maxCurrent(n), 10))},
+					":expires": {N: aws.String(strconv.FormatInt(expires, 10))},
+				},
+			},
+		})
+	}
+
+	_, err := b.client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	return err
+}
@@ -0,0 +1,184 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Backend keeps limits shared by every Orbit task
+type Backend interface {
+	// Take consumes n requests from every limited window of key, or none
+	// if any window would be exceeded. The decision describes the tightest
+	// window afterwards.
+	Take(ctx context.Context, key Key, limits Limits, n int, now time.Time) (Decision, error)
+}
+
+// LeaseConfig controls how many requests a task takes from the backend at
+// once. Leased requests a task does not use before the lease expires are
+// lost, so larger leases mean fewer backend calls but a limit that is
+// reached sooner when traffic is spread across many tasks.
+type LeaseConfig struct {
+	// Size is the most requests leased at once. A lease never exceeds a
+	// tenth of the smallest limit, so one task cannot drain a window.
+	Size int
+	// TTL is how long leased requests, and throttling decisions, are used
+	// before the backend is asked again
+	TTL time.Duration
+}
+
+// DefaultLeaseConfig returns the lease configuration used by Orbit
+func DefaultLeaseConfig() LeaseConfig {
+	return LeaseConfig{
+		Size: 10,
+		TTL:  time.Second,
+	}
+}
+
+type lease struct {
+	limits   Limits
+	tokens   int
+	decision Decision
+	taken    time.Time
+	expires  time.Time
+}
+
+// DistributedLimiter enforces limits kept by a Backend, serving most
+// requests from requests leased in advance
+type DistributedLimiter struct {
+	backend Backend
+	config  LeaseConfig
+	now     func() time.Time
+
+	mu     sync.Mutex
+	leases map[Key]*lease
+}
+
+// NewDistributedLimiter creates a limiter backed by backend
+func NewDistributedLimiter(backend Backend, config LeaseConfig) *DistributedLimiter {
+	return NewDistributedLimiterWithClock(backend, config, time.Now)
+}
+
+// NewDistributedLimiterWithClock creates a limiter backed by backend that
+// reads time from now
+func NewDistributedLimiterWithClock(backend Backend, config LeaseConfig, now func() time.Time) *DistributedLimiter {
+	return &DistributedLimiter{
+		backend: backend,
+		config:  config,
+		now:     now,
+		leases:  make(map[Key]*lease),
+	}
+}
+
+func (l *DistributedLimiter) Allow(ctx context.Context, key Key, limits Limits) (Decision, error) {
+	if !limits.Enabled() {
+		return Decision{Allowed: true}, nil
+	}
+
+	now := l.now()
+	if decision, ok := l.fromLease(key, limits, now); ok {
+		return decision, nil
+	}
+
+	size := l.leaseSize(limits)
+	decision, err := l.backend.Take(ctx, key, limits, size, now)
+	if err == nil && !decision.Allowed && size > 1 {
+		// Not enough left for a full lease; this request may still fit
+		size = 1
+		decision, err = l.backend.Take(ctx, key, limits, size, now)
+	}
+	if err != nil {
+		return Decision{}, err
+	}
+
+	l.store(key, &lease{
+		limits:   limits,
+		tokens:   leftover(decision, size),
+		decision: decision,
+		taken:    now,
+		expires:  leaseExpiry(decision, now, l.config.TTL),
+	})
+	if decision.Allowed {
+		decision.Remaining += leftover(decision, size)
+	}
+	return decision, nil
+}
+
+// fromLease serves a request from the key's lease, if it has a usable one
+func (l *DistributedLimiter) fromLease(key Key, limits Limits, now time.Time) (Decision, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current, ok := l.leases[key]
+	if !ok || current.limits != limits || !now.Before(current.expires) {
+		return Decision{}, false
+	}
+
+	elapsed := now.Sub(current.taken)
+	decision := current.decision
+	decision.Reset = nonNegative(decision.Reset - elapsed)
+
+	if !current.decision.Allowed {
+		decision.RetryAfter = nonNegative(decision.RetryAfter - elapsed)
+		return decision, true
+	}
+	if current.tokens == 0 {
+		return Decision{}, false
+	}
+
+	current.tokens--
+	decision.Remaining += current.tokens
+	return decision, true
+}
+
+func (l *DistributedLimiter) store(key Key, next *lease) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.leases[key]; !ok && len(l.leases) >= maxLocalKeys {
+		for k, existing := range l.leases {
+			if !next.taken.Before(existing.expires) {
+				delete(l.leases, k)
+			}
+		}
+	}
+	l.leases[key] = next
+}
+
+// leaseSize is the number of requests to take from the backend at once
+func (l *DistributedLimiter) leaseSize(limits Limits) int {
+	size := l.config.Size
+	for _, limit := range []int{limits.RequestsPerMinute, limits.RequestsPerHour} {
+		if limit > 0 && limit/10 < size {
+			size = limit / 10
+		}
+	}
+	if size < 1 {
+		return 1
+	}
+	return size
+}
+
+// leftover is the number of leased requests kept for later requests
+func leftover(decision Decision, size int) int {
+	if !decision.Allowed {
+		return 0
+	}
+	return size - 1
+}
+
+// leaseExpiry keeps a throttling decision no longer than the caller is told
+// to wait, so a caller that retries on time is not refused by a stale lease
+func leaseExpiry(decision Decision, now time.Time, ttl time.Duration) time.Time {
+	if !decision.Allowed && decision.RetryAfter < ttl {
+		return now.Add(decision.RetryAfter)
+	}
+	return now.Add(ttl)
+}
+
+func nonNegative(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
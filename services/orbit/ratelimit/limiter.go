@@ -0,0 +1,97 @@
+// Package ratelimit throttles dispatches per caller and intent using the
+// rate_limits declared by governance policies.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Key identifies the requests that share a rate limit
+type Key struct {
+	// Subject is the caller, or the client address for anonymous callers
+	Subject string
+	Intent  string
+}
+
+func (k Key) String() string {
+	return k.Intent + "|" + k.Subject
+}
+
+// Limits are the request rates allowed for a key; zero disables a window
+type Limits struct {
+	RequestsPerMinute int
+	RequestsPerHour   int
+}
+
+// Enabled reports whether any window is limited
+func (l Limits) Enabled() bool {
+	return l.RequestsPerMinute > 0 || l.RequestsPerHour > 0
+}
+
+// Decision is the outcome of a rate limit check. Limit, Remaining and Reset
+// describe the most restrictive window.
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the window is fully replenished
+	Reset time.Duration
+	// RetryAfter is how long a throttled caller should wait
+	RetryAfter time.Duration
+}
+
+// Limiter decides whether a request may proceed and consumes its share of
+// the limit if so
+type Limiter interface {
+	Allow(ctx context.Context, key Key, limits Limits) (Decision, error)
+}
+
+// MeteredLimiter counts the decisions of the limiter it wraps
+type MeteredLimiter struct {
+	next Limiter
+
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+// NewMeteredLimiter wraps next with decision counters
+func NewMeteredLimiter(next Limiter) *MeteredLimiter {
+	return &MeteredLimiter{
+		next:   next,
+		counts: make(map[string]int64),
+	}
+}
+
+func (m *MeteredLimiter) Allow(ctx context.Context, key Key, limits Limits) (Decision, error) {
+	decision, err := m.next.Allow(ctx, key, limits)
+
+	outcome := "allowed"
+	switch {
+	case err != nil:
+		outcome = "errors"
+	case !decision.Allowed:
+		outcome = "throttled"
+	}
+
+	m.mu.Lock()
+	m.counts[outcome]++
+	m.counts[key.Intent+"."+outcome]++
+	m.mu.Unlock()
+
+	return decision, err
+}
+
+// Snapshot returns the decision counts, in total ("allowed", "throttled",
+// "errors") and per intent ("call_reasoning.throttled")
+func (m *MeteredLimiter) Snapshot() map[string]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := make(map[string]int64, len(m.counts))
+	for name, count := range m.counts {
+		snapshot[name] = count
+	}
+	return snapshot
+}
@@ -0,0 +1,186 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// maxLocalKeys is the number of keys kept before idle buckets are swept
+const maxLocalKeys = 100000
+
+// bucket is a token bucket that refills to capacity over window
+type bucket struct {
+	capacity float64
+	window   time.Duration
+	tokens   float64
+	updated  time.Time
+}
+
+func newBucket(limit int, window time.Duration, now time.Time) *bucket {
+	return &bucket{
+		capacity: float64(limit),
+		window:   window,
+		tokens:   float64(limit),
+		updated:  now,
+	}
+}
+
+// rate is the number of tokens added per second
+func (b *bucket) rate() float64 {
+	return b.capacity / b.window.Seconds()
+}
+
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(b.capacity, b.tokens+elapsed*b.rate())
+		b.updated = now
+	}
+}
+
+// resize applies a changed limit, keeping the tokens already spent
+func (b *bucket) resize(limit int) {
+	if float64(limit) == b.capacity {
+		return
+	}
+	spent := b.capacity - b.tokens
+	b.capacity = float64(limit)
+	b.tokens = math.Max(0, b.capacity-spent)
+}
+
+func (b *bucket) untilFull() time.Duration {
+	return secondsToDuration((b.capacity - b.tokens) / b.rate())
+}
+
+func (b *bucket) untilToken() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return secondsToDuration((1 - b.tokens) / b.rate())
+}
+
+type keyBuckets struct {
+	minute *bucket
+	hour   *bucket
+}
+
+// LocalLimiter keeps a per-minute and a per-hour token bucket for each key
+// in memory. It limits a single Orbit process only.
+type LocalLimiter struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[Key]*keyBuckets
+}
+
+// NewLocalLimiter creates an in-memory limiter
+func NewLocalLimiter() *LocalLimiter {
+	return NewLocalLimiterWithClock(time.Now)
+}
+
+// NewLocalLimiterWithClock creates an in-memory limiter reading time from now
+func NewLocalLimiterWithClock(now func() time.Time) *LocalLimiter {
+	return &LocalLimiter{
+		now:     now,
+		buckets: make(map[Key]*keyBuckets),
+	}
+}
+
+func (l *LocalLimiter) Allow(ctx context.Context, key Key, limits Limits) (Decision, error) {
+	if !limits.Enabled() {
+		return Decision{Allowed: true}, nil
+	}
+
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	kb, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxLocalKeys {
+			l.sweepLocked(now)
+		}
+		kb = &keyBuckets{}
+		l.buckets[key] = kb
+	}
+	kb.minute = syncBucket(kb.minute, limits.RequestsPerMinute, time.Minute, now)
+	kb.hour = syncBucket(kb.hour, limits.RequestsPerHour, time.Hour, now)
+
+	var active []*bucket
+	for _, b := range []*bucket{kb.minute, kb.hour} {
+		if b != nil {
+			active = append(active, b)
+		}
+	}
+
+	allowed := true
+	var retryAfter time.Duration
+	for _, b := range active {
+		if wait := b.untilToken(); wait > 0 {
+			allowed = false
+			if wait > retryAfter {
+				retryAfter = wait
+			}
+		}
+	}
+	if allowed {
+		for _, b := range active {
+			b.tokens--
+		}
+	}
+
+	return describe(active, allowed, retryAfter), nil
+}
+
+// syncBucket refills b, creating, resizing or dropping it as limit requires
+func syncBucket(b *bucket, limit int, window time.Duration, now time.Time) *bucket {
+	if limit <= 0 {
+		return nil
+	}
+	if b == nil {
+		return newBucket(limit, window, now)
+	}
+	b.refill(now)
+	b.resize(limit)
+	return b
+}
+
+// describe reports the window with the fewest remaining requests
+func describe(active []*bucket, allowed bool, retryAfter time.Duration) Decision {
+	decision := Decision{Allowed: allowed, RetryAfter: retryAfter}
+
+	var tightest *bucket
+	for _, b := range active {
+		if tightest == nil || b.tokens < tightest.tokens {
+			tightest = b
+		}
+	}
+	decision.Limit = int(tightest.capacity)
+	decision.Remaining = int(math.Max(0, math.Floor(tightest.tokens)))
+	decision.Reset = tightest.untilFull()
+	return decision
+}
+
+// sweepLocked drops the buckets of keys that have fully replenished, which
+// behave exactly like new ones. Callers must hold l.mu.
+func (l *LocalLimiter) sweepLocked(now time.Time) {
+	for key, kb := range l.buckets {
+		idle := true
+		for _, b := range []*bucket{kb.minute, kb.hour} {
+			if b != nil {
+				b.refill(now)
+				idle = idle && b.tokens >= b.capacity
+			}
+		}
+		if idle {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// redisAttempts bounds the retries when another task updates the same keys
+// between our WATCH and EXEC
+const redisAttempts = 5
+
+// RedisBackend keeps limits in Redis using the generic cell rate algorithm.
+// Each window stores a theoretical arrival time that advances by
+// length/limit per request; a request is allowed while that time stays
+// within one window of now. Windows are updated together in an optimistic
+// transaction, so the backend needs only GET, SET, WATCH, MULTI and EXEC.
+type RedisBackend struct {
+	pool   *redisPool
+	prefix string
+}
+
+// NewRedisBackend creates a backend for the server described by config
+func NewRedisBackend(config RedisConfig) *RedisBackend {
+	return &RedisBackend{pool: newRedisPool(config), prefix: "orbit:ratelimit:"}
+}
+
+// gcraWindow holds a window's stored arrival time
+type gcraWindow struct {
+	window
+	key string
+	tat time.Time
+}
+
+func (g *gcraWindow) interval() time.Duration {
+	return g.length / time.Duration(g.limit)
+}
+
+// take decides n requests at now, returning the arrival time to store
+func (g *gcraWindow) take(n int, now time.Time) (windowDecision, time.Time) {
+	d := windowDecision{limit: g.limit}
+
+	tat := g.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	next := tat.Add(time.Duration(n) * g.interval())
+
+	if next.Sub(now) <= g.length {
+		d.allowed = true
+		tat = next
+	} else {
+		d.retryAfter = next.Sub(now) - g.length
+	}
+	d.remaining = int((g.length - tat.Sub(now)) / g.interval())
+	d.reset = tat.Sub(now)
+	return d, tat
+}
+
+func (b *RedisBackend) Take(ctx context.Context, key Key, limits Limits, n int, now time.Time) (decision Decision, err error) {
+	conn, err := b.pool.get(ctx)
+	if err != nil {
+		return Decision{}, err
+	}
+	defer func() { b.pool.put(conn, err) }()
+
+	var windows []*gcraWindow
+	args := []string{"WATCH"}
+	for _, w := range windowsOf(limits) {
+		g := &gcraWindow{window: w, key: b.prefix + key.String() + "|" + w.name}
+		windows = append(windows, g)
+		args = append(args, g.key)
+	}
+
+	for attempt := 1; attempt <= redisAttempts; attempt++ {
+		if _, err = conn.do(ctx, args...); err != nil {
+			return Decision{}, err
+		}
+		for _, g := range windows {
+			if g.tat, err = b.load(ctx, conn, g.key); err != nil {
+				return Decision{}, err
+			}
+		}
+
+		decisions := make([]windowDecision, len(windows))
+		arrivals := make([]time.Time, len(windows))
+		allowed := true
+		for i, g := range windows {
+			decisions[i], arrivals[i] = g.take(n, now)
+			allowed = allowed && decisions[i].allowed
+		}
+		if !allowed {
+			if _, err = conn.do(ctx, "UNWATCH"); err != nil {
+				return Decision{}, err
+			}
+			return combine(decisions), nil
+		}
+
+		if _, err = conn.do(ctx, "MULTI"); err != nil {
+			return Decision{}, err
+		}
+		for i, g := range windows {
+			ttl := arrivals[i].Sub(now)/time.Millisecond + 1
+			_, err = conn.do(ctx, "SET", g.key, strconv.FormatInt(arrivals[i].UnixNano(), 10), "PX", strconv.FormatInt(int64(ttl), 10))
+			if err != nil {
+				return Decision{}, err
+			}
+		}
+		var reply interface{}
+		if reply, err = conn.do(ctx, "EXEC"); err != nil {
+			return Decision{}, err
+		}
+		if reply != nil {
+			return combine(decisions), nil
+		}
+		// A watched key changed; try again with the new values
+	}
+	err = fmt.Errorf("rate limit keys for %s changed on every attempt", key)
+	return Decision{}, err
+}
+
+// load reads a stored arrival time; a missing key is the zero time
+func (b *RedisBackend) load(ctx context.Context, conn *redisConn, key string) (time.Time, error) {
+	reply, err := conn.do(ctx, "GET", key)
+	if err != nil || reply == nil {
+		return time.Time{}, err
+	}
+	value, ok := reply.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("unexpected redis reply for %s", key)
+	}
+	nanos, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid rate limit value for %s: %w", key, err)
+	}
+	return time.Unix(0, nanos), nil
+}
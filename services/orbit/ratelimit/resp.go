@@ -0,0 +1,174 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// redisError is an error reply from the server
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// RedisConfig describes how to reach a server speaking the Redis protocol
+type RedisConfig struct {
+	Addr     string
+	Password string
+	TLS      bool
+	// PoolSize is the number of idle connections kept
+	PoolSize int
+	// Timeout bounds dialing and each command when ctx has no deadline
+	Timeout time.Duration
+}
+
+// redisPool hands out connections for exclusive use; WATCH state belongs to
+// a connection, so a transaction must run on one connection throughout
+type redisPool struct {
+	config RedisConfig
+	idle   chan *redisConn
+}
+
+func newRedisPool(config RedisConfig) *redisPool {
+	size := config.PoolSize
+	if size < 1 {
+		size = 1
+	}
+	return &redisPool{config: config, idle: make(chan *redisConn, size)}
+}
+
+func (p *redisPool) get(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-p.idle:
+		return conn, nil
+	default:
+	}
+
+	dialer := &net.Dialer{Timeout: p.config.Timeout}
+	var conn net.Conn
+	var err error
+	if p.config.TLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer}).DialContext(ctx, "tcp", p.config.Addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", p.config.Addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+
+	c := &redisConn{conn: conn, reader: bufio.NewReader(conn), timeout: p.config.Timeout}
+	if p.config.Password != "" {
+		if _, err := c.do(ctx, "AUTH", p.config.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// put returns a connection to the pool. A connection used by a failed
+// command is closed instead, as it may be left in a transaction or holding
+// an unread reply.
+func (p *redisPool) put(conn *redisConn, err error) {
+	if err != nil {
+		conn.conn.Close()
+		return
+	}
+	select {
+	case p.idle <- conn:
+	default:
+		conn.conn.Close()
+	}
+}
+
+type redisConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+}
+
+// do sends a command and reads its reply: a string, an int64, nil or a
+// []interface{} of those
+func (c *redisConn) do(ctx context.Context, args ...string) (interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok && c.timeout > 0 {
+		deadline = time.Now().Add(c.timeout)
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, fmt.Errorf("failed to send redis command: %w", err)
+	}
+	return readReply(c.reader)
+}
+
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read redis reply: %w", err)
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed redis reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("malformed redis reply %q", line)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("failed to read redis reply: %w", err)
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("malformed redis reply %q", line)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			item, err := readReply(r)
+			var reply redisError
+			if err != nil && !errors.As(err, &reply) {
+				return nil, err
+			}
+			if err != nil {
+				item = reply
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unknown redis reply type %q", kind)
+}
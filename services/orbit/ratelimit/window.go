@@ -0,0 +1,57 @@
+package ratelimit
+
+import "time"
+
+// window is one limited window of a key's limits
+type window struct {
+	name   string
+	limit  int
+	length time.Duration
+}
+
+// windowsOf lists the windows limits restricts
+func windowsOf(limits Limits) []window {
+	var windows []window
+	if limits.RequestsPerMinute > 0 {
+		windows = append(windows, window{name: "minute", limit: limits.RequestsPerMinute, length: time.Minute})
+	}
+	if limits.RequestsPerHour > 0 {
+		windows = append(windows, window{name: "hour", limit: limits.RequestsPerHour, length: time.Hour})
+	}
+	return windows
+}
+
+// windowDecision is a backend's view of one window after a request
+type windowDecision struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+// combine reports the tightest window, allowing the request only if every
+// window allows it
+func combine(decisions []windowDecision) Decision {
+	decision := Decision{Allowed: true}
+
+	var tightest *windowDecision
+	for i := range decisions {
+		d := &decisions[i]
+		if !d.allowed {
+			decision.Allowed = false
+			if d.retryAfter > decision.RetryAfter {
+				decision.RetryAfter = d.retryAfter
+			}
+		}
+		if tightest == nil || d.remaining < tightest.remaining {
+			tightest = d
+		}
+	}
+	if tightest != nil {
+		decision.Limit = tightest.limit
+		decision.Remaining = tightest.remaining
+		decision.Reset = tightest.reset
+	}
+	return decision
+}
@@ -0,0 +1,87 @@
+package runs
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config bounds agent runs. The limits are the most a run may ask for, and
+// what it gets when it asks for none.
+type Config struct {
+	// MaxSteps bounds the reasoning steps of a run
+	MaxSteps int
+	// MaxDuration bounds a run's wall time
+	MaxDuration time.Duration
+	// MaxTokens bounds the tokens a run may use
+	MaxTokens int
+	// MaxConcurrent is the number of runs in progress at once
+	MaxConcurrent int
+	// ResultTTL is how long a finished run and its transcript are kept
+	ResultTTL time.Duration
+}
+
+// DefaultConfig returns the run settings used when none are configured
+func DefaultConfig() Config {
+	return Config{
+		MaxSteps:      10,
+		MaxDuration:   5 * time.Minute,
+		MaxTokens:     100000,
+		MaxConcurrent: 16,
+		ResultTTL:     time.Hour,
+	}
+}
+
+// ConfigFromEnv returns the default config overridden by RUN_MAX_STEPS,
+// RUN_MAX_TOKENS, RUN_MAX_CONCURRENT, RUN_MAX_DURATION and RUN_RESULT_TTL
+// (Go duration strings)
+func ConfigFromEnv() (Config, error) {
+	config := DefaultConfig()
+
+	counts := []struct {
+		name string
+		into *int
+	}{
+		{"RUN_MAX_STEPS", &config.MaxSteps},
+		{"RUN_MAX_TOKENS", &config.MaxTokens},
+		{"RUN_MAX_CONCURRENT", &config.MaxConcurrent},
+	}
+	for _, c := range counts {
+		if value := os.Getenv(c.name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return config, fmt.Errorf("invalid %s %q", c.name, value)
+			}
+			*c.into = n
+		}
+	}
+
+	durations := []struct {
+		name string
+		into *time.Duration
+	}{
+		{"RUN_MAX_DURATION", &config.MaxDuration},
+		{"RUN_RESULT_TTL", &config.ResultTTL},
+	}
+	for _, d := range durations {
+		if value := os.Getenv(d.name); value != "" {
+			duration, err := time.ParseDuration(value)
+			if err != nil || duration <= 0 {
+				return config, fmt.Errorf("invalid %s %q", d.name, value)
+			}
+			*d.into = duration
+		}
+	}
+
+	return config, nil
+}
+
+// Limits returns the most a run may ask for
+func (c Config) Limits() Limits {
+	return Limits{
+		MaxSteps:    c.MaxSteps,
+		MaxDuration: c.MaxDuration,
+		MaxTokens:   c.MaxTokens,
+	}
+}
@@ -0,0 +1,228 @@
+package runs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/contract"
+)
+
+// ErrBusy is returned when MaxConcurrent runs are already in progress
+var ErrBusy = errors.New("too many runs in progress")
+
+// ErrClosed is returned for runs started after the manager is closed, and
+// is the error of runs it interrupted when it closed
+var ErrClosed = errors.New("run manager is closed")
+
+// Func performs a run's steps, recording each once it finishes, and returns
+// the run's final answer. It must return once ctx is done; returning a
+// *LimitError stops the run at that limit.
+type Func func(ctx context.Context, record func(Step) error) (json.RawMessage, error)
+
+// Manager performs runs in the background, at most MaxConcurrent at a
+// time, and keeps their transcripts until ResultTTL after they finish
+type Manager struct {
+	logger zerolog.Logger
+	store  Store
+	config Config
+	now    func() time.Time
+
+	// ctx is the parent of every run's context; Close cancels it
+	ctx  context.Context
+	stop context.CancelFunc
+
+	slots chan struct{}
+	runs  sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewManager creates a manager keeping runs in store
+func NewManager(logger zerolog.Logger, store Store, config Config) *Manager {
+	m := &Manager{
+		logger: logger,
+		store:  store,
+		config: config,
+		now:    time.Now,
+		slots:  make(chan struct{}, config.MaxConcurrent),
+	}
+	m.ctx, m.stop = context.WithCancel(context.Background())
+	return m
+}
+
+// MaxLimits returns the most a run may ask for
+func (m *Manager) MaxLimits() Limits {
+	return m.config.Limits()
+}
+
+// Start records a run, assigning its ID, status and expiry, and performs it
+// with fn in the background under the run's limits. It returns ErrBusy
+// rather than waiting for room.
+func (m *Manager) Start(ctx context.Context, run Run, fn Func) (Run, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return Run{}, ErrClosed
+	}
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		m.mu.Unlock()
+		return Run{}, ErrBusy
+	}
+	m.runs.Add(1)
+	m.mu.Unlock()
+	release := func() {
+		<-m.slots
+		m.runs.Done()
+	}
+
+	run.ID = newRunID()
+	run.Status = StatusRunning
+	run.Steps = []Step{}
+	run.CreatedAt = m.now()
+	// A run in progress outlives the longest it can take, so one abandoned
+	// by a task that stopped is eventually forgotten too
+	run.ExpiresAt = run.CreatedAt.Add(run.Limits.MaxDuration + m.config.ResultTTL)
+
+	if err := m.store.Create(ctx, run); err != nil {
+		release()
+		return Run{}, fmt.Errorf("failed to create run: %w", err)
+	}
+
+	m.logger.Info().
+		Str("run_id", run.ID).
+		Str("correlation_id", run.CorrelationID).
+		Str("intent", run.Intent).
+		Int("max_steps", run.Limits.MaxSteps).
+		Dur("max_duration", run.Limits.MaxDuration).
+		Int("max_tokens", run.Limits.MaxTokens).
+		Msg("run_started")
+
+	go func() {
+		defer release()
+		m.perform(run, fn)
+	}()
+	return run, nil
+}
+
+// Get returns a run, reporting expired ones as missing
+func (m *Manager) Get(ctx context.Context, id string) (Run, error) {
+	run, err := m.store.Get(ctx, id)
+	if err != nil {
+		return Run{}, err
+	}
+	if m.now().After(run.ExpiresAt) {
+		return Run{}, ErrNotFound
+	}
+	return run, nil
+}
+
+// Close stops accepting runs, interrupts the ones in progress and waits for
+// them to stop. An interrupted run fails with ErrClosed.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+	m.stop()
+	m.runs.Wait()
+}
+
+func (m *Manager) perform(run Run, fn Func) {
+	ctx, cancel := context.WithTimeout(m.ctx, run.Limits.MaxDuration)
+	defer cancel()
+
+	record := func(step Step) error {
+		_, err := m.store.Update(context.Background(), run.ID, func(r *Run) {
+			r.Steps = append(r.Steps, step)
+			r.Usage = AddUsage(r.Usage, step.Usage)
+		})
+		if err == nil {
+			m.logger.Info().
+				Str("run_id", run.ID).
+				Str("correlation_id", step.CorrelationID).
+				Int("step", step.Index).
+				Str("status", step.Status).
+				Msg("run_step_completed")
+		}
+		return err
+	}
+
+	output, err := fn(ctx, record)
+	switch {
+	case err == nil:
+	case m.ctx.Err() != nil:
+		err = ErrClosed
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		err = &LimitError{Limit: LimitDuration}
+	}
+	m.finish(run.ID, output, err)
+}
+
+// finish records a run's final answer; a non-nil runErr stops it failed,
+// or at the limit a *LimitError names
+func (m *Manager) finish(id string, output json.RawMessage, runErr error) {
+	finished := m.now()
+	var limitErr *LimitError
+	run, err := m.store.Update(context.Background(), id, func(r *Run) {
+		r.FinishedAt = &finished
+		r.ExpiresAt = finished.Add(m.config.ResultTTL)
+		switch {
+		case errors.As(runErr, &limitErr):
+			r.Status = StatusLimitExceeded
+			r.Limit = limitErr.Limit
+			r.Error = runErr.Error()
+		case runErr != nil:
+			r.Status = StatusFailed
+			r.Error = runErr.Error()
+		default:
+			r.Status = StatusSucceeded
+			r.Output = output
+		}
+	})
+	if err != nil {
+		m.logger.Error().
+			Err(err).
+			Str("run_id", id).
+			Msg("run_completion_failed")
+		return
+	}
+
+	event := m.logger.Info()
+	if run.Status == StatusFailed {
+		event = m.logger.Error().Err(runErr)
+	}
+	event.
+		Str("run_id", id).
+		Str("correlation_id", run.CorrelationID).
+		Str("status", run.Status).
+		Str("limit", run.Limit).
+		Int("steps", len(run.Steps)).
+		Int("total_tokens", run.Usage.TotalTokens).
+		Msg("run_finished")
+}
+
+// AddUsage returns the sum of two usages
+func AddUsage(a, b contract.Usage) contract.Usage {
+	return contract.Usage{
+		InputTokens:  a.InputTokens + b.InputTokens,
+		OutputTokens: a.OutputTokens + b.OutputTokens,
+		TotalTokens:  a.TotalTokens + b.TotalTokens,
+	}
+}
+
+func newRunID() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(bytes)
+}
@@ -0,0 +1,187 @@
+package runs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"orbit-service/contract"
+)
+
+// Run statuses. A run is running until the reasoner gives its final answer,
+// a step fails, or it reaches one of its limits.
+const (
+	StatusRunning       = "running"
+	StatusSucceeded     = "succeeded"
+	StatusFailed        = "failed"
+	StatusLimitExceeded = "limit_exceeded"
+)
+
+// Limits a run can reach, named in LimitError and Run.Limit
+const (
+	LimitSteps    = "max_steps"
+	LimitDuration = "max_duration"
+	LimitTokens   = "max_tokens"
+)
+
+// ErrNotFound is returned for an unknown or expired run ID
+var ErrNotFound = errors.New("run not found")
+
+// LimitError stops a run that reached one of its limits
+type LimitError struct {
+	Limit string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("run reached its %s limit", e.Limit)
+}
+
+// Limits bound a run
+type Limits struct {
+	// MaxSteps bounds the reasoning steps, final answer included
+	MaxSteps int `json:"max_steps"`
+	// MaxDuration bounds the run's wall time
+	MaxDuration time.Duration `json:"-"`
+	// MaxTokens bounds the tokens used by the run's reasoning requests and
+	// actions together
+	MaxTokens int `json:"max_tokens"`
+}
+
+// MarshalJSON reports MaxDuration in whole seconds
+func (l Limits) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		MaxSteps   int `json:"max_steps"`
+		MaxSeconds int `json:"max_seconds"`
+		MaxTokens  int `json:"max_tokens"`
+	}{l.MaxSteps, int(l.MaxDuration / time.Second), l.MaxTokens})
+}
+
+// Step is one step of a run: a reasoning request and the action it
+// proposed, or the run's final answer
+type Step struct {
+	Index         int       `json:"index"`
+	CorrelationID string    `json:"correlation_id"`
+	StartedAt     time.Time `json:"started_at"`
+	FinishedAt    time.Time `json:"finished_at"`
+	// Action is the action the reasoner proposed; nil for the final answer
+	Action *contract.AgentAction `json:"action,omitempty"`
+	// Status, StatusCode and Reason are the outcome of the action, as a
+	// dispatch of it would have been answered
+	Status     string `json:"status,omitempty"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	// ErrorCode classifies a failed action, as the problem body of a
+	// failed dispatch does
+	ErrorCode string `json:"error_code,omitempty"`
+	// Output is the action's output, or the final answer
+	Output json.RawMessage `json:"output,omitempty"`
+	// Usage is what the step's reasoning request and action used together
+	Usage contract.Usage `json:"usage"`
+}
+
+// Run is a multi-step agent run: Orbit asks the reasoner for the next
+// action, checks it with governance, performs it and reports the outcome
+// back, until the reasoner gives a final answer
+type Run struct {
+	ID            string                 `json:"id"`
+	Status        string                 `json:"status"`
+	CorrelationID string                 `json:"correlation_id"`
+	Principal     string                 `json:"principal,omitempty"`
+	Intent        string                 `json:"intent"`
+	Goal          json.RawMessage        `json:"goal"`
+	Parameters    map[string]interface{} `json:"parameters,omitempty"`
+	Metadata      map[string]string      `json:"metadata,omitempty"`
+	Limits        Limits                 `json:"limits"`
+	Steps         []Step                 `json:"steps"`
+	// Usage totals the usage of every step
+	Usage  contract.Usage  `json:"usage"`
+	Output json.RawMessage `json:"output,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	// Limit names the limit a run stopped at
+	Limit      string     `json:"limit,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// ExpiresAt is when the run and its transcript are forgotten
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Finished reports whether the run has reached a final status
+func (r Run) Finished() bool {
+	return r.Status != StatusRunning
+}
+
+// Store keeps runs
+type Store interface {
+	Create(ctx context.Context, run Run) error
+	Get(ctx context.Context, id string) (Run, error)
+	// Update applies fn to the run and returns the updated run
+	Update(ctx context.Context, id string, fn func(*Run)) (Run, error)
+}
+
+// MemoryStore keeps runs in memory. Runs are local to the task that started
+// them, so a deployment with several tasks must route run requests to the
+// same task.
+type MemoryStore struct {
+	mu   sync.Mutex
+	runs map[string]Run
+}
+
+// NewMemoryStore creates an empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{runs: make(map[string]Run)}
+}
+
+func (s *MemoryStore) Create(ctx context.Context, run Run) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.runs[run.ID]; ok {
+		return fmt.Errorf("run %s already exists", run.ID)
+	}
+
+	// Drop expired runs, keeping the store bounded
+	for id, existing := range s.runs {
+		if run.CreatedAt.After(existing.ExpiresAt) {
+			delete(s.runs, id)
+		}
+	}
+
+	s.runs[run.ID] = run
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id string) (Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run, ok := s.runs[id]
+	if !ok {
+		return Run{}, ErrNotFound
+	}
+	return copyRun(run), nil
+}
+
+func (s *MemoryStore) Update(ctx context.Context, id string, fn func(*Run)) (Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run, ok := s.runs[id]
+	if !ok {
+		return Run{}, ErrNotFound
+	}
+	fn(&run)
+	s.runs[id] = run
+	return copyRun(run), nil
+}
+
+// copyRun copies the run's transcript, so steps appended to the stored run
+// do not race with readers of an earlier copy
+func copyRun(run Run) Run {
+	steps := make([]Step, len(run.Steps))
+	copy(steps, run.Steps)
+	run.Steps = steps
+	return run
+}
@@ -0,0 +1,54 @@
+// Package singleflight collapses concurrent calls that share a key
+package singleflight
+
+import (
+	"context"
+	"sync"
+)
+
+// Group collapses concurrent calls that share a key into a single
+// execution whose result is handed to every caller. The zero value is
+// ready to use.
+type Group[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*call[T]
+}
+
+type call[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+// Do runs fn once per key at a time. shared reports whether the result came
+// from a call started by another caller. A caller waiting on another's call
+// gives up with ctx's error when ctx is done first.
+func (g *Group[T]) Do(ctx context.Context, key string, fn func() (T, error)) (value T, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call[T])
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-c.done:
+			return c.value, c.err, true
+		case <-ctx.Done():
+			return value, ctx.Err(), true
+		}
+	}
+
+	c := &call[T]{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+
+	c.value, c.err = fn()
+	return c.value, c.err, false
+}
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"orbit-service/clients"
+	"orbit-service/contract"
 	"orbit-service/handlers"
 	"orbit-service/middleware"
 	"github.com/rs/zerolog"
@@ -20,22 +21,22 @@ type MockGovernanceClient struct {
 	err     error
 }
 
-func (m *MockGovernanceClient) CheckPermission(req clients.GovernanceRequest, correlationID string) (bool, string, error) {
+func (m *MockGovernanceClient) CheckPermission(ctx context.Context, req clients.GovernanceRequest, correlationID string) (clients.GovernanceResponse, error) {
 	if m.err != nil {
-		return false, "", m.err
+		return clients.GovernanceResponse{}, m.err
 	}
-	return m.allowed, m.reason, nil
+	return clients.GovernanceResponse{Allowed: m.allowed, Reason: m.reason}, nil
 }
 
 // MockAxonClient for integration tests
 type MockAxonClient struct {
-	response string
+	response *contract.ReasonResponse
 	err      error
 }
 
-func (m *MockAxonClient) CallReason(ctx context.Context, correlationID string) (string, error) {
+func (m *MockAxonClient) CallReason(ctx context.Context, req contract.ReasonRequest, correlationID string) (*contract.ReasonResponse, error) {
 	if m.err != nil {
-		return "", m.err
+		return nil, m.err
 	}
 	return m.response, nil
 }
@@ -43,7 +44,7 @@ func (m *MockAxonClient) CallReason(ctx context.Context, correlationID string) (
 func TestDispatchWithGovernance(t *testing.T) {
 	logger := zerolog.Nop()
 	governanceClient := &MockGovernanceClient{allowed: true}
-	axonClient := &MockAxonClient{response: "Axon heartbeat OK"}
+	axonClient := &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version, Model: "axon-echo-1"}}
 
 	handler := handlers.DispatchHandler(logger, governanceClient, axonClient)
 
@@ -125,8 +126,11 @@ func TestDispatchAxonFailure(t *testing.T) {
 	rr := httptest.NewRecorder()
 	handler.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusInternalServerError {
-		t.Errorf("Dispatch handler should return 500 on Axon failure: got %v want %v", status, http.StatusInternalServerError)
+	if status := rr.Code; status != http.StatusBadGateway {
+		t.Errorf("Dispatch handler should return 502 on Axon failure: got %v want %v", status, http.StatusBadGateway)
+	}
+	if contentType := rr.Header().Get("Content-Type"); contentType != handlers.ProblemContentType {
+		t.Errorf("Dispatch handler returned wrong content type: got %v want %v", contentType, handlers.ProblemContentType)
 	}
 }
 
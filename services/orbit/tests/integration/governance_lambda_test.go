@@ -0,0 +1,153 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+	"orbit-service/contract"
+	"orbit-service/handlers"
+	"orbit-service/middleware"
+	"orbit-service/policy"
+)
+
+// defaultPolicies are the policies loaded into DynamoDB by default
+var defaultPolicies = filepath.Join("..", "..", "..", "..", "governance", "policies", "default.json")
+
+// newLocalGovernanceClient starts the Lambda stand-in and returns a real
+// governance client configured from the environment to invoke it
+func newLocalGovernanceClient(t *testing.T, functionName string) *clients.GovernanceClient {
+	t.Helper()
+
+	policies, err := policy.LoadFile(defaultPolicies)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	engine, err := policy.NewEngine(zerolog.Nop(), policies)
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	server := httptest.NewServer(policy.InvokeHandler(zerolog.Nop(), engine, "governance"))
+	t.Cleanup(server.Close)
+
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("GOVERNANCE_ENDPOINT", server.URL)
+	t.Setenv("GOVERNANCE_FUNCTION_NAME", functionName)
+	t.Setenv("GOVERNANCE_MAX_ATTEMPTS", "1")
+
+	client, err := clients.NewGovernanceClient(zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewGovernanceClient failed: %v", err)
+	}
+	return client
+}
+
+func TestDispatchWithLocalGovernanceLambda(t *testing.T) {
+	governance := newLocalGovernanceClient(t, "governance")
+	axonClient := &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version, Model: "axon-echo-1"}}
+	handler := handlers.DispatchHandler(zerolog.Nop(), governance, axonClient)
+
+	tests := []struct {
+		name       string
+		callerType string
+		wantStatus int
+	}{
+		{"active user allowed", "active", http.StatusOK},
+		{"blocked user denied", "blocked", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/dispatch", nil)
+			ctx := context.WithValue(req.Context(), middleware.CorrelationIDKey, "test-correlation-id")
+			ctx = context.WithValue(ctx, middleware.CallerKey, middleware.Caller{ID: "user-1", Type: tt.callerType})
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req.WithContext(ctx))
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("Dispatch handler returned wrong status code: got %v want %v: %s", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestLocalGovernanceLambdaReturnsPolicyDetails(t *testing.T) {
+	governance := newLocalGovernanceClient(t, "governance")
+
+	response, err := governance.CheckPermission(context.Background(), clients.GovernanceRequest{
+		Service: "orbit",
+		Intent:  "call_reasoning",
+		Context: map[string]interface{}{"user_type": "active"},
+	}, "test-correlation-id")
+	if err != nil {
+		t.Fatalf("CheckPermission failed: %v", err)
+	}
+	if !response.Allowed || response.PolicyVersion == "" || response.RateLimits == nil || response.RateLimits.RequestsPerMinute != 100 {
+		t.Errorf("CheckPermission returned wrong decision: %+v", response)
+	}
+
+	response, err = governance.CheckPermission(context.Background(), clients.GovernanceRequest{Service: "orbit", Intent: "unknown"}, "test-correlation-id")
+	if err != nil {
+		t.Fatalf("CheckPermission failed: %v", err)
+	}
+	if response.Allowed || response.Reason != "No policy defined for orbit:unknown" {
+		t.Errorf("CheckPermission returned wrong decision: %+v", response)
+	}
+}
+
+func TestLocalGovernanceLambdaRejectsUnknownFunction(t *testing.T) {
+	governance := newLocalGovernanceClient(t, "missing")
+
+	_, err := governance.CheckPermission(context.Background(), clients.GovernanceRequest{Service: "orbit", Intent: "call_reasoning"}, "test-correlation-id")
+	if err == nil || !strings.Contains(err.Error(), "ResourceNotFoundException") {
+		t.Errorf("CheckPermission returned wrong error: got %v want ResourceNotFoundException", err)
+	}
+}
+
+func TestLocalGovernanceLambdaAcceptsProxyEvents(t *testing.T) {
+	policies, err := policy.LoadFile(defaultPolicies)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	engine, err := policy.NewEngine(zerolog.Nop(), policies)
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	handler := policy.InvokeHandler(zerolog.Nop(), engine, "")
+
+	event := `{"headers":{"X-Correlation-ID":"proxy-1"},"body":"{\"service\":\"orbit\",\"intent\":\"call_reasoning\",\"context\":{\"user_type\":\"blocked\"}}"}`
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/2015-03-31/functions/any/invocations", strings.NewReader(event)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Invoke returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var envelope struct {
+		StatusCode int    `json:"statusCode"`
+		Body       string `json:"body"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&envelope); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	var decision map[string]interface{}
+	if err := json.Unmarshal([]byte(envelope.Body), &decision); err != nil {
+		t.Fatalf("Failed to decode body: %v", err)
+	}
+	if envelope.StatusCode != http.StatusForbidden || decision["correlation_id"] != "proxy-1" ||
+		decision["reason"] != "Condition not met: User must not be blocked" {
+		t.Errorf("Invoke returned wrong decision: status %d body %s", envelope.StatusCode, envelope.Body)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/2015-03-31/functions/any/invocations", strings.NewReader("{")))
+	if rr.Code != http.StatusBadRequest || rr.Header().Get("X-Amzn-ErrorType") != "InvalidRequestContentException" {
+		t.Errorf("Invoke returned wrong error: got %v %s", rr.Code, rr.Header().Get("X-Amzn-ErrorType"))
+	}
+}
@@ -0,0 +1,103 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/rs/zerolog"
+	"orbit-service/contract"
+	"orbit-service/jobs"
+)
+
+// TestDynamoDBJobStoreAgainstDynamoDBLocal runs jobs through a pool backed
+// by DynamoDB Local, e.g.
+//
+//	docker run -p 8000:8000 amazon/dynamodb-local
+//	DYNAMODB_LOCAL_ENDPOINT=http://localhost:8000 go test ./tests/integration/...
+func TestDynamoDBJobStoreAgainstDynamoDBLocal(t *testing.T) {
+	endpoint := os.Getenv("DYNAMODB_LOCAL_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("DYNAMODB_LOCAL_ENDPOINT not set")
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(endpoint),
+		Credentials: credentials.NewStaticCredentials("local", "local", ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := dynamodb.New(sess)
+
+	table := fmt.Sprintf("orbit-jobs-%d", time.Now().UnixNano())
+	_, err = client.CreateTable(&dynamodb.CreateTableInput{
+		TableName:   aws.String(table),
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("pk"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("pk"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.DeleteTable(&dynamodb.DeleteTableInput{TableName: aws.String(table)})
+
+	release := make(chan struct{})
+	pool := jobs.NewPool(zerolog.Nop(), jobs.NewDynamoDBStore(client, table), jobs.DefaultConfig(), func(ctx context.Context, job jobs.Job) (*contract.ReasonResponse, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-release:
+			return &contract.ReasonResponse{Version: contract.Version, Model: "axon-echo-1"}, nil
+		}
+	}, nil)
+	defer pool.Close()
+
+	ctx := context.Background()
+	cancelled, err := pool.Submit(ctx, jobs.Job{Intent: "call_reasoning"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Cancel(ctx, cancelled.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	finished, err := pool.Submit(ctx, jobs.Job{Intent: "call_reasoning"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := pool.Get(ctx, finished.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == jobs.StatusSucceeded {
+			if job.Result == nil || job.Result.Model != "axon-echo-1" {
+				t.Errorf("Job has wrong result: %+v", job.Result)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Job stayed %s", job.Status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if job, _ := pool.Get(ctx, cancelled.ID); job.Status != jobs.StatusCancelled {
+		t.Errorf("Cancelled job has wrong status: got %s want %s", job.Status, jobs.StatusCancelled)
+	}
+}
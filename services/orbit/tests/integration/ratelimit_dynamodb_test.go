@@ -0,0 +1,77 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"orbit-service/ratelimit"
+)
+
+// TestDynamoDBBackendAgainstDynamoDBLocal runs the DynamoDB backend against
+// DynamoDB Local, e.g.
+//
+//	docker run -p 8000:8000 amazon/dynamodb-local
+//	DYNAMODB_LOCAL_ENDPOINT=http://localhost:8000 go test ./tests/integration/...
+func TestDynamoDBBackendAgainstDynamoDBLocal(t *testing.T) {
+	endpoint := os.Getenv("DYNAMODB_LOCAL_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("DYNAMODB_LOCAL_ENDPOINT not set")
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(endpoint),
+		Credentials: credentials.NewStaticCredentials("local", "local", ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := dynamodb.New(sess)
+
+	table := fmt.Sprintf("orbit-rate-limits-%d", time.Now().UnixNano())
+	_, err = client.CreateTable(&dynamodb.CreateTableInput{
+		TableName:   aws.String(table),
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("pk"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("pk"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.DeleteTable(&dynamodb.DeleteTableInput{TableName: aws.String(table)})
+
+	key := ratelimit.Key{Subject: "caller:user-1", Intent: "call_reasoning"}
+	limits := ratelimit.Limits{RequestsPerMinute: 5, RequestsPerHour: 100}
+	now := time.Now()
+
+	// Two tasks share the table, each leasing requests
+	tasks := []ratelimit.Limiter{
+		ratelimit.NewDistributedLimiterWithClock(ratelimit.NewDynamoDBBackend(client, table), ratelimit.DefaultLeaseConfig(), func() time.Time { return now }),
+		ratelimit.NewDistributedLimiterWithClock(ratelimit.NewDynamoDBBackend(client, table), ratelimit.DefaultLeaseConfig(), func() time.Time { return now }),
+	}
+
+	allowed := 0
+	for i := 0; i < 8; i++ {
+		decision, err := tasks[i%2].Allow(context.Background(), key, limits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decision.Allowed {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Errorf("Tasks sharing the table were allowed wrong number of requests: got %d want %d", allowed, 5)
+	}
+}
@@ -0,0 +1,333 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"orbit-service/approval"
+	"orbit-service/clients"
+	"orbit-service/contract"
+	"orbit-service/handlers"
+	"orbit-service/middleware"
+)
+
+// MockNotifier keeps the status of every approval notification
+type MockNotifier struct {
+	mu       sync.Mutex
+	statuses []string
+	err      error
+}
+
+func (m *MockNotifier) Notify(ctx context.Context, a approval.Approval) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.statuses = append(m.statuses, a.Status)
+	return m.err
+}
+
+func (m *MockNotifier) Statuses() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.statuses...)
+}
+
+// approvalServer routes dispatch and approval requests as main does
+type approvalServer struct {
+	router   *mux.Router
+	manager  *approval.Manager
+	notifier *MockNotifier
+	axon     *MockAxonClient
+	admin    *MockGovernanceClient
+	clock    *fakeClock
+}
+
+func newApprovalServer(obligations []clients.Obligation) *approvalServer {
+	s := &approvalServer{
+		notifier: &MockNotifier{},
+		axon:     &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version, Output: json.RawMessage(`{"answer":"ok","ssn":"123"}`)}},
+		admin:    &MockGovernanceClient{allowed: true},
+		clock:    &fakeClock{now: time.Unix(1700000000, 0)},
+	}
+	s.manager = approval.NewManagerWithClock(zerolog.Nop(), approval.NewMemoryStore(time.Hour), s.notifier, approval.DefaultConfig(), s.clock.Now)
+
+	opts := handlers.DefaultDispatchOptions()
+	opts.Approvals = s.manager
+	governance := &MockGovernanceClient{requiresApproval: true, obligations: obligations}
+
+	logger := zerolog.Nop()
+	s.router = mux.NewRouter()
+	s.router.Use(middleware.CallerMiddleware(middleware.CallerConfig{Auth: middleware.CallerAuthHeaders, IDHeader: "X-Caller-Id"}))
+	s.router.HandleFunc("/dispatch", handlers.DispatchHandlerWithOptions(logger, governance, s.axon, opts)).Methods("POST")
+	s.router.HandleFunc("/dispatch/approvals/{id}", handlers.ApprovalStatusHandler(logger, s.manager)).Methods("GET")
+	s.router.HandleFunc("/admin/approvals/{id}", handlers.ApprovalAdminHandler(logger, s.manager, s.admin)).Methods("GET")
+	s.router.HandleFunc("/admin/approvals/{id}/approve", handlers.ApprovalApproveHandler(logger, s.manager, s.admin, s.axon, opts)).Methods("POST")
+	s.router.HandleFunc("/admin/approvals/{id}/reject", handlers.ApprovalRejectHandler(logger, s.manager, s.admin)).Methods("POST")
+	return s
+}
+
+func (s *approvalServer) do(t *testing.T, method, path, caller, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caller != "" {
+		req.Header.Set("X-Caller-Id", caller)
+	}
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	return rr
+}
+
+// hold dispatches a request as alice and returns the approval ID
+func (s *approvalServer) hold(t *testing.T, body string) string {
+	t.Helper()
+	rr := s.do(t, "POST", "/dispatch", "alice", body)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("dispatch returned wrong status code: got %v want %v", rr.Code, http.StatusAccepted)
+	}
+	var response handlers.DispatchResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response.ApprovalID
+}
+
+func TestDispatchHeldForApproval(t *testing.T) {
+	s := newApprovalServer([]clients.Obligation{
+		{Type: clients.ObligationRedactFields, Value: []interface{}{"ssn"}},
+	})
+
+	rr := s.do(t, "POST", "/dispatch", "alice", `{"input":{"prompt":"wire funds","ssn":"123"}}`)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusAccepted)
+	}
+	var response handlers.DispatchResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Status != "pending_approval" || response.ApprovalID == "" {
+		t.Fatalf("handler returned wrong response: got %+v", response)
+	}
+	if location := rr.Header().Get("Location"); location != "/dispatch/approvals/"+response.ApprovalID {
+		t.Errorf("handler returned wrong Location: got %q", location)
+	}
+	if s.axon.lastRequest.Input != nil {
+		t.Error("held dispatch was sent to Axon")
+	}
+
+	held, err := s.manager.Get(context.Background(), response.ApprovalID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held.Status != approval.StatusPending || held.Principal != "alice" {
+		t.Errorf("approval stored wrong state: got %+v", held)
+	}
+	if strings.Contains(string(held.Input), "123") {
+		t.Errorf("approval stored unredacted input: %s", held.Input)
+	}
+	if got := s.notifier.Statuses(); len(got) != 1 || got[0] != approval.StatusPending {
+		t.Errorf("notifier received wrong notifications: got %v", got)
+	}
+}
+
+func TestDispatchRefusesApprovalWithoutManager(t *testing.T) {
+	governanceClient := &MockGovernanceClient{requiresApproval: true}
+	axonClient := &MockAxonClient{}
+	handler := handlers.DispatchHandlerWithOptions(zerolog.Nop(), governanceClient, axonClient, handlers.DefaultDispatchOptions())
+
+	req, err := http.NewRequest("POST", "/dispatch", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+}
+
+func TestApprovalApproveResumesDispatch(t *testing.T) {
+	s := newApprovalServer([]clients.Obligation{
+		{Type: clients.ObligationMaxTokens, Value: 64},
+		{Type: clients.ObligationRedactFields, Value: []interface{}{"ssn"}},
+	})
+	id := s.hold(t, `{"input":{"prompt":"wire funds"}}`)
+
+	rr := s.do(t, "POST", "/admin/approvals/"+id+"/approve", "bob", `{"comment":"looks fine"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("approve returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	s.manager.Wait()
+
+	if s.axon.lastRequest.Parameters[contract.ParameterMaxTokens] != 64 {
+		t.Errorf("resumed dispatch sent wrong parameters: got %v", s.axon.lastRequest.Parameters)
+	}
+
+	rr = s.do(t, "GET", "/dispatch/approvals/"+id, "alice", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var status handlers.ApprovalStatusResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != approval.StatusCompleted || status.Result == nil {
+		t.Fatalf("status returned wrong response: got %+v", status)
+	}
+	if strings.Contains(string(status.Result.Output), "123") {
+		t.Errorf("resumed dispatch returned unredacted output: %s", status.Result.Output)
+	}
+
+	want := []string{approval.StatusPending, approval.StatusApproved, approval.StatusCompleted}
+	if got := s.notifier.Statuses(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("notifier received wrong notifications: got %v want %v", got, want)
+	}
+}
+
+func TestApprovalResumedDispatchFailure(t *testing.T) {
+	s := newApprovalServer(nil)
+	s.axon.err = errors.New("axon down")
+	id := s.hold(t, `{}`)
+
+	if rr := s.do(t, "POST", "/admin/approvals/"+id+"/approve", "bob", ""); rr.Code != http.StatusOK {
+		t.Fatalf("approve returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	s.manager.Wait()
+
+	a, err := s.manager.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Status != approval.StatusFailed || a.Error == "" {
+		t.Errorf("approval recorded wrong state: got %+v", a)
+	}
+}
+
+func TestApprovalRejectCancelsDispatch(t *testing.T) {
+	s := newApprovalServer(nil)
+	id := s.hold(t, `{}`)
+
+	rr := s.do(t, "POST", "/admin/approvals/"+id+"/reject", "bob", `{"comment":"no"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("reject returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var rejected approval.Approval
+	if err := json.Unmarshal(rr.Body.Bytes(), &rejected); err != nil {
+		t.Fatal(err)
+	}
+	if rejected.Status != approval.StatusRejected || rejected.DecidedBy != "bob" || rejected.Comment != "no" {
+		t.Errorf("reject returned wrong approval: got %+v", rejected)
+	}
+
+	rr = s.do(t, "POST", "/admin/approvals/"+id+"/approve", "bob", "")
+	if rr.Code != http.StatusConflict {
+		t.Errorf("approve after reject returned wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	s.manager.Wait()
+	if s.axon.lastRequest.Input != nil {
+		t.Error("rejected dispatch was sent to Axon")
+	}
+}
+
+func TestApprovalExpires(t *testing.T) {
+	s := newApprovalServer(nil)
+	id := s.hold(t, `{}`)
+
+	s.clock.Advance(approval.DefaultConfig().TTL + time.Second)
+	expired, err := s.manager.ExpireOverdue(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired != 1 {
+		t.Errorf("ExpireOverdue returned wrong count: got %v want %v", expired, 1)
+	}
+
+	rr := s.do(t, "POST", "/admin/approvals/"+id+"/approve", "bob", "")
+	if rr.Code != http.StatusGone {
+		t.Errorf("approve after expiry returned wrong status code: got %v want %v", rr.Code, http.StatusGone)
+	}
+	if got := s.notifier.Statuses(); len(got) != 2 || got[1] != approval.StatusExpired {
+		t.Errorf("notifier received wrong notifications: got %v", got)
+	}
+}
+
+func TestApprovalAdminEndpointsRequireManagePolicies(t *testing.T) {
+	s := newApprovalServer(nil)
+	id := s.hold(t, `{}`)
+	s.admin.allowed = false
+	s.admin.reason = "denied"
+
+	for _, path := range []string{"/approve", "/reject"} {
+		rr := s.do(t, "POST", "/admin/approvals/"+id+path, "bob", "")
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("%s returned wrong status code: got %v want %v", path, rr.Code, http.StatusForbidden)
+		}
+	}
+	if rr := s.do(t, "GET", "/admin/approvals/"+id, "bob", ""); rr.Code != http.StatusForbidden {
+		t.Errorf("get returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	if s.admin.lastRequest.Service != "admin" || s.admin.lastRequest.Intent != "manage_policies" {
+		t.Errorf("admin check sent wrong request: got %+v", s.admin.lastRequest)
+	}
+	if s.admin.lastRequest.Context["caller_id"] != "bob" {
+		t.Errorf("admin check sent wrong context: got %v", s.admin.lastRequest.Context)
+	}
+}
+
+func TestApprovalRefusesAnonymousCallers(t *testing.T) {
+	s := newApprovalServer(nil)
+
+	// An anonymous request is not held at all
+	if rr := s.do(t, "POST", "/dispatch", "", `{}`); rr.Code != http.StatusForbidden {
+		t.Errorf("anonymous dispatch returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	if got := s.notifier.Statuses(); len(got) != 0 {
+		t.Errorf("anonymous dispatch was held: got notifications %v", got)
+	}
+
+	id := s.hold(t, `{}`)
+	for _, path := range []string{"/approve", "/reject"} {
+		if rr := s.do(t, "POST", "/admin/approvals/"+id+path, "", ""); rr.Code != http.StatusForbidden {
+			t.Errorf("anonymous %s returned wrong status code: got %v want %v", path, rr.Code, http.StatusForbidden)
+		}
+	}
+	held, err := s.manager.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held.Status != approval.StatusPending {
+		t.Errorf("anonymous caller decided approval: got %+v", held)
+	}
+}
+
+func TestApprovalRefusesSelfApproval(t *testing.T) {
+	s := newApprovalServer(nil)
+	id := s.hold(t, `{}`)
+
+	if rr := s.do(t, "POST", "/admin/approvals/"+id+"/approve", "alice", ""); rr.Code != http.StatusForbidden {
+		t.Errorf("self-approval returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+}
+
+func TestApprovalStatusHiddenFromOtherCallers(t *testing.T) {
+	s := newApprovalServer(nil)
+	id := s.hold(t, `{}`)
+
+	if rr := s.do(t, "GET", "/dispatch/approvals/"+id, "mallory", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("status returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := s.do(t, "GET", "/dispatch/approvals/unknown", "alice", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("status returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,271 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/firehose/firehoseiface"
+	"github.com/rs/zerolog"
+	"orbit-service/audit"
+	"orbit-service/clients"
+	"orbit-service/middleware"
+)
+
+func testEntry(correlationID string) audit.Entry {
+	return audit.Entry{
+		Timestamp:     time.Now(),
+		CorrelationID: correlationID,
+		Service:       "orbit",
+		Intent:        "call_reasoning",
+		Decision:      audit.DecisionAllow,
+		Reason:        "Request authorized",
+	}
+}
+
+// recordAll records entries through a recorder writing to sink and closes it
+func recordAll(t *testing.T, sink audit.AuditSink, resume *audit.Record, ids ...string) {
+	t.Helper()
+
+	recorder := audit.NewRecorder(zerolog.Nop(), sink, audit.DefaultRecorderConfig(), resume)
+	for _, id := range ids {
+		if err := recorder.Record(context.Background(), testEntry(id)); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func readRecords(t *testing.T, data []byte) []audit.Record {
+	t.Helper()
+
+	var records []audit.Record
+	err := audit.ReadRecords(bytes.NewReader(data), func(r audit.Record) error {
+		records = append(records, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadRecords failed: %v", err)
+	}
+	return records
+}
+
+func verifyAll(records []audit.Record) []error {
+	verifier := audit.NewVerifier()
+	var problems []error
+	for _, r := range records {
+		if err := verifier.Check(r); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	return problems
+}
+
+func TestAuditRecorderBuildsVerifiableChain(t *testing.T) {
+	var buf bytes.Buffer
+	recordAll(t, audit.NewJSONSink(&buf), nil, "a", "b", "c")
+
+	records := readRecords(t, buf.Bytes())
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(records))
+	}
+	for i, r := range records {
+		if r.Sequence != uint64(i+1) {
+			t.Errorf("record %d has wrong sequence: got %d want %d", i, r.Sequence, i+1)
+		}
+	}
+	if records[1].PrevHash != records[0].Hash {
+		t.Error("record 2 is not linked to record 1")
+	}
+
+	if problems := verifyAll(records); len(problems) != 0 {
+		t.Errorf("Expected chain to verify, got %v", problems)
+	}
+}
+
+func TestAuditVerifierDetectsTampering(t *testing.T) {
+	var buf bytes.Buffer
+	recordAll(t, audit.NewJSONSink(&buf), nil, "a", "b", "c", "d")
+	original := readRecords(t, buf.Bytes())
+
+	tests := []struct {
+		name   string
+		mutate func([]audit.Record) []audit.Record
+	}{
+		{"altered decision", func(r []audit.Record) []audit.Record {
+			r[1].Decision = audit.DecisionDeny
+			return r
+		}},
+		{"removed record", func(r []audit.Record) []audit.Record {
+			return append(r[:2:2], r[3:]...)
+		}},
+		{"rehashed record", func(r []audit.Record) []audit.Record {
+			r[1].Reason = "forged"
+			r[1].Hash, _ = r[1].ComputeHash()
+			return r
+		}},
+		{"missing head", func(r []audit.Record) []audit.Record {
+			return r[1:]
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := tt.mutate(append([]audit.Record(nil), original...))
+			if problems := verifyAll(records); len(problems) == 0 {
+				t.Error("Expected verifier to report a problem")
+			}
+		})
+	}
+}
+
+func TestAuditFileSinkContinuesChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	sink, err := audit.NewFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordAll(t, sink, nil, "a", "b")
+
+	last, ok, err := audit.LastRecord(path)
+	if err != nil || !ok {
+		t.Fatalf("LastRecord failed: ok=%v err=%v", ok, err)
+	}
+
+	sink, err = audit.NewFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordAll(t, sink, &last, "c")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := readRecords(t, data)
+
+	if len(records) != 3 || records[2].Sequence != 3 || records[2].ChainID != records[0].ChainID {
+		t.Fatalf("Expected the chain to continue across restarts, got %+v", records)
+	}
+	if problems := verifyAll(records); len(problems) != 0 {
+		t.Errorf("Expected chain to verify, got %v", problems)
+	}
+}
+
+func TestAuditCheckerRecordsDecision(t *testing.T) {
+	var buf bytes.Buffer
+	recorder := audit.NewRecorder(zerolog.Nop(), audit.NewJSONSink(&buf), audit.DefaultRecorderConfig(), nil)
+
+	next := &MockGovernanceClient{allowed: false, reason: "Policy violation"}
+	checker := audit.NewChecker(zerolog.Nop(), next, recorder)
+
+	ctx := context.WithValue(context.Background(), middleware.CallerKey, middleware.Caller{ID: "user-1"})
+	req := clients.GovernanceRequest{
+		Service: "orbit",
+		Intent:  "call_reasoning",
+		Context: map[string]interface{}{"user_type": "active"},
+	}
+	if _, err := checker.CheckPermission(ctx, req, "test-correlation-id"); err != nil {
+		t.Fatalf("CheckPermission failed: %v", err)
+	}
+	recorder.Close()
+
+	records := readRecords(t, buf.Bytes())
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(records))
+	}
+	r := records[0]
+	if r.Decision != audit.DecisionDeny || r.Reason != "Policy violation" || r.Principal != "user-1" ||
+		r.CorrelationID != "test-correlation-id" || r.ContextDigest != audit.ContextDigest(req.Context) {
+		t.Errorf("Record has wrong contents: %+v", r)
+	}
+}
+
+func TestAuditRecorderKeepsContextOnlyWhenConfigured(t *testing.T) {
+	for _, recordContext := range []bool{false, true} {
+		var buf bytes.Buffer
+		config := audit.DefaultRecorderConfig()
+		config.RecordContext = recordContext
+		recorder := audit.NewRecorder(zerolog.Nop(), audit.NewJSONSink(&buf), config, nil)
+
+		checker := audit.NewChecker(zerolog.Nop(), &MockGovernanceClient{allowed: true}, recorder)
+		req := clients.GovernanceRequest{
+			Service: "orbit",
+			Intent:  "call_reasoning",
+			Context: map[string]interface{}{"user_type": "active"},
+		}
+		if _, err := checker.CheckPermission(context.Background(), req, "test-correlation-id"); err != nil {
+			t.Fatalf("CheckPermission failed: %v", err)
+		}
+		recorder.Close()
+
+		records := readRecords(t, buf.Bytes())
+		if len(records) != 1 {
+			t.Fatalf("Expected 1 record, got %d", len(records))
+		}
+		r := records[0]
+		if got := r.Context["user_type"] == "active"; got != recordContext {
+			t.Errorf("Record kept wrong context with RecordContext=%v: got %v", recordContext, r.Context)
+		}
+		if r.ContextDigest != audit.ContextDigest(req.Context) {
+			t.Errorf("Record has wrong context digest: got %s want %s", r.ContextDigest, audit.ContextDigest(req.Context))
+		}
+		if problems := verifyAll(records); len(problems) != 0 {
+			t.Errorf("Expected chain to verify, got %v", problems)
+		}
+	}
+}
+
+func TestAuditCheckerFailsClosedWhenUnrecorded(t *testing.T) {
+	recorder := audit.NewRecorder(zerolog.Nop(), audit.NewJSONSink(&bytes.Buffer{}), audit.DefaultRecorderConfig(), nil)
+	recorder.Close()
+
+	checker := audit.NewChecker(zerolog.Nop(), &MockGovernanceClient{allowed: true}, recorder)
+	_, err := checker.CheckPermission(context.Background(), clients.GovernanceRequest{Service: "orbit", Intent: "call_reasoning"}, "test-correlation-id")
+
+	var unavailable *clients.GovernanceUnavailableError
+	if !errors.As(err, &unavailable) {
+		t.Errorf("Expected unrecorded decision to fail as unavailable, got %v", err)
+	}
+}
+
+// fakeFirehose rejects the first record of the first batch it receives
+type fakeFirehose struct {
+	firehoseiface.FirehoseAPI
+	calls    int
+	received int
+}
+
+func (f *fakeFirehose) PutRecordBatchWithContext(ctx aws.Context, input *firehose.PutRecordBatchInput, opts ...request.Option) (*firehose.PutRecordBatchOutput, error) {
+	f.calls++
+	output := &firehose.PutRecordBatchOutput{FailedPutCount: aws.Int64(0)}
+	for i := range input.Records {
+		if f.calls == 1 && i == 0 {
+			output.FailedPutCount = aws.Int64(1)
+			output.RequestResponses = append(output.RequestResponses, &firehose.PutRecordBatchResponseEntry{ErrorCode: aws.String("ServiceUnavailableException")})
+			continue
+		}
+		f.received++
+		output.RequestResponses = append(output.RequestResponses, &firehose.PutRecordBatchResponseEntry{RecordId: aws.String("id")})
+	}
+	return output, nil
+}
+
+func TestAuditFirehoseSinkResendsRejectedRecords(t *testing.T) {
+	client := &fakeFirehose{}
+	recordAll(t, audit.NewFirehoseSink(client, "audit"), nil, "a", "b", "c")
+
+	if client.calls != 2 || client.received != 3 {
+		t.Errorf("Expected the rejected record to be resent: calls=%d received=%d", client.calls, client.received)
+	}
+}
@@ -0,0 +1,68 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+	"orbit-service/contract"
+)
+
+func TestAxonClientCallReason(t *testing.T) {
+	var received contract.ReasonRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("Expected POST, got %s", r.Method)
+		}
+		if r.Header.Get("Authorization") == "" {
+			t.Error("Expected request to be SigV4 signed")
+		}
+		if r.Header.Get("X-Amz-Content-Sha256") == "" {
+			t.Error("Expected payload hash header to be set")
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(contract.ReasonResponse{
+			Version: contract.Version,
+			Output:  json.RawMessage(`{"answer":"ok"}`),
+			Usage:   contract.Usage{InputTokens: 3, OutputTokens: 4, TotalTokens: 7},
+			Model:   "axon-echo-1",
+		})
+	}))
+	defer server.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "test-access-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test-secret-key")
+	t.Setenv("AXON_SERVICE_URL", server.URL+"/reason")
+
+	client, err := clients.NewAxonClient(zerolog.Nop())
+	if err != nil {
+		t.Fatalf("Failed to create axon client: %v", err)
+	}
+
+	resp, err := client.CallReason(context.Background(), contract.ReasonRequest{
+		Input: json.RawMessage(`{"question":"status?"}`),
+	}, "test-correlation-id")
+	if err != nil {
+		t.Fatalf("CallReason failed: %v", err)
+	}
+
+	if received.Version != contract.Version {
+		t.Errorf("Expected request version %q, got %q", contract.Version, received.Version)
+	}
+
+	if string(received.Input) != `{"question":"status?"}` {
+		t.Errorf("Expected input to be forwarded, got %s", received.Input)
+	}
+
+	if resp.Model != "axon-echo-1" || resp.Usage.TotalTokens != 7 {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+}
@@ -0,0 +1,260 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+	"orbit-service/contract"
+	"orbit-service/handlers"
+	"orbit-service/middleware"
+	"orbit-service/ratelimit"
+)
+
+// IntentGovernanceClient denies the intents in denied and allows the rest
+// under rateLimits
+type IntentGovernanceClient struct {
+	denied     map[string]bool
+	rateLimits *clients.RateLimits
+}
+
+func (m *IntentGovernanceClient) CheckPermission(ctx context.Context, req clients.GovernanceRequest, correlationID string) (clients.GovernanceResponse, error) {
+	if m.denied[req.Intent] {
+		return clients.GovernanceResponse{Reason: "Intent not allowed"}, nil
+	}
+	return clients.GovernanceResponse{Allowed: true, Reason: "allowed", RateLimits: m.rateLimits}, nil
+}
+
+// ConcurrentAxonClient holds each call for delay, recording the most calls
+// in flight at once. Inputs containing "fail" fail with a downstream 503.
+type ConcurrentAxonClient struct {
+	delay time.Duration
+
+	mu          sync.Mutex
+	calls       int
+	inFlight    int
+	maxInFlight int
+}
+
+func (m *ConcurrentAxonClient) CallReason(ctx context.Context, req contract.ReasonRequest, correlationID string) (*contract.ReasonResponse, error) {
+	m.mu.Lock()
+	m.calls++
+	m.inFlight++
+	if m.inFlight > m.maxInFlight {
+		m.maxInFlight = m.inFlight
+	}
+	m.mu.Unlock()
+
+	time.Sleep(m.delay)
+
+	m.mu.Lock()
+	m.inFlight--
+	m.mu.Unlock()
+
+	if strings.Contains(string(req.Input), "fail") {
+		return nil, &clients.DownstreamError{Target: "axon", Kind: clients.DownstreamStatus, StatusCode: http.StatusServiceUnavailable, Err: errors.New("axon returned status 503")}
+	}
+	return &contract.ReasonResponse{Version: contract.Version, Output: req.Input}, nil
+}
+
+func (m *ConcurrentAxonClient) stats() (calls, maxInFlight int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls, m.maxInFlight
+}
+
+func sendBatch(t *testing.T, handler http.Handler, path, body string) (*httptest.ResponseRecorder, handlers.BatchResponse) {
+	t.Helper()
+	req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.CorrelationIDKey, "batch-1"))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var response handlers.BatchResponse
+	if rr.Code == http.StatusOK {
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rr, response
+}
+
+func batchItemStatuses(response handlers.BatchResponse) []string {
+	statuses := make([]string, len(response.Items))
+	for i, item := range response.Items {
+		statuses[i] = item.Status
+	}
+	return statuses
+}
+
+func TestDispatchBatch(t *testing.T) {
+	governance := &IntentGovernanceClient{denied: map[string]bool{"delete_records": true}}
+	axon := &ConcurrentAxonClient{delay: 20 * time.Millisecond}
+	opts := handlers.DefaultDispatchOptions()
+	opts.Batch.Parallelism = 2
+	handler := handlers.DispatchBatchHandler(zerolog.Nop(), governance, axon, opts)
+
+	rr, response := sendBatch(t, handler, "/dispatch/batch", `{"items":[
+		{"input":{"q":"one"}},
+		{"intent":"delete_records","input":{"q":"two"}},
+		{"input":{"q":"three"}},
+		{"input":{"q":"fail"}},
+		{"input":{"q":"five"}},
+		{"input":{"q":"six"}}
+	]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Batch handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	want := []string{"success", "denied", "success", "error", "success", "success"}
+	if got := batchItemStatuses(response); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("Batch handler returned wrong item statuses: got %v want %v", got, want)
+	}
+	if response.Status != handlers.BatchStatusPartial || response.Succeeded != 4 || response.Failed != 2 {
+		t.Errorf("Batch handler returned wrong summary: got %s, %d succeeded, %d failed", response.Status, response.Succeeded, response.Failed)
+	}
+
+	if item := response.Items[0]; string(item.Result.Output) != `{"q":"one"}` || item.CorrelationID != "batch-1-0" || item.StatusCode != http.StatusOK {
+		t.Errorf("Batch handler returned wrong result for the first item: %+v", item)
+	}
+	if item := response.Items[1]; item.StatusCode != http.StatusForbidden || item.Reason != "Intent not allowed" {
+		t.Errorf("Batch handler returned wrong result for the denied item: %+v", item)
+	}
+	if item := response.Items[3]; item.StatusCode != http.StatusBadGateway || item.Error == nil || item.Error.Code != handlers.ErrorCodeDownstreamError {
+		t.Errorf("Batch handler returned wrong result for the failed item: %+v", item)
+	}
+
+	calls, maxInFlight := axon.stats()
+	if calls != 5 {
+		t.Errorf("Batch handler made wrong number of Axon calls: got %d want %d", calls, 5)
+	}
+	if maxInFlight > opts.Batch.Parallelism {
+		t.Errorf("Batch handler exceeded its parallelism: %d calls in flight, limit %d", maxInFlight, opts.Batch.Parallelism)
+	}
+}
+
+func TestDispatchBatchAllOrNothing(t *testing.T) {
+	governance := &IntentGovernanceClient{denied: map[string]bool{"delete_records": true}}
+	axon := &ConcurrentAxonClient{}
+	handler := handlers.DispatchBatchHandler(zerolog.Nop(), governance, axon, handlers.DefaultDispatchOptions())
+
+	rr, response := sendBatch(t, handler, "/dispatch/batch", `{"all_or_nothing":true,"items":[
+		{"input":{"q":"one"}},
+		{"intent":"delete_records"},
+		{"input":{"q":"three"}}
+	]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Batch handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	want := []string{handlers.BatchItemSkipped, "denied", handlers.BatchItemSkipped}
+	if got := batchItemStatuses(response); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Batch handler returned wrong item statuses: got %v want %v", got, want)
+	}
+	if response.Status != handlers.BatchStatusAborted || response.Succeeded != 0 || response.Failed != 1 {
+		t.Errorf("Batch handler returned wrong summary: got %s, %d succeeded, %d failed", response.Status, response.Succeeded, response.Failed)
+	}
+	if calls, _ := axon.stats(); calls != 0 {
+		t.Errorf("Aborted batch made %d Axon calls", calls)
+	}
+
+	_, response = sendBatch(t, handler, "/dispatch/batch", `{"all_or_nothing":true,"items":[{"input":{"q":"one"}},{"input":{"q":"two"}}]}`)
+	if response.Status != handlers.BatchStatusSucceeded || response.Succeeded != 2 {
+		t.Errorf("Batch handler returned wrong summary for an allowed batch: got %s, %d succeeded", response.Status, response.Succeeded)
+	}
+}
+
+func TestDispatchBatchAllOrNothingRateLimits(t *testing.T) {
+	governance := &IntentGovernanceClient{denied: map[string]bool{"delete_records": true}, rateLimits: &clients.RateLimits{RequestsPerMinute: 2}}
+	limiter := ratelimit.NewMeteredLimiter(ratelimit.NewLocalLimiter())
+	opts := handlers.DefaultDispatchOptions()
+	opts.RateLimiter = limiter
+	axon := &ConcurrentAxonClient{}
+	handler := handlers.DispatchBatchHandler(zerolog.Nop(), governance, axon, opts)
+
+	// A batch refused by governance spends no rate limit
+	_, response := sendBatch(t, handler, "/dispatch/batch", `{"all_or_nothing":true,"items":[{"input":{}},{"intent":"delete_records"}]}`)
+	if response.Status != handlers.BatchStatusAborted {
+		t.Fatalf("Batch handler returned wrong status: got %v want %v", response.Status, handlers.BatchStatusAborted)
+	}
+	if snapshot := limiter.Snapshot(); len(snapshot) != 0 {
+		t.Errorf("Batch refused by governance spent rate limits: %v", snapshot)
+	}
+
+	// A throttled item aborts the batch; the items checked before it have
+	// spent their share, the ones after it are not checked
+	_, response = sendBatch(t, handler, "/dispatch/batch", `{"all_or_nothing":true,"items":[{"input":{}},{"input":{}},{"input":{}},{"input":{}}]}`)
+	want := []string{handlers.BatchItemSkipped, handlers.BatchItemSkipped, "throttled", handlers.BatchItemSkipped}
+	if got := batchItemStatuses(response); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Batch handler returned wrong item statuses: got %v want %v", got, want)
+	}
+	snapshot := limiter.Snapshot()
+	if snapshot["allowed"] != 2 || snapshot["throttled"] != 1 {
+		t.Errorf("Batch handler spent wrong rate limits: got %v", snapshot)
+	}
+	if calls, _ := axon.stats(); calls != 0 {
+		t.Errorf("Aborted batch made %d Axon calls", calls)
+	}
+}
+
+func TestDispatchBatchRejectsInvalidBatches(t *testing.T) {
+	opts := handlers.DefaultDispatchOptions()
+	opts.Batch.MaxItems = 2
+	handler := handlers.DispatchBatchHandler(zerolog.Nop(), &IntentGovernanceClient{}, &ConcurrentAxonClient{}, opts)
+
+	tests := []struct {
+		name  string
+		path  string
+		body  string
+		field string
+	}{
+		{"invalid item", "/dispatch/batch", `{"items":[{"input":{}},{"intent":"Not An Intent"}]}`, "/items/1/intent"},
+		{"too many items", "/dispatch/batch", `{"items":[{},{},{}]}`, "/items"},
+		{"no items", "/dispatch/batch", `{"items":[]}`, "/items"},
+		{"streamed", "/dispatch/batch?stream=true", `{"items":[{}]}`, ""},
+		{"asynchronous", "/dispatch/batch?mode=async", `{"items":[{}]}`, ""},
+	}
+	for _, tt := range tests {
+		rr, _ := sendBatch(t, handler, tt.path, tt.body)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: Batch handler returned wrong status code: got %v want %v", tt.name, rr.Code, http.StatusBadRequest)
+			continue
+		}
+		var response handlers.DispatchResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if tt.field != "" && (len(response.Errors) == 0 || response.Errors[0].Field != tt.field) {
+			t.Errorf("%s: Batch handler returned wrong field errors: got %+v want %s", tt.name, response.Errors, tt.field)
+		}
+	}
+}
+
+func TestDispatchBatchRunsCustomStages(t *testing.T) {
+	opts := handlers.DefaultDispatchOptions()
+	opts.Pipeline = []string{"validate", "tenant", "governance", "obligations", "rate_limit", "redaction", "audit", "async", "stream"}
+	opts.Stages = map[string]handlers.Stage{"tenant": handlers.StageFuncs{BeforeFunc: func(w http.ResponseWriter, d *handlers.Dispatch) bool {
+		if strings.Contains(string(d.Request.Input), "other") {
+			return d.Refuse(w, http.StatusForbidden, "Tenant not allowed")
+		}
+		return true
+	}}}
+	handler := handlers.DispatchBatchHandler(zerolog.Nop(), &IntentGovernanceClient{}, &ConcurrentAxonClient{}, opts)
+
+	_, response := sendBatch(t, handler, "/dispatch/batch", `{"items":[{"input":{"q":"one"}},{"input":{"q":"other"}}]}`)
+	want := []string{"success", "denied"}
+	if got := batchItemStatuses(response); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("Batch handler returned wrong item statuses: got %v want %v", got, want)
+	}
+	if item := response.Items[1]; item.StatusCode != http.StatusForbidden || item.Reason != "Tenant not allowed" {
+		t.Errorf("Batch handler returned wrong result for the refused item: %+v", item)
+	}
+}
@@ -0,0 +1,144 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+	"orbit-service/contract"
+	"orbit-service/handlers"
+)
+
+// SlowGovernanceClient takes longer than any test budget to answer
+type SlowGovernanceClient struct {
+	delay time.Duration
+}
+
+func (m *SlowGovernanceClient) CheckPermission(ctx context.Context, req clients.GovernanceRequest, correlationID string) (clients.GovernanceResponse, error) {
+	select {
+	case <-time.After(m.delay):
+		return clients.GovernanceResponse{Allowed: true}, nil
+	case <-ctx.Done():
+		return clients.GovernanceResponse{}, ctx.Err()
+	}
+}
+
+// BlockingAxonClient waits until the caller's context is done
+type BlockingAxonClient struct {
+	deadline    time.Time
+	hasDeadline bool
+}
+
+func (m *BlockingAxonClient) CallReason(ctx context.Context, req contract.ReasonRequest, correlationID string) (*contract.ReasonResponse, error) {
+	m.deadline, m.hasDeadline = ctx.Deadline()
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func testBudget(total, governance time.Duration) handlers.DispatchOptions {
+	opts := handlers.DefaultDispatchOptions()
+	opts.Budget = handlers.DispatchBudget{Total: total, Governance: governance}
+	return opts
+}
+
+func TestDispatchHandlerAxonDeadlineExceeded(t *testing.T) {
+	governanceClient := &MockGovernanceClient{allowed: true}
+	axonClient := &BlockingAxonClient{}
+
+	handler := handlers.DispatchHandlerWithOptions(zerolog.Nop(), governanceClient, axonClient, testBudget(50*time.Millisecond, 20*time.Millisecond))
+
+	req, err := http.NewRequest("POST", "/dispatch", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	finished := time.Now()
+
+	if status := rr.Code; status != http.StatusGatewayTimeout {
+		t.Errorf("Dispatch handler should return 504 when the budget is exhausted: got %v want %v", status, http.StatusGatewayTimeout)
+	}
+
+	if !axonClient.hasDeadline || axonClient.deadline.After(finished) {
+		t.Errorf("Axon stage was not bounded by the dispatch budget: deadline %v", axonClient.deadline)
+	}
+}
+
+func TestDispatchHandlerGovernanceTimeout(t *testing.T) {
+	governanceClient := &SlowGovernanceClient{delay: 200 * time.Millisecond}
+	axonClient := &MockAxonClient{}
+
+	handler := handlers.DispatchHandlerWithOptions(zerolog.Nop(), governanceClient, axonClient, testBudget(time.Second, 20*time.Millisecond))
+
+	req, err := http.NewRequest("POST", "/dispatch", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusGatewayTimeout {
+		t.Errorf("Dispatch handler should return 504 when governance exceeds its budget: got %v want %v", status, http.StatusGatewayTimeout)
+	}
+}
+
+func TestAxonClientPropagatesDeadline(t *testing.T) {
+	var header string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get(contract.DeadlineHeader)
+		if !isHeaderSigned(r.Header.Get("Authorization"), "x-request-deadline") {
+			t.Errorf("Deadline header is not covered by the signature: %s", r.Header.Get("Authorization"))
+		}
+		json.NewEncoder(w).Encode(contract.ReasonResponse{Version: contract.Version})
+	}))
+	defer server.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "test-access-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test-secret-key")
+	t.Setenv("AXON_SERVICE_URL", server.URL+"/reason")
+
+	client, err := clients.NewAxonClient(zerolog.Nop())
+	if err != nil {
+		t.Fatalf("Failed to create axon client: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	if _, err := client.CallReason(ctx, contract.ReasonRequest{Input: json.RawMessage(`{}`)}, "test-correlation-id"); err != nil {
+		t.Fatalf("CallReason failed: %v", err)
+	}
+
+	got, err := contract.ParseDeadline(header)
+	if err != nil {
+		t.Fatalf("Failed to parse deadline header %q: %v", header, err)
+	}
+
+	if !got.Equal(deadline) {
+		t.Errorf("Propagated wrong deadline: got %v want %v", got, deadline)
+	}
+}
+
+func isHeaderSigned(authorization, header string) bool {
+	fields := strings.FieldsFunc(authorization, func(r rune) bool { return r == ' ' || r == ',' })
+	for _, field := range fields {
+		if !strings.HasPrefix(field, "SignedHeaders=") {
+			continue
+		}
+		for _, signed := range strings.Split(strings.TrimPrefix(field, "SignedHeaders="), ";") {
+			if signed == header {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -1,14 +1,17 @@
 package unit
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"orbit-service/clients"
+	"orbit-service/contract"
 	"orbit-service/handlers"
 	"orbit-service/middleware"
 	"github.com/rs/zerolog"
@@ -16,27 +19,38 @@ import (
 
 // MockGovernanceClient is a mock implementation of GovernanceClient
 type MockGovernanceClient struct {
-	allowed bool
-	reason  string
-	err     error
+	allowed     bool
+	reason      string
+	err         error
+	rateLimits  *clients.RateLimits
+	obligations []clients.Obligation
+	// requiresApproval holds allowed requests for approval instead
+	requiresApproval bool
+	lastRequest      clients.GovernanceRequest
 }
 
-func (m *MockGovernanceClient) CheckPermission(req clients.GovernanceRequest, correlationID string) (bool, string, error) {
+func (m *MockGovernanceClient) CheckPermission(ctx context.Context, req clients.GovernanceRequest, correlationID string) (clients.GovernanceResponse, error) {
+	m.lastRequest = req
 	if m.err != nil {
-		return false, "", m.err
+		return clients.GovernanceResponse{}, m.err
 	}
-	return m.allowed, m.reason, nil
+	if m.requiresApproval {
+		return clients.GovernanceResponse{Reason: "Request requires approval", RequiresApproval: true, Obligations: m.obligations}, nil
+	}
+	return clients.GovernanceResponse{Allowed: m.allowed, Reason: m.reason, RateLimits: m.rateLimits, Obligations: m.obligations}, nil
 }
 
 // MockAxonClient is a mock implementation of AxonClient
 type MockAxonClient struct {
-	response string
-	err      error
+	response    *contract.ReasonResponse
+	err         error
+	lastRequest contract.ReasonRequest
 }
 
-func (m *MockAxonClient) CallReason(ctx context.Context, correlationID string) (string, error) {
+func (m *MockAxonClient) CallReason(ctx context.Context, req contract.ReasonRequest, correlationID string) (*contract.ReasonResponse, error) {
+	m.lastRequest = req
 	if m.err != nil {
-		return "", m.err
+		return nil, m.err
 	}
 	return m.response, nil
 }
@@ -44,7 +58,7 @@ func (m *MockAxonClient) CallReason(ctx context.Context, correlationID string) (
 func TestDispatchHandlerSuccess(t *testing.T) {
 	logger := zerolog.Nop()
 	governanceClient := &MockGovernanceClient{allowed: true}
-	axonClient := &MockAxonClient{response: "Axon heartbeat OK"}
+	axonClient := &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version, Model: "axon-echo-1"}}
 
 	handler := handlers.DispatchHandler(logger, governanceClient, axonClient)
 
@@ -72,8 +86,8 @@ func TestDispatchHandlerSuccess(t *testing.T) {
 		t.Errorf("Dispatch handler returned wrong status: got %v want success", response.Status)
 	}
 
-	if response.Message != "Axon heartbeat OK" {
-		t.Errorf("Dispatch handler returned wrong message: got %v want 'Axon heartbeat OK'", response.Message)
+	if response.Result == nil || response.Result.Model != "axon-echo-1" {
+		t.Errorf("Dispatch handler returned wrong result: got %+v", response.Result)
 	}
 }
 
@@ -136,8 +150,155 @@ func TestDispatchHandlerGovernanceError(t *testing.T) {
 	rr := httptest.NewRecorder()
 	handler.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusInternalServerError {
-		t.Errorf("Dispatch handler should return 500 on governance error: got %v want %v", status, http.StatusInternalServerError)
+	if status := rr.Code; status != http.StatusBadGateway {
+		t.Errorf("Dispatch handler should return 502 on governance error: got %v want %v", status, http.StatusBadGateway)
+	}
+
+	var problem handlers.Problem
+	if err := json.NewDecoder(rr.Body).Decode(&problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.Error.Code != handlers.ErrorCodeGovernanceError || problem.CorrelationID != "test-correlation-id" {
+		t.Errorf("Dispatch handler returned wrong problem: %+v", problem)
+	}
+}
+
+func TestDispatchHandlerForwardsInput(t *testing.T) {
+	logger := zerolog.Nop()
+	governanceClient := &MockGovernanceClient{allowed: true}
+	axonClient := &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version}}
+
+	handler := handlers.DispatchHandler(logger, governanceClient, axonClient)
+
+	req, err := http.NewRequest("POST", "/dispatch", bytes.NewBufferString(`{"input":{"question":"status?"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(req.Context(), middleware.CorrelationIDKey, "test-correlation-id")
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Dispatch handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	if string(axonClient.lastRequest.Input) != `{"question":"status?"}` {
+		t.Errorf("Dispatch handler forwarded wrong input: got %s", axonClient.lastRequest.Input)
+	}
+
+	if axonClient.lastRequest.Metadata["correlation_id"] != "test-correlation-id" {
+		t.Errorf("Dispatch handler did not forward correlation ID metadata: got %v", axonClient.lastRequest.Metadata)
 	}
 }
 
+func TestDispatchHandlerForwardsStructuredRequest(t *testing.T) {
+	governanceClient := &MockGovernanceClient{allowed: true}
+	axonClient := &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version}}
+	handler := handlers.DispatchHandler(zerolog.Nop(), governanceClient, axonClient)
+
+	body := `{
+		"intent": "call_metrics",
+		"input": {"question": "status?"},
+		"parameters": {"temperature": 0.2},
+		"metadata": {"ticket": "T-1", "correlation_id": "spoofed"},
+		"callback": {"url": "https://example.com/hooks/dispatch"}
+	}`
+	req, err := http.NewRequest("POST", "/dispatch", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(context.WithValue(req.Context(), middleware.CorrelationIDKey, "test-correlation-id"))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Dispatch handler returned wrong status code: got %v want %v: %s", status, http.StatusOK, rr.Body.String())
+	}
+	if governanceClient.lastRequest.Intent != "call_metrics" {
+		t.Errorf("Dispatch handler checked wrong intent: got %s want call_metrics", governanceClient.lastRequest.Intent)
+	}
+	sent := axonClient.lastRequest
+	if string(sent.Input) != `{"question": "status?"}` {
+		t.Errorf("Dispatch handler forwarded wrong input: got %s", sent.Input)
+	}
+	if got := sent.Parameters["temperature"]; got != json.Number("0.2") {
+		t.Errorf("Dispatch handler forwarded wrong parameters: got %v", sent.Parameters)
+	}
+	if sent.Metadata["ticket"] != "T-1" || sent.Metadata["correlation_id"] != "test-correlation-id" || sent.Metadata["source_service"] != "orbit" {
+		t.Errorf("Dispatch handler forwarded wrong metadata: got %v", sent.Metadata)
+	}
+}
+
+func TestDispatchHandlerRejectsInvalidFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		field  string
+	}{
+		{"unknown field", `{"question": "status?"}`, http.StatusBadRequest, "/question"},
+		{"bad intent", `{"intent": "Call Reasoning"}`, http.StatusBadRequest, "/intent"},
+		{"non-string metadata", `{"metadata": {"ticket": 1}}`, http.StatusBadRequest, "/metadata/ticket"},
+		{"callback without url", `{"callback": {}}`, http.StatusBadRequest, "/callback"},
+		{"bad callback url", `{"callback": {"url": "ftp://example.com"}}`, http.StatusBadRequest, "/callback/url"},
+		{"bad max tokens", `{"parameters": {"max_tokens": 0}}`, http.StatusBadRequest, "/parameters/max_tokens"},
+		{"not an object", `["status?"]`, http.StatusBadRequest, ""},
+		{"too large", `{"input": "` + strings.Repeat("x", 100) + `"}`, http.StatusRequestEntityTooLarge, ""},
+	}
+
+	opts := handlers.DefaultDispatchOptions()
+	opts.MaxBodyBytes = 64
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			axonClient := &MockAxonClient{}
+			handler := handlers.DispatchHandlerWithOptions(zerolog.Nop(), &MockGovernanceClient{allowed: true}, axonClient, opts)
+
+			req, err := http.NewRequest("POST", "/dispatch", bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Fatalf("Dispatch handler returned wrong status code: got %v want %v", rr.Code, tt.status)
+			}
+			var response handlers.DispatchResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if tt.status == http.StatusBadRequest && tt.field != "" {
+				if len(response.Errors) != 1 || response.Errors[0].Field != tt.field {
+					t.Errorf("Dispatch handler returned wrong field errors: got %+v want %s", response.Errors, tt.field)
+				}
+			}
+			if axonClient.lastRequest.Version != "" {
+				t.Error("Dispatch handler called Axon with an invalid body")
+			}
+		})
+	}
+}
+
+func TestDispatchHandlerInvalidBody(t *testing.T) {
+	logger := zerolog.Nop()
+	governanceClient := &MockGovernanceClient{allowed: true}
+	axonClient := &MockAxonClient{}
+
+	handler := handlers.DispatchHandler(logger, governanceClient, axonClient)
+
+	req, err := http.NewRequest("POST", "/dispatch", bytes.NewBufferString(`{not json`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("Dispatch handler should return 400 for invalid JSON: got %v want %v", status, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,209 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+	"orbit-service/contract"
+	"orbit-service/handlers"
+)
+
+// CountingGovernanceClient counts calls and can hold them to force overlap
+type CountingGovernanceClient struct {
+	mu      sync.Mutex
+	calls   int
+	allowed bool
+	err     error
+	delay   time.Duration
+}
+
+func (m *CountingGovernanceClient) CheckPermission(ctx context.Context, req clients.GovernanceRequest, correlationID string) (clients.GovernanceResponse, error) {
+	m.mu.Lock()
+	m.calls++
+	m.mu.Unlock()
+
+	time.Sleep(m.delay)
+	if m.err != nil {
+		return clients.GovernanceResponse{}, m.err
+	}
+	return clients.GovernanceResponse{Allowed: m.allowed, Reason: "decided"}, nil
+}
+
+func (m *CountingGovernanceClient) Calls() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls
+}
+
+var cacheTestRequest = clients.GovernanceRequest{Service: "orbit", Intent: "call_reasoning"}
+
+func TestGovernanceCacheServesRepeatedDecisions(t *testing.T) {
+	next := &CountingGovernanceClient{allowed: true}
+	cache := clients.NewCachingGovernanceChecker(zerolog.Nop(), next, clients.DefaultGovernanceCacheConfig())
+
+	first, err := cache.CheckPermission(context.Background(), cacheTestRequest, "test-correlation-id")
+	if err != nil {
+		t.Fatalf("CheckPermission failed: %v", err)
+	}
+	second, err := cache.CheckPermission(context.Background(), cacheTestRequest, "test-correlation-id")
+	if err != nil {
+		t.Fatalf("CheckPermission failed: %v", err)
+	}
+
+	if first.Cached || !second.Cached {
+		t.Errorf("Expected miss then hit, got cached=%v then cached=%v", first.Cached, second.Cached)
+	}
+
+	if next.Calls() != 1 {
+		t.Errorf("Expected 1 underlying check, got %d", next.Calls())
+	}
+}
+
+func TestGovernanceCacheSeparateDenyTTL(t *testing.T) {
+	next := &CountingGovernanceClient{allowed: false}
+	config := clients.DefaultGovernanceCacheConfig()
+	config.DenyTTL = 0
+	cache := clients.NewCachingGovernanceChecker(zerolog.Nop(), next, config)
+
+	for i := 0; i < 2; i++ {
+		if _, err := cache.CheckPermission(context.Background(), cacheTestRequest, "test-correlation-id"); err != nil {
+			t.Fatalf("CheckPermission failed: %v", err)
+		}
+	}
+
+	if next.Calls() != 2 {
+		t.Errorf("Expected denies not to be cached with a zero deny TTL, got %d underlying checks", next.Calls())
+	}
+}
+
+func TestGovernanceCacheDoesNotCacheErrors(t *testing.T) {
+	next := &CountingGovernanceClient{err: errors.New("lambda unavailable")}
+	cache := clients.NewCachingGovernanceChecker(zerolog.Nop(), next, clients.DefaultGovernanceCacheConfig())
+
+	for i := 0; i < 2; i++ {
+		if _, err := cache.CheckPermission(context.Background(), cacheTestRequest, "test-correlation-id"); err == nil {
+			t.Fatal("Expected error to be returned")
+		}
+	}
+
+	if next.Calls() != 2 {
+		t.Errorf("Expected errors not to be cached, got %d underlying checks", next.Calls())
+	}
+}
+
+func TestGovernanceCacheCollapsesConcurrentChecks(t *testing.T) {
+	next := &CountingGovernanceClient{allowed: true, delay: 50 * time.Millisecond}
+	cache := clients.NewCachingGovernanceChecker(zerolog.Nop(), next, clients.DefaultGovernanceCacheConfig())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.CheckPermission(context.Background(), cacheTestRequest, "test-correlation-id"); err != nil {
+				t.Errorf("CheckPermission failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if next.Calls() != 1 {
+		t.Errorf("Expected concurrent checks to collapse into 1 underlying check, got %d", next.Calls())
+	}
+}
+
+func TestGovernanceCacheWaiterHonoursOwnDeadline(t *testing.T) {
+	next := &CountingGovernanceClient{allowed: true, delay: 500 * time.Millisecond}
+	cache := clients.NewCachingGovernanceChecker(zerolog.Nop(), next, clients.DefaultGovernanceCacheConfig())
+
+	go cache.CheckPermission(context.Background(), cacheTestRequest, "leader")
+	for next.Calls() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := cache.CheckPermission(ctx, cacheTestRequest, "waiter")
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("CheckPermission returned wrong error for a waiter past its deadline: got %v want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("Waiter outlived its deadline waiting on the shared check: took %v", elapsed)
+	}
+}
+
+func TestGovernanceCacheInvalidatePolicy(t *testing.T) {
+	next := &CountingGovernanceClient{allowed: true}
+	cache := clients.NewCachingGovernanceChecker(zerolog.Nop(), next, clients.DefaultGovernanceCacheConfig())
+
+	cache.CheckPermission(context.Background(), cacheTestRequest, "test-correlation-id")
+
+	if dropped := cache.InvalidatePolicy("orbit", "call_reasoning"); dropped != 1 {
+		t.Errorf("Expected 1 dropped decision, got %d", dropped)
+	}
+
+	decision, _ := cache.CheckPermission(context.Background(), cacheTestRequest, "test-correlation-id")
+	if decision.Cached || next.Calls() != 2 {
+		t.Errorf("Expected invalidated decision to be re-evaluated, got cached=%v calls=%d", decision.Cached, next.Calls())
+	}
+}
+
+func TestDispatchHandlerReportsCachedDecision(t *testing.T) {
+	next := &CountingGovernanceClient{allowed: true}
+	cache := clients.NewCachingGovernanceChecker(zerolog.Nop(), next, clients.DefaultGovernanceCacheConfig())
+	axonClient := &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version}}
+
+	handler := handlers.DispatchHandler(zerolog.Nop(), cache, axonClient)
+
+	var got []string
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("POST", "/dispatch", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		got = append(got, rr.Header().Get(handlers.GovernanceCacheHeader))
+	}
+
+	if got[0] != "miss" || got[1] != "hit" {
+		t.Errorf("Expected cache header miss then hit, got %v", got)
+	}
+}
+
+func TestDebugVarsRequireManagePolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed bool
+		status  int
+	}{
+		{"admin", true, http.StatusOK},
+		{"not admin", false, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		authorizer := &MockGovernanceClient{allowed: tt.allowed, reason: "denied"}
+		req, _ := http.NewRequest("GET", "/debug/vars", nil)
+		rr := httptest.NewRecorder()
+		handlers.DebugVarsHandler(zerolog.Nop(), authorizer).ServeHTTP(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("%s: Debug vars handler returned wrong status code: got %v want %v", tt.name, rr.Code, tt.status)
+		}
+		if exposed := strings.Contains(rr.Body.String(), `"cmdline"`); exposed != tt.allowed {
+			t.Errorf("%s: Debug vars handler exposed the expvars: got %v want %v", tt.name, exposed, tt.allowed)
+		}
+		if authorizer.lastRequest.Service != "admin" || authorizer.lastRequest.Intent != "manage_policies" {
+			t.Errorf("%s: admin check sent wrong request: got %+v", tt.name, authorizer.lastRequest)
+		}
+	}
+}
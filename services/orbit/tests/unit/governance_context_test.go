@@ -0,0 +1,287 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/contract"
+	"orbit-service/handlers"
+	"orbit-service/middleware"
+)
+
+func TestDispatchHandlerSendsGovernanceContext(t *testing.T) {
+	governanceClient := &MockGovernanceClient{allowed: true}
+	axonClient := &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version}}
+
+	opts := handlers.DefaultDispatchOptions()
+	opts.Context = handlers.GovernanceContextConfig{
+		Attributes: []handlers.ContextAttribute{
+			{Key: "user_type", Source: handlers.ContextSourceCaller, Name: "type"},
+			{Key: "tenant", Source: handlers.ContextSourceHeader, Name: "X-Tenant-Id"},
+			{Key: "task", Source: handlers.ContextSourceBody, Name: "input.task.kind"},
+			{Key: "source_ip", Source: handlers.ContextSourceIP},
+			{Key: "hour", Source: handlers.ContextSourceTime, Name: "hour"},
+		},
+	}
+	handler := handlers.DispatchHandlerWithOptions(zerolog.Nop(), governanceClient, axonClient, opts)
+
+	body := []byte(`{"input":{"task":{"kind":"summarize","text":"not allowlisted"}}}`)
+	req, err := http.NewRequest("POST", "/dispatch", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "10.0.0.7:51234"
+	req.Header.Set("X-Tenant-Id", "acme")
+	req.Header.Set("X-Not-Allowlisted", "ignored")
+	ctx := context.WithValue(req.Context(), middleware.CallerKey, middleware.Caller{ID: "user-1", Type: "active"})
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	got := governanceClient.lastRequest.Context
+	want := map[string]interface{}{
+		"user_type": "active",
+		"tenant":    "acme",
+		"task":      "summarize",
+		"source_ip": "10.0.0.7",
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("governance context has wrong %s: got %v want %v", key, got[key], value)
+		}
+	}
+	if hour, ok := got["hour"].(int); !ok || hour < 0 || hour > 23 {
+		t.Errorf("governance context has wrong hour: got %v", got["hour"])
+	}
+	if len(got) != len(want)+1 {
+		t.Errorf("governance context has unexpected keys: got %v", got)
+	}
+}
+
+func TestDispatchHandlerOmitsMissingContext(t *testing.T) {
+	governanceClient := &MockGovernanceClient{allowed: true}
+	axonClient := &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version}}
+
+	opts := handlers.DefaultDispatchOptions()
+	opts.Context = handlers.GovernanceContextConfig{
+		Attributes: []handlers.ContextAttribute{
+			{Key: "user_type", Source: handlers.ContextSourceCaller, Name: "type"},
+			{Key: "task", Source: handlers.ContextSourceBody, Name: "input.task"},
+		},
+	}
+	handler := handlers.DispatchHandlerWithOptions(zerolog.Nop(), governanceClient, axonClient, opts)
+
+	// task is an object, which is never copied into the context
+	req, err := http.NewRequest("POST", "/dispatch", bytes.NewReader([]byte(`{"input":{"task":{"kind":"summarize"}}}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if got := governanceClient.lastRequest.Context; len(got) != 0 {
+		t.Errorf("governance context should be empty: got %v", got)
+	}
+}
+
+func TestGovernanceContextForwardedFor(t *testing.T) {
+	governanceClient := &MockGovernanceClient{allowed: true}
+	axonClient := &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version}}
+
+	opts := handlers.DefaultDispatchOptions()
+	handler := handlers.DispatchHandlerWithOptions(zerolog.Nop(), governanceClient, axonClient, opts)
+
+	req, err := http.NewRequest("POST", "/dispatch", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "10.0.0.7:51234"
+	req.Header.Set("X-Forwarded-For", "1.1.1.1, 203.0.113.9")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if got := governanceClient.lastRequest.Context["source_ip"]; got != "203.0.113.9" {
+		t.Errorf("governance context has wrong source_ip: got %v want %v", got, "203.0.113.9")
+	}
+}
+
+func TestLoadGovernanceContextConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{"valid", `{"attributes":[{"key":"user_type","source":"caller","name":"type"}]}`, false},
+		{"unknown source", `{"attributes":[{"key":"user_type","source":"cookie","name":"type"}]}`, true},
+		{"duplicate key", `{"attributes":[{"key":"ip","source":"source_ip"},{"key":"ip","source":"source_ip"}]}`, true},
+		{"header without name", `{"attributes":[{"key":"tenant","source":"header"}]}`, true},
+		{"unknown field", `{"attributes":[],"extra":true}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "context.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := handlers.LoadGovernanceContextConfig(path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadGovernanceContextConfig returned wrong error: got %v wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCallerMiddleware(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/key-1" {
+			http.NotFound(w, r)
+			return
+		}
+		pem.Encode(w, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	}))
+	defer keyServer.Close()
+
+	const albARN = "arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/orbit/1"
+	sign := func(signer string, claims string) string {
+		header := base64.URLEncoding.EncodeToString([]byte(`{"alg":"ES256","kid":"key-1","signer":"` + signer + `","exp":` + fmt.Sprint(time.Now().Add(time.Minute).Unix()) + `}`))
+		payload := base64.URLEncoding.EncodeToString([]byte(claims))
+		digest := sha256.Sum256([]byte(header + "." + payload))
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+		return header + "." + payload + "." + base64.URLEncoding.EncodeToString(signature)
+	}
+	valid := sign(albARN, `{"sub":"user-1","caller_type":"blocked"}`)
+
+	headers := middleware.CallerConfig{Auth: middleware.CallerAuthHeaders, IDHeader: "X-Caller-Id", TypeHeader: "X-Caller-Type"}
+	alb := middleware.CallerConfig{Auth: middleware.CallerAuthALB, ALB: middleware.NewALBVerifier(albARN, keyServer.URL+"/"), TypeClaim: "caller_type"}
+
+	tests := []struct {
+		name   string
+		config middleware.CallerConfig
+		header map[string]string
+		status int
+		want   middleware.Caller
+	}{
+		{"no auth ignores headers", middleware.CallerConfig{IDHeader: "X-Caller-Id"}, map[string]string{"X-Caller-Id": "user-1"}, http.StatusOK, middleware.Caller{}},
+		{"trusted headers", headers, map[string]string{"X-Caller-Id": "user-1", "X-Caller-Type": "blocked"}, http.StatusOK, middleware.Caller{ID: "user-1", Type: "blocked"}},
+		{"alb token", alb, map[string]string{middleware.ALBDataHeader: valid}, http.StatusOK, middleware.Caller{ID: "user-1", Type: "blocked"}},
+		{"alb ignores identity header", alb, map[string]string{"X-Amzn-Oidc-Identity": "user-1"}, http.StatusOK, middleware.Caller{}},
+		{"alb token from another load balancer", alb, map[string]string{middleware.ALBDataHeader: sign(albARN+"x", `{"sub":"user-1"}`)}, http.StatusUnauthorized, middleware.Caller{}},
+		{"alb token tampered", alb, map[string]string{middleware.ALBDataHeader: valid[:strings.Index(valid, ".")+1] + base64.URLEncoding.EncodeToString([]byte(`{"sub":"admin"}`)) + valid[strings.LastIndex(valid, "."):]}, http.StatusUnauthorized, middleware.Caller{}},
+	}
+
+	for _, tt := range tests {
+		var got middleware.Caller
+		handler := middleware.CallerMiddleware(tt.config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, _ = middleware.GetCaller(r.Context())
+		}))
+
+		req, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, value := range tt.header {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("%s: CallerMiddleware returned wrong status code: got %v want %v", tt.name, rr.Code, tt.status)
+		}
+		if got != tt.want {
+			t.Errorf("%s: CallerMiddleware set wrong caller: got %+v want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestALBVerifierUnknownKey(t *testing.T) {
+	var mu sync.Mutex
+	fetches := 0
+	keyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches++
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		http.NotFound(w, r)
+	}))
+	defer keyServer.Close()
+
+	const albARN = "arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/orbit/1"
+	verifier := middleware.NewALBVerifier(albARN, keyServer.URL+"/")
+	token := func(kid string) string {
+		header := base64.URLEncoding.EncodeToString([]byte(`{"alg":"ES256","kid":"` + kid + `","signer":"` + albARN + `"}`))
+		return header + "." + base64.URLEncoding.EncodeToString([]byte(`{"sub":"admin"}`)) + "." + base64.URLEncoding.EncodeToString(make([]byte, 64))
+	}
+
+	// Concurrent and later tokens with the same made-up key ID cost one fetch
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := verifier.Verify(context.Background(), token("made-up")); err == nil {
+				t.Error("Verify accepted a token with an unknown key")
+			}
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < 3; i++ {
+		if _, err := verifier.Verify(context.Background(), token("made-up")); err == nil {
+			t.Error("Verify accepted a token with an unknown key")
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("Verify fetched an unknown key wrong number of times: got %d want %d", fetches, 1)
+	}
+
+	// The fetch is made under the request's context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := verifier.Verify(ctx, token("other")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Verify returned wrong error for a cancelled request: got %v want %v", err, context.Canceled)
+	}
+	if fetches != 1 {
+		t.Errorf("Verify fetched a key for a cancelled request: got %d fetches want %d", fetches, 1)
+	}
+}
@@ -0,0 +1,213 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+	"orbit-service/contract"
+	"orbit-service/handlers"
+	"orbit-service/policy"
+)
+
+func failureConfig(mode string) clients.GovernanceFailureConfig {
+	config := clients.DefaultGovernanceFailureConfig()
+	config.Modes["call_reasoning"] = mode
+	return config
+}
+
+func dispatchThrough(t *testing.T, governance clients.GovernanceChecker) *httptest.ResponseRecorder {
+	t.Helper()
+
+	axonClient := &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version}}
+	handler := handlers.DispatchHandler(zerolog.Nop(), governance, axonClient)
+
+	req, err := http.NewRequest("POST", "/dispatch", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestFailoverClosedReturnsUnavailable(t *testing.T) {
+	primary := &MockGovernanceClient{err: errors.New("lambda unavailable")}
+	checker := clients.NewFailoverGovernanceChecker(zerolog.Nop(), primary, nil, failureConfig(clients.FailureModeClosed))
+
+	rr := dispatchThrough(t, checker)
+
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("handler returned wrong Retry-After: got %q want %q", got, "30")
+	}
+}
+
+func TestFailoverCachedServesLastKnownDecision(t *testing.T) {
+	primary := &MockGovernanceClient{allowed: true}
+	checker := clients.NewFailoverGovernanceChecker(zerolog.Nop(), primary, nil, failureConfig(clients.FailureModeCached))
+
+	if rr := dispatchThrough(t, checker); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	primary.err = errors.New("lambda unavailable")
+	rr := dispatchThrough(t, checker)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if got := rr.Header().Get(handlers.GovernanceDegradedHeader); got != clients.FailureModeCached {
+		t.Errorf("handler returned wrong degraded header: got %q want %q", got, clients.FailureModeCached)
+	}
+
+	var response handlers.DispatchResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Degraded != clients.FailureModeCached {
+		t.Errorf("handler returned wrong degraded: got %q want %q", response.Degraded, clients.FailureModeCached)
+	}
+}
+
+func TestFailoverCachedRejectsStaleDecision(t *testing.T) {
+	primary := &MockGovernanceClient{allowed: true}
+	config := failureConfig(clients.FailureModeCached)
+	config.MaxStaleness = time.Nanosecond
+	checker := clients.NewFailoverGovernanceChecker(zerolog.Nop(), primary, nil, config)
+
+	req := clients.GovernanceRequest{Service: "orbit", Intent: "call_reasoning"}
+	if _, err := checker.CheckPermission(context.Background(), req, "test-correlation-id"); err != nil {
+		t.Fatalf("CheckPermission failed: %v", err)
+	}
+
+	time.Sleep(time.Millisecond)
+	primary.err = errors.New("lambda unavailable")
+
+	_, err := checker.CheckPermission(context.Background(), req, "test-correlation-id")
+	var unavailable *clients.GovernanceUnavailableError
+	if !errors.As(err, &unavailable) {
+		t.Errorf("Expected stale decision to be rejected as unavailable, got %v", err)
+	}
+}
+
+// CachingGovernanceClient answers as a caching checker does, marking its
+// decisions cached once cached is set
+type CachingGovernanceClient struct {
+	cached bool
+	err    error
+}
+
+func (m *CachingGovernanceClient) CheckPermission(ctx context.Context, req clients.GovernanceRequest, correlationID string) (clients.GovernanceResponse, error) {
+	if m.err != nil {
+		return clients.GovernanceResponse{}, m.err
+	}
+	return clients.GovernanceResponse{Allowed: true, Reason: "allowed", Cached: m.cached}, nil
+}
+
+func TestFailoverCachedAgesFromTheDecision(t *testing.T) {
+	primary := &CachingGovernanceClient{}
+	config := failureConfig(clients.FailureModeCached)
+	config.MaxStaleness = 50 * time.Millisecond
+	checker := clients.NewFailoverGovernanceChecker(zerolog.Nop(), primary, nil, config)
+
+	req := clients.GovernanceRequest{Service: "orbit", Intent: "call_reasoning"}
+	if _, err := checker.CheckPermission(context.Background(), req, "test-correlation-id"); err != nil {
+		t.Fatalf("CheckPermission failed: %v", err)
+	}
+
+	// A cache hit of the same decision does not make it any younger
+	time.Sleep(30 * time.Millisecond)
+	primary.cached = true
+	if _, err := checker.CheckPermission(context.Background(), req, "test-correlation-id"); err != nil {
+		t.Fatalf("CheckPermission failed: %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	primary.err = errors.New("lambda unavailable")
+	_, err := checker.CheckPermission(context.Background(), req, "test-correlation-id")
+	var unavailable *clients.GovernanceUnavailableError
+	if !errors.As(err, &unavailable) {
+		t.Errorf("Expected a decision older than MaxStaleness to be rejected as unavailable, got %v", err)
+	}
+}
+
+func TestFailoverLocalUsesPolicySnapshot(t *testing.T) {
+	local, err := policy.NewSnapshotEngine(zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary := &MockGovernanceClient{err: errors.New("lambda unavailable")}
+	checker := clients.NewFailoverGovernanceChecker(zerolog.Nop(), primary, local, failureConfig(clients.FailureModeLocal))
+
+	rr := dispatchThrough(t, checker)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if got := rr.Header().Get(handlers.GovernanceDegradedHeader); got != clients.FailureModeLocal {
+		t.Errorf("handler returned wrong degraded header: got %q want %q", got, clients.FailureModeLocal)
+	}
+}
+
+func TestFailoverHealthyPrimaryIsNotDegraded(t *testing.T) {
+	primary := &MockGovernanceClient{allowed: true}
+	checker := clients.NewFailoverGovernanceChecker(zerolog.Nop(), primary, nil, failureConfig(clients.FailureModeLocal))
+
+	rr := dispatchThrough(t, checker)
+
+	if got := rr.Header().Get(handlers.GovernanceDegradedHeader); got != "" {
+		t.Errorf("handler returned degraded header for a healthy primary: got %q", got)
+	}
+}
+
+func TestGovernanceFailureConfigFromEnv(t *testing.T) {
+	t.Setenv("GOVERNANCE_FAILURE_MODE", "cached")
+	t.Setenv("GOVERNANCE_FAILURE_MODES", "call_reasoning=local, call_metrics=closed")
+
+	config, err := clients.GovernanceFailureConfigFromEnv()
+	if err != nil {
+		t.Fatalf("GovernanceFailureConfigFromEnv failed: %v", err)
+	}
+
+	for intent, want := range map[string]string{
+		"call_reasoning":  clients.FailureModeLocal,
+		"call_metrics":    clients.FailureModeClosed,
+		"manage_policies": clients.FailureModeCached,
+	} {
+		if got := config.ModeFor(intent); got != want {
+			t.Errorf("ModeFor(%s) returned wrong mode: got %v want %v", intent, got, want)
+		}
+	}
+
+	t.Setenv("GOVERNANCE_FAILURE_MODES", "call_reasoning=open")
+	if _, err := clients.GovernanceFailureConfigFromEnv(); err == nil {
+		t.Error("Expected unknown failure mode to be rejected")
+	}
+}
+
+func TestPolicySnapshotMatchesDefaultPolicies(t *testing.T) {
+	defaults, err := os.ReadFile(filepath.Join("..", "..", "..", "..", "governance", "policies", "default.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := os.ReadFile(filepath.Join("..", "..", "policy", "snapshot.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(defaults, snapshot) {
+		t.Error("policy/snapshot.json is out of sync with governance/policies/default.json")
+	}
+}
@@ -1,10 +1,17 @@
 package unit
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
 	"orbit-service/clients"
 	"github.com/rs/zerolog"
 )
@@ -47,3 +54,120 @@ func TestGovernanceClientCheckPermission(t *testing.T) {
 	}
 }
 
+// fakeLambda returns queued Invoke results in order, repeating the last one
+type fakeLambda struct {
+	lambdaiface.LambdaAPI
+	results []fakeInvokeResult
+	calls   int
+}
+
+type fakeInvokeResult struct {
+	output *lambda.InvokeOutput
+	err    error
+}
+
+func (f *fakeLambda) InvokeWithContext(ctx aws.Context, input *lambda.InvokeInput, opts ...request.Option) (*lambda.InvokeOutput, error) {
+	result := f.results[len(f.results)-1]
+	if f.calls < len(f.results) {
+		result = f.results[f.calls]
+	}
+	f.calls++
+	return result.output, result.err
+}
+
+func proxyPayload(statusCode int, body string) *lambda.InvokeOutput {
+	payload, _ := json.Marshal(map[string]interface{}{
+		"statusCode": statusCode,
+		"body":       body,
+	})
+	return &lambda.InvokeOutput{StatusCode: aws.Int64(200), Payload: payload}
+}
+
+func testGovernanceConfig() clients.GovernanceConfig {
+	return clients.GovernanceConfig{
+		Timeout:             time.Second,
+		MaxAttempts:         3,
+		BreakerMaxFailures:  2,
+		BreakerResetTimeout: time.Minute,
+	}
+}
+
+func TestGovernanceClientParsesProxyEnvelope(t *testing.T) {
+	fake := &fakeLambda{results: []fakeInvokeResult{
+		{output: proxyPayload(403, `{"allowed": false, "reason": "Policy is disabled"}`)},
+	}}
+	client := clients.NewGovernanceClientWithLambda(zerolog.Nop(), fake, "governance", testGovernanceConfig())
+
+	decision, err := client.CheckPermission(context.Background(), clients.GovernanceRequest{Service: "orbit", Intent: "call_reasoning"}, "test-correlation-id")
+	if err != nil {
+		t.Fatalf("CheckPermission failed: %v", err)
+	}
+
+	if decision.Allowed || decision.Reason != "Policy is disabled" {
+		t.Errorf("Expected denial with reason, got %+v", decision)
+	}
+}
+
+func TestGovernanceClientRetriesThrottling(t *testing.T) {
+	fake := &fakeLambda{results: []fakeInvokeResult{
+		{err: awserr.New(lambda.ErrCodeTooManyRequestsException, "Rate exceeded", nil)},
+		{output: proxyPayload(200, `{"allowed": true, "reason": "Request authorized"}`)},
+	}}
+	config := testGovernanceConfig()
+	config.BreakerMaxFailures = 5
+	client := clients.NewGovernanceClientWithLambda(zerolog.Nop(), fake, "governance", config)
+
+	decision, err := client.CheckPermission(context.Background(), clients.GovernanceRequest{Service: "orbit", Intent: "call_reasoning"}, "test-correlation-id")
+	if err != nil {
+		t.Fatalf("CheckPermission failed: %v", err)
+	}
+
+	if !decision.Allowed {
+		t.Error("Expected request to be allowed after retrying a throttle")
+	}
+
+	if fake.calls != 2 {
+		t.Errorf("Expected 2 invocations, got %d", fake.calls)
+	}
+}
+
+func TestGovernanceClientDoesNotRetryFunctionErrors(t *testing.T) {
+	fake := &fakeLambda{results: []fakeInvokeResult{
+		{output: &lambda.InvokeOutput{FunctionError: aws.String("Unhandled"), Payload: []byte(`{}`)}},
+	}}
+	config := testGovernanceConfig()
+	config.BreakerMaxFailures = 5
+	client := clients.NewGovernanceClientWithLambda(zerolog.Nop(), fake, "governance", config)
+
+	if _, err := client.CheckPermission(context.Background(), clients.GovernanceRequest{Service: "orbit", Intent: "call_reasoning"}, "test-correlation-id"); err == nil {
+		t.Fatal("Expected function error to be returned")
+	}
+
+	if fake.calls != 1 {
+		t.Errorf("Expected function errors not to be retried, got %d invocations", fake.calls)
+	}
+}
+
+func TestGovernanceClientBreakerOpens(t *testing.T) {
+	fake := &fakeLambda{results: []fakeInvokeResult{
+		{err: awserr.New(lambda.ErrCodeServiceException, "Service unavailable", nil)},
+	}}
+	client := clients.NewGovernanceClientWithLambda(zerolog.Nop(), fake, "governance", testGovernanceConfig())
+
+	if _, err := client.CheckPermission(context.Background(), clients.GovernanceRequest{Service: "orbit", Intent: "call_reasoning"}, "test-correlation-id"); err == nil {
+		t.Fatal("Expected service errors to fail the check")
+	}
+
+	if state := client.BreakerState(); state != "open" {
+		t.Fatalf("Expected breaker to be open, got %q", state)
+	}
+
+	calls := fake.calls
+	if _, err := client.CheckPermission(context.Background(), clients.GovernanceRequest{Service: "orbit", Intent: "call_reasoning"}, "test-correlation-id"); err == nil {
+		t.Fatal("Expected open breaker to fail the check")
+	}
+
+	if fake.calls != calls {
+		t.Errorf("Expected open breaker to skip invocation, got %d extra calls", fake.calls-calls)
+	}
+}
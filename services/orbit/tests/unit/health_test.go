@@ -0,0 +1,50 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+	"orbit-service/handlers"
+)
+
+type staticBreaker string
+
+func (s staticBreaker) BreakerState() string {
+	return string(s)
+}
+
+func TestHealthHandlerReportsDependencies(t *testing.T) {
+	handler := handlers.HealthHandlerWithDependencies(zerolog.Nop(), map[string]clients.BreakerReporter{
+		"governance": staticBreaker("open"),
+		"axon":       staticBreaker("closed"),
+	})
+
+	req, err := http.NewRequest("GET", "/health", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("Health handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var response handlers.HealthResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if response.Status != "degraded" {
+		t.Errorf("Health handler should report degraded with an open breaker: got %v", response.Status)
+	}
+
+	if response.Dependencies["governance"].CircuitBreaker != "open" {
+		t.Errorf("Health handler returned wrong governance state: got %+v", response.Dependencies)
+	}
+}
@@ -0,0 +1,271 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/rs/zerolog"
+	"orbit-service/contract"
+	"orbit-service/handlers"
+	"orbit-service/idempotency"
+	"orbit-service/middleware"
+)
+
+func idempotentHandler(governance *CountingGovernanceClient, axon *MockAxonClient) http.Handler {
+	opts := handlers.DefaultDispatchOptions()
+	opts.Idempotency = idempotency.NewMemoryStore()
+	handler := handlers.DispatchHandlerWithOptions(zerolog.Nop(), governance, axon, opts)
+	return middleware.CallerMiddleware(middleware.CallerConfig{Auth: middleware.CallerAuthHeaders, IDHeader: "X-Caller-Id"})(handler)
+}
+
+func sendIdempotent(handler http.Handler, path, caller, key, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("X-Caller-Id", caller)
+	if key != "" {
+		req.Header.Set(handlers.IdempotencyKeyHeader, key)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestIdempotentDispatch(t *testing.T) {
+	governance := &CountingGovernanceClient{allowed: true}
+	axon := &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version, Model: "axon-echo-1"}}
+	handler := idempotentHandler(governance, axon)
+	body := `{"input":{"q":"refund order 7"}}`
+
+	first := sendIdempotent(handler, "/dispatch", "alice", "refund-7", body)
+	if first.Code != http.StatusOK {
+		t.Fatalf("Dispatch handler returned wrong status code: got %v want %v: %s", first.Code, http.StatusOK, first.Body.String())
+	}
+
+	retry := sendIdempotent(handler, "/dispatch", "alice", "refund-7", body)
+	if retry.Code != http.StatusOK || retry.Body.String() != first.Body.String() {
+		t.Errorf("Retry was not replayed the first response: got %v %s", retry.Code, retry.Body.String())
+	}
+	if retry.Header().Get(handlers.IdempotentReplayedHeader) != "true" {
+		t.Error("Replayed response is not marked as replayed")
+	}
+	if got := retry.Header().Get(handlers.GovernanceCacheHeader); got != first.Header().Get(handlers.GovernanceCacheHeader) {
+		t.Errorf("Replayed response has wrong %s header: got %q", handlers.GovernanceCacheHeader, got)
+	}
+	if calls := governance.Calls(); calls != 1 {
+		t.Errorf("Retry was dispatched again: governance called %d times", calls)
+	}
+
+	if rr := sendIdempotent(handler, "/dispatch", "alice", "refund-7", `{"input":{"q":"refund order 8"}}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Dispatch handler returned wrong status code for a reused key: got %v want %v", rr.Code, http.StatusUnprocessableEntity)
+	}
+	if rr := sendIdempotent(handler, "/dispatch?mode=async", "alice", "refund-7", body); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Dispatch handler returned wrong status code for a key reused in another mode: got %v want %v", rr.Code, http.StatusUnprocessableEntity)
+	}
+
+	// Keys belong to the caller who sent them
+	if rr := sendIdempotent(handler, "/dispatch", "bob", "refund-7", body); rr.Code != http.StatusOK || rr.Header().Get(handlers.IdempotentReplayedHeader) != "" {
+		t.Errorf("Another caller's key was replayed: got %v", rr.Code)
+	}
+	if calls := governance.Calls(); calls != 2 {
+		t.Errorf("Another caller's request was not dispatched: governance called %d times", calls)
+	}
+
+	for _, tt := range []struct {
+		name string
+		path string
+		key  string
+	}{
+		{"long key", "/dispatch", strings.Repeat("k", 256)},
+		{"control character", "/dispatch", "refund\x007"},
+		{"streamed", "/dispatch?stream=true", "refund-9"},
+	} {
+		if rr := sendIdempotent(handler, tt.path, "alice", tt.key, body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: Dispatch handler returned wrong status code: got %v want %v", tt.name, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestIdempotentDispatchAnonymous(t *testing.T) {
+	governance := &CountingGovernanceClient{allowed: true}
+	axon := &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version}}
+	handler := idempotentHandler(governance, axon)
+
+	// Anonymous callers cannot be told apart, so their keys would collide
+	if rr := sendIdempotent(handler, "/dispatch", "", "refund-7", `{}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Dispatch handler returned wrong status code for an anonymous key: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if calls := governance.Calls(); calls != 0 {
+		t.Errorf("Anonymous keyed request was dispatched: governance called %d times", calls)
+	}
+	if rr := sendIdempotent(handler, "/dispatch", "", "", `{}`); rr.Code != http.StatusOK {
+		t.Errorf("Dispatch handler returned wrong status code for an anonymous request without a key: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestIdempotentDispatchInProgress(t *testing.T) {
+	governance := &CountingGovernanceClient{allowed: true, delay: 100 * time.Millisecond}
+	axon := &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version}}
+	handler := idempotentHandler(governance, axon)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- sendIdempotent(handler, "/dispatch", "alice", "report-1", "")
+	}()
+	deadline := time.Now().Add(time.Second)
+	for governance.Calls() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	rr := sendIdempotent(handler, "/dispatch", "alice", "report-1", "")
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Dispatch handler returned wrong status code for a concurrent duplicate: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("Concurrent duplicate has no Retry-After header")
+	}
+
+	if first := <-done; first.Code != http.StatusOK {
+		t.Fatalf("First request returned wrong status code: got %v want %v", first.Code, http.StatusOK)
+	}
+	if rr := sendIdempotent(handler, "/dispatch", "alice", "report-1", ""); rr.Code != http.StatusOK || rr.Header().Get(handlers.IdempotentReplayedHeader) != "true" {
+		t.Errorf("Retry after the first request finished was not replayed: got %v", rr.Code)
+	}
+}
+
+func TestIdempotentDispatchFailureNotStored(t *testing.T) {
+	governance := &CountingGovernanceClient{allowed: true}
+	axon := &MockAxonClient{err: errors.New("axon unavailable")}
+	handler := idempotentHandler(governance, axon)
+
+	if rr := sendIdempotent(handler, "/dispatch", "alice", "report-2", ""); rr.Code != http.StatusBadGateway {
+		t.Fatalf("Dispatch handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadGateway)
+	}
+
+	axon.err = nil
+	axon.response = &contract.ReasonResponse{Version: contract.Version}
+	if rr := sendIdempotent(handler, "/dispatch", "alice", "report-2", ""); rr.Code != http.StatusOK || rr.Header().Get(handlers.IdempotentReplayedHeader) != "" {
+		t.Errorf("Retry of a failed dispatch was not dispatched: got %v", rr.Code)
+	}
+	if calls := governance.Calls(); calls != 2 {
+		t.Errorf("Governance called %d times, want 2", calls)
+	}
+}
+
+// fakeIdempotencyTable keeps idempotency items in memory, honouring the
+// conditions the DynamoDB store writes with
+type fakeIdempotencyTable struct {
+	dynamodbiface.DynamoDBAPI
+
+	mu    sync.Mutex
+	items map[string]map[string]*dynamodb.AttributeValue
+}
+
+func (f *fakeIdempotencyTable) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &dynamodb.GetItemOutput{Item: f.items[aws.StringValue(input.Key["pk"].S)]}, nil
+}
+
+func (f *fakeIdempotencyTable) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pk := aws.StringValue(input.Item["pk"].S)
+	if !f.satisfies(f.items[pk], input.ConditionExpression, input.ExpressionAttributeValues) {
+		return nil, &dynamodb.ConditionalCheckFailedException{Message_: aws.String("The conditional request failed")}
+	}
+	f.items[pk] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeIdempotencyTable) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pk := aws.StringValue(input.Key["pk"].S)
+	if !f.satisfies(f.items[pk], input.ConditionExpression, input.ExpressionAttributeValues) {
+		return nil, &dynamodb.ConditionalCheckFailedException{Message_: aws.String("The conditional request failed")}
+	}
+	delete(f.items, pk)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func (f *fakeIdempotencyTable) satisfies(existing map[string]*dynamodb.AttributeValue, condition *string, values map[string]*dynamodb.AttributeValue) bool {
+	switch aws.StringValue(condition) {
+	case "attribute_not_exists(#pk) OR #expires_at < :now":
+		if existing == nil {
+			return true
+		}
+		expires, _ := strconv.ParseInt(aws.StringValue(existing["expires_at"].N), 10, 64)
+		now, _ := strconv.ParseInt(aws.StringValue(values[":now"].N), 10, 64)
+		return expires < now
+	case "#token = :token":
+		return existing != nil && aws.StringValue(existing["token"].S) == aws.StringValue(values[":token"].S)
+	}
+	return true
+}
+
+func TestDynamoDBIdempotencyStore(t *testing.T) {
+	table := &fakeIdempotencyTable{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+	store := idempotency.NewDynamoDBStore(table, "orbit-idempotency")
+	ctx := context.Background()
+
+	now := time.Now()
+	claim := idempotency.Record{
+		Key:         idempotency.Key("alice", "refund-7"),
+		Fingerprint: "f1",
+		Status:      idempotency.StatusInProgress,
+		Token:       "t1",
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(time.Minute),
+	}
+	if _, claimed, err := store.Claim(ctx, claim); err != nil || !claimed {
+		t.Fatalf("Claim failed for a new key: claimed %v, %v", claimed, err)
+	}
+
+	second := claim
+	second.Token = "t2"
+	existing, claimed, err := store.Claim(ctx, second)
+	if err != nil || claimed || existing.Token != "t1" || existing.Status != idempotency.StatusInProgress {
+		t.Errorf("Claim returned wrong result for a claimed key: %+v, claimed %v, %v", existing, claimed, err)
+	}
+
+	if err := store.Complete(ctx, second); !errors.Is(err, idempotency.ErrClaimLost) {
+		t.Errorf("Complete returned wrong error for another claim: got %v want %v", err, idempotency.ErrClaimLost)
+	}
+	claim.Status = idempotency.StatusCompleted
+	claim.Response = &idempotency.Response{StatusCode: http.StatusOK, Body: []byte(`{"status":"success"}`)}
+	if err := store.Complete(ctx, claim); err != nil {
+		t.Fatal(err)
+	}
+	existing, _, _ = store.Claim(ctx, second)
+	if existing.Response == nil || string(existing.Response.Body) != `{"status":"success"}` {
+		t.Errorf("Claim returned wrong stored response: %+v", existing.Response)
+	}
+
+	// An expired record is claimed again, even before TTL deletes it
+	table.items[claim.Key]["expires_at"].N = aws.String(strconv.FormatInt(now.Add(-time.Minute).Unix(), 10))
+	if _, claimed, err := store.Claim(ctx, second); err != nil || !claimed {
+		t.Errorf("Claim failed for an expired key: claimed %v, %v", claimed, err)
+	}
+
+	if err := store.Release(ctx, claim.Key, "t1"); !errors.Is(err, idempotency.ErrClaimLost) {
+		t.Errorf("Release returned wrong error for another claim: got %v want %v", err, idempotency.ErrClaimLost)
+	}
+	if err := store.Release(ctx, claim.Key, "t2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := table.items[claim.Key]; ok {
+		t.Error("Release left the record in the table")
+	}
+}
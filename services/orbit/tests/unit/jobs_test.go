@@ -0,0 +1,410 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+	"orbit-service/contract"
+	"orbit-service/handlers"
+	"orbit-service/jobs"
+	"orbit-service/middleware"
+)
+
+// blockingRunner runs jobs until they are cancelled or released
+type blockingRunner struct {
+	started chan string
+	release chan struct{}
+}
+
+func newBlockingRunner() *blockingRunner {
+	return &blockingRunner{started: make(chan string, 10), release: make(chan struct{})}
+}
+
+func (b *blockingRunner) Run(ctx context.Context, job jobs.Job) (*contract.ReasonResponse, error) {
+	b.started <- job.ID
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-b.release:
+		return &contract.ReasonResponse{Version: contract.Version, Model: "axon-echo-1"}, nil
+	}
+}
+
+func testJobConfig() jobs.Config {
+	config := jobs.DefaultConfig()
+	config.Workers = 1
+	config.QueueSize = 1
+	return config
+}
+
+// waitForJob polls until the job reaches status
+func waitForJob(t *testing.T, pool *jobs.Pool, id, status string) jobs.Job {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		job, err := pool.Get(context.Background(), id)
+		if err != nil {
+			t.Fatalf("Get returned error: %v", err)
+		}
+		if job.Status == status {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job stayed %s, want %s", job.Status, status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestJobPoolRunsJobs(t *testing.T) {
+	runner := newBlockingRunner()
+	pool := jobs.NewPool(zerolog.Nop(), jobs.NewMemoryStore(), testJobConfig(), runner.Run, nil)
+	defer pool.Close()
+
+	job, err := pool.Submit(context.Background(), jobs.Job{Intent: "call_reasoning", Input: json.RawMessage(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ID == "" || job.Status != jobs.StatusQueued {
+		t.Fatalf("Submit returned wrong job: %+v", job)
+	}
+
+	<-runner.started
+	running := waitForJob(t, pool, job.ID, jobs.StatusRunning)
+	if running.StartedAt == nil {
+		t.Error("Running job has no start time")
+	}
+
+	close(runner.release)
+	done := waitForJob(t, pool, job.ID, jobs.StatusSucceeded)
+	if done.Result == nil || done.Result.Model != "axon-echo-1" || done.FinishedAt == nil {
+		t.Errorf("Finished job has wrong result: %+v", done)
+	}
+	if want := done.FinishedAt.Add(jobs.DefaultConfig().ResultTTL); !done.ExpiresAt.Equal(want) {
+		t.Errorf("Finished job has wrong expiry: got %v want %v", done.ExpiresAt, want)
+	}
+}
+
+func TestJobPoolCancelsJobs(t *testing.T) {
+	runner := newBlockingRunner()
+	pool := jobs.NewPool(zerolog.Nop(), jobs.NewMemoryStore(), testJobConfig(), runner.Run, nil)
+	defer pool.Close()
+	defer close(runner.release)
+
+	running, _ := pool.Submit(context.Background(), jobs.Job{})
+	<-runner.started
+	queued, _ := pool.Submit(context.Background(), jobs.Job{})
+
+	// One worker and a queue of one: the pool is full
+	if _, err := pool.Submit(context.Background(), jobs.Job{}); !errors.Is(err, jobs.ErrQueueFull) {
+		t.Errorf("Submit returned wrong error for a full queue: got %v want %v", err, jobs.ErrQueueFull)
+	}
+
+	for _, id := range []string{queued.ID, running.ID} {
+		job, err := pool.Cancel(context.Background(), id)
+		if err != nil || job.Status != jobs.StatusCancelled {
+			t.Fatalf("Cancel returned %+v, %v", job, err)
+		}
+	}
+
+	// The running job's dispatch is aborted and its result discarded; the
+	// queued one never runs
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case id := <-runner.started:
+		t.Errorf("Cancelled job %s was run", id)
+	default:
+	}
+	if job, _ := pool.Get(context.Background(), running.ID); job.Status != jobs.StatusCancelled || job.Error != "" {
+		t.Errorf("Cancelled job was overwritten: %+v", job)
+	}
+
+	var statusErr *jobs.StatusError
+	if _, err := pool.Cancel(context.Background(), running.ID); !errors.As(err, &statusErr) {
+		t.Errorf("Cancel returned wrong error for a finished job: got %v", err)
+	}
+	if _, err := pool.Submit(context.Background(), jobs.Job{}); err != nil {
+		t.Errorf("Submit failed once the queue had room: %v", err)
+	}
+}
+
+func TestJobPoolCloseInterruptsJobs(t *testing.T) {
+	runner := newBlockingRunner()
+	pool := jobs.NewPool(zerolog.Nop(), jobs.NewMemoryStore(), testJobConfig(), runner.Run, nil)
+	defer close(runner.release)
+
+	running, _ := pool.Submit(context.Background(), jobs.Job{})
+	<-runner.started
+	queued, _ := pool.Submit(context.Background(), jobs.Job{})
+
+	// Close returns without waiting for the jobs to be released
+	pool.Close()
+	for _, id := range []string{running.ID, queued.ID} {
+		job, err := pool.Get(context.Background(), id)
+		if err != nil || job.Status != jobs.StatusFailed || job.ErrorCode != jobs.ErrorCodeJobInterrupted {
+			t.Errorf("Close left job with wrong status: got %+v, %v want %s %s", job, err, jobs.StatusFailed, jobs.ErrorCodeJobInterrupted)
+		}
+	}
+	select {
+	case id := <-runner.started:
+		t.Errorf("Queued job %s was run after the pool closed", id)
+	default:
+	}
+}
+
+func TestJobPoolForgetsExpiredResults(t *testing.T) {
+	config := testJobConfig()
+	config.ResultTTL = 100 * time.Millisecond
+	pool := jobs.NewPool(zerolog.Nop(), jobs.NewMemoryStore(), config, func(ctx context.Context, job jobs.Job) (*contract.ReasonResponse, error) {
+		return nil, errors.New("axon unavailable")
+	}, nil)
+	defer pool.Close()
+
+	job, _ := pool.Submit(context.Background(), jobs.Job{})
+	failed := waitForJob(t, pool, job.ID, jobs.StatusFailed)
+	// The raw error is only logged
+	if failed.ErrorCode != jobs.ErrorCodeJobFailed || failed.Error != "Job failed" {
+		t.Errorf("Failed job has wrong error: got %q %q", failed.ErrorCode, failed.Error)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if _, err := pool.Get(context.Background(), job.ID); !errors.Is(err, jobs.ErrNotFound) {
+		t.Errorf("Get returned wrong error for an expired job: got %v want %v", err, jobs.ErrNotFound)
+	}
+}
+
+// fakeJobTable keeps job items in memory, honouring the conditions the
+// DynamoDB store writes with
+type fakeJobTable struct {
+	dynamodbiface.DynamoDBAPI
+
+	mu    sync.Mutex
+	items map[string]map[string]*dynamodb.AttributeValue
+	// beforePut runs before the next put, to simulate another task
+	beforePut func()
+}
+
+func (f *fakeJobTable) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &dynamodb.GetItemOutput{Item: f.items[aws.StringValue(input.Key["pk"].S)]}, nil
+}
+
+func (f *fakeJobTable) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	if hook := f.beforePut; hook != nil {
+		f.beforePut = nil
+		hook()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pk := aws.StringValue(input.Item["pk"].S)
+	existing, exists := f.items[pk]
+	failed := &dynamodb.ConditionalCheckFailedException{Message_: aws.String("The conditional request failed")}
+	switch condition := aws.StringValue(input.ConditionExpression); {
+	case strings.HasPrefix(condition, "attribute_not_exists") && exists:
+		return nil, failed
+	case strings.Contains(condition, ":read") &&
+		(!exists || aws.StringValue(existing["status"].S) != aws.StringValue(input.ExpressionAttributeValues[":read"].S)):
+		return nil, failed
+	}
+	f.items[pk] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func TestDynamoDBJobStore(t *testing.T) {
+	table := &fakeJobTable{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+	store := jobs.NewDynamoDBStore(table, "orbit-jobs")
+	ctx := context.Background()
+
+	job := jobs.Job{
+		ID:         "job-1",
+		Status:     jobs.StatusQueued,
+		Input:      json.RawMessage(`{"q":"status?"}`),
+		Parameters: map[string]interface{}{contract.ParameterMaxTokens: json.Number("64")},
+		ExpiresAt:  time.Unix(1700000000, 0),
+	}
+	if err := store.Create(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Create(ctx, job); err == nil {
+		t.Error("Create accepted a duplicate job ID")
+	}
+	if expires := aws.StringValue(table.items["job-1"]["expires_at"].N); expires != "1700000000" {
+		t.Errorf("Create wrote wrong expires_at: got %s", expires)
+	}
+
+	got, err := store.Get(ctx, "job-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Parameters[contract.ParameterMaxTokens] != json.Number("64") || string(got.Input) != `{"q":"status?"}` {
+		t.Errorf("Get returned wrong job: %+v", got)
+	}
+	if _, err := store.Get(ctx, "job-2"); !errors.Is(err, jobs.ErrNotFound) {
+		t.Errorf("Get returned wrong error for an unknown job: got %v want %v", err, jobs.ErrNotFound)
+	}
+
+	// Another task cancels the job between our read and our write
+	table.beforePut = func() {
+		store.Update(ctx, "job-1", []string{jobs.StatusQueued}, func(j *jobs.Job) { j.Status = jobs.StatusCancelled })
+	}
+	_, err = store.Update(ctx, "job-1", []string{jobs.StatusQueued}, func(j *jobs.Job) { j.Status = jobs.StatusRunning })
+	var statusErr *jobs.StatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != jobs.StatusCancelled {
+		t.Errorf("Update returned wrong error for a concurrently cancelled job: got %v", err)
+	}
+}
+
+// jobServer routes dispatch and job requests as main does
+type jobServer struct {
+	router *mux.Router
+	pool   *jobs.Pool
+	axon   *MockAxonClient
+}
+
+func newJobServer(t *testing.T) *jobServer {
+	s := &jobServer{axon: &MockAxonClient{response: &contract.ReasonResponse{
+		Version: contract.Version,
+		Output:  json.RawMessage(`{"answer":"ok","ssn":"123"}`),
+	}}}
+
+	opts := handlers.DefaultDispatchOptions()
+	logger := zerolog.Nop()
+	s.pool = jobs.NewPool(logger, jobs.NewMemoryStore(), testJobConfig(), handlers.DispatchJobRunner(logger, s.axon, opts), nil)
+	t.Cleanup(s.pool.Close)
+	opts.Jobs = s.pool
+
+	governance := &MockGovernanceClient{allowed: true, obligations: []clients.Obligation{
+		{Type: clients.ObligationRedactFields, Value: []interface{}{"ssn"}},
+	}}
+	s.router = mux.NewRouter()
+	s.router.Use(middleware.CallerMiddleware(middleware.CallerConfig{Auth: middleware.CallerAuthHeaders, IDHeader: "X-Caller-Id"}))
+	s.router.HandleFunc("/dispatch", handlers.DispatchHandlerWithOptions(logger, governance, s.axon, opts)).Methods("POST")
+	s.router.HandleFunc("/jobs/{id}", handlers.JobStatusHandler(logger, s.pool)).Methods("GET")
+	s.router.HandleFunc("/jobs/{id}", handlers.JobCancelHandler(logger, s.pool)).Methods("DELETE")
+	return s
+}
+
+func (s *jobServer) do(t *testing.T, method, path, caller, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Caller-Id", caller)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAsyncDispatch(t *testing.T) {
+	s := newJobServer(t)
+
+	rr := s.do(t, "POST", "/dispatch?mode=async", "alice", `{"input":{"question":"status?","ssn":"123-45-6789"}}`)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Dispatch handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusAccepted, rr.Body.String())
+	}
+	var response handlers.DispatchResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Status != jobs.StatusQueued || response.JobID == "" {
+		t.Fatalf("Dispatch handler returned wrong response: %+v", response)
+	}
+	if location := rr.Header().Get("Location"); location != "/jobs/"+response.JobID {
+		t.Errorf("Dispatch handler returned wrong Location: got %s", location)
+	}
+
+	waitForJob(t, s.pool, response.JobID, jobs.StatusSucceeded)
+	if strings.Contains(string(s.axon.lastRequest.Input), "123-45-6789") {
+		t.Errorf("Redacted input field reached Axon: %s", s.axon.lastRequest.Input)
+	}
+
+	rr = s.do(t, "GET", "/jobs/"+response.JobID, "alice", "")
+	var status handlers.JobStatusResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusOK || status.Status != jobs.StatusSucceeded || status.Result == nil {
+		t.Fatalf("Job status handler returned %v: %s", rr.Code, rr.Body.String())
+	}
+	if strings.Contains(string(status.Result.Output), `"123"`) {
+		t.Errorf("Redacted output field reached the caller: %s", status.Result.Output)
+	}
+
+	if rr := s.do(t, "GET", "/jobs/"+response.JobID, "mallory", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Job status handler returned wrong status code for another caller: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := s.do(t, "DELETE", "/jobs/"+response.JobID, "alice", ""); rr.Code != http.StatusConflict {
+		t.Errorf("Job cancel handler returned wrong status code for a finished job: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	if rr := s.do(t, "GET", "/jobs/unknown", "alice", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Job status handler returned wrong status code for an unknown job: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestAsyncDispatchFailed(t *testing.T) {
+	s := newJobServer(t)
+	s.axon.response = nil
+	s.axon.err = &clients.DownstreamError{Target: "axon", Kind: clients.DownstreamUnreachable, Err: errors.New("dial tcp 10.0.3.7:8081: connection refused")}
+
+	rr := s.do(t, "POST", "/dispatch?mode=async", "alice", `{"input":{}}`)
+	var response handlers.DispatchResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	waitForJob(t, s.pool, response.JobID, jobs.StatusFailed)
+
+	rr = s.do(t, "GET", "/jobs/"+response.JobID, "alice", "")
+	var status handlers.JobStatusResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.ErrorCode != handlers.ErrorCodeDownstreamUnreachable || status.Error != "Downstream service is unreachable" {
+		t.Errorf("Job status handler returned wrong error: got %q %q", status.ErrorCode, status.Error)
+	}
+	if strings.Contains(rr.Body.String(), "10.0.3.7") {
+		t.Errorf("Job status handler leaked the raw error: %s", rr.Body.String())
+	}
+}
+
+func TestAsyncDispatchRefused(t *testing.T) {
+	s := newJobServer(t)
+
+	for _, tt := range []struct {
+		name string
+		path string
+	}{
+		{"unknown mode", "/dispatch?mode=later"},
+		{"streamed", "/dispatch?mode=async&stream=true"},
+	} {
+		if rr := s.do(t, "POST", tt.path, "alice", ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: Dispatch handler returned wrong status code: got %v want %v", tt.name, rr.Code, http.StatusBadRequest)
+		}
+	}
+
+	// Without a job pool, asynchronous dispatches are refused
+	handler := handlers.DispatchHandler(zerolog.Nop(), &MockGovernanceClient{allowed: true}, &MockAxonClient{})
+	req, _ := http.NewRequest("POST", "/dispatch?mode=async", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Dispatch handler without jobs returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
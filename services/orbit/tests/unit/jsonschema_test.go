@@ -0,0 +1,122 @@
+package unit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"orbit-service/jsonschema"
+)
+
+var policySchemaFile = filepath.Join("..", "..", "..", "..", "governance", "policies", "schema.json")
+
+func decodeJSON(t *testing.T, data string) interface{} {
+	t.Helper()
+	decoder := json.NewDecoder(bytes.NewReader([]byte(data)))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestJSONSchemaValidate(t *testing.T) {
+	schema, err := jsonschema.Compile([]byte(`{
+		"type": "object",
+		"required": ["name", "count"],
+		"additionalProperties": false,
+		"properties": {
+			"name": {"type": "string", "minLength": 1, "maxLength": 8, "pattern": "^[a-z]+$"},
+			"count": {"type": "integer", "minimum": 1, "exclusiveMaximum": 10},
+			"mode": {"enum": ["fast", "slow"]},
+			"tags": {"type": "array", "maxItems": 2, "items": {"type": "string"}},
+			"limit": {"oneOf": [{"type": "integer"}, {"type": "null"}]},
+			"labels": {"type": "object", "maxProperties": 1}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		path  string
+	}{
+		{"valid", `{"name": "abc", "count": 3, "mode": "fast", "tags": ["x"], "limit": null}`, ""},
+		{"missing required", `{"name": "abc"}`, ""},
+		{"wrong type", `{"name": 1, "count": 3}`, "/name"},
+		{"pattern", `{"name": "ABC", "count": 3}`, "/name"},
+		{"integer", `{"name": "abc", "count": 1.5}`, "/count"},
+		{"exclusive maximum", `{"name": "abc", "count": 10}`, "/count"},
+		{"enum", `{"name": "abc", "count": 3, "mode": "medium"}`, "/mode"},
+		{"items", `{"name": "abc", "count": 3, "tags": ["x", 2]}`, "/tags/1"},
+		{"max items", `{"name": "abc", "count": 3, "tags": ["x", "y", "z"]}`, "/tags"},
+		{"additional property", `{"name": "abc", "count": 3, "extra": true}`, "/extra"},
+		{"one of", `{"name": "abc", "count": 3, "limit": "none"}`, "/limit"},
+		{"max properties", `{"name": "abc", "count": 3, "labels": {"a": 1, "b": 2}}`, "/labels"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := schema.Validate(decodeJSON(t, tt.value))
+			if tt.name == "valid" {
+				if len(errs) != 0 {
+					t.Errorf("Validate returned unexpected errors: %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 {
+				t.Fatalf("Validate returned wrong errors: got %v", errs)
+			}
+			if errs[0].Path != tt.path {
+				t.Errorf("Validate returned wrong path: got %q want %q", errs[0].Path, tt.path)
+			}
+		})
+	}
+}
+
+func TestJSONSchemaCompileRejectsUnsupportedKeywords(t *testing.T) {
+	_, err := jsonschema.Compile([]byte(`{"properties": {"a": {"$ref": "#/definitions/a"}}}`))
+	if err == nil || !strings.Contains(err.Error(), "/properties/a/$ref") {
+		t.Errorf("Compile returned wrong error: got %v", err)
+	}
+}
+
+func TestPolicySchemaAcceptsDefaultAndGoldenPolicies(t *testing.T) {
+	data, err := os.ReadFile(policySchemaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := jsonschema.Compile(data)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	defaults, err := os.ReadFile(filepath.Join("..", "..", "..", "..", "governance", "policies", "default.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden, err := os.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var suite struct {
+		Policies json.RawMessage `json:"policies"`
+	}
+	if err := json.Unmarshal(golden, &suite); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{string(defaults), string(suite.Policies)} {
+		for i, p := range decodeJSON(t, file).([]interface{}) {
+			if errs := schema.Validate(p); len(errs) != 0 {
+				t.Errorf("policy %d does not match the schema: %v", i, errs)
+			}
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/audit"
+	"orbit-service/clients"
+	"orbit-service/contract"
+	"orbit-service/handlers"
+)
+
+// MockAuditRecorder keeps the entries it is asked to record
+type MockAuditRecorder struct {
+	entries []audit.Entry
+	err     error
+	// stalled recorders wait for the caller to give up, like a recorder
+	// whose queue is full
+	stalled bool
+}
+
+func (m *MockAuditRecorder) Record(ctx context.Context, entry audit.Entry) error {
+	if m.stalled {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if m.err != nil {
+		return m.err
+	}
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+func dispatchWithObligations(t *testing.T, obligations []clients.Obligation, axonClient clients.AxonCaller, opts handlers.DispatchOptions, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	governanceClient := &MockGovernanceClient{allowed: true, obligations: obligations}
+	handler := handlers.DispatchHandlerWithOptions(zerolog.Nop(), governanceClient, axonClient, opts)
+
+	req, err := http.NewRequest("POST", "/dispatch", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestParseObligations(t *testing.T) {
+	parsed, err := clients.ParseObligations([]clients.Obligation{
+		{Type: clients.ObligationMaxPayloadBytes, Value: json.Number("2048")},
+		{Type: clients.ObligationMaxPayloadBytes, Value: float64(1024)},
+		{Type: clients.ObligationMaxReasoningSeconds, Value: 1.5},
+		{Type: clients.ObligationRedactFields, Value: []interface{}{"ssn", "card.number"}},
+		{Type: clients.ObligationAuditLevel, Value: clients.AuditLevelFull},
+		{Type: clients.ObligationAuditLevel, Value: clients.AuditLevelDecision},
+		{Type: clients.ObligationMaxTokens, Value: 256},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.MaxPayloadBytes != 1024 {
+		t.Errorf("ParseObligations returned wrong max payload: got %d want %d", parsed.MaxPayloadBytes, 1024)
+	}
+	if parsed.MaxReasoningTime != 1500*time.Millisecond {
+		t.Errorf("ParseObligations returned wrong max reasoning time: got %v want %v", parsed.MaxReasoningTime, 1500*time.Millisecond)
+	}
+	if len(parsed.RedactFields) != 2 {
+		t.Errorf("ParseObligations returned wrong redact fields: %v", parsed.RedactFields)
+	}
+	if parsed.AuditLevel != clients.AuditLevelFull {
+		t.Errorf("ParseObligations returned wrong audit level: got %q want %q", parsed.AuditLevel, clients.AuditLevelFull)
+	}
+	if parsed.MaxTokens != 256 {
+		t.Errorf("ParseObligations returned wrong max tokens: got %d want %d", parsed.MaxTokens, 256)
+	}
+}
+
+func TestParseObligationsRejectsUnsupported(t *testing.T) {
+	for _, obligation := range []clients.Obligation{
+		{Type: "watermark_output", Value: true},
+		{Type: clients.ObligationMaxTokens, Value: "many"},
+		{Type: clients.ObligationMaxPayloadBytes, Value: 0.5},
+		{Type: clients.ObligationRedactFields, Value: "ssn"},
+		{Type: clients.ObligationAuditLevel, Value: "verbose"},
+	} {
+		_, err := clients.ParseObligations([]clients.Obligation{obligation})
+		var unsupported *clients.UnsupportedObligationError
+		if !errors.As(err, &unsupported) {
+			t.Errorf("ParseObligations accepted %+v", obligation)
+		}
+	}
+}
+
+func TestDispatchHandlerRefusesUnknownObligation(t *testing.T) {
+	axonClient := &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version}}
+	rr := dispatchWithObligations(t, []clients.Obligation{{Type: "watermark_output", Value: true}}, axonClient, handlers.DefaultDispatchOptions(), "")
+
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+	}
+	if axonClient.lastRequest.Version != "" {
+		t.Error("Axon should not be called for an unsupported obligation")
+	}
+}
+
+func TestDispatchHandlerEnforcesMaxPayload(t *testing.T) {
+	axonClient := &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version}}
+	obligations := []clients.Obligation{{Type: clients.ObligationMaxPayloadBytes, Value: 16}}
+
+	rr := dispatchWithObligations(t, obligations, axonClient, handlers.DefaultDispatchOptions(), `{"input":{"question":"far too long for the limit"}}`)
+	if status := rr.Code; status != http.StatusRequestEntityTooLarge {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusRequestEntityTooLarge)
+	}
+
+	rr = dispatchWithObligations(t, obligations, axonClient, handlers.DefaultDispatchOptions(), `{"input":{"q":"short"}}`)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+}
+
+func TestDispatchHandlerRedactsFields(t *testing.T) {
+	axonClient := &MockAxonClient{response: &contract.ReasonResponse{
+		Version: contract.Version,
+		Output:  json.RawMessage(`{"answer":"ok","card":{"number":"4111"}}`),
+	}}
+	obligations := []clients.Obligation{{Type: clients.ObligationRedactFields, Value: []interface{}{"ssn", "card.number"}}}
+
+	rr := dispatchWithObligations(t, obligations, axonClient, handlers.DefaultDispatchOptions(), `{"input":{"question":"q","ssn":"123-45-6789"}}`)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	if strings.Contains(string(axonClient.lastRequest.Input), "123-45-6789") {
+		t.Errorf("Redacted input field reached Axon: %s", axonClient.lastRequest.Input)
+	}
+	if body := rr.Body.String(); strings.Contains(body, "4111") || !strings.Contains(body, clients.RedactedValue) {
+		t.Errorf("Redacted output field reached the caller: %s", body)
+	}
+}
+
+func TestDispatchHandlerPassesMaxTokens(t *testing.T) {
+	axonClient := &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version}}
+	obligations := []clients.Obligation{{Type: clients.ObligationMaxTokens, Value: 128}}
+
+	dispatchWithObligations(t, obligations, axonClient, handlers.DefaultDispatchOptions(), "")
+
+	if got := axonClient.lastRequest.Parameters[contract.ParameterMaxTokens]; got != 128 {
+		t.Errorf("handler sent wrong max tokens parameter: got %v want %v", got, 128)
+	}
+
+	// A caller may ask for fewer tokens than the obligation allows, not more
+	dispatchWithObligations(t, obligations, axonClient, handlers.DefaultDispatchOptions(), `{"parameters":{"max_tokens":64}}`)
+	if got := axonClient.lastRequest.Parameters[contract.ParameterMaxTokens]; got != json.Number("64") {
+		t.Errorf("handler sent wrong max tokens parameter: got %v want %v", got, 64)
+	}
+	dispatchWithObligations(t, obligations, axonClient, handlers.DefaultDispatchOptions(), `{"parameters":{"max_tokens":4096}}`)
+	if got := axonClient.lastRequest.Parameters[contract.ParameterMaxTokens]; got != 128 {
+		t.Errorf("handler sent wrong max tokens parameter: got %v want %v", got, 128)
+	}
+}
+
+func TestDispatchHandlerEnforcesMaxReasoningTime(t *testing.T) {
+	axonClient := &BlockingAxonClient{}
+	obligations := []clients.Obligation{{Type: clients.ObligationMaxReasoningSeconds, Value: 0.05}}
+
+	start := time.Now()
+	rr := dispatchWithObligations(t, obligations, axonClient, handlers.DefaultDispatchOptions(), "")
+
+	if status := rr.Code; status != http.StatusGatewayTimeout {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusGatewayTimeout)
+	}
+	if !axonClient.hasDeadline || axonClient.deadline.After(start.Add(time.Second)) {
+		t.Errorf("Axon stage was not bounded by the obligation: deadline %v", axonClient.deadline)
+	}
+}
+
+func TestDispatchHandlerAuditLevel(t *testing.T) {
+	axonClient := &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version}}
+	obligations := []clients.Obligation{{Type: clients.ObligationAuditLevel, Value: clients.AuditLevelFull}}
+
+	rr := dispatchWithObligations(t, obligations, axonClient, handlers.DefaultDispatchOptions(), "")
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler without an auditor returned wrong status code: got %v want %v", status, http.StatusForbidden)
+	}
+
+	recorder := &MockAuditRecorder{}
+	opts := handlers.DefaultDispatchOptions()
+	opts.Auditor = recorder
+	rr = dispatchWithObligations(t, obligations, axonClient, opts, `{"input":{"question":"q"}}`)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if len(recorder.entries) != 1 {
+		t.Fatalf("handler recorded wrong number of outcomes: got %d want %d", len(recorder.entries), 1)
+	}
+	entry := recorder.entries[0]
+	if entry.Outcome != audit.OutcomeSucceeded || entry.PayloadDigest != audit.PayloadDigest([]byte(`{"question":"q"}`)) {
+		t.Errorf("handler recorded wrong outcome: %+v", entry)
+	}
+
+	recorder.err = errors.New("sink unavailable")
+	rr = dispatchWithObligations(t, obligations, axonClient, opts, "")
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code for an unrecorded outcome: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+
+	// A backed-up auditor refuses the dispatch instead of holding it
+	opts.Auditor = &MockAuditRecorder{stalled: true}
+	opts.AuditTimeout = 20 * time.Millisecond
+	rr = dispatchWithObligations(t, obligations, axonClient, opts, "")
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code for a stalled auditor: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+}
+
+func TestAxonClientEnforcesMaxTokens(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(contract.ReasonResponse{
+			Version: contract.Version,
+			Output:  json.RawMessage(`{"answer":"ok"}`),
+			Usage:   contract.Usage{InputTokens: 3, OutputTokens: 40, TotalTokens: 43},
+		})
+	}))
+	defer server.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "test-access-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test-secret-key")
+	t.Setenv("AXON_SERVICE_URL", server.URL+"/reason")
+
+	client, err := clients.NewAxonClient(zerolog.Nop())
+	if err != nil {
+		t.Fatalf("Failed to create axon client: %v", err)
+	}
+
+	_, err = client.CallReason(context.Background(), contract.ReasonRequest{
+		Input:      json.RawMessage(`{}`),
+		Parameters: map[string]interface{}{contract.ParameterMaxTokens: 10},
+	}, "test-correlation-id")
+
+	var violation *clients.ObligationViolationError
+	if !errors.As(err, &violation) {
+		t.Errorf("CallReason returned wrong error: got %v want an obligation violation", err)
+	}
+}
@@ -0,0 +1,285 @@
+package unit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"orbit-service/contract"
+	"orbit-service/handlers"
+	"orbit-service/jobs"
+	"orbit-service/middleware"
+)
+
+// recordingStage records its hooks in calls, short-circuiting the hooks
+// named in refuse
+type recordingStage struct {
+	name   string
+	calls  *[]string
+	refuse map[string]bool
+}
+
+func (s recordingStage) Before(w http.ResponseWriter, d *handlers.Dispatch) bool {
+	*s.calls = append(*s.calls, s.name+".before")
+	if s.refuse["before"] {
+		return d.Refuse(w, http.StatusForbidden, s.name+" refused")
+	}
+	return true
+}
+
+func (s recordingStage) After(w http.ResponseWriter, d *handlers.Dispatch) bool {
+	*s.calls = append(*s.calls, s.name+".after")
+	if s.refuse["after"] {
+		return d.Fail(w, http.StatusBadGateway, "guardrail_failed", s.name+" failed")
+	}
+	return true
+}
+
+func pipelineOptions(stages ...handlers.Stage) handlers.DispatchOptions {
+	opts := handlers.DefaultDispatchOptions()
+	opts.Pipeline = []string{"validate", "tenant", "governance", "obligations", "rate_limit", "guardrail", "redaction", "audit", "async", "stream"}
+	opts.Stages = map[string]handlers.Stage{"tenant": stages[0], "guardrail": stages[1]}
+	return opts
+}
+
+func sendDispatch(handler http.Handler) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/dispatch", strings.NewReader(`{"input":{"q":"hello"}}`))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.CorrelationIDKey, "test-correlation-id"))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+// stageTimings returns the stage timings and halting stage of the
+// dispatch_stage_timings log line
+func stageTimings(t *testing.T, logs *bytes.Buffer) (map[string]interface{}, interface{}) {
+	t.Helper()
+	scanner := bufio.NewScanner(logs)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		if line["message"] == "dispatch_stage_timings" {
+			return line["stages"].(map[string]interface{}), line["halted_by"]
+		}
+	}
+	t.Fatal("Dispatch handler did not log its stage timings")
+	return nil, nil
+}
+
+func TestDispatchPipelineRunsCustomStages(t *testing.T) {
+	var calls []string
+	var logs bytes.Buffer
+	axonClient := &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version, Output: json.RawMessage(`{"a":1}`)}}
+	opts := pipelineOptions(
+		recordingStage{name: "tenant", calls: &calls},
+		handlers.StageFuncs{AfterFunc: func(w http.ResponseWriter, d *handlers.Dispatch) bool {
+			calls = append(calls, "guardrail.after")
+			d.Result.Output = json.RawMessage(`{"a":"checked"}`)
+			return true
+		}},
+	)
+	handler := handlers.DispatchHandlerWithOptions(zerolog.New(&logs), &MockGovernanceClient{allowed: true}, axonClient, opts)
+
+	rr := sendDispatch(handler)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Dispatch handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	want := []string{"tenant.before", "guardrail.after", "tenant.after"}
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Errorf("Dispatch handler ran wrong hooks: got %v want %v", calls, want)
+	}
+
+	var response handlers.DispatchResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if string(response.Result.Output) != `{"a":"checked"}` {
+		t.Errorf("Dispatch handler returned wrong result: got %s want %s", response.Result.Output, `{"a":"checked"}`)
+	}
+
+	timings, halted := stageTimings(t, &logs)
+	for _, name := range []string{"validate", "tenant", "governance", "call", "guardrail_after", "audit_after"} {
+		if _, ok := timings[name]; !ok {
+			t.Errorf("Dispatch handler did not log the timing of %s: %v", name, timings)
+		}
+	}
+	if halted != nil {
+		t.Errorf("Dispatch handler logged wrong halting stage: got %v want none", halted)
+	}
+}
+
+func TestDispatchPipelineShortCircuits(t *testing.T) {
+	tests := []struct {
+		name      string
+		tenant    map[string]bool
+		guardrail map[string]bool
+		status    int
+		calls     []string
+		called    bool
+	}{
+		{
+			name:   "before",
+			tenant: map[string]bool{"before": true},
+			status: http.StatusForbidden,
+			calls:  []string{"tenant.before"},
+		},
+		{
+			name:      "after",
+			guardrail: map[string]bool{"after": true},
+			status:    http.StatusBadGateway,
+			calls:     []string{"tenant.before", "guardrail.before", "guardrail.after"},
+			called:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		var calls []string
+		var logs bytes.Buffer
+		governanceClient := &MockGovernanceClient{allowed: true}
+		axonClient := &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version}}
+		opts := pipelineOptions(
+			recordingStage{name: "tenant", calls: &calls, refuse: tt.tenant},
+			recordingStage{name: "guardrail", calls: &calls, refuse: tt.guardrail},
+		)
+		handler := handlers.DispatchHandlerWithOptions(zerolog.New(&logs), governanceClient, axonClient, opts)
+
+		rr := sendDispatch(handler)
+		if rr.Code != tt.status {
+			t.Errorf("%s: Dispatch handler returned wrong status code: got %v want %v", tt.name, rr.Code, tt.status)
+		}
+		if fmt.Sprint(calls) != fmt.Sprint(tt.calls) {
+			t.Errorf("%s: Dispatch handler ran wrong hooks: got %v want %v", tt.name, calls, tt.calls)
+		}
+		if called := axonClient.lastRequest.Version != ""; called != tt.called {
+			t.Errorf("%s: Dispatch handler called Axon: got %v want %v", tt.name, called, tt.called)
+		}
+		if tt.name == "before" && governanceClient.lastRequest.Service != "" {
+			t.Errorf("%s: Dispatch handler checked governance after the dispatch was refused", tt.name)
+		}
+
+		wantHalted := map[string]string{"before": "tenant", "after": "guardrail"}[tt.name]
+		if _, halted := stageTimings(t, &logs); halted != wantHalted {
+			t.Errorf("%s: Dispatch handler logged wrong halting stage: got %v want %v", tt.name, halted, wantHalted)
+		}
+	}
+}
+
+func TestValidatePipeline(t *testing.T) {
+	custom := map[string]handlers.Stage{"tenant": handlers.StageFuncs{}}
+	tests := []struct {
+		name     string
+		pipeline []string
+		stages   map[string]handlers.Stage
+		valid    bool
+	}{
+		{"default", nil, nil, true},
+		{"custom stage", []string{"validate", "tenant", "governance", "obligations", "rate_limit", "redaction", "audit", "async", "stream"}, custom, true},
+		{"custom stage last", append(append([]string{}, handlers.DefaultPipeline...), "tenant"), custom, true},
+		{"unknown stage", append(append([]string{}, handlers.DefaultPipeline...), "tenant"), nil, false},
+		{"validate not first", []string{"tenant", "validate", "governance", "obligations", "rate_limit", "redaction", "audit", "async", "stream"}, custom, false},
+		{"out of order", []string{"validate", "obligations", "governance", "rate_limit", "redaction", "audit", "async", "stream"}, nil, false},
+		{"missing stage", []string{"validate", "governance", "obligations", "redaction", "audit", "async", "stream"}, nil, false},
+		{"duplicate stage", []string{"validate", "tenant", "governance", "tenant", "obligations", "rate_limit", "redaction", "audit", "async", "stream"}, custom, false},
+		{"built-in name", nil, map[string]handlers.Stage{"audit": handlers.StageFuncs{}}, false},
+	}
+
+	for _, tt := range tests {
+		opts := handlers.DefaultDispatchOptions()
+		opts.Pipeline = tt.pipeline
+		opts.Stages = tt.stages
+		if err := opts.ValidatePipeline(); (err == nil) != tt.valid {
+			t.Errorf("%s: ValidatePipeline returned wrong result: got %v want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestDispatchOptionsFromEnvPipeline(t *testing.T) {
+	t.Setenv("DISPATCH_PIPELINE", "validate, governance,obligations,rate_limit,redaction,audit,async,stream")
+
+	opts, err := handlers.DispatchOptionsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(opts.Pipeline) != fmt.Sprint(handlers.DefaultPipeline) {
+		t.Errorf("DispatchOptionsFromEnv returned wrong pipeline: got %v want %v", opts.Pipeline, handlers.DefaultPipeline)
+	}
+	if err := opts.ValidatePipeline(); err != nil {
+		t.Errorf("ValidatePipeline rejected the configured pipeline: %v", err)
+	}
+}
+
+func TestDispatchPipelineStreamRunsAfterHooks(t *testing.T) {
+	var calls []string
+	var result *contract.ReasonResponse
+	axonClient := &MockStreamingAxonClient{events: []contract.StreamEvent{
+		{ID: 1, Type: contract.EventDelta, Delta: "hello"},
+		{ID: 2, Type: contract.EventDone, Status: "succeeded", Response: &contract.ReasonResponse{Version: contract.Version, Output: json.RawMessage(`{"a":1}`)}},
+	}}
+	opts := pipelineOptions(
+		recordingStage{name: "tenant", calls: &calls},
+		handlers.StageFuncs{AfterFunc: func(w http.ResponseWriter, d *handlers.Dispatch) bool {
+			calls = append(calls, "guardrail.after")
+			result = d.Result
+			return d.Fail(w, http.StatusBadGateway, "guardrail_failed", "guardrail failed")
+		}},
+	)
+	handler := handlers.DispatchHandlerWithOptions(zerolog.Nop(), &MockGovernanceClient{allowed: true}, axonClient, opts)
+
+	req, _ := http.NewRequest("POST", "/dispatch?stream=true", strings.NewReader(`{"input":{"q":"hello"}}`))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.CorrelationIDKey, "test-correlation-id"))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	want := []string{"tenant.before", "guardrail.after"}
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Errorf("Dispatch handler ran wrong hooks for a stream: got %v want %v", calls, want)
+	}
+	if result == nil || string(result.Output) != `{"a":1}` {
+		t.Errorf("Dispatch handler gave After hooks wrong stream result: got %+v", result)
+	}
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != contract.StreamContentType {
+		t.Errorf("Dispatch handler replaced the sent stream: got %v %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if events := decodeSSE(t, rr.Body.String()); len(events) != 2 {
+		t.Errorf("Dispatch handler relayed wrong number of events: got %d want 2", len(events))
+	}
+}
+
+func TestDispatchJobRunnerRunsCustomStages(t *testing.T) {
+	var calls []string
+	axonClient := &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version}}
+	job := jobs.Job{ID: "job-1", CorrelationID: "test-correlation-id", Principal: "alice", Service: "orbit", Intent: "reason", Input: json.RawMessage(`{"q":"hello"}`)}
+
+	runner := handlers.DispatchJobRunner(zerolog.Nop(), axonClient, pipelineOptions(
+		recordingStage{name: "tenant", calls: &calls},
+		recordingStage{name: "guardrail", calls: &calls},
+	))
+	if _, err := runner(context.Background(), job); err != nil {
+		t.Fatalf("Job runner returned an error: %v", err)
+	}
+	want := []string{"tenant.before", "guardrail.before", "guardrail.after", "tenant.after"}
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Errorf("Job runner ran wrong hooks: got %v want %v", calls, want)
+	}
+
+	calls = nil
+	runner = handlers.DispatchJobRunner(zerolog.Nop(), axonClient, pipelineOptions(
+		recordingStage{name: "tenant", calls: &calls},
+		recordingStage{name: "guardrail", calls: &calls, refuse: map[string]bool{"after": true}},
+	))
+	_, err := runner(context.Background(), job)
+	var failure *jobs.Failure
+	if !errors.As(err, &failure) || failure.Code != "guardrail_failed" || failure.Title != "guardrail failed" {
+		t.Errorf("Job runner returned wrong failure for a failed stage: got %#v", err)
+	}
+}
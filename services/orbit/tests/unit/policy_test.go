@@ -0,0 +1,416 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/audit"
+	"orbit-service/clients"
+	"orbit-service/policy"
+)
+
+// goldenFile is shared with the governance Lambda's test suite
+var goldenFile = filepath.Join("..", "..", "..", "..", "governance", "policies", "golden", "cases.json")
+
+type goldenCase struct {
+	Name    string                 `json:"name"`
+	Service string                 `json:"service"`
+	Intent  string                 `json:"intent"`
+	Hour    int                    `json:"hour"`
+	Context map[string]interface{} `json:"context"`
+	Allowed bool                   `json:"allowed"`
+	Reason  string                 `json:"reason"`
+
+	RequiresApproval bool `json:"requires_approval"`
+}
+
+func TestPolicyEngineGoldenCases(t *testing.T) {
+	data, err := os.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var golden struct {
+		Policies json.RawMessage `json:"policies"`
+		Cases    []goldenCase    `json:"cases"`
+	}
+	if err := json.Unmarshal(data, &golden); err != nil {
+		t.Fatal(err)
+	}
+
+	policies, err := policy.Parse(golden.Policies)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	engine, err := policy.NewEngine(zerolog.Nop(), policies)
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+
+	for _, tc := range golden.Cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			now := time.Date(2024, 1, 1, tc.Hour, 30, 0, 0, time.UTC)
+			decision := engine.Evaluate(tc.Service, tc.Intent, tc.Context, now)
+
+			if decision.Allowed != tc.Allowed {
+				t.Errorf("Evaluate returned wrong allowed: got %v want %v", decision.Allowed, tc.Allowed)
+			}
+			if decision.Reason != tc.Reason {
+				t.Errorf("Evaluate returned wrong reason: got %q want %q", decision.Reason, tc.Reason)
+			}
+			if decision.RequiresApproval != tc.RequiresApproval {
+				t.Errorf("Evaluate returned wrong requires approval: got %v want %v", decision.RequiresApproval, tc.RequiresApproval)
+			}
+		})
+	}
+}
+
+func TestPolicyEngineLoadsDefaultPolicies(t *testing.T) {
+	policies, err := policy.LoadFile(filepath.Join("..", "..", "..", "..", "governance", "policies", "default.json"))
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	engine, err := policy.NewEngine(zerolog.Nop(), policies)
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+
+	req := clients.GovernanceRequest{
+		Service: "orbit",
+		Intent:  "call_reasoning",
+		Context: map[string]interface{}{"user_type": "active", "hour": 12},
+	}
+	decision, err := engine.CheckPermission(context.Background(), req, "test-correlation-id")
+	if err != nil {
+		t.Fatalf("CheckPermission failed: %v", err)
+	}
+	if !decision.Allowed {
+		t.Errorf("CheckPermission returned wrong decision: got %+v", decision)
+	}
+}
+
+func TestPolicyParseRejectsInvalidPolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies string
+	}{
+		{"missing enabled", `[{"service":"orbit","intent":"call_reasoning"}]`},
+		{"hour out of range", `[{"service":"orbit","intent":"call_reasoning","enabled":true,"time_restrictions":{"allowed_hours":[24]}}]`},
+		{"unknown operator", `[{"service":"orbit","intent":"call_reasoning","enabled":true,"conditions":[{"type":"context_check","field":"a","operator":"matches","value":"b"}]}]`},
+		{"object value", `[{"service":"orbit","intent":"call_reasoning","enabled":true,"conditions":[{"type":"context_check","field":"a","operator":"equals","value":{}}]}]`},
+		{"obligation without type", `[{"service":"orbit","intent":"call_reasoning","enabled":true,"obligations":[{"value":1}]}]`},
+		{"not an array", `{"service":"orbit"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := policy.Parse([]byte(tt.policies)); err == nil {
+				t.Error("Expected Parse to reject the policies")
+			}
+		})
+	}
+}
+
+func TestPolicyEngineRejectsDuplicatePolicies(t *testing.T) {
+	policies, err := policy.Parse([]byte(`[
+		{"service":"orbit","intent":"call_reasoning","enabled":true},
+		{"service":"orbit","intent":"call_reasoning","enabled":false}
+	]`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if _, err := policy.NewEngine(zerolog.Nop(), policies); err == nil {
+		t.Error("Expected NewEngine to reject duplicate policies")
+	}
+}
+
+func TestPolicyEngineReturnsObligations(t *testing.T) {
+	policies, err := policy.Parse([]byte(`[
+		{"service":"orbit","intent":"call_reasoning","enabled":true,
+		 "obligations":[{"type":"max_payload_bytes","value":4096},{"type":"redact_fields","value":["ssn"]}]}
+	]`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	engine, err := policy.NewEngine(zerolog.Nop(), policies)
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+
+	response, err := engine.CheckPermission(context.Background(), clients.GovernanceRequest{Service: "orbit", Intent: "call_reasoning"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := clients.ParseObligations(response.Obligations)
+	if err != nil {
+		t.Fatalf("ParseObligations failed: %v", err)
+	}
+	if parsed.MaxPayloadBytes != 4096 || len(parsed.RedactFields) != 1 {
+		t.Errorf("CheckPermission returned wrong obligations: %+v", parsed)
+	}
+}
+
+func TestPolicyEngineExplainsDecision(t *testing.T) {
+	policies, err := policy.Parse([]byte(`[
+		{"service":"orbit","intent":"call_reasoning","enabled":true,
+		 "time_restrictions":{"allowed_hours":[9,10]},
+		 "conditions":[
+			{"type":"context_check","field":"tier","operator":"greater_than","value":1,"description":"Tier above 1"},
+			{"type":"context_check","field":"user_type","operator":"not_equals","value":"blocked","description":"Not blocked"}
+		 ]}
+	]`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	engine, err := policy.NewEngine(zerolog.Nop(), policies)
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	decision, trace := engine.Explain("orbit", "call_reasoning", map[string]interface{}{"tier": 2, "user_type": "blocked"}, now)
+	if decision.Allowed || decision.Reason != "Condition not met: Not blocked" {
+		t.Errorf("Explain returned wrong decision: got %+v", decision)
+	}
+
+	wantChecks := []string{"policy", "enabled", "time_restrictions", "condition[0]", "condition[1]"}
+	wantPassed := []bool{true, true, true, true, false}
+	if len(trace) != len(wantChecks) {
+		t.Fatalf("Explain returned wrong trace: got %+v", trace)
+	}
+	for i, step := range trace {
+		if step.Check != wantChecks[i] || step.Passed != wantPassed[i] {
+			t.Errorf("Explain returned wrong step %d: got %+v want %s passed=%v", i, step, wantChecks[i], wantPassed[i])
+		}
+	}
+
+	if evaluated := engine.Evaluate("orbit", "call_reasoning", map[string]interface{}{"tier": 2, "user_type": "blocked"}, now); evaluated.Reason != decision.Reason {
+		t.Errorf("Evaluate and Explain disagree: got %q want %q", evaluated.Reason, decision.Reason)
+	}
+}
+
+func TestPolicyLint(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies string
+		severity string
+		message  string
+	}{
+		{"duplicate pair", `[{"service":"a","intent":"b","enabled":true},{"service":"a","intent":"b","enabled":false}]`, policy.SeverityError, "duplicates policy 0"},
+		{"empty hours", `[{"service":"a","intent":"b","enabled":true,"time_restrictions":{"allowed_hours":[]}}]`, policy.SeverityWarning, "allows every hour"},
+		{"repeated hour", `[{"service":"a","intent":"b","enabled":true,"time_restrictions":{"allowed_hours":[9,9]}}]`, policy.SeverityWarning, "listed more than once"},
+		{"minute limit above hour limit", `[{"service":"a","intent":"b","enabled":true,"rate_limits":{"requests_per_minute":100,"requests_per_hour":10}}]`, policy.SeverityWarning, "never reached"},
+		{"conflicting equals", `[{"service":"a","intent":"b","enabled":true,"conditions":[
+			{"type":"context_check","field":"tier","operator":"equals","value":1},
+			{"type":"context_check","field":"tier","operator":"equals","value":2}]}]`, policy.SeverityError, "conflict"},
+		{"equals and not equals", `[{"service":"a","intent":"b","enabled":true,"conditions":[
+			{"type":"context_check","field":"tier","operator":"not_equals","value":"gold"},
+			{"type":"context_check","field":"tier","operator":"equals","value":"gold"}]}]`, policy.SeverityError, "conflict"},
+		{"empty range", `[{"service":"a","intent":"b","enabled":true,"conditions":[
+			{"type":"context_check","field":"age","operator":"greater_than","value":30},
+			{"type":"context_check","field":"age","operator":"less_than","value":18}]}]`, policy.SeverityError, "conflict"},
+		{"ordering against string", `[{"service":"a","intent":"b","enabled":true,"conditions":[
+			{"type":"context_check","field":"age","operator":"less_than","value":"18"}]}]`, policy.SeverityError, "non-numeric"},
+		{"unsupported obligation", `[{"service":"a","intent":"b","enabled":true,"obligations":[{"type":"max_widgets","value":1}]}]`, policy.SeverityWarning, "max_widgets"},
+		{"invalid policy", `[{"service":"a","intent":"b"}]`, policy.SeverityError, "enabled"},
+		{"undecodable policy", `[{"service":"a","intent":"b","enabled":"yes"}]`, policy.SeverityError, "cannot be decoded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings, err := policy.LintDocument([]byte(tt.policies))
+			if err != nil {
+				t.Fatalf("LintDocument failed: %v", err)
+			}
+			if len(findings) != 1 {
+				t.Fatalf("LintDocument returned wrong findings: got %v", findings)
+			}
+			if findings[0].Severity != tt.severity || !strings.Contains(findings[0].Message, tt.message) {
+				t.Errorf("LintDocument returned wrong finding: got %v want %s containing %q", findings[0], tt.severity, tt.message)
+			}
+		})
+	}
+}
+
+func TestPolicyLintAcceptsDefaultPolicies(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "..", "..", "governance", "policies", "default.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings, err := policy.LintDocument(data)
+	if err != nil {
+		t.Fatalf("LintDocument failed: %v", err)
+	}
+	if len(findings) != 0 {
+		t.Errorf("LintDocument reported findings for the default policies: %v", findings)
+	}
+}
+
+func TestPolicySuiteRunsGoldenCases(t *testing.T) {
+	data, err := os.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	suite, err := policy.ParseSuite(data)
+	if err != nil {
+		t.Fatalf("ParseSuite failed: %v", err)
+	}
+	policies, err := policy.Parse(suite.Policies)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	engine, err := policy.NewEngine(zerolog.Nop(), policies)
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+
+	for _, result := range suite.Run(engine) {
+		if !result.Passed() {
+			t.Errorf("case %q failed: %v", result.Case.Name, result.Failures)
+		}
+	}
+}
+
+func TestPolicySuiteReportsFailures(t *testing.T) {
+	suite, err := policy.ParseSuite([]byte(`{
+		"policies": "default.json",
+		"cases": [
+			{"name": "wrong expectation", "service": "orbit", "intent": "call_reasoning", "context": {"user_type": "blocked"}, "allowed": true, "reason": "Request authorized"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("ParseSuite failed: %v", err)
+	}
+	if path, ok := suite.PolicyPath(); !ok || path != "default.json" {
+		t.Errorf("PolicyPath returned wrong path: got %q, %v", path, ok)
+	}
+
+	policies, err := policy.LoadFile(filepath.Join("..", "..", "..", "..", "governance", "policies", "default.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := policy.NewEngine(zerolog.Nop(), policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := suite.Run(engine)
+	if len(results) != 1 || results[0].Passed() {
+		t.Fatalf("Run returned wrong results: got %+v", results)
+	}
+	if len(results[0].Failures) != 2 || len(results[0].Trace) == 0 {
+		t.Errorf("Run returned wrong failures: got %v", results[0].Failures)
+	}
+}
+
+func TestPolicyParseSuiteRejectsInvalidSuites(t *testing.T) {
+	tests := []struct {
+		name  string
+		suite string
+	}{
+		{"no cases", `{"cases": []}`},
+		{"missing allowed", `{"cases": [{"name": "a", "service": "orbit", "intent": "call_reasoning"}]}`},
+		{"unknown field", `{"cases": [{"name": "a", "service": "orbit", "intent": "call_reasoning", "allowed": true, "alowed": true}]}`},
+		{"hour out of range", `{"cases": [{"name": "a", "service": "orbit", "intent": "call_reasoning", "allowed": true, "hour": 24}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := policy.ParseSuite([]byte(tt.suite)); err == nil {
+				t.Error("Expected ParseSuite to reject the suite")
+			}
+		})
+	}
+}
+
+func TestPolicyImpactAnalysisGroupsChangedDecisions(t *testing.T) {
+	load := func(data string) *policy.Engine {
+		t.Helper()
+		policies, err := policy.Parse([]byte(data))
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		engine, err := policy.NewEngine(zerolog.Nop(), policies)
+		if err != nil {
+			t.Fatalf("NewEngine failed: %v", err)
+		}
+		return engine
+	}
+	current := load(`[
+		{"service":"orbit","intent":"call_reasoning","enabled":true,
+		 "conditions":[{"type":"context_check","field":"tier","operator":"greater_than","value":0,"description":"Paying tier"}]},
+		{"service":"orbit","intent":"export","enabled":false}
+	]`)
+	candidate := load(`[
+		{"service":"orbit","intent":"call_reasoning","enabled":true,
+		 "conditions":[{"type":"context_check","field":"tier","operator":"greater_than","value":1,"description":"Premium tier"}]},
+		{"service":"orbit","intent":"export","enabled":true}
+	]`)
+
+	// Recorded by an Orbit keeping contexts, interleaved with service logs
+	// and a dispatch outcome
+	var buf bytes.Buffer
+	config := audit.DefaultRecorderConfig()
+	config.RecordContext = true
+	recorder := audit.NewRecorder(zerolog.Nop(), audit.NewJSONSink(&buf), config, nil)
+	at := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	entries := []audit.Entry{
+		{CorrelationID: "tier-1a", Intent: "call_reasoning", Context: map[string]interface{}{"tier": 1}},
+		{CorrelationID: "tier-1b", Intent: "call_reasoning", Context: map[string]interface{}{"tier": 1}},
+		{CorrelationID: "tier-1c", Intent: "call_reasoning", Context: map[string]interface{}{"tier": 1}},
+		{CorrelationID: "tier-2", Intent: "call_reasoning", Context: map[string]interface{}{"tier": 2}},
+		{CorrelationID: "export", Intent: "export"},
+		{CorrelationID: "tier-2", Intent: "call_reasoning", Outcome: audit.OutcomeSucceeded},
+		{CorrelationID: "digest-only", Intent: "call_reasoning", ContextDigest: audit.ContextDigest(map[string]interface{}{"tier": 1})},
+	}
+	for _, entry := range entries {
+		entry.Timestamp, entry.Service, entry.Decision = at, "orbit", audit.DecisionAllow
+		if err := recorder.Record(context.Background(), entry); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+	recorder.Close()
+	logs := append([]byte(`{"level":"info","message":"request_started"}`+"\n"), buf.Bytes()...)
+
+	analysis := policy.NewImpactAnalysis(current, candidate, 2)
+	skipped := 0
+	err := audit.ReadRecords(bytes.NewReader(logs), func(record audit.Record) error {
+		if req, ok := policy.RequestFromRecord(record); ok {
+			analysis.Add(req)
+		} else if record.Outcome == "" {
+			skipped++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadRecords failed: %v", err)
+	}
+
+	report := analysis.Report()
+	if report.Replayed != 5 || report.Changed() != 4 || skipped != 1 {
+		t.Errorf("Report returned wrong totals: got replayed=%d changed=%d skipped=%d want 5, 4, 1", report.Replayed, report.Changed(), skipped)
+	}
+	want := []policy.Change{
+		{Service: "orbit", Intent: "call_reasoning", From: "allow", To: "deny", Cause: "condition[0]: Condition not met: Premium tier", Count: 3, Samples: []string{"tier-1a", "tier-1b"}},
+		{Service: "orbit", Intent: "export", From: "deny", To: "allow", Cause: "enabled: Policy is disabled", Count: 1, Samples: []string{"export"}},
+	}
+	if len(report.Changes) != len(want) {
+		t.Fatalf("Report returned wrong changes: got %+v", report.Changes)
+	}
+	for i, change := range report.Changes {
+		w := want[i]
+		if change.Service != w.Service || change.Intent != w.Intent || change.From != w.From || change.To != w.To ||
+			change.Cause != w.Cause || change.Count != w.Count || strings.Join(change.Samples, ",") != strings.Join(w.Samples, ",") {
+			t.Errorf("Report returned wrong change %d: got %+v want %+v", i, change, w)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+	"orbit-service/contract"
+	"orbit-service/handlers"
+	"orbit-service/middleware"
+)
+
+func newTestDownstreamClient(url string) *clients.DownstreamClient {
+	target := clients.DefaultTarget("axon", url)
+	target.Timeout = 50 * time.Millisecond
+	target.MaxAttempts = 1
+	target.BreakerMaxFailures = 100
+	return clients.NewDownstreamClient(zerolog.Nop(), target, clients.Credentials{
+		AccessKeyID:     "test-access-key",
+		SecretAccessKey: "test-secret-key",
+		Region:          "us-east-1",
+	})
+}
+
+func TestDownstreamClientErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/throttled":
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/unauthorized":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/garbled":
+			w.Write([]byte("not json"))
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		url        string
+		kind       string
+		statusCode int
+		retryAfter time.Duration
+	}{
+		{server.URL + "/throttled", clients.DownstreamStatus, http.StatusTooManyRequests, 7 * time.Second},
+		{server.URL + "/unauthorized", clients.DownstreamStatus, http.StatusUnauthorized, 0},
+		{server.URL + "/garbled", clients.DownstreamInvalidResponse, 0, 0},
+		{server.URL + "/slow", clients.DownstreamTimeout, 0, 0},
+		{closed.URL, clients.DownstreamUnreachable, 0, 0},
+	}
+	for _, tt := range tests {
+		client := newTestDownstreamClient(tt.url)
+		_, err := client.CallReason(context.Background(), contract.ReasonRequest{}, "test-correlation-id")
+
+		var downstream *clients.DownstreamError
+		if !errors.As(err, &downstream) {
+			t.Errorf("%s: CallReason returned an untyped error: %v", tt.url, err)
+			continue
+		}
+		if downstream.Kind != tt.kind || downstream.StatusCode != tt.statusCode || downstream.RetryAfter != tt.retryAfter {
+			t.Errorf("%s: CallReason returned wrong error: got %+v want kind %s, status %d, retry after %v", tt.url, downstream, tt.kind, tt.statusCode, tt.retryAfter)
+		}
+		if downstream.Target != "axon" {
+			t.Errorf("%s: CallReason returned wrong target: got %v want %v", tt.url, downstream.Target, "axon")
+		}
+	}
+}
+
+func TestDownstreamClientBreakerOpen(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	target := clients.DefaultTarget("axon", server.URL)
+	target.MaxAttempts = 1
+	target.BreakerMaxFailures = 1
+	target.BreakerResetTimeout = 10 * time.Second
+	client := clients.NewDownstreamClient(zerolog.Nop(), target, clients.Credentials{AccessKeyID: "k", SecretAccessKey: "s", Region: "us-east-1"})
+
+	client.CallReason(context.Background(), contract.ReasonRequest{}, "test-correlation-id")
+	_, err := client.CallReason(context.Background(), contract.ReasonRequest{}, "test-correlation-id")
+
+	var downstream *clients.DownstreamError
+	if !errors.As(err, &downstream) || downstream.Kind != clients.DownstreamBreakerOpen {
+		t.Fatalf("CallReason returned wrong error with the breaker open: %v", err)
+	}
+	if !errors.Is(err, clients.ErrBreakerOpen) {
+		t.Errorf("CallReason error does not wrap %v", clients.ErrBreakerOpen)
+	}
+	if downstream.RetryAfter <= 9*time.Second || downstream.RetryAfter > 10*time.Second {
+		t.Errorf("CallReason returned wrong retry after: got %v want about %v", downstream.RetryAfter, target.BreakerResetTimeout)
+	}
+}
+
+func TestDownstreamClientRetries(t *testing.T) {
+	tests := []struct {
+		name        string
+		statuses    []int
+		retryAfter  string
+		maxFailures int
+		calls       int
+		kind        string
+	}{
+		{name: "client error", statuses: []int{http.StatusBadRequest}, maxFailures: 1, calls: 1, kind: clients.DownstreamStatus},
+		{name: "server error", statuses: []int{http.StatusServiceUnavailable, http.StatusOK}, maxFailures: 5, calls: 2},
+		{name: "breaker opened between attempts", statuses: []int{http.StatusInternalServerError}, maxFailures: 1, calls: 1, kind: clients.DownstreamBreakerOpen},
+		{name: "retry after past the deadline", statuses: []int{http.StatusTooManyRequests}, retryAfter: "5", maxFailures: 5, calls: 1, kind: clients.DownstreamStatus},
+	}
+	for _, tt := range tests {
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			status := tt.statuses[len(tt.statuses)-1]
+			if calls < len(tt.statuses) {
+				status = tt.statuses[calls]
+			}
+			calls++
+			if tt.retryAfter != "" {
+				w.Header().Set("Retry-After", tt.retryAfter)
+			}
+			w.WriteHeader(status)
+			if status == http.StatusOK {
+				json.NewEncoder(w).Encode(contract.ReasonResponse{Version: contract.Version})
+			}
+		}))
+
+		target := clients.DefaultTarget("axon", server.URL)
+		target.MaxAttempts = 3
+		target.Backoff = time.Millisecond
+		target.BreakerMaxFailures = tt.maxFailures
+		target.BreakerResetTimeout = 10 * time.Second
+		client := clients.NewDownstreamClient(zerolog.Nop(), target, clients.Credentials{AccessKeyID: "k", SecretAccessKey: "s", Region: "us-east-1"})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := client.CallReason(ctx, contract.ReasonRequest{}, "test-correlation-id")
+		cancel()
+		server.Close()
+
+		if calls != tt.calls {
+			t.Errorf("%s: CallReason made wrong number of calls: got %v want %v", tt.name, calls, tt.calls)
+		}
+		var downstream *clients.DownstreamError
+		switch {
+		case tt.kind == "" && err != nil:
+			t.Errorf("%s: CallReason returned an error: %v", tt.name, err)
+		case tt.kind != "" && (!errors.As(err, &downstream) || downstream.Kind != tt.kind):
+			t.Errorf("%s: CallReason returned wrong error: got %v want kind %s", tt.name, err, tt.kind)
+		}
+		// Only transient failures count against the breaker
+		if tt.name == "client error" && client.BreakerState() != "closed" {
+			t.Errorf("%s: CallReason counted a client error against the breaker: state %v", tt.name, client.BreakerState())
+		}
+	}
+}
+
+func TestDownstreamClientCallerGaveUp(t *testing.T) {
+	release := make(chan struct{})
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	target := clients.DefaultTarget("axon", server.URL)
+	target.MaxAttempts = 3
+	target.Backoff = time.Millisecond
+	target.BreakerMaxFailures = 1
+	client := clients.NewDownstreamClient(zerolog.Nop(), target, clients.Credentials{AccessKeyID: "k", SecretAccessKey: "s", Region: "us-east-1"})
+
+	tests := []struct {
+		name   string
+		ctx    func() (context.Context, context.CancelFunc)
+		stream bool
+	}{
+		// A max_reasoning_time obligation or the dispatch budget ran out
+		{name: "deadline", ctx: func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 20*time.Millisecond)
+		}},
+		{name: "cancelled", ctx: func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(20*time.Millisecond, cancel)
+			return ctx, cancel
+		}},
+		{name: "stream deadline", stream: true, ctx: func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 20*time.Millisecond)
+		}},
+	}
+	for _, tt := range tests {
+		calls = 0
+		ctx, cancel := tt.ctx()
+		var err error
+		if tt.stream {
+			_, err = client.StreamReason(ctx, contract.ReasonRequest{}, "test-correlation-id")
+		} else {
+			_, err = client.CallReason(ctx, contract.ReasonRequest{}, "test-correlation-id")
+		}
+		cancel()
+
+		if err == nil {
+			t.Fatalf("%s: Downstream client returned no error", tt.name)
+		}
+		if calls != 1 {
+			t.Errorf("%s: Downstream client made wrong number of calls: got %v want %v", tt.name, calls, 1)
+		}
+		if client.BreakerState() != "closed" {
+			t.Errorf("%s: Downstream client counted the caller giving up against the breaker: state %v", tt.name, client.BreakerState())
+		}
+	}
+}
+
+func TestDispatchProblems(t *testing.T) {
+	tests := []struct {
+		name       string
+		governance *MockGovernanceClient
+		axonErr    error
+		status     int
+		code       string
+		retryAfter string
+	}{
+		{
+			name:       "breaker open",
+			governance: &MockGovernanceClient{allowed: true},
+			axonErr:    &clients.DownstreamError{Target: "axon", Kind: clients.DownstreamBreakerOpen, RetryAfter: 1500 * time.Millisecond, Err: clients.ErrBreakerOpen},
+			status:     http.StatusServiceUnavailable,
+			code:       handlers.ErrorCodeDownstreamUnavailable,
+			retryAfter: "2",
+		},
+		{
+			name:       "downstream timeout",
+			governance: &MockGovernanceClient{allowed: true},
+			axonErr:    &clients.DownstreamError{Target: "axon", Kind: clients.DownstreamTimeout, Err: context.DeadlineExceeded},
+			status:     http.StatusGatewayTimeout,
+			code:       handlers.ErrorCodeDownstreamTimeout,
+		},
+		{
+			name:       "downstream throttled",
+			governance: &MockGovernanceClient{allowed: true},
+			axonErr:    &clients.DownstreamError{Target: "axon", Kind: clients.DownstreamStatus, StatusCode: http.StatusTooManyRequests, RetryAfter: 7 * time.Second, Err: errors.New("axon returned status 429")},
+			status:     http.StatusTooManyRequests,
+			code:       handlers.ErrorCodeDownstreamRateLimited,
+			retryAfter: "7",
+		},
+		{
+			name:       "downstream unauthorized",
+			governance: &MockGovernanceClient{allowed: true},
+			axonErr:    &clients.DownstreamError{Target: "axon", Kind: clients.DownstreamStatus, StatusCode: http.StatusUnauthorized, Err: errors.New("axon returned status 401")},
+			status:     http.StatusBadGateway,
+			code:       handlers.ErrorCodeDownstreamUnauthorized,
+		},
+		{
+			name:       "obligation violated",
+			governance: &MockGovernanceClient{allowed: true},
+			axonErr:    &clients.ObligationViolationError{Type: clients.ObligationMaxTokens, Detail: "too long"},
+			status:     http.StatusBadGateway,
+			code:       handlers.ErrorCodeObligationViolated,
+		},
+		{
+			name:       "governance unavailable",
+			governance: &MockGovernanceClient{err: &clients.GovernanceUnavailableError{RetryAfter: 30 * time.Second, Err: clients.ErrBreakerOpen}},
+			status:     http.StatusServiceUnavailable,
+			code:       handlers.ErrorCodeGovernanceUnavailable,
+			retryAfter: "30",
+		},
+	}
+
+	for _, tt := range tests {
+		handler := handlers.DispatchHandler(zerolog.Nop(), tt.governance, &MockAxonClient{err: tt.axonErr})
+
+		req, _ := http.NewRequest("POST", "/dispatch", strings.NewReader(`{"input":{}}`))
+		req = req.WithContext(context.WithValue(req.Context(), middleware.CorrelationIDKey, "test-correlation-id"))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("%s: Dispatch handler returned wrong status code: got %v want %v", tt.name, rr.Code, tt.status)
+		}
+		if got := rr.Header().Get("Content-Type"); got != handlers.ProblemContentType {
+			t.Errorf("%s: Dispatch handler returned wrong content type: got %v want %v", tt.name, got, handlers.ProblemContentType)
+		}
+		if got := rr.Header().Get("Retry-After"); got != tt.retryAfter {
+			t.Errorf("%s: Dispatch handler returned wrong Retry-After: got %q want %q", tt.name, got, tt.retryAfter)
+		}
+
+		var problem handlers.Problem
+		if err := json.NewDecoder(rr.Body).Decode(&problem); err != nil {
+			t.Fatal(err)
+		}
+		if problem.Error.Code != tt.code || problem.Type != "urn:orbit:error:"+tt.code {
+			t.Errorf("%s: Dispatch handler returned wrong error code: got %v want %v", tt.name, problem.Error.Code, tt.code)
+		}
+		if problem.Status != tt.status || problem.CorrelationID != "test-correlation-id" || problem.Instance != "/dispatch" {
+			t.Errorf("%s: Dispatch handler returned wrong problem: %+v", tt.name, problem)
+		}
+	}
+}
@@ -0,0 +1,473 @@
+package unit
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"orbit-service/ratelimit"
+)
+
+// fakeRedis is an in-process stand-in for a Redis server that supports the
+// commands the rate limit backend uses, including optimistic transactions
+type fakeRedis struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	values   map[string]string
+	versions map[string]int
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{
+		listener: listener,
+		values:   make(map[string]string),
+		versions: make(map[string]int),
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) Addr() string {
+	return f.listener.Addr().String()
+}
+
+// Set changes a key as another client would
+func (f *fakeRedis) Set(key, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.values[key] = value
+	f.versions[key]++
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	watched := make(map[string]int)
+	var queued [][]string
+	inMulti := false
+
+	for {
+		args, err := readRedisCommand(reader)
+		if err != nil {
+			return
+		}
+
+		var reply string
+		switch command := strings.ToUpper(args[0]); {
+		case command == "EXEC":
+			reply = f.exec(watched, queued)
+			watched = make(map[string]int)
+			queued, inMulti = nil, false
+		case inMulti:
+			queued = append(queued, args)
+			reply = "+QUEUED\r\n"
+		case command == "MULTI":
+			inMulti = true
+			reply = "+OK\r\n"
+		case command == "WATCH":
+			f.mu.Lock()
+			for _, key := range args[1:] {
+				watched[key] = f.versions[key]
+			}
+			f.mu.Unlock()
+			reply = "+OK\r\n"
+		case command == "UNWATCH":
+			watched = make(map[string]int)
+			reply = "+OK\r\n"
+		default:
+			f.mu.Lock()
+			reply = f.apply(args)
+			f.mu.Unlock()
+		}
+
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// exec runs queued commands unless a watched key changed. Callers must not
+// hold f.mu.
+func (f *fakeRedis) exec(watched map[string]int, queued [][]string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for key, version := range watched {
+		if f.versions[key] != version {
+			return "*-1\r\n"
+		}
+	}
+	reply := fmt.Sprintf("*%d\r\n", len(queued))
+	for _, args := range queued {
+		reply += f.apply(args)
+	}
+	return reply
+}
+
+// apply runs a single command. Callers must hold f.mu.
+func (f *fakeRedis) apply(args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case "PING", "AUTH":
+		return "+OK\r\n"
+	case "GET":
+		value, ok := f.values[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+	case "SET":
+		f.values[args[1]] = args[2]
+		f.versions[args[1]]++
+		return "+OK\r\n"
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+func readRedisCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, count)
+	for i := range args {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(header[1:]))
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+// fakeDynamoDB keeps rate limit counters in memory, honouring the
+// conditions the backend writes with
+type fakeDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+
+	mu     sync.Mutex
+	counts map[string]int64
+	// beforeWrite runs before the next transaction, to simulate another task
+	beforeWrite func()
+}
+
+func newFakeDynamoDB() *fakeDynamoDB {
+	return &fakeDynamoDB{counts: make(map[string]int64)}
+}
+
+func (f *fakeDynamoDB) BatchGetItemWithContext(ctx aws.Context, input *dynamodb.BatchGetItemInput, opts ...request.Option) (*dynamodb.BatchGetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	output := &dynamodb.BatchGetItemOutput{Responses: make(map[string][]map[string]*dynamodb.AttributeValue)}
+	for table, keys := range input.RequestItems {
+		for _, key := range keys.Keys {
+			pk := aws.StringValue(key["pk"].S)
+			count, ok := f.counts[pk]
+			if !ok {
+				continue
+			}
+			output.Responses[table] = append(output.Responses[table], map[string]*dynamodb.AttributeValue{
+				"pk":       {S: aws.String(pk)},
+				"requests": {N: aws.String(strconv.FormatInt(count, 10))},
+			})
+		}
+	}
+	return output, nil
+}
+
+func (f *fakeDynamoDB) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	if hook := f.beforeWrite; hook != nil {
+		f.beforeWrite = nil
+		hook()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, item := range input.TransactItems {
+		pk := aws.StringValue(item.Update.Key["pk"].S)
+		max, _ := strconv.ParseInt(aws.StringValue(item.Update.ExpressionAttributeValues[":max"].N), 10, 64)
+		if count, ok := f.counts[pk]; ok && count > max {
+			return nil, &dynamodb.TransactionCanceledException{Message_: aws.String("Transaction cancelled")}
+		}
+	}
+	for _, item := range input.TransactItems {
+		pk := aws.StringValue(item.Update.Key["pk"].S)
+		n, _ := strconv.ParseInt(aws.StringValue(item.Update.ExpressionAttributeValues[":n"].N), 10, 64)
+		f.counts[pk] += n
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+// Add increases every counter, as requests through other tasks would
+func (f *fakeDynamoDB) Add(n int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for pk := range f.counts {
+		f.counts[pk] += n
+	}
+}
+
+// countingBackend counts the calls made to the backend it wraps
+type countingBackend struct {
+	next  ratelimit.Backend
+	calls int
+}
+
+func (c *countingBackend) Take(ctx context.Context, key ratelimit.Key, limits ratelimit.Limits, n int, now time.Time) (ratelimit.Decision, error) {
+	c.calls++
+	return c.next.Take(ctx, key, limits, n, now)
+}
+
+func TestRedisBackendEnforcesLimit(t *testing.T) {
+	server := newFakeRedis(t)
+	backend := ratelimit.NewRedisBackend(ratelimit.RedisConfig{Addr: server.Addr(), PoolSize: 2, Timeout: time.Second})
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limits := ratelimit.Limits{RequestsPerMinute: 3, RequestsPerHour: 100}
+
+	for i := 0; i < 3; i++ {
+		decision, err := backend.Take(context.Background(), rateLimitKey, limits, 1, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !decision.Allowed || decision.Remaining != 2-i {
+			t.Fatalf("Take returned wrong decision for request %d: %+v", i+1, decision)
+		}
+	}
+
+	decision, err := backend.Take(context.Background(), rateLimitKey, limits, 1, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision.Allowed {
+		t.Fatal("request 4 should be throttled")
+	}
+	if decision.RetryAfter != 20*time.Second {
+		t.Errorf("Take returned wrong retry after: got %v want %v", decision.RetryAfter, 20*time.Second)
+	}
+
+	decision, _ = backend.Take(context.Background(), rateLimitKey, limits, 1, now.Add(20*time.Second))
+	if !decision.Allowed {
+		t.Error("request should be allowed once the window has moved on")
+	}
+}
+
+func TestRedisBackendSharedAcrossTasks(t *testing.T) {
+	server := newFakeRedis(t)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limits := ratelimit.Limits{RequestsPerMinute: 10}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for task := 0; task < 3; task++ {
+		backend := ratelimit.NewRedisBackend(ratelimit.RedisConfig{Addr: server.Addr(), PoolSize: 4, Timeout: time.Second})
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 3; j++ {
+					decision, err := backend.Take(context.Background(), rateLimitKey, limits, 1, now)
+					if err != nil {
+						continue
+					}
+					if decision.Allowed {
+						mu.Lock()
+						allowed++
+						mu.Unlock()
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	if allowed != 10 {
+		t.Errorf("Tasks sharing the backend were allowed wrong number of requests: got %d want %d", allowed, 10)
+	}
+}
+
+func TestRedisBackendRetriesConflicts(t *testing.T) {
+	server := newFakeRedis(t)
+	backend := ratelimit.NewRedisBackend(ratelimit.RedisConfig{Addr: server.Addr(), Timeout: time.Second})
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limits := ratelimit.Limits{RequestsPerMinute: 2}
+
+	backend.Take(context.Background(), rateLimitKey, limits, 1, now)
+	// Another task takes the last request
+	server.Set("orbit:ratelimit:"+rateLimitKey.String()+"|minute", strconv.FormatInt(now.Add(time.Minute).UnixNano(), 10))
+
+	decision, err := backend.Take(context.Background(), rateLimitKey, limits, 1, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision.Allowed {
+		t.Error("request should be throttled by the other task's usage")
+	}
+}
+
+func TestDynamoDBBackendSlidingWindow(t *testing.T) {
+	client := newFakeDynamoDB()
+	backend := ratelimit.NewDynamoDBBackend(client, "rate-limits")
+	limits := ratelimit.Limits{RequestsPerMinute: 10}
+
+	// Fill the previous minute
+	previous := time.Date(2024, 1, 1, 0, 0, 30, 0, time.UTC)
+	decision, err := backend.Take(context.Background(), rateLimitKey, limits, 10, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decision.Allowed || decision.Remaining != 0 {
+		t.Fatalf("Take returned wrong decision: %+v", decision)
+	}
+
+	// Halfway through the next minute half the previous count still applies
+	now := previous.Add(time.Minute)
+	for i := 0; i < 5; i++ {
+		if decision, _ := backend.Take(context.Background(), rateLimitKey, limits, 1, now); !decision.Allowed {
+			t.Fatalf("request %d should be allowed", i+1)
+		}
+	}
+
+	decision, err = backend.Take(context.Background(), rateLimitKey, limits, 1, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision.Allowed {
+		t.Fatal("request 6 should be throttled")
+	}
+	if decision.RetryAfter != 6*time.Second {
+		t.Errorf("Take returned wrong retry after: got %v want %v", decision.RetryAfter, 6*time.Second)
+	}
+
+	if decision, _ := backend.Take(context.Background(), rateLimitKey, limits, 1, now.Add(6*time.Second)); !decision.Allowed {
+		t.Error("request should be allowed after the retry after")
+	}
+}
+
+func TestDynamoDBBackendConditionalWrite(t *testing.T) {
+	client := newFakeDynamoDB()
+	backend := ratelimit.NewDynamoDBBackend(client, "rate-limits")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limits := ratelimit.Limits{RequestsPerMinute: 5, RequestsPerHour: 100}
+
+	backend.Take(context.Background(), rateLimitKey, limits, 1, now)
+
+	// Another task uses up the minute between our read and our write
+	client.beforeWrite = func() { client.Add(4) }
+
+	decision, err := backend.Take(context.Background(), rateLimitKey, limits, 1, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision.Allowed {
+		t.Error("write should be refused once the other task used the window")
+	}
+}
+
+func TestDistributedLimiterLeasesRequests(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	backend := &countingBackend{next: ratelimit.NewDynamoDBBackend(newFakeDynamoDB(), "rate-limits")}
+	limiter := ratelimit.NewDistributedLimiterWithClock(backend, ratelimit.LeaseConfig{Size: 5, TTL: time.Second}, clock.Now)
+	limits := ratelimit.Limits{RequestsPerMinute: 100}
+
+	for i := 0; i < 5; i++ {
+		decision, err := limiter.Allow(context.Background(), rateLimitKey, limits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !decision.Allowed || decision.Remaining != 99-i {
+			t.Fatalf("Allow returned wrong decision for request %d: %+v", i+1, decision)
+		}
+	}
+	if backend.calls != 1 {
+		t.Errorf("Leased requests should not call the backend: got %d calls want %d", backend.calls, 1)
+	}
+
+	limiter.Allow(context.Background(), rateLimitKey, limits)
+	if backend.calls != 2 {
+		t.Errorf("Exhausted lease should call the backend: got %d calls want %d", backend.calls, 2)
+	}
+
+	clock.Advance(2 * time.Second)
+	limiter.Allow(context.Background(), rateLimitKey, limits)
+	if backend.calls != 3 {
+		t.Errorf("Expired lease should call the backend: got %d calls want %d", backend.calls, 3)
+	}
+}
+
+func TestDistributedLimiterFallsBackToSingleRequest(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	client := newFakeDynamoDB()
+	limiter := ratelimit.NewDistributedLimiterWithClock(ratelimit.NewDynamoDBBackend(client, "rate-limits"), ratelimit.LeaseConfig{Size: 10, TTL: time.Second}, clock.Now)
+	limits := ratelimit.Limits{RequestsPerMinute: 20}
+
+	allowed := 0
+	for i := 0; i < 25; i++ {
+		if decision, _ := limiter.Allow(context.Background(), rateLimitKey, limits); decision.Allowed {
+			allowed++
+		}
+	}
+	if allowed != 20 {
+		t.Errorf("Limiter allowed wrong number of requests: got %d want %d", allowed, 20)
+	}
+}
+
+func TestDistributedLimiterCachesThrottling(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	backend := &countingBackend{next: ratelimit.NewDynamoDBBackend(newFakeDynamoDB(), "rate-limits")}
+	limiter := ratelimit.NewDistributedLimiterWithClock(backend, ratelimit.DefaultLeaseConfig(), clock.Now)
+	limits := ratelimit.Limits{RequestsPerMinute: 1}
+
+	limiter.Allow(context.Background(), rateLimitKey, limits)
+	limiter.Allow(context.Background(), rateLimitKey, limits)
+	calls := backend.calls
+
+	decision, _ := limiter.Allow(context.Background(), rateLimitKey, limits)
+	if decision.Allowed || decision.RetryAfter <= 0 {
+		t.Errorf("Allow returned wrong decision: %+v", decision)
+	}
+	if backend.calls != calls {
+		t.Errorf("Throttled key should not call the backend again: got %d calls want %d", backend.calls, calls)
+	}
+}
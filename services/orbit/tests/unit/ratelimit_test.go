@@ -0,0 +1,154 @@
+package unit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+	"orbit-service/contract"
+	"orbit-service/handlers"
+	"orbit-service/middleware"
+	"orbit-service/ratelimit"
+)
+
+// fakeClock is a manually advanced clock
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+var rateLimitKey = ratelimit.Key{Subject: "caller:user-1", Intent: "call_reasoning"}
+
+func TestLocalLimiterThrottlesAndRefills(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	limiter := ratelimit.NewLocalLimiterWithClock(clock.Now)
+	limits := ratelimit.Limits{RequestsPerMinute: 3}
+
+	for i := 0; i < 3; i++ {
+		decision, _ := limiter.Allow(context.Background(), rateLimitKey, limits)
+		if !decision.Allowed {
+			t.Fatalf("request %d should be allowed", i+1)
+		}
+		if decision.Remaining != 2-i {
+			t.Errorf("Allow returned wrong remaining: got %d want %d", decision.Remaining, 2-i)
+		}
+	}
+
+	decision, _ := limiter.Allow(context.Background(), rateLimitKey, limits)
+	if decision.Allowed {
+		t.Fatal("request 4 should be throttled")
+	}
+	if decision.RetryAfter != 20*time.Second {
+		t.Errorf("Allow returned wrong retry after: got %v want %v", decision.RetryAfter, 20*time.Second)
+	}
+	if decision.Reset != time.Minute {
+		t.Errorf("Allow returned wrong reset: got %v want %v", decision.Reset, time.Minute)
+	}
+
+	clock.Advance(20 * time.Second)
+	if decision, _ := limiter.Allow(context.Background(), rateLimitKey, limits); !decision.Allowed {
+		t.Error("request should be allowed once a token has refilled")
+	}
+}
+
+func TestLocalLimiterReportsTightestWindow(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	limiter := ratelimit.NewLocalLimiterWithClock(clock.Now)
+	limits := ratelimit.Limits{RequestsPerMinute: 100, RequestsPerHour: 2}
+
+	limiter.Allow(context.Background(), rateLimitKey, limits)
+	decision, _ := limiter.Allow(context.Background(), rateLimitKey, limits)
+	if !decision.Allowed || decision.Limit != 2 || decision.Remaining != 0 {
+		t.Errorf("Allow returned wrong decision: %+v", decision)
+	}
+
+	clock.Advance(time.Minute)
+	if decision, _ := limiter.Allow(context.Background(), rateLimitKey, limits); decision.Allowed {
+		t.Error("hourly limit should still apply after the minute window refills")
+	}
+}
+
+func TestLocalLimiterSeparatesKeys(t *testing.T) {
+	limiter := ratelimit.NewLocalLimiter()
+	limits := ratelimit.Limits{RequestsPerMinute: 1}
+
+	limiter.Allow(context.Background(), rateLimitKey, limits)
+
+	other := ratelimit.Key{Subject: "caller:user-2", Intent: "call_reasoning"}
+	if decision, _ := limiter.Allow(context.Background(), other, limits); !decision.Allowed {
+		t.Error("another caller should have its own bucket")
+	}
+}
+
+func TestMeteredLimiterCountsDecisions(t *testing.T) {
+	limiter := ratelimit.NewMeteredLimiter(ratelimit.NewLocalLimiter())
+	limits := ratelimit.Limits{RequestsPerMinute: 1}
+
+	limiter.Allow(context.Background(), rateLimitKey, limits)
+	limiter.Allow(context.Background(), rateLimitKey, limits)
+
+	snapshot := limiter.Snapshot()
+	for name, want := range map[string]int64{
+		"allowed":                  1,
+		"throttled":                1,
+		"call_reasoning.throttled": 1,
+	} {
+		if snapshot[name] != want {
+			t.Errorf("Snapshot returned wrong %s: got %d want %d", name, snapshot[name], want)
+		}
+	}
+}
+
+func TestDispatchHandlerRateLimited(t *testing.T) {
+	governanceClient := &MockGovernanceClient{allowed: true, rateLimits: &clients.RateLimits{RequestsPerMinute: 1}}
+	axonClient := &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version}}
+
+	opts := handlers.DefaultDispatchOptions()
+	opts.RateLimiter = ratelimit.NewLocalLimiter()
+	handler := handlers.DispatchHandlerWithOptions(zerolog.Nop(), governanceClient, axonClient, opts)
+
+	var responses []*httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("POST", "/dispatch", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.WithValue(req.Context(), middleware.CallerKey, middleware.Caller{ID: "user-1"})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req.WithContext(ctx))
+		responses = append(responses, rr)
+	}
+
+	if status := responses[0].Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if got := responses[0].Header().Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("handler returned wrong X-RateLimit-Remaining: got %q want %q", got, "0")
+	}
+
+	throttled := responses[1]
+	if status := throttled.Code; status != http.StatusTooManyRequests {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusTooManyRequests)
+	}
+	for header, want := range map[string]string{
+		"Retry-After":           "60",
+		"X-RateLimit-Limit":     "1",
+		"X-RateLimit-Remaining": "0",
+		"X-RateLimit-Reset":     "60",
+	} {
+		if got := throttled.Header().Get(header); got != want {
+			t.Errorf("handler returned wrong %s: got %q want %q", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+	"orbit-service/contract"
+	"orbit-service/handlers"
+	"orbit-service/middleware"
+)
+
+var testCredentials = clients.Credentials{AccessKeyID: "test-access-key", SecretAccessKey: "test-secret-key", Region: "us-east-1"}
+
+func writeRouteTable(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadRouteTableYAML(t *testing.T) {
+	path := writeRouteTable(t, "routes.yaml", `
+targets:
+  axon:
+    url: http://axon/reason
+  metrics:
+    url: https://metrics.example.com/v1/query
+    method: put
+    signing_service: lambda
+    timeout: 5s
+    retry: {max_attempts: 1}
+    breaker: {max_failures: 2, reset_timeout: 1m}
+routes:
+  call_reasoning: axon
+  call_metrics: metrics
+`)
+
+	table, err := clients.LoadRouteTable(path)
+	if err != nil {
+		t.Fatalf("LoadRouteTable failed: %v", err)
+	}
+
+	target, err := table.Target("metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := clients.Target{
+		Name:                "metrics",
+		URL:                 "https://metrics.example.com/v1/query",
+		Method:              "PUT",
+		SigningService:      "lambda",
+		Timeout:             5 * time.Second,
+		MaxAttempts:         1,
+		Backoff:             100 * time.Millisecond,
+		BreakerMaxFailures:  2,
+		BreakerResetTimeout: time.Minute,
+	}
+	if target != want {
+		t.Errorf("Target returned wrong settings: got %+v want %+v", target, want)
+	}
+
+	target, _ = table.Target("axon")
+	if target != clients.DefaultTarget("axon", "http://axon/reason") {
+		t.Errorf("Target returned wrong defaults: got %+v", target)
+	}
+}
+
+func TestRouteTableValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		table string
+	}{
+		{"no routes", `{"targets": {}, "routes": {}}`},
+		{"unknown target", `{"targets": {"axon": {"url": "http://axon/reason"}}, "routes": {"call_reasoning": "axon", "call_metrics": "metrics"}}`},
+		{"unrouted target", `{"targets": {"axon": {"url": "http://axon/reason"}, "metrics": {"url": "http://metrics"}}, "routes": {"call_reasoning": "axon"}}`},
+		{"bad intent", `{"targets": {"axon": {"url": "http://axon/reason"}}, "routes": {"Call Reasoning": "axon"}}`},
+		{"relative url", `{"targets": {"axon": {"url": "/reason"}}, "routes": {"call_reasoning": "axon"}}`},
+		{"bad method", `{"targets": {"axon": {"url": "http://axon/reason", "method": "GET"}}, "routes": {"call_reasoning": "axon"}}`},
+		{"bad timeout", `{"targets": {"axon": {"url": "http://axon/reason", "timeout": "soon"}}, "routes": {"call_reasoning": "axon"}}`},
+		{"unknown field", `{"targets": {"axon": {"url": "http://axon/reason", "retries": 3}}, "routes": {"call_reasoning": "axon"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := clients.LoadRouteTable(writeRouteTable(t, "routes.json", tt.table)); err == nil {
+				t.Errorf("LoadRouteTable accepted %s", tt.table)
+			}
+		})
+	}
+}
+
+func TestDefaultRouteTable(t *testing.T) {
+	t.Setenv("AXON_SERVICE_URL", "http://localhost:8081/reason")
+
+	table := clients.DefaultRouteTable()
+	if err := table.Validate(); err != nil {
+		t.Fatalf("DefaultRouteTable is invalid: %v", err)
+	}
+	if name := table.Routes[handlers.DefaultIntent]; name != "axon" {
+		t.Errorf("DefaultRouteTable routed %s to wrong target: got %q want axon", handlers.DefaultIntent, name)
+	}
+	if target, _ := table.Target("axon"); target.URL != "http://localhost:8081/reason" {
+		t.Errorf("DefaultRouteTable returned wrong axon URL: got %s", target.URL)
+	}
+}
+
+// routeTarget is a downstream service recording the requests it is sent
+type routeTarget struct {
+	server  *httptest.Server
+	method  string
+	auth    string
+	request contract.ReasonRequest
+}
+
+func newRouteTarget(t *testing.T, model string) *routeTarget {
+	target := &routeTarget{}
+	target.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target.method = r.Method
+		target.auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&target.request)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(contract.ReasonResponse{Version: contract.Version, Model: model})
+	}))
+	t.Cleanup(target.server.Close)
+	return target
+}
+
+func TestDispatchHandlerRoutesIntents(t *testing.T) {
+	axon := newRouteTarget(t, "axon-echo-1")
+	metrics := newRouteTarget(t, "metrics-1")
+
+	table := clients.RouteTable{
+		Targets: map[string]clients.TargetConfig{
+			"axon":    {URL: axon.server.URL + "/reason"},
+			"metrics": {URL: metrics.server.URL + "/query", Method: "PUT", SigningService: "lambda"},
+		},
+		Routes: map[string]string{"call_reasoning": "axon", "call_metrics": "metrics"},
+	}
+	if err := table.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	router, err := clients.NewRouter(zerolog.Nop(), table, testCredentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := handlers.DefaultDispatchOptions()
+	opts.Routes = router
+	governanceClient := &MockGovernanceClient{allowed: true}
+	handler := handlers.DispatchHandlerWithOptions(zerolog.Nop(), governanceClient, nil, opts)
+
+	dispatch := func(body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/dispatch", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), middleware.CorrelationIDKey, "test-correlation-id"))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := dispatch(`{"intent": "call_metrics", "input": {"metric": "latency"}}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Dispatch handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	var response handlers.DispatchResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Result == nil || response.Result.Model != "metrics-1" {
+		t.Errorf("Dispatch handler returned wrong result: got %+v", response.Result)
+	}
+	if metrics.method != "PUT" || !strings.Contains(metrics.auth, "/us-east-1/lambda/aws4_request") {
+		t.Errorf("Metrics target got wrong request: method %s, authorization %q", metrics.method, metrics.auth)
+	}
+	if string(metrics.request.Input) != `{"metric":"latency"}` {
+		t.Errorf("Metrics target got wrong input: %s", metrics.request.Input)
+	}
+	if axon.method != "" {
+		t.Error("call_metrics dispatch reached the axon target")
+	}
+
+	rr = dispatch(`{"input": {"question": "status?"}}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Dispatch handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if axon.method != "POST" || !strings.Contains(axon.auth, "/us-east-1/execute-api/aws4_request") {
+		t.Errorf("Axon target got wrong request: method %s, authorization %q", axon.method, axon.auth)
+	}
+
+	governanceClient.lastRequest = clients.GovernanceRequest{}
+	rr = dispatch(`{"intent": "call_billing"}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("Dispatch handler returned wrong status code for an unrouted intent: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	response = handlers.DispatchResponse{}
+	json.NewDecoder(rr.Body).Decode(&response)
+	if len(response.Errors) != 1 || response.Errors[0].Field != "/intent" {
+		t.Errorf("Dispatch handler returned wrong field errors: got %+v", response.Errors)
+	}
+	if governanceClient.lastRequest.Intent != "" {
+		t.Error("Dispatch handler checked governance for an unrouted intent")
+	}
+}
@@ -0,0 +1,371 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+	"orbit-service/contract"
+	"orbit-service/handlers"
+	"orbit-service/middleware"
+	"orbit-service/ratelimit"
+	"orbit-service/runs"
+)
+
+// ScriptedReasoner proposes the actions of script in turn, one per step,
+// then gives final as its answer. With repeat, it proposes the last action
+// forever. Every answer uses tokens.
+type ScriptedReasoner struct {
+	script []contract.AgentAction
+	final  json.RawMessage
+	repeat bool
+	tokens int
+	delay  time.Duration
+
+	mu    sync.Mutex
+	turns []contract.AgentTurn
+}
+
+func (m *ScriptedReasoner) CallReason(ctx context.Context, req contract.ReasonRequest, correlationID string) (*contract.ReasonResponse, error) {
+	var turn contract.AgentTurn
+	if err := json.Unmarshal(req.Input, &turn); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	m.turns = append(m.turns, turn)
+	m.mu.Unlock()
+
+	select {
+	case <-time.After(m.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	decision := contract.AgentDecision{Final: m.final}
+	switch n := len(turn.Steps); {
+	case n < len(m.script):
+		decision = contract.AgentDecision{Action: &m.script[n]}
+	case m.repeat:
+		decision = contract.AgentDecision{Action: &m.script[len(m.script)-1]}
+	}
+	output, _ := json.Marshal(decision)
+	return &contract.ReasonResponse{
+		Version: contract.Version,
+		Output:  output,
+		Usage:   contract.Usage{TotalTokens: m.tokens},
+	}, nil
+}
+
+func (m *ScriptedReasoner) lastTurn() contract.AgentTurn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.turns[len(m.turns)-1]
+}
+
+// intentRoutes routes each intent to its caller
+type intentRoutes map[string]clients.AxonCaller
+
+func (r intentRoutes) Route(intent string) (clients.AxonCaller, bool) {
+	caller, ok := r[intent]
+	return caller, ok
+}
+
+type runServer struct {
+	router  *mux.Router
+	manager *runs.Manager
+	tool    *ConcurrentAxonClient
+}
+
+func newRunServer(t *testing.T, governance clients.GovernanceChecker, reasoner *ScriptedReasoner, config runs.Config) *runServer {
+	t.Helper()
+	s := &runServer{
+		manager: runs.NewManager(zerolog.Nop(), runs.NewMemoryStore(), config),
+		tool:    &ConcurrentAxonClient{},
+	}
+	t.Cleanup(s.manager.Close)
+
+	opts := handlers.DefaultDispatchOptions()
+	opts.RateLimiter = ratelimit.NewLocalLimiter()
+	opts.Routes = intentRoutes{
+		handlers.DefaultIntent: reasoner,
+		"lookup_order":         s.tool,
+		"delete_records":       s.tool,
+		"call_secrets":         reasoner,
+	}
+	s.router = mux.NewRouter()
+	s.router.HandleFunc("/runs", handlers.RunStartHandler(zerolog.Nop(), governance, nil, opts, s.manager)).Methods("POST")
+	s.router.HandleFunc("/runs/{id}", handlers.RunStatusHandler(zerolog.Nop(), s.manager)).Methods("GET")
+	return s
+}
+
+func (s *runServer) send(method, path, body, callerID string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	ctx := context.WithValue(req.Context(), middleware.CorrelationIDKey, "run-1")
+	ctx = context.WithValue(ctx, middleware.CallerKey, middleware.Caller{ID: callerID})
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req.WithContext(ctx))
+	return rr
+}
+
+// start starts a run and waits for it to finish
+func (s *runServer) start(t *testing.T, body string) handlers.RunStatusResponse {
+	t.Helper()
+	rr := s.send("POST", "/runs", body, "agent-1")
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Run handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusAccepted, rr.Body.String())
+	}
+
+	location := rr.Header().Get("Location")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rr = s.send("GET", location, "", "agent-1")
+		var run handlers.RunStatusResponse
+		if err := json.NewDecoder(rr.Body).Decode(&run); err != nil {
+			t.Fatal(err)
+		}
+		if run.Status != runs.StatusRunning {
+			return run
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Run did not finish: %+v", run)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func stepStatuses(run handlers.RunStatusResponse) []string {
+	statuses := make([]string, len(run.Steps))
+	for i, step := range run.Steps {
+		statuses[i] = step.Status
+	}
+	return statuses
+}
+
+func TestAgentRun(t *testing.T) {
+	reasoner := &ScriptedReasoner{
+		script: []contract.AgentAction{
+			{Intent: "lookup_order", Input: json.RawMessage(`{"order":"A-1"}`)},
+			{Intent: "delete_records", Input: json.RawMessage(`{"order":"A-1"}`)},
+			{Intent: "lookup_order", Input: json.RawMessage(`{"fail":true}`)},
+		},
+		final:  json.RawMessage(`{"answer":"shipped"}`),
+		tokens: 10,
+	}
+	governance := &IntentGovernanceClient{denied: map[string]bool{"delete_records": true}}
+	s := newRunServer(t, governance, reasoner, runs.DefaultConfig())
+
+	run := s.start(t, `{"goal":{"question":"where is order A-1?"}}`)
+	if run.Status != runs.StatusSucceeded {
+		t.Fatalf("Run finished with wrong status: got %v want %v: %s", run.Status, runs.StatusSucceeded, run.Error)
+	}
+	if string(run.Output) != `{"answer":"shipped"}` {
+		t.Errorf("Run returned wrong output: got %s want %s", run.Output, `{"answer":"shipped"}`)
+	}
+
+	want := []string{"success", "denied", "error", ""}
+	if got := stepStatuses(run); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("Run recorded wrong step statuses: got %q want %q", got, want)
+	}
+	if step := run.Steps[0]; step.Action.Intent != "lookup_order" || string(step.Output) != `{"order":"A-1"}` || step.CorrelationID != "run-1-1" {
+		t.Errorf("Run recorded wrong first step: %+v", step)
+	}
+	if step := run.Steps[1]; step.StatusCode != http.StatusForbidden || step.Reason != "Intent not allowed" {
+		t.Errorf("Run recorded wrong denied step: %+v", step)
+	}
+	if step := run.Steps[2]; step.StatusCode != http.StatusBadGateway || step.ErrorCode != handlers.ErrorCodeDownstreamError {
+		t.Errorf("Run recorded wrong failed step: %+v", step)
+	}
+	if run.Usage.TotalTokens != 40 {
+		t.Errorf("Run recorded wrong usage: got %d tokens want %d", run.Usage.TotalTokens, 40)
+	}
+
+	// The reasoner is told the goal and the outcome of every earlier action
+	turn := reasoner.lastTurn()
+	if string(turn.Goal) != `{"question":"where is order A-1?"}` || len(turn.Steps) != 3 || turn.RemainingSteps != 7 {
+		t.Fatalf("Reasoner was given wrong turn: %+v", turn)
+	}
+	if turn.Steps[1].Status != "denied" || turn.Steps[1].Reason != "Intent not allowed" {
+		t.Errorf("Reasoner was given wrong outcome for the denied action: %+v", turn.Steps[1])
+	}
+	if calls, _ := s.tool.stats(); calls != 2 {
+		t.Errorf("Run performed wrong number of actions: got %d want %d", calls, 2)
+	}
+}
+
+func TestAgentRunLimits(t *testing.T) {
+	lookup := []contract.AgentAction{{Intent: "lookup_order", Input: json.RawMessage(`{}`)}}
+
+	tests := []struct {
+		name     string
+		reasoner *ScriptedReasoner
+		config   runs.Config
+		body     string
+		limit    string
+		steps    int
+	}{
+		{
+			name:     "steps",
+			reasoner: &ScriptedReasoner{script: lookup, repeat: true},
+			config:   runs.DefaultConfig(),
+			body:     `{"goal":{},"limits":{"max_steps":3}}`,
+			limit:    runs.LimitSteps,
+			steps:    3,
+		},
+		{
+			name:     "tokens",
+			reasoner: &ScriptedReasoner{script: lookup, repeat: true, tokens: 40},
+			config:   runs.DefaultConfig(),
+			body:     `{"goal":{},"limits":{"max_tokens":100}}`,
+			limit:    runs.LimitTokens,
+			steps:    3,
+		},
+		{
+			name:     "duration",
+			reasoner: &ScriptedReasoner{script: lookup, repeat: true, delay: 20 * time.Millisecond},
+			config:   runs.Config{MaxSteps: 100, MaxDuration: 50 * time.Millisecond, MaxTokens: 100, MaxConcurrent: 1, ResultTTL: time.Minute},
+			body:     `{"goal":{}}`,
+			limit:    runs.LimitDuration,
+		},
+	}
+
+	for _, tt := range tests {
+		s := newRunServer(t, &IntentGovernanceClient{}, tt.reasoner, tt.config)
+		run := s.start(t, tt.body)
+		if run.Status != runs.StatusLimitExceeded || run.Limit != tt.limit {
+			t.Errorf("%s: Run finished with wrong status: got %v at %q want %v at %q", tt.name, run.Status, run.Limit, runs.StatusLimitExceeded, tt.limit)
+		}
+		if tt.steps > 0 && len(run.Steps) != tt.steps {
+			t.Errorf("%s: Run took wrong number of steps: got %d want %d", tt.name, len(run.Steps), tt.steps)
+		}
+	}
+}
+
+// RevokingGovernanceClient allows an intent for its first allowed checks
+// and denies it after
+type RevokingGovernanceClient struct {
+	intent  string
+	allowed int
+
+	mu     sync.Mutex
+	checks int
+}
+
+func (m *RevokingGovernanceClient) CheckPermission(ctx context.Context, req clients.GovernanceRequest, correlationID string) (clients.GovernanceResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if req.Intent == m.intent {
+		m.checks++
+		if m.checks > m.allowed {
+			return clients.GovernanceResponse{Reason: "Intent no longer allowed"}, nil
+		}
+	}
+	return clients.GovernanceResponse{Allowed: true, Reason: "allowed"}, nil
+}
+
+func TestAgentRunGovernsEachStep(t *testing.T) {
+	lookup := []contract.AgentAction{{Intent: "lookup_order", Input: json.RawMessage(`{}`)}}
+
+	tests := []struct {
+		name       string
+		governance clients.GovernanceChecker
+		reason     string
+	}{
+		{
+			// The run start and its first step are allowed
+			name:       "revoked",
+			governance: &RevokingGovernanceClient{intent: handlers.DefaultIntent, allowed: 2},
+			reason:     "Intent no longer allowed",
+		},
+		{
+			// The run start's request is used by its first step
+			name:       "throttled",
+			governance: &IntentGovernanceClient{rateLimits: &clients.RateLimits{RequestsPerMinute: 1}},
+			reason:     "Rate limit exceeded",
+		},
+	}
+
+	for _, tt := range tests {
+		reasoner := &ScriptedReasoner{script: lookup, repeat: true}
+		s := newRunServer(t, tt.governance, reasoner, runs.DefaultConfig())
+		run := s.start(t, `{"goal":{}}`)
+		if run.Status != runs.StatusFailed || !strings.Contains(run.Error, tt.reason) {
+			t.Errorf("%s: Run finished with wrong status: got %v (%s) want %v (%s)", tt.name, run.Status, run.Error, runs.StatusFailed, tt.reason)
+		}
+		if len(run.Steps) != 1 {
+			t.Errorf("%s: Run took wrong number of steps: got %d want %d", tt.name, len(run.Steps), 1)
+		}
+		if len(reasoner.turns) != 1 {
+			t.Errorf("%s: Reasoner was asked wrong number of times: got %d want %d", tt.name, len(reasoner.turns), 1)
+		}
+	}
+}
+
+func TestRunManagerCloseInterruptsRuns(t *testing.T) {
+	reasoner := &ScriptedReasoner{final: json.RawMessage(`{}`), delay: time.Minute}
+	s := newRunServer(t, &IntentGovernanceClient{}, reasoner, runs.DefaultConfig())
+
+	rr := s.send("POST", "/runs", `{"goal":{}}`, "agent-1")
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Run handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusAccepted, rr.Body.String())
+	}
+
+	// Close returns without waiting for the reasoner
+	s.manager.Close()
+	var run handlers.RunStatusResponse
+	if err := json.NewDecoder(s.send("GET", rr.Header().Get("Location"), "", "agent-1").Body).Decode(&run); err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != runs.StatusFailed || run.Error != runs.ErrClosed.Error() {
+		t.Errorf("Close left run with wrong status: got %v (%s) want %v (%s)", run.Status, run.Error, runs.StatusFailed, runs.ErrClosed)
+	}
+}
+
+func TestRunStartRejects(t *testing.T) {
+	reasoner := &ScriptedReasoner{final: json.RawMessage(`"done"`)}
+	governance := &IntentGovernanceClient{denied: map[string]bool{"call_secrets": true}}
+	s := newRunServer(t, governance, reasoner, runs.DefaultConfig())
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		field  string
+	}{
+		{"no goal", `{}`, http.StatusBadRequest, ""},
+		{"limit too high", `{"goal":{},"limits":{"max_steps":11}}`, http.StatusBadRequest, "/limits/max_steps"},
+		{"unknown limit", `{"goal":{},"limits":{"max_retries":1}}`, http.StatusBadRequest, "/limits/max_retries"},
+		{"no route", `{"goal":{},"intent":"call_metrics"}`, http.StatusBadRequest, "/intent"},
+		{"denied", `{"goal":{},"intent":"call_secrets"}`, http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		rr := s.send("POST", "/runs", tt.body, "agent-1")
+		if rr.Code != tt.status {
+			t.Errorf("%s: Run handler returned wrong status code: got %v want %v", tt.name, rr.Code, tt.status)
+			continue
+		}
+		var response handlers.DispatchResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if tt.field != "" && (len(response.Errors) == 0 || response.Errors[0].Field != tt.field) {
+			t.Errorf("%s: Run handler returned wrong field errors: got %+v want %s", tt.name, response.Errors, tt.field)
+		}
+	}
+
+	run := s.start(t, `{"goal":{}}`)
+	if run.Status != runs.StatusSucceeded || string(run.Output) != `"done"` {
+		t.Fatalf("Run finished with wrong outcome: %+v", run)
+	}
+	if rr := s.send("GET", "/runs/"+run.RunID, "", "agent-2"); rr.Code != http.StatusNotFound {
+		t.Errorf("Run status handler returned wrong status code for another caller: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,196 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+	"orbit-service/contract"
+	"orbit-service/handlers"
+	"orbit-service/middleware"
+)
+
+// MockStreamingAxonClient replays a fixed sequence of stream events
+type MockStreamingAxonClient struct {
+	MockAxonClient
+	events []contract.StreamEvent
+}
+
+func (m *MockStreamingAxonClient) StreamReason(ctx context.Context, req contract.ReasonRequest, correlationID string) (<-chan contract.StreamEvent, error) {
+	ch := make(chan contract.StreamEvent, len(m.events))
+	for _, event := range m.events {
+		ch <- event
+	}
+	close(ch)
+	return ch, nil
+}
+
+func decodeSSE(t *testing.T, body string) []contract.StreamEvent {
+	t.Helper()
+	var events []contract.StreamEvent
+	for _, line := range strings.Split(body, "\n") {
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var event contract.StreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+			t.Fatalf("Failed to decode event: %v", err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestDispatchHandlerStream(t *testing.T) {
+	logger := zerolog.Nop()
+	governanceClient := &MockGovernanceClient{allowed: true}
+	axonClient := &MockStreamingAxonClient{events: []contract.StreamEvent{
+		{ID: 1, Type: contract.EventDelta, Delta: "hello "},
+		{ID: 2, Type: contract.EventHeartbeat},
+		{ID: 3, Type: contract.EventDelta, Delta: "world"},
+		{ID: 4, Type: contract.EventDone, Status: "succeeded", Response: &contract.ReasonResponse{Version: contract.Version}},
+	}}
+
+	handler := handlers.DispatchHandler(logger, governanceClient, axonClient)
+
+	req, err := http.NewRequest("POST", "/dispatch?stream=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(req.Context(), middleware.CorrelationIDKey, "test-correlation-id")
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if contentType := rr.Header().Get("Content-Type"); contentType != contract.StreamContentType {
+		t.Fatalf("Dispatch handler returned wrong content type: got %v want %v", contentType, contract.StreamContentType)
+	}
+
+	events := decodeSSE(t, rr.Body.String())
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 relayed events (heartbeats absorbed), got %d", len(events))
+	}
+
+	for i, event := range events {
+		if event.ID != int64(i+1) {
+			t.Errorf("Expected event %d to have ID %d, got %d", i, i+1, event.ID)
+		}
+		if event.CorrelationID != "test-correlation-id" {
+			t.Errorf("Expected correlation ID on event, got %q", event.CorrelationID)
+		}
+	}
+
+	if last := events[len(events)-1]; last.Type != contract.EventDone || last.Status != "succeeded" {
+		t.Errorf("Expected terminal done event, got %+v", last)
+	}
+}
+
+func TestDispatchHandlerStreamUnsupported(t *testing.T) {
+	logger := zerolog.Nop()
+	governanceClient := &MockGovernanceClient{allowed: true}
+	axonClient := &MockAxonClient{}
+
+	handler := handlers.DispatchHandler(logger, governanceClient, axonClient)
+
+	req, err := http.NewRequest("POST", "/dispatch", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", contract.StreamContentType)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotAcceptable {
+		t.Errorf("Dispatch handler should return 406 when streaming is unsupported: got %v want %v", status, http.StatusNotAcceptable)
+	}
+}
+
+func TestAxonClientStreamReasonTruncated(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != contract.StreamContentType {
+			t.Errorf("Expected stream Accept header, got %q", r.Header.Get("Accept"))
+		}
+		w.Header().Set("Content-Type", contract.StreamContentType)
+		contract.WriteSSE(w, contract.StreamEvent{ID: 1, Type: contract.EventDelta, Delta: "partial"})
+		// Connection closes without a terminal event
+	}))
+	defer server.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "test-access-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test-secret-key")
+	t.Setenv("AXON_SERVICE_URL", server.URL+"/reason")
+
+	client, err := clients.NewAxonClient(zerolog.Nop())
+	if err != nil {
+		t.Fatalf("Failed to create axon client: %v", err)
+	}
+
+	events, err := client.StreamReason(context.Background(), contract.ReasonRequest{Input: json.RawMessage(`{}`)}, "test-correlation-id")
+	if err != nil {
+		t.Fatalf("StreamReason failed: %v", err)
+	}
+
+	var received []contract.StreamEvent
+	for event := range events {
+		received = append(received, event)
+	}
+
+	if len(received) != 2 {
+		t.Fatalf("Expected delta and synthetic error event, got %d events", len(received))
+	}
+
+	if received[0].Delta != "partial" {
+		t.Errorf("Expected partial delta, got %+v", received[0])
+	}
+
+	if received[1].Type != contract.EventError {
+		t.Errorf("Expected synthetic error event for truncated stream, got %+v", received[1])
+	}
+}
+
+// StalledStreamingAxonClient sends one delta, then nothing until the stream
+// is cancelled
+type StalledStreamingAxonClient struct {
+	MockAxonClient
+}
+
+func (m *StalledStreamingAxonClient) StreamReason(ctx context.Context, req contract.ReasonRequest, correlationID string) (<-chan contract.StreamEvent, error) {
+	ch := make(chan contract.StreamEvent, 1)
+	ch <- contract.StreamEvent{ID: 1, Type: contract.EventDelta, Delta: "partial"}
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch, nil
+}
+
+func TestDispatchHandlerStreamDeadline(t *testing.T) {
+	opts := handlers.DefaultDispatchOptions()
+	opts.Budget = handlers.DispatchBudget{Total: 50 * time.Millisecond, Governance: 10 * time.Millisecond}
+	handler := handlers.DispatchHandlerWithOptions(zerolog.Nop(), &MockGovernanceClient{allowed: true}, &StalledStreamingAxonClient{}, opts)
+
+	req, err := http.NewRequest("POST", "/dispatch?stream=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	events := decodeSSE(t, rr.Body.String())
+	if len(events) != 2 {
+		t.Fatalf("Expected delta and terminal events, got %d events", len(events))
+	}
+	if last := events[1]; last.Type != contract.EventError || last.Status != "timeout" {
+		t.Errorf("Expected terminal timeout event for a stream past the dispatch budget, got %+v", last)
+	}
+}
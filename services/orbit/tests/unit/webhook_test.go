@@ -0,0 +1,418 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"orbit-service/clients"
+	"orbit-service/contract"
+	"orbit-service/handlers"
+	"orbit-service/jobs"
+	"orbit-service/middleware"
+	"orbit-service/webhook"
+)
+
+// webhookReceiver answers deliveries with the queued statuses in turn, then
+// 200, and hands each delivery to the test
+type webhookReceiver struct {
+	server   *httptest.Server
+	received chan receivedWebhook
+
+	mu       sync.Mutex
+	statuses []int
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	r := &webhookReceiver{received: make(chan receivedWebhook, 20), statuses: statuses}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.received <- receivedWebhook{header: req.Header, body: body}
+
+		r.mu.Lock()
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		r.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *webhookReceiver) next(t *testing.T) receivedWebhook {
+	t.Helper()
+	select {
+	case delivery := <-r.received:
+		return delivery
+	case <-time.After(2 * time.Second):
+		t.Fatal("No webhook delivered")
+		return receivedWebhook{}
+	}
+}
+
+func testWebhookConfig() webhook.Config {
+	return webhook.Config{
+		MaxAttempts:            3,
+		Backoff:                time.Millisecond,
+		MaxBackoff:             5 * time.Millisecond,
+		Timeout:                2 * time.Second,
+		Workers:                1,
+		QueueSize:              10,
+		DeadLetters:            10,
+		CallbackSecret:         []byte("callback-secret"),
+		AllowInsecureCallbacks: true,
+	}
+}
+
+func waitForDeadLetters(t *testing.T, d *webhook.Dispatcher, n int) []webhook.Delivery {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		deliveries, err := d.DeadLetters(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) >= n {
+			return deliveries
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Got %d dead letters, want %d", len(deliveries), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"id":"evt_1"}`)
+	now := time.Unix(1700000000, 0)
+	header := webhook.Sign(secret, now, body)
+
+	if err := webhook.Verify(secret, header, body, webhook.DefaultTolerance, now.Add(time.Minute)); err != nil {
+		t.Errorf("Verify rejected a valid signature: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		secret []byte
+		header string
+		body   []byte
+		now    time.Time
+		want   error
+	}{
+		{"tampered body", secret, header, []byte(`{"id":"evt_2"}`), now, webhook.ErrInvalidSignature},
+		{"wrong secret", []byte("other"), header, body, now, webhook.ErrInvalidSignature},
+		{"malformed header", secret, "v1=zz", body, now, webhook.ErrInvalidSignature},
+		{"old signature", secret, header, body, now.Add(time.Hour), webhook.ErrSignatureExpired},
+	} {
+		if err := webhook.Verify(tt.secret, tt.header, tt.body, webhook.DefaultTolerance, tt.now); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Verify returned wrong error: got %v want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestWebhookDispatcherRetries(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusInternalServerError, http.StatusTooManyRequests)
+	d := webhook.NewDispatcher(zerolog.Nop(), testWebhookConfig(), nil, webhook.NewMemoryDeadLetterStore(10))
+	defer d.Close()
+
+	event := webhook.NewDispatchCompleted(jobs.Job{ID: "job-1", Status: jobs.StatusSucceeded, Intent: "call_reasoning"})
+	d.Callback(receiver.server.URL, event)
+
+	var last receivedWebhook
+	for i := 0; i < 3; i++ {
+		last = receiver.next(t)
+	}
+	if err := webhook.Verify([]byte("callback-secret"), last.header.Get(webhook.SignatureHeader), last.body, webhook.DefaultTolerance, time.Now()); err != nil {
+		t.Errorf("Delivery has invalid signature: %v", err)
+	}
+	if got := last.header.Get(webhook.EventHeader); got != webhook.EventDispatchCompleted {
+		t.Errorf("Delivery has wrong event header: got %s want %s", got, webhook.EventDispatchCompleted)
+	}
+
+	var got struct {
+		ID   string                    `json:"id"`
+		Type string                    `json:"type"`
+		Data webhook.DispatchCompleted `json:"data"`
+	}
+	if err := json.Unmarshal(last.body, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != event.ID || got.Data.JobID != "job-1" || got.Data.Status != jobs.StatusSucceeded {
+		t.Errorf("Delivery has wrong body: %s", last.body)
+	}
+
+	d.Close()
+	if deliveries, _ := d.DeadLetters(context.Background()); len(deliveries) != 0 {
+		t.Errorf("Delivered webhook was dead-lettered: %+v", deliveries)
+	}
+}
+
+func TestWebhookDeadLettersAndReplay(t *testing.T) {
+	receiver := newWebhookReceiver(t,
+		http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, // retried, then dead-lettered
+		http.StatusGone, // not retried
+	)
+	d := webhook.NewDispatcher(zerolog.Nop(), testWebhookConfig(), nil, webhook.NewMemoryDeadLetterStore(10))
+	defer d.Close()
+
+	d.Callback(receiver.server.URL, webhook.NewDispatchCompleted(jobs.Job{ID: "job-1"}))
+	deliveries := waitForDeadLetters(t, d, 1)
+	if deliveries[0].Attempts != 3 || deliveries[0].LastError != "endpoint answered 502" {
+		t.Errorf("Dead letter has wrong attempts or error: %+v", deliveries[0])
+	}
+
+	d.Callback(receiver.server.URL, webhook.NewDispatchCompleted(jobs.Job{ID: "job-2"}))
+	deliveries = waitForDeadLetters(t, d, 2)
+	if deliveries[1].Attempts != 1 {
+		t.Errorf("Rejected delivery was retried: %+v", deliveries[1])
+	}
+	for i := 0; i < 4; i++ {
+		receiver.next(t)
+	}
+
+	router := mux.NewRouter()
+	logger := zerolog.Nop()
+	admin := &MockGovernanceClient{allowed: true}
+	router.HandleFunc("/admin/webhooks/dead-letters", handlers.WebhookDeadLettersHandler(logger, d, admin)).Methods("GET")
+	router.HandleFunc("/admin/webhooks/dead-letters/{id}/replay", handlers.WebhookReplayHandler(logger, d, admin)).Methods("POST")
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do("GET", "/admin/webhooks/dead-letters")
+	var listed handlers.DeadLettersResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusOK || len(listed.DeadLetters) != 2 {
+		t.Fatalf("Dead letters handler returned %v: %s", rr.Code, rr.Body.String())
+	}
+
+	id := listed.DeadLetters[0].ID
+	if rr := do("POST", "/admin/webhooks/dead-letters/"+id+"/replay"); rr.Code != http.StatusAccepted {
+		t.Fatalf("Replay handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusAccepted, rr.Body.String())
+	}
+	replayed := receiver.next(t)
+	if got := replayed.header.Get(webhook.DeliveryHeader); got != id {
+		t.Errorf("Replay has wrong delivery ID: got %s want %s", got, id)
+	}
+	if rr := do("POST", "/admin/webhooks/dead-letters/"+id+"/replay"); rr.Code != http.StatusNotFound {
+		t.Errorf("Replay handler returned wrong status code for a replayed delivery: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	d.Close()
+	if deliveries, _ := d.DeadLetters(context.Background()); len(deliveries) != 1 || deliveries[0].ID == id {
+		t.Errorf("Replayed delivery is still dead-lettered: %+v", deliveries)
+	}
+
+	admin.allowed = false
+	if rr := do("GET", "/admin/webhooks/dead-letters"); rr.Code != http.StatusForbidden {
+		t.Errorf("Dead letters handler returned wrong status code for a non-admin: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+}
+
+func TestWebhookCallbackRestrictions(t *testing.T) {
+	config := testWebhookConfig()
+	config.AllowInsecureCallbacks = false
+	d := webhook.NewDispatcher(zerolog.Nop(), config, nil, webhook.NewMemoryDeadLetterStore(10))
+	defer d.Close()
+
+	for _, url := range []string{"http://hooks.example.com/done", "https://127.0.0.1/done", "https://10.0.0.8/done", "/done"} {
+		if err := d.CheckCallback(url); err == nil {
+			t.Errorf("CheckCallback accepted %s", url)
+		}
+	}
+	if err := d.CheckCallback("https://hooks.example.com/done"); err != nil {
+		t.Errorf("CheckCallback rejected a public https URL: %v", err)
+	}
+
+	// A host resolving to a private address is refused when dialled
+	receiver := newWebhookReceiver(t)
+	d.Callback(receiver.server.URL, webhook.NewDispatchCompleted(jobs.Job{ID: "job-1"}))
+	deliveries := waitForDeadLetters(t, d, 1)
+	if deliveries[0].Attempts != 1 || !strings.Contains(deliveries[0].LastError, webhook.ErrBlockedAddress.Error()) {
+		t.Errorf("Callback to a private address has wrong dead letter: %+v", deliveries[0])
+	}
+
+	config.CallbackSecret = nil
+	disabled := webhook.NewDispatcher(zerolog.Nop(), config, nil, webhook.NewMemoryDeadLetterStore(10))
+	defer disabled.Close()
+	if err := disabled.CheckCallback("https://hooks.example.com/done"); err == nil {
+		t.Error("CheckCallback accepted a callback without a signing secret")
+	}
+}
+
+func TestWebhookGovernanceDecisions(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	subscription := webhook.Subscription{
+		Name:      "security",
+		URL:       receiver.server.URL,
+		Secret:    []byte("security-secret"),
+		Events:    []string{webhook.EventGovernanceDecision},
+		Decisions: []string{"deny"},
+	}
+	d := webhook.NewDispatcher(zerolog.Nop(), testWebhookConfig(), []webhook.Subscription{subscription}, webhook.NewMemoryDeadLetterStore(10))
+	defer d.Close()
+
+	governance := &MockGovernanceClient{allowed: true}
+	checker := webhook.NewChecker(governance, d)
+	ctx := context.WithValue(context.Background(), middleware.CallerKey, middleware.Caller{ID: "alice"})
+	req := clients.GovernanceRequest{Service: "orbit", Intent: "call_reasoning", Context: map[string]interface{}{"secret": "value"}}
+
+	// Allowed decisions are not subscribed to
+	checker.CheckPermission(ctx, req, "corr-1")
+	governance.allowed = false
+	governance.reason = "outside business hours"
+	if _, err := checker.CheckPermission(ctx, req, "corr-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	delivery := receiver.next(t)
+	if err := webhook.Verify([]byte("security-secret"), delivery.header.Get(webhook.SignatureHeader), delivery.body, webhook.DefaultTolerance, time.Now()); err != nil {
+		t.Errorf("Delivery has invalid signature: %v", err)
+	}
+	var got struct {
+		Data webhook.GovernanceDecision `json:"data"`
+	}
+	if err := json.Unmarshal(delivery.body, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := webhook.GovernanceDecision{
+		CorrelationID: "corr-2",
+		Principal:     "alice",
+		Service:       "orbit",
+		Intent:        "call_reasoning",
+		Decision:      "deny",
+		Reason:        "outside business hours",
+	}
+	if got.Data != want {
+		t.Errorf("Decision event has wrong data: got %+v want %+v", got.Data, want)
+	}
+	if strings.Contains(string(delivery.body), "value") {
+		t.Errorf("Decision event carries the governance context: %s", delivery.body)
+	}
+
+	select {
+	case extra := <-receiver.received:
+		t.Errorf("Unsubscribed decision was delivered: %s", extra.body)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestAsyncDispatchCallback(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	d := webhook.NewDispatcher(zerolog.Nop(), testWebhookConfig(), nil, webhook.NewMemoryDeadLetterStore(10))
+	defer d.Close()
+
+	axon := &MockAxonClient{response: &contract.ReasonResponse{Version: contract.Version, Output: json.RawMessage(`{"answer":"ok","ssn":"123"}`)}}
+	opts := handlers.DefaultDispatchOptions()
+	logger := zerolog.Nop()
+	pool := jobs.NewPool(logger, jobs.NewMemoryStore(), testJobConfig(), handlers.DispatchJobRunner(logger, axon, opts), d)
+	defer pool.Close()
+	opts.Jobs = pool
+	opts.Webhooks = d
+
+	governance := &MockGovernanceClient{allowed: true, obligations: []clients.Obligation{
+		{Type: clients.ObligationRedactFields, Value: []interface{}{"ssn"}},
+	}}
+	handler := handlers.DispatchHandlerWithOptions(logger, governance, axon, opts)
+	dispatch := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/dispatch?mode=async", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := dispatch(`{"input":{"q":"status?"},"callback":{"url":"` + receiver.server.URL + `/done"}}`)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Dispatch handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusAccepted, rr.Body.String())
+	}
+	var response handlers.DispatchResponse
+	json.Unmarshal(rr.Body.Bytes(), &response)
+
+	delivery := receiver.next(t)
+	var got struct {
+		Data webhook.DispatchCompleted `json:"data"`
+	}
+	if err := json.Unmarshal(delivery.body, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Data.JobID != response.JobID || got.Data.Status != jobs.StatusSucceeded || got.Data.Result == nil {
+		t.Fatalf("Callback has wrong body: %s", delivery.body)
+	}
+	if strings.Contains(string(got.Data.Result.Output), `"123"`) {
+		t.Errorf("Redacted output field reached the callback: %s", got.Data.Result.Output)
+	}
+
+	// Callbacks must be deliverable when the dispatch is accepted
+	opts.Webhooks = nil
+	handler = handlers.DispatchHandlerWithOptions(logger, governance, axon, opts)
+	rr = dispatch(`{"callback":{"url":"https://hooks.example.com/done"}}`)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "/callback/url") {
+		t.Errorf("Dispatch handler without webhooks returned %v: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestLoadSubscriptions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "webhooks.yaml")
+	contents := `endpoints:
+  - name: security
+    url: https://siem.example.com/orbit
+    secret_env: TEST_WEBHOOK_SECRET
+    events: [governance.decision]
+    decisions: [deny, error]
+`
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := webhook.LoadSubscriptions(path); err == nil {
+		t.Error("LoadSubscriptions accepted an endpoint whose secret is not set")
+	}
+
+	t.Setenv("TEST_WEBHOOK_SECRET", "s3cret")
+	subscriptions, err := webhook.LoadSubscriptions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subscriptions.Endpoints) != 1 || string(subscriptions.Endpoints[0].Secret) != "s3cret" {
+		t.Errorf("LoadSubscriptions returned wrong endpoints: %+v", subscriptions.Endpoints)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		endpoint webhook.Subscription
+	}{
+		{"unknown event", webhook.Subscription{Name: "a", URL: "https://a.example.com", Secret: []byte("s"), Events: []string{"dispatch.started"}}},
+		{"unknown decision", webhook.Subscription{Name: "a", URL: "https://a.example.com", Secret: []byte("s"), Events: []string{webhook.EventGovernanceDecision}, Decisions: []string{"maybe"}}},
+		{"reserved name", webhook.Subscription{Name: webhook.CallbackEndpoint, URL: "https://a.example.com", Secret: []byte("s"), Events: []string{webhook.EventDispatchCompleted}}},
+		{"relative url", webhook.Subscription{Name: "a", URL: "/hooks", Secret: []byte("s"), Events: []string{webhook.EventDispatchCompleted}}},
+	} {
+		if err := (webhook.Subscriptions{Endpoints: []webhook.Subscription{tt.endpoint}}).Validate(); err == nil {
+			t.Errorf("%s: Validate accepted an invalid endpoint", tt.name)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package webhook
+
+import (
+	"context"
+
+	"orbit-service/audit"
+	"orbit-service/clients"
+	"orbit-service/middleware"
+)
+
+// Checker publishes every decision of the governance checker it wraps as a
+// governance.decision event. Delivery happens in the background and never
+// affects the decision.
+type Checker struct {
+	next       clients.GovernanceChecker
+	dispatcher *Dispatcher
+}
+
+// NewChecker wraps next so its decisions are published by dispatcher
+func NewChecker(next clients.GovernanceChecker, dispatcher *Dispatcher) *Checker {
+	return &Checker{
+		next:       next,
+		dispatcher: dispatcher,
+	}
+}
+
+func (c *Checker) CheckPermission(ctx context.Context, req clients.GovernanceRequest, correlationID string) (clients.GovernanceResponse, error) {
+	response, err := c.next.CheckPermission(ctx, req, correlationID)
+
+	decision := GovernanceDecision{
+		CorrelationID: correlationID,
+		Service:       req.Service,
+		Intent:        req.Intent,
+	}
+	if caller, ok := middleware.GetCaller(ctx); ok {
+		decision.Principal = caller.ID
+	}
+
+	switch {
+	case err != nil:
+		decision.Decision = audit.DecisionError
+	case response.Allowed:
+		decision.Decision = audit.DecisionAllow
+	case response.RequiresApproval:
+		decision.Decision = audit.DecisionApprovalRequired
+	default:
+		decision.Decision = audit.DecisionDeny
+	}
+	if err == nil {
+		decision.Reason = response.Reason
+		decision.PolicyVersion = response.PolicyVersion
+		decision.Cached = response.Cached
+		decision.Degraded = response.Degraded
+	}
+
+	c.dispatcher.Publish(NewGovernanceDecision(decision))
+	return response, err
+}
@@ -0,0 +1,130 @@
+package webhook
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Config controls how deliveries are made and retried
+type Config struct {
+	// MaxAttempts bounds the attempts at a delivery before it is
+	// dead-lettered
+	MaxAttempts int
+	// Backoff is the wait after the first failed attempt, doubled after each
+	// further one up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout bounds each attempt
+	Timeout time.Duration
+	// Workers is the number of deliveries made at once
+	Workers int
+	// QueueSize is the number of deliveries that may wait for a worker;
+	// deliveries beyond it are dead-lettered
+	QueueSize int
+	// DeadLetters is the number of dead-lettered deliveries kept
+	DeadLetters int
+	// CallbackSecret signs deliveries to callback URLs; while empty,
+	// dispatches cannot ask for callbacks
+	CallbackSecret []byte
+	// AllowInsecureCallbacks lets callbacks use plain http and reach
+	// private addresses, for development only
+	AllowInsecureCallbacks bool
+}
+
+// DefaultConfig returns the delivery settings used when none are configured
+func DefaultConfig() Config {
+	return Config{
+		MaxAttempts: 5,
+		Backoff:     time.Second,
+		MaxBackoff:  time.Minute,
+		Timeout:     10 * time.Second,
+		Workers:     4,
+		QueueSize:   1000,
+		DeadLetters: 1000,
+	}
+}
+
+// ConfigFromEnv returns the default config overridden by
+// WEBHOOK_MAX_ATTEMPTS, WEBHOOK_WORKERS, WEBHOOK_QUEUE_SIZE,
+// WEBHOOK_DEAD_LETTERS, WEBHOOK_BACKOFF, WEBHOOK_MAX_BACKOFF and
+// WEBHOOK_TIMEOUT (Go duration strings), with the callback secret from
+// WEBHOOK_SIGNING_SECRET and WEBHOOK_ALLOW_INSECURE_CALLBACKS
+func ConfigFromEnv() (Config, error) {
+	config := DefaultConfig()
+
+	counts := []struct {
+		name string
+		into *int
+	}{
+		{"WEBHOOK_MAX_ATTEMPTS", &config.MaxAttempts},
+		{"WEBHOOK_WORKERS", &config.Workers},
+		{"WEBHOOK_QUEUE_SIZE", &config.QueueSize},
+		{"WEBHOOK_DEAD_LETTERS", &config.DeadLetters},
+	}
+	for _, c := range counts {
+		if value := os.Getenv(c.name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return config, fmt.Errorf("invalid %s %q", c.name, value)
+			}
+			*c.into = n
+		}
+	}
+
+	durations := []struct {
+		name string
+		into *time.Duration
+	}{
+		{"WEBHOOK_BACKOFF", &config.Backoff},
+		{"WEBHOOK_MAX_BACKOFF", &config.MaxBackoff},
+		{"WEBHOOK_TIMEOUT", &config.Timeout},
+	}
+	for _, d := range durations {
+		if value := os.Getenv(d.name); value != "" {
+			duration, err := time.ParseDuration(value)
+			if err != nil || duration <= 0 {
+				return config, fmt.Errorf("invalid %s %q", d.name, value)
+			}
+			*d.into = duration
+		}
+	}
+
+	config.CallbackSecret = []byte(os.Getenv("WEBHOOK_SIGNING_SECRET"))
+	if value := os.Getenv("WEBHOOK_ALLOW_INSECURE_CALLBACKS"); value != "" {
+		allow, err := strconv.ParseBool(value)
+		if err != nil {
+			return config, fmt.Errorf("invalid WEBHOOK_ALLOW_INSECURE_CALLBACKS %q", value)
+		}
+		config.AllowInsecureCallbacks = allow
+	}
+
+	return config, nil
+}
+
+// DispatcherFromEnv creates a dispatcher for the endpoints in
+// WEBHOOK_SUBSCRIPTIONS_FILE, configured by ConfigFromEnv, with dead
+// letters kept in memory. A nil dispatcher means webhooks are disabled:
+// neither subscriptions nor a callback secret are configured.
+func DispatcherFromEnv(logger zerolog.Logger) (*Dispatcher, error) {
+	config, err := ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	var subscriptions Subscriptions
+	if path := os.Getenv("WEBHOOK_SUBSCRIPTIONS_FILE"); path != "" {
+		subscriptions, err = LoadSubscriptions(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(subscriptions.Endpoints) == 0 && len(config.CallbackSecret) == 0 {
+		return nil, nil
+	}
+
+	return NewDispatcher(logger, config, subscriptions.Endpoints, NewMemoryDeadLetterStore(config.DeadLetters)), nil
+}
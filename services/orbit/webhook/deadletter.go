@@ -0,0 +1,86 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrNotFound is returned for an unknown dead-lettered delivery
+var ErrNotFound = errors.New("dead-lettered delivery not found")
+
+// DeadLetterStore keeps deliveries that could not be made, for an
+// administrator to inspect and replay
+type DeadLetterStore interface {
+	Add(ctx context.Context, delivery Delivery) error
+	// List returns the deliveries, oldest first
+	List(ctx context.Context) ([]Delivery, error)
+	Get(ctx context.Context, id string) (Delivery, error)
+	Remove(ctx context.Context, id string) error
+}
+
+// MemoryDeadLetterStore keeps up to a fixed number of deliveries in memory,
+// dropping the oldest to make room
+type MemoryDeadLetterStore struct {
+	mu         sync.Mutex
+	max        int
+	deliveries []Delivery
+}
+
+// NewMemoryDeadLetterStore creates a store keeping up to max deliveries
+func NewMemoryDeadLetterStore(max int) *MemoryDeadLetterStore {
+	return &MemoryDeadLetterStore{max: max}
+}
+
+func (s *MemoryDeadLetterStore) Add(ctx context.Context, delivery Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remove(delivery.ID)
+	if len(s.deliveries) >= s.max {
+		s.deliveries = s.deliveries[len(s.deliveries)-s.max+1:]
+	}
+	s.deliveries = append(s.deliveries, delivery)
+	return nil
+}
+
+func (s *MemoryDeadLetterStore) List(ctx context.Context) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveries := make([]Delivery, len(s.deliveries))
+	copy(deliveries, s.deliveries)
+	return deliveries, nil
+}
+
+func (s *MemoryDeadLetterStore) Get(ctx context.Context, id string) (Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, delivery := range s.deliveries {
+		if delivery.ID == id {
+			return delivery, nil
+		}
+	}
+	return Delivery{}, ErrNotFound
+}
+
+func (s *MemoryDeadLetterStore) Remove(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.remove(id) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *MemoryDeadLetterStore) remove(id string) bool {
+	for i, delivery := range s.deliveries {
+		if delivery.ID == id {
+			s.deliveries = append(s.deliveries[:i], s.deliveries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
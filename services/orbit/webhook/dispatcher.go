@@ -0,0 +1,423 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"orbit-service/jobs"
+)
+
+// ErrQueueFull is returned when a delivery cannot be queued
+var ErrQueueFull = errors.New("webhook delivery queue is full")
+
+// ErrClosed is returned for deliveries queued after the dispatcher is closed
+var ErrClosed = errors.New("webhook dispatcher is closed")
+
+// ErrBlockedAddress is returned for a callback resolving to a loopback,
+// private or link-local address
+var ErrBlockedAddress = errors.New("callback address is not public")
+
+// Delivery is one event sent to one endpoint. Secrets are not kept with it:
+// they are looked up by endpoint name on every attempt.
+type Delivery struct {
+	ID       string `json:"id"`
+	Event    string `json:"event"`
+	Endpoint string `json:"endpoint"`
+	URL      string `json:"url"`
+	// Payload is the signed body, identical on every attempt
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	FailedAt  *time.Time      `json:"failed_at,omitempty"`
+}
+
+// permanentError is a failed attempt not worth retrying
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Dispatcher delivers events to subscribed endpoints and to the callback
+// URLs of asynchronous dispatches. Deliveries are signed, retried with
+// exponential backoff, and dead-lettered once they cannot be made.
+type Dispatcher struct {
+	logger      zerolog.Logger
+	config      Config
+	endpoints   []Subscription
+	deadLetters DeadLetterStore
+
+	// endpointClient reaches the configured endpoints; callbackClient
+	// reaches caller-supplied URLs, and only public addresses
+	endpointClient *http.Client
+	callbackClient *http.Client
+
+	queue   chan Delivery
+	stop    chan struct{}
+	workers sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewDispatcher creates a dispatcher delivering to endpoints and starts its
+// workers
+func NewDispatcher(logger zerolog.Logger, config Config, endpoints []Subscription, deadLetters DeadLetterStore) *Dispatcher {
+	noRedirects := func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	d := &Dispatcher{
+		logger:         logger,
+		config:         config,
+		endpoints:      endpoints,
+		deadLetters:    deadLetters,
+		endpointClient: &http.Client{CheckRedirect: noRedirects},
+		callbackClient: &http.Client{CheckRedirect: noRedirects, Transport: callbackTransport(config.AllowInsecureCallbacks)},
+		queue:          make(chan Delivery, config.QueueSize),
+		stop:           make(chan struct{}),
+	}
+	d.workers.Add(config.Workers)
+	for i := 0; i < config.Workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+// CheckCallback checks that url can receive callbacks: callbacks must be
+// enabled, and the URL must be https unless insecure callbacks are allowed
+func (d *Dispatcher) CheckCallback(raw string) error {
+	if len(d.config.CallbackSecret) == 0 {
+		return errors.New("callbacks are not available")
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return errors.New("callback url must be an absolute URL")
+	}
+	if u.Scheme != "https" && !(u.Scheme == "http" && d.config.AllowInsecureCallbacks) {
+		return errors.New("callback url must use https")
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !d.config.AllowInsecureCallbacks && !publicIP(ip) {
+		return errors.New("callback url must not address a private network")
+	}
+	return nil
+}
+
+// Publish delivers event to every endpoint subscribed to it
+func (d *Dispatcher) Publish(event Event) {
+	var payload []byte
+	for _, endpoint := range d.endpoints {
+		if !endpoint.Matches(event) {
+			continue
+		}
+		if payload == nil {
+			var ok bool
+			if payload, ok = d.encode(event); !ok {
+				return
+			}
+		}
+		d.send(newDelivery(event, endpoint.Name, endpoint.URL, payload))
+	}
+}
+
+// Callback delivers event to a caller-supplied URL
+func (d *Dispatcher) Callback(url string, event Event) {
+	payload, ok := d.encode(event)
+	if !ok {
+		return
+	}
+	d.send(newDelivery(event, CallbackEndpoint, url, payload))
+}
+
+// JobFinished delivers dispatch.completed for job to its callback URL and
+// the subscribed endpoints
+func (d *Dispatcher) JobFinished(job jobs.Job) {
+	event := NewDispatchCompleted(job)
+	d.Publish(event)
+	if job.Callback != "" {
+		d.Callback(job.Callback, event)
+	}
+}
+
+// DeadLetters returns the deliveries that could not be made
+func (d *Dispatcher) DeadLetters(ctx context.Context) ([]Delivery, error) {
+	return d.deadLetters.List(ctx)
+}
+
+// Replay takes a delivery out of the dead letters and tries it again, with
+// a fresh set of attempts
+func (d *Dispatcher) Replay(ctx context.Context, id string) (Delivery, error) {
+	delivery, err := d.deadLetters.Get(ctx, id)
+	if err != nil {
+		return Delivery{}, err
+	}
+	if err := d.deadLetters.Remove(ctx, id); err != nil {
+		return Delivery{}, err
+	}
+
+	replay := delivery
+	replay.Attempts = 0
+	replay.LastError = ""
+	replay.FailedAt = nil
+	if err := d.enqueue(replay); err != nil {
+		// Put it back as it was rather than lose it
+		if addErr := d.deadLetters.Add(ctx, delivery); addErr != nil {
+			d.logger.Error().
+				Err(addErr).
+				Str("delivery_id", id).
+				Msg("webhook_dead_letter_failed")
+		}
+		return Delivery{}, err
+	}
+
+	d.logger.Info().
+		Str("delivery_id", id).
+		Str("endpoint", replay.Endpoint).
+		Msg("webhook_replay_queued")
+	return replay, nil
+}
+
+// Close stops accepting deliveries and waits for the queued ones to be
+// attempted. Deliveries still failing are dead-lettered rather than retried.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.stop)
+		close(d.queue)
+	}
+	d.mu.Unlock()
+	d.workers.Wait()
+}
+
+func (d *Dispatcher) encode(event Event) ([]byte, bool) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		d.logger.Error().
+			Err(err).
+			Str("event_id", event.ID).
+			Str("event", event.Type).
+			Msg("webhook_event_encode_failed")
+		return nil, false
+	}
+	return payload, true
+}
+
+func newDelivery(event Event, endpoint, url string, payload []byte) Delivery {
+	return Delivery{
+		ID:        "dlv_" + newID(),
+		Event:     event.Type,
+		Endpoint:  endpoint,
+		URL:       url,
+		Payload:   payload,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// send queues a delivery, dead-lettering it if it cannot be queued
+func (d *Dispatcher) send(delivery Delivery) {
+	if err := d.enqueue(delivery); err != nil {
+		delivery.LastError = err.Error()
+		d.deadLetter(delivery)
+	}
+}
+
+func (d *Dispatcher) enqueue(delivery Delivery) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrClosed
+	}
+	select {
+	case d.queue <- delivery:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.workers.Done()
+	for delivery := range d.queue {
+		d.deliver(delivery)
+	}
+}
+
+// deliver attempts a delivery until it succeeds, fails permanently or runs
+// out of attempts
+func (d *Dispatcher) deliver(delivery Delivery) {
+	for {
+		delivery.Attempts++
+		err := d.attempt(delivery)
+		if err == nil {
+			d.logger.Info().
+				Str("delivery_id", delivery.ID).
+				Str("event", delivery.Event).
+				Str("endpoint", delivery.Endpoint).
+				Int("attempts", delivery.Attempts).
+				Msg("webhook_delivered")
+			return
+		}
+		delivery.LastError = err.Error()
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) || delivery.Attempts >= d.config.MaxAttempts {
+			d.deadLetter(delivery)
+			return
+		}
+
+		backoff := d.backoff(delivery.Attempts)
+		d.logger.Warn().
+			Err(err).
+			Str("delivery_id", delivery.ID).
+			Str("endpoint", delivery.Endpoint).
+			Int("attempt", delivery.Attempts).
+			Dur("backoff", backoff).
+			Msg("retrying_webhook_delivery")
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-d.stop:
+			timer.Stop()
+			d.deadLetter(delivery)
+			return
+		}
+	}
+}
+
+// attempt makes one signed delivery. Endpoints answering 408, 429 or 5xx,
+// and ones that cannot be reached, are retried; other answers are final.
+func (d *Dispatcher) attempt(delivery Delivery) error {
+	secret, client, err := d.endpoint(delivery)
+	if err != nil {
+		return &permanentError{err}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return &permanentError{fmt.Errorf("invalid webhook request: %w", err)}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "orbit-webhooks/1")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(SignatureHeader, Sign(secret, time.Now(), delivery.Payload))
+
+	resp, err := client.Do(req)
+	if errors.Is(err, ErrBlockedAddress) {
+		return &permanentError{err}
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("endpoint answered %d", resp.StatusCode)
+	default:
+		return &permanentError{fmt.Errorf("endpoint answered %d", resp.StatusCode)}
+	}
+}
+
+// endpoint returns the secret and client for a delivery's endpoint
+func (d *Dispatcher) endpoint(delivery Delivery) ([]byte, *http.Client, error) {
+	if delivery.Endpoint == CallbackEndpoint {
+		if len(d.config.CallbackSecret) == 0 {
+			return nil, nil, errors.New("callbacks are not available")
+		}
+		return d.config.CallbackSecret, d.callbackClient, nil
+	}
+	for _, endpoint := range d.endpoints {
+		if endpoint.Name == delivery.Endpoint {
+			return endpoint.Secret, d.endpointClient, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("unknown webhook endpoint %q", delivery.Endpoint)
+}
+
+// backoff returns the wait after the given number of failed attempts,
+// doubling from Backoff up to MaxBackoff
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	backoff := d.config.Backoff
+	for i := 1; i < attempts && backoff < d.config.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > d.config.MaxBackoff {
+		backoff = d.config.MaxBackoff
+	}
+	return backoff
+}
+
+func (d *Dispatcher) deadLetter(delivery Delivery) {
+	failed := time.Now().UTC()
+	delivery.FailedAt = &failed
+
+	d.logger.Error().
+		Str("delivery_id", delivery.ID).
+		Str("event", delivery.Event).
+		Str("endpoint", delivery.Endpoint).
+		Int("attempts", delivery.Attempts).
+		Str("last_error", delivery.LastError).
+		Msg("webhook_dead_lettered")
+
+	if err := d.deadLetters.Add(context.Background(), delivery); err != nil {
+		d.logger.Error().
+			Err(err).
+			Str("delivery_id", delivery.ID).
+			Msg("webhook_dead_letter_failed")
+	}
+}
+
+// callbackTransport dials only public addresses, checked after name
+// resolution so a callback host cannot be pointed at an internal service.
+// It ignores proxy settings, which would bypass the check.
+func callbackTransport(allowPrivate bool) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+			}
+			return nil
+		}
+	}
+	return &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	}
+}
+
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
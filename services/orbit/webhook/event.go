@@ -0,0 +1,94 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"orbit-service/contract"
+	"orbit-service/jobs"
+)
+
+// Event types
+const (
+	// EventDispatchCompleted is sent when an asynchronous dispatch succeeds,
+	// fails or is cancelled
+	EventDispatchCompleted = "dispatch.completed"
+	// EventGovernanceDecision is sent for every governance decision
+	EventGovernanceDecision = "governance.decision"
+)
+
+// Event is the body of a delivery
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// DispatchCompleted is the data of a dispatch.completed event
+type DispatchCompleted struct {
+	JobID         string                   `json:"job_id"`
+	Status        string                   `json:"status"`
+	Intent        string                   `json:"intent"`
+	CorrelationID string                   `json:"correlation_id"`
+	FinishedAt    *time.Time               `json:"finished_at,omitempty"`
+	Result        *contract.ReasonResponse `json:"result,omitempty"`
+	ErrorCode     string                   `json:"error_code,omitempty"`
+	Error         string                   `json:"error,omitempty"`
+}
+
+// GovernanceDecision is the data of a governance.decision event. Like an
+// approval notification it leaves out the evaluated context, which may
+// hold data subscribers should not see.
+type GovernanceDecision struct {
+	CorrelationID string `json:"correlation_id"`
+	Principal     string `json:"principal,omitempty"`
+	Service       string `json:"service"`
+	Intent        string `json:"intent"`
+	// Decision is one of the audit decisions: allow, deny,
+	// requires_approval or error
+	Decision      string `json:"decision"`
+	Reason        string `json:"reason,omitempty"`
+	PolicyVersion string `json:"policy_version,omitempty"`
+	Cached        bool   `json:"cached,omitempty"`
+	Degraded      string `json:"degraded,omitempty"`
+}
+
+// NewDispatchCompleted creates the event for a finished job. The result is
+// the one the requester would collect, already redacted.
+func NewDispatchCompleted(job jobs.Job) Event {
+	return newEvent(EventDispatchCompleted, DispatchCompleted{
+		JobID:         job.ID,
+		Status:        job.Status,
+		Intent:        job.Intent,
+		CorrelationID: job.CorrelationID,
+		FinishedAt:    job.FinishedAt,
+		Result:        job.Result,
+		ErrorCode:     job.ErrorCode,
+		Error:         job.Error,
+	})
+}
+
+// NewGovernanceDecision creates the event for a governance decision
+func NewGovernanceDecision(decision GovernanceDecision) Event {
+	return newEvent(EventGovernanceDecision, decision)
+}
+
+func newEvent(eventType string, data interface{}) Event {
+	return Event{
+		ID:        "evt_" + newID(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+}
+
+func newID() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(bytes)
+}
@@ -0,0 +1,94 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers sent with every delivery
+const (
+	// SignatureHeader carries "t=<unix seconds>,v1=<hex HMAC-SHA256>", the
+	// HMAC taken over "<t>.<body>" with the endpoint's secret
+	SignatureHeader = "X-Orbit-Signature"
+	// EventHeader names the event type
+	EventHeader = "X-Orbit-Event"
+	// DeliveryHeader identifies the delivery; it is the same on every
+	// attempt and replay, so receivers can drop duplicates
+	DeliveryHeader = "X-Orbit-Delivery"
+)
+
+// DefaultTolerance is how old a signature Verify accepts by default
+const DefaultTolerance = 5 * time.Minute
+
+// ErrInvalidSignature is returned by Verify for a missing, malformed or
+// mismatched signature
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// ErrSignatureExpired is returned by Verify for a signature older than the
+// tolerance, which may be a replayed delivery
+var ErrSignatureExpired = errors.New("webhook signature timestamp outside tolerance")
+
+// Sign returns the signature header value for body sent at t
+func Sign(secret []byte, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", timestamp, hex.EncodeToString(mac(secret, timestamp, body)))
+}
+
+// Verify checks a signature header against body, as a receiver would. The
+// signature's timestamp must be within tolerance of now.
+func Verify(secret []byte, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var timestamp string
+	var signatures [][]byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return ErrInvalidSignature
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature, err := hex.DecodeString(value)
+			if err != nil {
+				return ErrInvalidSignature
+			}
+			signatures = append(signatures, signature)
+		}
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+
+	expected := mac(secret, timestamp, body)
+	matched := false
+	for _, signature := range signatures {
+		if hmac.Equal(signature, expected) {
+			matched = true
+		}
+	}
+	if !matched {
+		return ErrInvalidSignature
+	}
+
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrSignatureExpired
+	}
+	return nil
+}
+
+func mac(secret []byte, timestamp string, body []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
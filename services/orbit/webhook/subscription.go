@@ -0,0 +1,151 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"orbit-service/audit"
+)
+
+// CallbackEndpoint is the endpoint name of deliveries to a URL supplied by
+// a dispatch's caller
+const CallbackEndpoint = "callback"
+
+// Subscriptions is the file naming the endpoints events are delivered to
+type Subscriptions struct {
+	Endpoints []Subscription `json:"endpoints"`
+}
+
+// Subscription is an endpoint and the events it receives. Decision events
+// can be narrowed to some decisions, and both event types to some intents.
+type Subscription struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// SecretEnv names the environment variable holding the endpoint's
+	// signing secret; the secret itself never appears in the file
+	SecretEnv string   `json:"secret_env"`
+	Events    []string `json:"events"`
+	Decisions []string `json:"decisions,omitempty"`
+	Intents   []string `json:"intents,omitempty"`
+
+	// Secret is read from SecretEnv when the file is loaded
+	Secret []byte `json:"-"`
+}
+
+// LoadSubscriptions reads a subscriptions file, JSON or (by extension)
+// YAML, and the secrets it names
+func LoadSubscriptions(path string) (Subscriptions, error) {
+	var subscriptions Subscriptions
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return subscriptions, fmt.Errorf("failed to read webhook subscriptions: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var value interface{}
+		if err := yaml.Unmarshal(data, &value); err != nil {
+			return subscriptions, fmt.Errorf("failed to parse webhook subscriptions: %w", err)
+		}
+		data, err = json.Marshal(value)
+		if err != nil {
+			return subscriptions, fmt.Errorf("failed to parse webhook subscriptions: %w", err)
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&subscriptions); err != nil {
+		return subscriptions, fmt.Errorf("failed to parse webhook subscriptions: %w", err)
+	}
+
+	for i := range subscriptions.Endpoints {
+		s := &subscriptions.Endpoints[i]
+		if s.SecretEnv == "" {
+			return subscriptions, fmt.Errorf("endpoint %q: secret_env is required", s.Name)
+		}
+		secret := os.Getenv(s.SecretEnv)
+		if secret == "" {
+			return subscriptions, fmt.Errorf("endpoint %q: %s environment variable not set", s.Name, s.SecretEnv)
+		}
+		s.Secret = []byte(secret)
+	}
+
+	return subscriptions, subscriptions.Validate()
+}
+
+// Validate checks that endpoints are uniquely named, have an http(s) URL and
+// a secret, and subscribe to known events and decisions
+func (s Subscriptions) Validate() error {
+	names := make(map[string]bool, len(s.Endpoints))
+	for _, endpoint := range s.Endpoints {
+		if endpoint.Name == "" {
+			return fmt.Errorf("webhook endpoint has no name")
+		}
+		if endpoint.Name == CallbackEndpoint || names[endpoint.Name] {
+			return fmt.Errorf("endpoint %q: name is reserved or already used", endpoint.Name)
+		}
+		names[endpoint.Name] = true
+
+		u, err := url.Parse(endpoint.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("endpoint %q: url must be an absolute http(s) URL", endpoint.Name)
+		}
+		if len(endpoint.Secret) == 0 {
+			return fmt.Errorf("endpoint %q: no signing secret", endpoint.Name)
+		}
+
+		if len(endpoint.Events) == 0 {
+			return fmt.Errorf("endpoint %q: subscribes to no events", endpoint.Name)
+		}
+		for _, event := range endpoint.Events {
+			if event != EventDispatchCompleted && event != EventGovernanceDecision {
+				return fmt.Errorf("endpoint %q: unknown event %q", endpoint.Name, event)
+			}
+		}
+		for _, decision := range endpoint.Decisions {
+			switch decision {
+			case audit.DecisionAllow, audit.DecisionDeny, audit.DecisionApprovalRequired, audit.DecisionError:
+			default:
+				return fmt.Errorf("endpoint %q: unknown decision %q", endpoint.Name, decision)
+			}
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the endpoint receives event
+func (s Subscription) Matches(event Event) bool {
+	if !contains(s.Events, event.Type) {
+		return false
+	}
+	switch data := event.Data.(type) {
+	case GovernanceDecision:
+		return matchesAny(s.Decisions, data.Decision) && matchesAny(s.Intents, data.Intent)
+	case DispatchCompleted:
+		return matchesAny(s.Intents, data.Intent)
+	}
+	return true
+}
+
+// matchesAny reports whether value is in values, an empty list matching
+// anything
+func matchesAny(values []string, value string) bool {
+	return len(values) == 0 || contains(values, value)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}